@@ -46,10 +46,15 @@ func compileCmd(ctx *cli.Context) error {
 		return err
 	}
 
-	bin, err := compiler.Compile(fn, src, debug)
+	bin, srcmap, err := compiler.Compile(fn, src, debug)
 	if err != nil {
 		return err
 	}
+	if path := ctx.GlobalString(SourceMapFlag.Name); path != "" {
+		if err := writeSourceMap(path, srcmap); err != nil {
+			return err
+		}
+	}
 	fmt.Println(bin)
 	return nil
 }
@@ -0,0 +1,212 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v3"
+)
+
+var FillOutputFlag = cli.StringFlag{
+	Name:  "output.dir",
+	Usage: "directory the filled tests are written to, named after the filler files (default = stdout)",
+}
+
+var fillCommand = cli.Command{
+	Action:    fillCmd,
+	Name:      "fill",
+	Usage:     "fills state tests from the given filler files (JSON or YAML)",
+	ArgsUsage: "<file> [<file> ...]",
+	Flags: []cli.Flag{
+		FillOutputFlag,
+	},
+}
+
+func fillCmd(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return errors.New("path-to-filler argument required")
+	}
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	outdir := ctx.String(FillOutputFlag.Name)
+	if outdir != "" {
+		if err := os.MkdirAll(outdir, 0755); err != nil {
+			return err
+		}
+	}
+	for _, path := range ctx.Args() {
+		fillers, err := readFillers(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		names := make([]string, 0, len(fillers))
+		for name := range fillers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		filled := make(map[string]*tests.StateTest, len(fillers))
+		for _, name := range names {
+			test, err := fillers[name].Fill(vm.Config{})
+			if err != nil {
+				return fmt.Errorf("%s: failed to fill %s:\n%v", path, name, err)
+			}
+			log.Info("Filled state test", "file", path, "name", name)
+			filled[name] = test
+		}
+		out, err := json.MarshalIndent(filled, "", "  ")
+		if err != nil {
+			return err
+		}
+		if outdir == "" {
+			fmt.Println(string(out))
+			continue
+		}
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		base = strings.TrimSuffix(base, "Filler")
+		if err := ioutil.WriteFile(filepath.Join(outdir, base+".json"), out, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFillers loads the named state fillers from a JSON or YAML file. As the
+// filler fields are strings, numbers of both formats are kept in their literal
+// form, allowing the full range of hex and decimal values.
+func readFillers(path string) (map[string]*tests.StateFiller, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var content interface{}
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(src, &doc); err != nil {
+			return nil, err
+		}
+		if content, err = yamlToJSON(&doc); err != nil {
+			return nil, err
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(src))
+		dec.UseNumber()
+		if err := dec.Decode(&content); err != nil {
+			return nil, err
+		}
+		content = jsonNumbersToStrings(content)
+	}
+	enc, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var fillers map[string]*tests.StateFiller
+	if err := json.Unmarshal(enc, &fillers); err != nil {
+		return nil, err
+	}
+	return fillers, nil
+}
+
+// yamlToJSON converts a YAML node to the values of a JSON document, rendering
+// all scalars but booleans and nulls as strings.
+func yamlToJSON(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlToJSON(node.Content[0])
+
+	case yaml.AliasNode:
+		return yamlToJSON(node.Alias)
+
+	case yaml.MappingNode:
+		obj := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: unsupported mapping key", key.Line)
+			}
+			value, err := yamlToJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			obj[key.Value] = value
+		}
+		return obj, nil
+
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := yamlToJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+		return node.Value, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported yaml node", node.Line)
+}
+
+// jsonNumbersToStrings replaces all the numbers of a decoded JSON document with
+// their literal string form.
+func jsonNumbersToStrings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbersToStrings(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbersToStrings(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/tests/fuzzers/evm"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	FuzzSeedFlag = cli.Int64Flag{
+		Name:  "seed",
+		Usage: "seed of the program generator (default: current time)",
+	}
+	FuzzIterationsFlag = cli.IntFlag{
+		Name:  "iterations",
+		Usage: "number of programs to generate and check (0 = unlimited)",
+	}
+	FuzzDurationFlag = cli.DurationFlag{
+		Name:  "duration",
+		Usage: "time to run the fuzzer for (0 = unlimited)",
+	}
+	FuzzCrashersFlag = cli.StringFlag{
+		Name:  "crashers",
+		Usage: "directory to store the inputs of failing programs in",
+		Value: "crashers",
+	}
+)
+
+var fuzzCommand = cli.Command{
+	Action:    fuzzCmd,
+	Name:      "fuzz",
+	Usage:     "runs the differential fuzzer on generated programs",
+	ArgsUsage: "[<input file>...]",
+	Description: `
+The fuzz command generates random programs and executes each with different
+interpreter configurations, checking that they agree on the outcome and that the
+traces produced are consistent. The inputs of the programs violating any of the
+invariants are stored in the crashers directory.
+
+If input files are given, the programs in them are checked instead, which can be
+used to replay the crashers found.`,
+	Flags: []cli.Flag{
+		FuzzSeedFlag,
+		FuzzIterationsFlag,
+		FuzzDurationFlag,
+		FuzzCrashersFlag,
+	},
+}
+
+// fuzzCheck runs the checks of the fuzzer on the given input, converting any
+// panic into an error.
+func fuzzCheck(input []byte) (outcome *evm.Outcome, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	prog := evm.Decode(input)
+	if prog == nil {
+		return nil, errors.New("input too short")
+	}
+	return evm.Check(prog)
+}
+
+func fuzzCmd(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	// Replay the given inputs if any
+	if ctx.NArg() > 0 {
+		var failed bool
+		for _, path := range ctx.Args() {
+			input, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			outcome, err := fuzzCheck(input)
+			if err != nil {
+				failed = true
+				fmt.Printf("%s: FAIL: %v\n", path, err)
+				continue
+			}
+			fmt.Printf("%s: ok: %v\n", path, outcome)
+		}
+		if failed {
+			return errors.New("fuzzer invariants violated")
+		}
+		return nil
+	}
+	// Otherwise generate and check programs until the limits are reached
+	seed := time.Now().UnixNano()
+	if ctx.IsSet(FuzzSeedFlag.Name) {
+		seed = ctx.Int64(FuzzSeedFlag.Name)
+	}
+	var (
+		r          = rand.New(rand.NewSource(seed))
+		iterations = ctx.Int(FuzzIterationsFlag.Name)
+		duration   = ctx.Duration(FuzzDurationFlag.Name)
+		crashers   = ctx.String(FuzzCrashersFlag.Name)
+		start      = time.Now()
+		logged     = start
+		failures   int
+	)
+	log.Info("Starting EVM fuzzer", "seed", seed, "iterations", iterations, "duration", duration)
+	for i := 0; iterations == 0 || i < iterations; i++ {
+		if duration != 0 && time.Since(start) > duration {
+			break
+		}
+		input := evm.Generate(r)
+		if _, err := fuzzCheck(input); err != nil {
+			failures++
+			if err := os.MkdirAll(crashers, 0755); err != nil {
+				return err
+			}
+			path := filepath.Join(crashers, fmt.Sprintf("%x", crypto.Keccak256(input)[:8]))
+			if err := ioutil.WriteFile(path, input, 0644); err != nil {
+				return err
+			}
+			log.Error("Fuzzer invariant violated", "input", path, "err", err)
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Fuzzing programs", "checked", i+1, "failures", failures, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+	}
+	log.Info("EVM fuzzer finished", "failures", failures, "elapsed", time.Since(start))
+	if failures > 0 {
+		return fmt.Errorf("%d programs violated the fuzzer invariants", failures)
+	}
+	return nil
+}
@@ -23,17 +23,19 @@ import (
 	"github.com/ethereum/go-ethereum/core/asm"
 )
 
-func Compile(fn string, src []byte, debug bool) (string, error) {
+// Compile compiles the easm source in the given file, returning the hex encoded
+// binary and the source map of its instructions.
+func Compile(fn string, src []byte, debug bool) (string, asm.SourceMap, error) {
 	compiler := asm.NewCompiler(debug)
-	compiler.Feed(asm.Lex(src, debug))
+	compiler.Feed(asm.LexFile(fn, src, debug))
 
 	bin, compileErrors := compiler.Compile()
 	if len(compileErrors) > 0 {
 		// report errors
 		for _, err := range compileErrors {
-			fmt.Printf("%s:%v\n", fn, err)
+			fmt.Println(err)
 		}
-		return "", errors.New("compiling failed")
+		return "", nil, errors.New("compiling failed")
 	}
-	return bin, nil
+	return bin, compiler.SourceMap(), nil
 }
@@ -0,0 +1,401 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//go:generate gencodec -type header -field-override headerMarshaling -out gen_header.go
+type header struct {
+	ParentHash  common.Hash       `json:"parentHash"`
+	OmmerHash   *common.Hash      `json:"sha3Uncles"`
+	Coinbase    *common.Address   `json:"miner"`
+	Root        common.Hash       `json:"stateRoot"        gencodec:"required"`
+	TxHash      *common.Hash      `json:"transactionsRoot"`
+	ReceiptHash *common.Hash      `json:"receiptsRoot"`
+	Bloom       types.Bloom       `json:"logsBloom"`
+	Difficulty  *big.Int          `json:"difficulty"`
+	Number      *big.Int          `json:"number"           gencodec:"required"`
+	GasLimit    uint64            `json:"gasLimit"         gencodec:"required"`
+	GasUsed     uint64            `json:"gasUsed"`
+	Time        uint64            `json:"timestamp"        gencodec:"required"`
+	Extra       []byte            `json:"extraData"`
+	MixDigest   common.Hash       `json:"mixHash"`
+	Nonce       *types.BlockNonce `json:"nonce"`
+}
+
+type headerMarshaling struct {
+	Difficulty *math.HexOrDecimal256
+	Number     *math.HexOrDecimal256
+	GasLimit   math.HexOrDecimal64
+	GasUsed    math.HexOrDecimal64
+	Time       math.HexOrDecimal64
+	Extra      hexutil.Bytes
+}
+
+// cliqueInput contains the sealing parameters of a clique block.
+type cliqueInput struct {
+	Key       *ecdsa.PrivateKey
+	Voted     *common.Address
+	Authorize *bool
+	Vanity    common.Hash
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (c *cliqueInput) UnmarshalJSON(input []byte) error {
+	var dec struct {
+		Key       *common.Hash    `json:"secretKey"`
+		Voted     *common.Address `json:"voted"`
+		Authorize *bool           `json:"authorize"`
+		Vanity    common.Hash     `json:"vanity"`
+	}
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.Key == nil {
+		return errors.New("missing required field 'secretKey' for cliqueInput")
+	}
+	key, err := crypto.ToECDSA(dec.Key[:])
+	if err != nil {
+		return err
+	}
+	c.Key, c.Voted, c.Authorize, c.Vanity = key, dec.Voted, dec.Authorize, dec.Vanity
+	return nil
+}
+
+// bbInput is the combined input of the block builder, as read from stdin.
+type bbInput struct {
+	Header *header              `json:"header,omitempty"`
+	Ommers []string             `json:"ommers,omitempty"`
+	TxRlp  string               `json:"txs,omitempty"`
+	Clique *cliqueInput         `json:"clique,omitempty"`
+	Ethash bool                 `json:"-"`
+	Txs    []*types.Transaction `json:"-"`
+	Uncles []*types.Header      `json:"-"`
+
+	EthashDir string      `json:"-"`
+	PowMode   ethash.Mode `json:"-"`
+}
+
+// ToBlock assembles the block from the input, filling in the transaction and
+// ommer hashes if they were not given, and checking them otherwise.
+func (i *bbInput) ToBlock() (*types.Block, error) {
+	header := &types.Header{
+		ParentHash:  i.Header.ParentHash,
+		UncleHash:   types.CalcUncleHash(i.Uncles),
+		Coinbase:    common.Address{},
+		Root:        i.Header.Root,
+		TxHash:      types.DeriveSha(types.Transactions(i.Txs), trie.NewStackTrie(nil)),
+		ReceiptHash: types.EmptyRootHash,
+		Bloom:       i.Header.Bloom,
+		Difficulty:  common.Big0,
+		Number:      i.Header.Number,
+		GasLimit:    i.Header.GasLimit,
+		GasUsed:     i.Header.GasUsed,
+		Time:        i.Header.Time,
+		Extra:       i.Header.Extra,
+		MixDigest:   i.Header.MixDigest,
+	}
+	if i.Header.OmmerHash != nil && *i.Header.OmmerHash != header.UncleHash {
+		return nil, fmt.Errorf("ommer hash mismatch: given %x, computed %x", *i.Header.OmmerHash, header.UncleHash)
+	}
+	if i.Header.TxHash != nil && *i.Header.TxHash != header.TxHash {
+		return nil, fmt.Errorf("transaction root mismatch: given %x, computed %x", *i.Header.TxHash, header.TxHash)
+	}
+	if i.Header.Coinbase != nil {
+		header.Coinbase = *i.Header.Coinbase
+	}
+	if i.Header.ReceiptHash != nil {
+		header.ReceiptHash = *i.Header.ReceiptHash
+	}
+	if i.Header.Difficulty != nil {
+		header.Difficulty = i.Header.Difficulty
+	}
+	if i.Header.Nonce != nil {
+		header.Nonce = *i.Header.Nonce
+	}
+	return types.NewBlockWithHeader(header).WithBody(i.Txs, i.Uncles), nil
+}
+
+// SealBlock seals the given block with the configured consensus engine, if any.
+func (i *bbInput) SealBlock(block *types.Block) (*types.Block, error) {
+	switch {
+	case i.Ethash:
+		return i.sealEthash(block)
+	case i.Clique != nil:
+		return i.sealClique(block)
+	default:
+		return block, nil
+	}
+}
+
+// sealEthash seals the given block using ethash.
+func (i *bbInput) sealEthash(block *types.Block) (*types.Block, error) {
+	if i.Header.Nonce != nil {
+		return nil, NewError(ErrorConfig, errors.New("sealing with ethash will overwrite provided nonce"))
+	}
+	if i.Header.MixDigest != (common.Hash{}) {
+		return nil, NewError(ErrorConfig, errors.New("sealing with ethash will overwrite provided mixHash"))
+	}
+	engine := ethash.New(ethash.Config{
+		CacheDir:       i.EthashDir,
+		CachesInMem:    2,
+		CachesOnDisk:   3,
+		DatasetDir:     i.EthashDir,
+		DatasetsInMem:  1,
+		DatasetsOnDisk: 2,
+		PowMode:        i.PowMode,
+	}, nil, false)
+	defer engine.Close()
+
+	// Use a buffered chan for results, as the engine may not wait for the reader
+	results := make(chan *types.Block, 1)
+	if err := engine.Seal(nil, block, results, nil); err != nil {
+		return nil, NewError(ErrorSealing, fmt.Errorf("failed to seal block: %v", err))
+	}
+	return <-results, nil
+}
+
+// sealClique seals the given block using clique.
+func (i *bbInput) sealClique(block *types.Block) (*types.Block, error) {
+	header := block.Header()
+
+	// If any clique value overwrites an explicit header value, fail
+	// to avoid silently building a block with unexpected values.
+	if i.Clique.Voted != nil {
+		if i.Header.Coinbase != nil {
+			return nil, NewError(ErrorConfig, errors.New("sealing with clique and voting will overwrite provided coinbase"))
+		}
+		header.Coinbase = *i.Clique.Voted
+	}
+	if i.Clique.Authorize != nil {
+		if i.Header.Nonce != nil {
+			return nil, NewError(ErrorConfig, errors.New("sealing with clique and voting will overwrite provided nonce"))
+		}
+		if *i.Clique.Authorize {
+			header.Nonce = types.BlockNonce{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		} else {
+			header.Nonce = types.BlockNonce{}
+		}
+	}
+	// Assemble the extra data as the vanity, followed by any provided extra data
+	// (e.g. the signer list of a checkpoint block) and the signature
+	header.Extra = make([]byte, 0, len(i.Clique.Vanity)+len(i.Header.Extra)+crypto.SignatureLength)
+	header.Extra = append(header.Extra, i.Clique.Vanity[:]...)
+	header.Extra = append(header.Extra, i.Header.Extra...)
+	header.Extra = append(header.Extra, make([]byte, crypto.SignatureLength)...)
+
+	sighash, err := crypto.Sign(clique.SealHash(header).Bytes(), i.Clique.Key)
+	if err != nil {
+		return nil, NewError(ErrorSealing, fmt.Errorf("failed to sign block: %v", err))
+	}
+	copy(header.Extra[len(header.Extra)-crypto.SignatureLength:], sighash)
+
+	return block.WithSeal(header), nil
+}
+
+// BuildBlock constructs a block from the given inputs.
+func BuildBlock(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	baseDir, err := createBasedir(ctx)
+	if err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed creating output basedir: %v", err))
+	}
+	inputData, err := readInput(ctx)
+	if err != nil {
+		return err
+	}
+	block, err := inputData.ToBlock()
+	if err != nil {
+		return NewError(ErrorConfig, err)
+	}
+	block, err = inputData.SealBlock(block)
+	if err != nil {
+		return err
+	}
+	return dispatchBlock(ctx, baseDir, block)
+}
+
+// readInput loads the block builder inputs from the files or stdin given on the
+// command line and validates the sealing configuration.
+func readInput(ctx *cli.Context) (*bbInput, error) {
+	var (
+		headerStr  = ctx.String(InputHeaderFlag.Name)
+		ommersStr  = ctx.String(InputOmmersFlag.Name)
+		txsStr     = ctx.String(InputTxsRlpFlag.Name)
+		cliqueStr  = ctx.String(SealCliqueFlag.Name)
+		ethashOn   = ctx.Bool(SealEthashFlag.Name)
+		ethashDir  = ctx.String(SealEthashDirFlag.Name)
+		ethashMode = ctx.String(SealEthashModeFlag.Name)
+		inputData  = &bbInput{}
+	)
+	if ethashOn && cliqueStr != "" {
+		return nil, NewError(ErrorConfig, errors.New("both ethash and clique sealing specified, only one may be chosen"))
+	}
+	if ethashOn {
+		inputData.Ethash = ethashOn
+		inputData.EthashDir = ethashDir
+		switch ethashMode {
+		case "normal":
+			inputData.PowMode = ethash.ModeNormal
+		case "test":
+			inputData.PowMode = ethash.ModeTest
+		case "fake":
+			inputData.PowMode = ethash.ModeFake
+		default:
+			return nil, NewError(ErrorConfig, fmt.Errorf("unknown pow mode: %s, supported modes: test, fake, normal", ethashMode))
+		}
+	}
+	if headerStr == stdinSelector || ommersStr == stdinSelector || txsStr == stdinSelector || cliqueStr == stdinSelector {
+		decoder := json.NewDecoder(os.Stdin)
+		if err := decoder.Decode(inputData); err != nil {
+			return nil, NewError(ErrorJson, fmt.Errorf("failed unmarshaling input: %v", err))
+		}
+	}
+	if cliqueStr != stdinSelector && cliqueStr != "" {
+		var clique cliqueInput
+		if err := readFile(cliqueStr, "clique", &clique); err != nil {
+			return nil, err
+		}
+		inputData.Clique = &clique
+	}
+	if headerStr != stdinSelector {
+		var env header
+		if err := readFile(headerStr, "header", &env); err != nil {
+			return nil, err
+		}
+		inputData.Header = &env
+	}
+	if inputData.Header == nil {
+		return nil, NewError(ErrorJson, errors.New("missing block header"))
+	}
+	if ommersStr != stdinSelector && ommersStr != "" {
+		var ommers []string
+		if err := readFile(ommersStr, "ommers", &ommers); err != nil {
+			return nil, err
+		}
+		inputData.Ommers = ommers
+	}
+	for i, str := range inputData.Ommers {
+		var ommer types.Header
+		if err := rlp.DecodeBytes(common.FromHex(str), &ommer); err != nil {
+			return nil, NewError(ErrorRlp, fmt.Errorf("unable to decode ommer %d: %v", i, err))
+		}
+		inputData.Uncles = append(inputData.Uncles, &ommer)
+	}
+	if txsStr != stdinSelector {
+		var txs string
+		if err := readFile(txsStr, "txs", &txs); err != nil {
+			return nil, err
+		}
+		inputData.TxRlp = txs
+	}
+	if len(inputData.TxRlp) > 0 {
+		var txs []*types.Transaction
+		if err := rlp.DecodeBytes(common.FromHex(inputData.TxRlp), &txs); err != nil {
+			return nil, NewError(ErrorRlp, fmt.Errorf("unable to decode transaction from rlp data: %v", err))
+		}
+		inputData.Txs = txs
+	}
+	return inputData, nil
+}
+
+// readFile reads the json-encoded contents of the given file into the value.
+func readFile(path, desc string, dest interface{}) error {
+	inFile, err := os.Open(path)
+	if err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed reading %s file: %v", desc, err))
+	}
+	defer inFile.Close()
+
+	decoder := json.NewDecoder(inFile)
+	if err := decoder.Decode(dest); err != nil {
+		return NewError(ErrorJson, fmt.Errorf("failed unmarshaling %s file: %v", desc, err))
+	}
+	return nil
+}
+
+// createBasedir makes sure the output basedir exists, if one was specified.
+func createBasedir(ctx *cli.Context) (string, error) {
+	baseDir := ""
+	if ctx.IsSet(OutputBasedir.Name) {
+		if base := ctx.String(OutputBasedir.Name); len(base) > 0 {
+			if err := os.MkdirAll(base, 0755); err != nil {
+				return "", err
+			}
+			baseDir = base
+		}
+	}
+	return baseDir, nil
+}
+
+// blockInfo is the output of the block builder.
+type blockInfo struct {
+	Rlp  hexutil.Bytes `json:"rlp"`
+	Hash common.Hash   `json:"hash"`
+}
+
+// dispatchBlock writes the output data to either stderr or stdout, or to the specified
+// files
+func dispatchBlock(ctx *cli.Context, baseDir string, block *types.Block) error {
+	raw, _ := rlp.EncodeToBytes(block)
+	info := &blockInfo{
+		Rlp:  raw,
+		Hash: block.Hash(),
+	}
+	switch dest := ctx.String(OutputBlockFlag.Name); dest {
+	case "stdout":
+		return writeJSON(os.Stdout, map[string]interface{}{"block": info})
+	case "stderr":
+		return writeJSON(os.Stderr, map[string]interface{}{"block": info})
+	default:
+		return saveFile(baseDir, dest, info)
+	}
+}
+
+// writeJSON marshals the object and writes it to the given file.
+func writeJSON(out *os.File, obj interface{}) error {
+	b, err := json.MarshalIndent(obj, "", " ")
+	if err != nil {
+		return NewError(ErrorJson, fmt.Errorf("failed marshalling output: %v", err))
+	}
+	out.Write(b)
+	out.Write([]byte("\n"))
+	return nil
+}
@@ -194,6 +194,12 @@ func (pre *Prestate) Apply(vmConfig vm.Config, chainConfig *params.ChainConfig,
 			receipt.TransactionIndex = uint(txIndex)
 			receipts = append(receipts, receipt)
 		}
+		if tracer, ok := tracer.(*vm.StandardJSONLogger); ok {
+			// Close the trace with the summary, which needs the state root after the transaction
+			if err := tracer.WriteSummary(statedb.IntermediateRoot(chainConfig.IsEIP158(vmContext.BlockNumber)), "", nil); err != nil {
+				return nil, nil, NewError(ErrorIO, fmt.Errorf("failed writing trace summary: %v", err))
+			}
+		}
 
 		txIndex++
 	}
@@ -0,0 +1,302 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// replayDebugger is the tracer name selecting the interactive step debugger,
+// which reads its commands from stdin.
+const replayDebugger = "debugger"
+
+// replayNonce is an account nonce, which the prestateTracer renders as a plain
+// number rather than a hex string.
+type replayNonce uint64
+
+// UnmarshalJSON implements json.Unmarshaler, accepting both numbers and strings.
+func (n *replayNonce) UnmarshalJSON(input []byte) error {
+	if len(input) > 0 && input[0] == '"' {
+		var nonce math.HexOrDecimal64
+		if err := json.Unmarshal(input, &nonce); err != nil {
+			return err
+		}
+		*n = replayNonce(nonce)
+		return nil
+	}
+	nonce, err := strconv.ParseUint(string(input), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid nonce %s", input)
+	}
+	*n = replayNonce(nonce)
+	return nil
+}
+
+// replayAccount is an account of the state of a replayed transaction, as produced
+// by the prestateTracer. Missing fields are not checked in an expected poststate.
+type replayAccount struct {
+	Balance *math.HexOrDecimal256       `json:"balance"`
+	Nonce   *replayNonce                `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// replayAlloc is the state of the accounts accessed by a replayed transaction.
+type replayAlloc map[common.Address]replayAccount
+
+// genesisAlloc converts the accounts to a genesis allocation.
+func (a replayAlloc) genesisAlloc() core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc, len(a))
+	for addr, account := range a {
+		genesis := core.GenesisAccount{
+			Balance: new(big.Int),
+			Code:    account.Code,
+			Storage: account.Storage,
+		}
+		if account.Balance != nil {
+			genesis.Balance = (*big.Int)(account.Balance)
+		}
+		if account.Nonce != nil {
+			genesis.Nonce = uint64(*account.Nonce)
+		}
+		alloc[addr] = genesis
+	}
+	return alloc
+}
+
+// Replay re-executes a single transaction on top of the state it accessed, as
+// produced by the prestateTracer, optionally tracing it and checking the
+// resulting state against an expected poststate.
+func Replay(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	baseDir, err := createBasedir(ctx)
+	if err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed creating output basedir: %v", err))
+	}
+	// Construct the chainconfig, copying it as the fork configs are shared
+	cConf, extraEips, err := tests.GetChainConfig(ctx.String(ForknameFlag.Name))
+	if err != nil {
+		return NewError(ErrorVMConfig, fmt.Errorf("failed constructing chain configuration: %v", err))
+	}
+	chainConfig := new(params.ChainConfig)
+	*chainConfig = *cConf
+	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Load the prestate, the block environment and the transaction
+	var (
+		alloc    replayAlloc
+		prestate Prestate
+	)
+	if err := readFile(ctx.String(InputAllocFlag.Name), "prestate", &alloc); err != nil {
+		return err
+	}
+	prestate.Pre = alloc.genesisAlloc()
+
+	if err := readFile(ctx.String(InputEnvFlag.Name), "env", &prestate.Env); err != nil {
+		return err
+	}
+	tx, err := readTransaction(ctx.String(InputTxFlag.Name))
+	if err != nil {
+		return err
+	}
+	// Re-execute the transaction with the requested tracer
+	var (
+		tracer     vm.Tracer
+		traceFile  *os.File
+		tracerName = ctx.String(ReplayTracerFlag.Name)
+	)
+	if ctx.Bool(TraceFlag.Name) && tracerName != "" {
+		return NewError(ErrorConfig, fmt.Errorf("--%s and --%s are mutually exclusive", TraceFlag.Name, ReplayTracerFlag.Name))
+	}
+	getTracer := func(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+		switch {
+		case ctx.Bool(TraceFlag.Name):
+			logConfig := &vm.LogConfig{
+				DisableStack:      ctx.Bool(TraceDisableStackFlag.Name),
+				DisableMemory:     ctx.Bool(TraceDisableMemoryFlag.Name),
+				DisableReturnData: ctx.Bool(TraceDisableReturnDataFlag.Name),
+				Debug:             true,
+			}
+			if traceFile, err = os.Create(path.Join(baseDir, fmt.Sprintf("trace-%d-%v.jsonl", txIndex, txHash.String()))); err != nil {
+				return nil, NewError(ErrorIO, fmt.Errorf("failed creating trace-file: %v", err))
+			}
+			if ctx.Bool(TraceEIP3155Flag.Name) {
+				tracer = vm.NewStandardJSONLogger(logConfig, traceFile)
+			} else {
+				tracer = vm.NewJSONLogger(logConfig, traceFile)
+			}
+
+		case tracerName == replayDebugger:
+			tracer = vm.NewDebugger(os.Stdin, os.Stdout)
+
+		case tracerName != "":
+			signer := types.MakeSigner(chainConfig, new(big.Int).SetUint64(prestate.Env.Number))
+			msg, err := tx.AsMessage(signer)
+			if err != nil {
+				return nil, err
+			}
+			txContext := core.NewEVMTxContext(msg)
+			if tracer, err = tracers.New(tracerName, txContext); err != nil {
+				return nil, NewError(ErrorConfig, fmt.Errorf("failed creating tracer: %v", err))
+			}
+		}
+		return tracer, nil
+	}
+	vmConfig := vm.Config{ExtraEips: extraEips}
+	state, result, err := prestate.Apply(vmConfig, chainConfig, types.Transactions{tx}, -1, getTracer)
+	if traceFile != nil {
+		traceFile.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Rejected) > 0 {
+		return NewError(ErrorEVM, fmt.Errorf("transaction %v rejected", tx.Hash()))
+	}
+	if jst, ok := tracer.(*tracers.Tracer); ok {
+		res, err := jst.GetResult()
+		if err != nil {
+			return NewError(ErrorEVM, fmt.Errorf("failed retrieving trace result: %v", err))
+		}
+		if err := saveFile(baseDir, fmt.Sprintf("trace-0-%v.json", tx.Hash().String()), res); err != nil {
+			return err
+		}
+	}
+	collector := make(Alloc)
+	state.DumpToCollector(collector, false, false, false, nil, -1)
+
+	body, _ := rlp.EncodeToBytes(types.Transactions{tx})
+	if err := dispatchOutput(ctx, baseDir, result, collector, body); err != nil {
+		return err
+	}
+	// Compare the resulting state with the expected one if requested
+	if expectFile := ctx.String(InputPoststateFlag.Name); expectFile != "" {
+		var expected replayAlloc
+		if err := readFile(expectFile, "poststate", &expected); err != nil {
+			return err
+		}
+		if diffs := diffPoststate(collector, expected); len(diffs) > 0 {
+			for _, diff := range diffs {
+				fmt.Fprintln(os.Stderr, diff)
+			}
+			return NewError(ErrorPoststate, fmt.Errorf("poststate mismatch: %d differences", len(diffs)))
+		}
+		log.Info("Poststate matches", "accounts", len(expected))
+	}
+	return nil
+}
+
+// readTransaction reads the transaction to replay from the given file, either as
+// JSON, e.g. as returned by eth_getTransactionByHash, or as hex encoded RLP.
+func readTransaction(path string) (*types.Transaction, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewError(ErrorIO, fmt.Errorf("failed reading tx file: %v", err))
+	}
+	data = bytes.TrimSpace(data)
+
+	tx := new(types.Transaction)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, tx); err != nil {
+			return nil, NewError(ErrorJson, fmt.Errorf("failed unmarshaling tx file: %v", err))
+		}
+		return tx, nil
+	}
+	var enc string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &enc); err != nil {
+			return nil, NewError(ErrorJson, fmt.Errorf("failed unmarshaling tx file: %v", err))
+		}
+	} else {
+		enc = string(data)
+	}
+	blob, err := hexutil.Decode(enc)
+	if err != nil {
+		return nil, NewError(ErrorRlp, fmt.Errorf("invalid tx encoding: %v", err))
+	}
+	if err := tx.UnmarshalBinary(blob); err != nil {
+		return nil, NewError(ErrorRlp, fmt.Errorf("unable to decode transaction: %v", err))
+	}
+	return tx, nil
+}
+
+// diffPoststate compares the fields present in the expected accounts with the
+// resulting state, returning a description of every difference. Accounts missing
+// from the state are considered empty.
+func diffPoststate(have Alloc, want replayAlloc) []string {
+	addrs := make([]common.Address, 0, len(want))
+	for addr := range want {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	var diffs []string
+	for _, addr := range addrs {
+		var (
+			exp = want[addr]
+			acc = have[addr]
+		)
+		if acc.Balance == nil {
+			acc.Balance = new(big.Int)
+		}
+		if exp.Balance != nil && (*big.Int)(exp.Balance).Cmp(acc.Balance) != 0 {
+			diffs = append(diffs, fmt.Sprintf("%#x: balance mismatch: have %#x, want %#x", addr, acc.Balance, (*big.Int)(exp.Balance)))
+		}
+		if exp.Nonce != nil && uint64(*exp.Nonce) != acc.Nonce {
+			diffs = append(diffs, fmt.Sprintf("%#x: nonce mismatch: have %d, want %d", addr, acc.Nonce, *exp.Nonce))
+		}
+		if exp.Code != nil && !bytes.Equal(exp.Code, acc.Code) {
+			diffs = append(diffs, fmt.Sprintf("%#x: code mismatch: have %#x, want %#x", addr, acc.Code, []byte(exp.Code)))
+		}
+		slots := make([]common.Hash, 0, len(exp.Storage))
+		for slot := range exp.Storage {
+			slots = append(slots, slot)
+		}
+		sort.Slice(slots, func(i, j int) bool { return bytes.Compare(slots[i][:], slots[j][:]) < 0 })
+		for _, slot := range slots {
+			if value := acc.Storage[slot]; value != exp.Storage[slot] {
+				diffs = append(diffs, fmt.Sprintf("%#x: storage %#x mismatch: have %#x, want %#x", addr, slot, value, exp.Storage[slot]))
+			}
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// txResult contains the outcome of validating a single transaction.
+type txResult struct {
+	Error        error           `json:"-"`
+	Address      *common.Address `json:"address,omitempty"`
+	Hash         *common.Hash    `json:"hash,omitempty"`
+	IntrinsicGas hexutil.Uint64  `json:"intrinsicGas,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, rendering the error as a string.
+func (r *txResult) MarshalJSON() ([]byte, error) {
+	type txResult struct {
+		Error        string          `json:"error,omitempty"`
+		Address      *common.Address `json:"address,omitempty"`
+		Hash         *common.Hash    `json:"hash,omitempty"`
+		IntrinsicGas hexutil.Uint64  `json:"intrinsicGas,omitempty"`
+	}
+	enc := txResult{
+		Address:      r.Address,
+		Hash:         r.Hash,
+		IntrinsicGas: r.IntrinsicGas,
+	}
+	if r.Error != nil {
+		enc.Error = r.Error.Error()
+	}
+	return json.Marshal(&enc)
+}
+
+// t9nInput is the combined input of the transaction tool, as read from stdin.
+type t9nInput struct {
+	Txs string `json:"txs"`
+}
+
+// Transaction validates the given RLP encoded transactions against the rules of
+// a fork, reporting the sender, hash and intrinsic gas of each, or the reason it
+// is invalid.
+func Transaction(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	// Construct the chainconfig, copying it as the fork configs are shared
+	cConf, _, err := tests.GetChainConfig(ctx.String(ForknameFlag.Name))
+	if err != nil {
+		return NewError(ErrorVMConfig, fmt.Errorf("failed constructing chain configuration: %v", err))
+	}
+	chainConfig := new(params.ChainConfig)
+	*chainConfig = *cConf
+	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Load the transactions, either from stdin or from a file
+	var (
+		txStr     = ctx.String(InputTxsRlpFlag.Name)
+		inputData = &t9nInput{}
+	)
+	if txStr == stdinSelector {
+		decoder := json.NewDecoder(os.Stdin)
+		if err := decoder.Decode(inputData); err != nil {
+			return NewError(ErrorJson, fmt.Errorf("failed unmarshaling input: %v", err))
+		}
+	} else {
+		if err := readFile(txStr, "txs", &inputData.Txs); err != nil {
+			return err
+		}
+	}
+	var txs []*types.Transaction
+	if err := rlp.DecodeBytes(common.FromHex(inputData.Txs), &txs); err != nil {
+		return NewError(ErrorRlp, fmt.Errorf("unable to decode transactions from rlp data: %v", err))
+	}
+	results := make([]*txResult, 0, len(txs))
+	for _, tx := range txs {
+		results = append(results, validateTransaction(chainConfig, tx))
+	}
+	return writeJSON(os.Stdout, results)
+}
+
+// validateTransaction checks the given transaction against the rules of the first
+// block of the chain config.
+func validateTransaction(config *params.ChainConfig, tx *types.Transaction) *txResult {
+	var (
+		number = new(big.Int)
+		signer = types.MakeSigner(config, number)
+		hash   = tx.Hash()
+	)
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		return &txResult{Error: err, Hash: &hash}
+	}
+	gas, err := core.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, config.IsHomestead(number), config.IsIstanbul(number))
+	if err != nil {
+		return &txResult{Error: err, Address: &sender, Hash: &hash}
+	}
+	result := &txResult{Address: &sender, Hash: &hash, IntrinsicGas: hexutil.Uint64(gas)}
+	if tx.Gas() < gas {
+		result.Error = fmt.Errorf("%w: have %d, want %d", core.ErrIntrinsicGas, tx.Gas(), gas)
+	}
+	return result
+}
@@ -43,9 +43,13 @@ const (
 	ErrorEVM              = 2
 	ErrorVMConfig         = 3
 	ErrorMissingBlockhash = 4
+	ErrorPoststate        = 5
 
-	ErrorJson = 10
-	ErrorIO   = 11
+	ErrorJson    = 10
+	ErrorIO      = 11
+	ErrorRlp     = 12
+	ErrorConfig  = 13
+	ErrorSealing = 14
 
 	stdinSelector = "stdin"
 )
@@ -120,6 +124,9 @@ func Main(ctx *cli.Context) error {
 				return nil, NewError(ErrorIO, fmt.Errorf("failed creating trace-file: %v", err))
 			}
 			prevFile = traceFile
+			if ctx.Bool(TraceEIP3155Flag.Name) {
+				return vm.NewStandardJSONLogger(logConfig, traceFile), nil
+			}
 			return vm.NewJSONLogger(logConfig, traceFile), nil
 		}
 	} else {
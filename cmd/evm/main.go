@@ -105,6 +105,10 @@ var (
 		Name:  "json",
 		Usage: "output trace logs in machine readable format (json)",
 	}
+	EIP3155Flag = cli.BoolFlag{
+		Name:  "eip3155",
+		Usage: "output trace logs in the standard EIP-3155 json format, closed by a summary line (implies --json)",
+	}
 	SenderFlag = cli.StringFlag{
 		Name:  "sender",
 		Usage: "The transaction origin",
@@ -129,11 +133,23 @@ var (
 		Name:  "noreturndata",
 		Usage: "disable return data output",
 	}
+	ProfileOutFlag = cli.StringFlag{
+		Name:  "profile-out",
+		Usage: "writes a pprof profile of the executed EVM instructions to the given path",
+	}
 	EVMInterpreterFlag = cli.StringFlag{
 		Name:  "vm.evm",
 		Usage: "External EVM configuration (default = built-in interpreter)",
 		Value: "",
 	}
+	DebuggerFlag = cli.BoolFlag{
+		Name:  "debugger",
+		Usage: "runs the code in a step debugger reading commands from stdin",
+	}
+	SourceMapFlag = cli.StringFlag{
+		Name:  "sourcemap",
+		Usage: "JSON file the source map of the code is written to by compile, and read from by run to annotate the traces",
+	}
 )
 
 var stateTransitionCommand = cli.Command{
@@ -146,6 +162,7 @@ var stateTransitionCommand = cli.Command{
 		t8ntool.TraceDisableMemoryFlag,
 		t8ntool.TraceDisableStackFlag,
 		t8ntool.TraceDisableReturnDataFlag,
+		t8ntool.TraceEIP3155Flag,
 		t8ntool.OutputBasedir,
 		t8ntool.OutputAllocFlag,
 		t8ntool.OutputResultFlag,
@@ -160,6 +177,62 @@ var stateTransitionCommand = cli.Command{
 	},
 }
 
+var blockBuilderCommand = cli.Command{
+	Name:    "block-builder",
+	Aliases: []string{"b11r"},
+	Usage:   "builds a block",
+	Action:  t8ntool.BuildBlock,
+	Flags: []cli.Flag{
+		t8ntool.OutputBasedir,
+		t8ntool.OutputBlockFlag,
+		t8ntool.InputHeaderFlag,
+		t8ntool.InputOmmersFlag,
+		t8ntool.InputTxsRlpFlag,
+		t8ntool.SealCliqueFlag,
+		t8ntool.SealEthashFlag,
+		t8ntool.SealEthashDirFlag,
+		t8ntool.SealEthashModeFlag,
+		t8ntool.VerbosityFlag,
+	},
+}
+
+var transactionCommand = cli.Command{
+	Name:    "transaction",
+	Aliases: []string{"t9n"},
+	Usage:   "performs transaction validation",
+	Action:  t8ntool.Transaction,
+	Flags: []cli.Flag{
+		t8ntool.InputTxsRlpFlag,
+		t8ntool.ForknameFlag,
+		t8ntool.ChainIDFlag,
+		t8ntool.VerbosityFlag,
+	},
+}
+
+var replayCommand = cli.Command{
+	Name:   "replay",
+	Usage:  "replays a transaction on top of a prestate",
+	Action: t8ntool.Replay,
+	Flags: []cli.Flag{
+		t8ntool.TraceFlag,
+		t8ntool.TraceDisableMemoryFlag,
+		t8ntool.TraceDisableStackFlag,
+		t8ntool.TraceDisableReturnDataFlag,
+		t8ntool.TraceEIP3155Flag,
+		t8ntool.ReplayTracerFlag,
+		t8ntool.OutputBasedir,
+		t8ntool.OutputAllocFlag,
+		t8ntool.OutputResultFlag,
+		t8ntool.InputAllocFlag,
+		t8ntool.InputEnvFlag,
+		t8ntool.InputTxFlag,
+		t8ntool.InputPoststateFlag,
+		t8ntool.ForknameFlag,
+		t8ntool.ChainIDFlag,
+		t8ntool.VerbosityFlag,
+	},
+}
+
 func init() {
 	app.Flags = []cli.Flag{
 		BenchFlag,
@@ -176,9 +249,11 @@ func init() {
 		InputFileFlag,
 		MemProfileFlag,
 		CPUProfileFlag,
+		ProfileOutFlag,
 		StatDumpFlag,
 		GenesisFlag,
 		MachineFlag,
+		EIP3155Flag,
 		SenderFlag,
 		ReceiverFlag,
 		DisableMemoryFlag,
@@ -186,13 +261,21 @@ func init() {
 		DisableStorageFlag,
 		DisableReturnDataFlag,
 		EVMInterpreterFlag,
+		SourceMapFlag,
+		DebuggerFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		fillCommand,
+		blockTestCommand,
 		stateTransitionCommand,
+		transactionCommand,
+		replayCommand,
+		blockBuilderCommand,
+		fuzzCommand,
 	}
 	cli.CommandHelpTemplate = flags.OriginCommandHelpTemplate
 }
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"os"
@@ -32,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/asm"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -118,13 +120,17 @@ func runCmd(ctx *cli.Context) error {
 	var (
 		tracer        vm.Tracer
 		debugLogger   *vm.StructLogger
+		standard      *vm.StandardJSONLogger
 		statedb       *state.StateDB
 		chainConfig   *params.ChainConfig
 		sender        = common.BytesToAddress([]byte("sender"))
 		receiver      = common.BytesToAddress([]byte("receiver"))
 		genesisConfig *core.Genesis
 	)
-	if ctx.GlobalBool(MachineFlag.Name) {
+	if ctx.GlobalBool(EIP3155Flag.Name) {
+		standard = vm.NewStandardJSONLogger(logconfig, os.Stdout)
+		tracer = standard
+	} else if ctx.GlobalBool(MachineFlag.Name) {
 		tracer = vm.NewJSONLogger(logconfig, os.Stdout)
 	} else if ctx.GlobalBool(DebugFlag.Name) {
 		debugLogger = vm.NewStructLogger(logconfig)
@@ -132,6 +138,23 @@ func runCmd(ctx *cli.Context) error {
 	} else {
 		debugLogger = vm.NewStructLogger(logconfig)
 	}
+	var profiler *vm.Profiler
+	if ctx.GlobalString(ProfileOutFlag.Name) != "" {
+		if tracer != nil {
+			utils.Fatalf("--%s cannot be combined with --%s or --%s", ProfileOutFlag.Name, DebugFlag.Name, MachineFlag.Name)
+		}
+		profiler = vm.NewProfiler()
+		tracer = profiler
+	}
+	if ctx.GlobalBool(DebuggerFlag.Name) {
+		if tracer != nil {
+			utils.Fatalf("--%s cannot be combined with --%s, --%s or --%s", DebuggerFlag.Name, DebugFlag.Name, MachineFlag.Name, ProfileOutFlag.Name)
+		}
+		if ctx.GlobalString(CodeFileFlag.Name) == "-" {
+			utils.Fatalf("--%s reads commands from stdin, the code can't be read from it", DebuggerFlag.Name)
+		}
+		tracer = vm.NewDebugger(os.Stdin, os.Stdout)
+	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		genesisConfig = gen
@@ -152,7 +175,10 @@ func runCmd(ctx *cli.Context) error {
 		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
 	}
 
-	var code []byte
+	var (
+		code   []byte
+		srcmap asm.SourceMap
+	)
 	codeFileFlag := ctx.GlobalString(CodeFileFlag.Name)
 	codeFlag := ctx.GlobalString(CodeFlag.Name)
 
@@ -184,18 +210,36 @@ func runCmd(ctx *cli.Context) error {
 			os.Exit(1)
 		}
 		code = common.FromHex(string(hexcode))
+
+		if path := ctx.GlobalString(SourceMapFlag.Name); path != "" {
+			var err error
+			if srcmap, err = readSourceMap(path); err != nil {
+				return err
+			}
+		}
 	} else if fn := ctx.Args().First(); len(fn) > 0 {
 		// EASM-file to compile
 		src, err := ioutil.ReadFile(fn)
 		if err != nil {
 			return err
 		}
-		bin, err := compiler.Compile(fn, src, false)
-		if err != nil {
+		var bin string
+		if bin, srcmap, err = compiler.Compile(fn, src, false); err != nil {
 			return err
 		}
 		code = common.Hex2Bytes(bin)
 	}
+	// Annotate the JSON trace of code with a source map with the source lines
+	if srcmap != nil && standard != nil {
+		tracer = newSourceJSONLogger(os.Stdout, srcmap, func(out io.Writer) vm.Tracer {
+			standard = vm.NewStandardJSONLogger(logconfig, out)
+			return standard
+		})
+	} else if srcmap != nil && ctx.GlobalBool(MachineFlag.Name) {
+		tracer = newSourceJSONLogger(os.Stdout, srcmap, func(out io.Writer) vm.Tracer {
+			return vm.NewJSONLogger(logconfig, out)
+		})
+	}
 	initialGas := ctx.GlobalUint64(GasFlag.Name)
 	if genesisConfig.GasLimit != 0 {
 		initialGas = genesisConfig.GasLimit
@@ -212,7 +256,7 @@ func runCmd(ctx *cli.Context) error {
 		BlockNumber: new(big.Int).SetUint64(genesisConfig.Number),
 		EVMConfig: vm.Config{
 			Tracer:         tracer,
-			Debug:          ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
+			Debug:          tracer != nil,
 			EVMInterpreter: ctx.GlobalString(EVMInterpreterFlag.Name),
 		},
 	}
@@ -267,6 +311,13 @@ func runCmd(ctx *cli.Context) error {
 	bench := ctx.GlobalBool(BenchFlag.Name)
 	output, leftOverGas, stats, err := timedExec(bench, execFunc)
 
+	if standard != nil {
+		root := statedb.IntermediateRoot(runtimeConfig.ChainConfig.IsEIP158(runtimeConfig.BlockNumber))
+		if err := standard.WriteSummary(root, "", nil); err != nil {
+			return err
+		}
+	}
+
 	if ctx.GlobalBool(DumpFlag.Name) {
 		statedb.Commit(true)
 		statedb.IntermediateRoot(true)
@@ -286,10 +337,27 @@ func runCmd(ctx *cli.Context) error {
 		f.Close()
 	}
 
+	if profiler != nil {
+		f, err := os.Create(ctx.GlobalString(ProfileOutFlag.Name))
+		if err != nil {
+			fmt.Println("could not create EVM profile: ", err)
+			os.Exit(1)
+		}
+		if err := profiler.WriteProfile(f); err != nil {
+			fmt.Println("could not write EVM profile: ", err)
+			os.Exit(1)
+		}
+		f.Close()
+	}
+
 	if ctx.GlobalBool(DebugFlag.Name) {
 		if debugLogger != nil {
 			fmt.Fprintln(os.Stderr, "#### TRACE ####")
-			vm.WriteTrace(os.Stderr, debugLogger.StructLogs())
+			if srcmap != nil {
+				writeSourceTrace(os.Stderr, debugLogger.StructLogs(), srcmap)
+			} else {
+				vm.WriteTrace(os.Stderr, debugLogger.StructLogs())
+			}
 		}
 		fmt.Fprintln(os.Stderr, "#### LOGS ####")
 		vm.WriteLogs(os.Stderr, statedb.Logs())
@@ -302,7 +370,7 @@ allocations:     %d
 allocated bytes: %d
 `, initialGas-leftOverGas, stats.time, stats.allocs, stats.bytesAllocated)
 	}
-	if tracer == nil {
+	if tracer == nil || profiler != nil {
 		fmt.Printf("0x%x\n", output)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
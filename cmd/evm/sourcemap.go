@@ -0,0 +1,138 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// writeSourceMap writes the source map of compiled code to the given file.
+func writeSourceMap(path string, srcmap asm.SourceMap) error {
+	b, err := json.MarshalIndent(srcmap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// readSourceMap reads the source map of the code to run from the given file.
+func readSourceMap(path string) (asm.SourceMap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var srcmap asm.SourceMap
+	if err := json.Unmarshal(b, &srcmap); err != nil {
+		return nil, fmt.Errorf("invalid source map %s: %v", path, err)
+	}
+	return srcmap, nil
+}
+
+// sourceLines caches the lines of the source files referenced by a source map.
+type sourceLines map[string][]string
+
+// line returns the text of the source line at the given location, or an empty
+// string if the source file can't be read.
+func (s sourceLines) line(loc asm.SourceLocation) string {
+	lines, ok := s[loc.File]
+	if !ok {
+		if src, err := ioutil.ReadFile(loc.File); err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		s[loc.File] = lines
+	}
+	if loc.Line < 1 || loc.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[loc.Line-1])
+}
+
+// writeSourceTrace writes the given struct logs like vm.WriteTrace, preceding
+// the steps of the top-level code with the source line they were compiled from.
+func writeSourceTrace(writer io.Writer, logs []vm.StructLog, srcmap asm.SourceMap) {
+	sources := make(sourceLines)
+	for _, log := range logs {
+		if loc, ok := srcmap[log.Pc]; ok && log.Depth == 1 {
+			fmt.Fprintf(writer, "%v: %s\n", loc, sources.line(loc))
+		}
+		vm.WriteTrace(writer, []vm.StructLog{log})
+	}
+}
+
+// sourceJSONLogger wraps a JSON logger, adding the source location of the executed
+// instruction to the steps of the top-level code, as a "source" field.
+type sourceJSONLogger struct {
+	vm.Tracer
+	out    *sourceWriter
+	srcmap asm.SourceMap
+}
+
+// newSourceJSONLogger creates the JSON logger with the given constructor, writing
+// it through a writer adding the source locations.
+func newSourceJSONLogger(writer io.Writer, srcmap asm.SourceMap, newLogger func(io.Writer) vm.Tracer) *sourceJSONLogger {
+	out := &sourceWriter{writer: writer}
+	return &sourceJSONLogger{
+		Tracer: newLogger(out),
+		out:    out,
+		srcmap: srcmap,
+	}
+}
+
+// CaptureState outputs state information on the logger, along with the source
+// location of the instruction.
+func (l *sourceJSONLogger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rData []byte, contract *vm.Contract, depth int, err error) error {
+	if loc, ok := l.srcmap[pc]; ok && depth == 1 {
+		l.out.source = loc.String()
+		defer func() { l.out.source = "" }()
+	}
+	return l.Tracer.CaptureState(env, pc, op, gas, cost, memory, stack, rData, contract, depth, err)
+}
+
+// sourceWriter adds the current source location as a field of the JSON objects
+// written through it, one object per write as done by json.Encoder.
+type sourceWriter struct {
+	writer io.Writer
+	source string
+}
+
+func (w *sourceWriter) Write(p []byte) (int, error) {
+	end := bytes.LastIndexByte(p, '}')
+	if w.source == "" || end < 0 {
+		return w.writer.Write(p)
+	}
+	field, err := json.Marshal(w.source)
+	if err != nil {
+		return 0, err
+	}
+	out := make([]byte, 0, len(p)+len(field)+16)
+	out = append(out, p[:end]...)
+	out = append(out, `,"source":`...)
+	out = append(out, field...)
+	out = append(out, p[end:]...)
+	if _, err := w.writer.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -67,8 +67,13 @@ func stateTestCmd(ctx *cli.Context) error {
 	var (
 		tracer   vm.Tracer
 		debugger *vm.StructLogger
+		standard *vm.StandardJSONLogger
 	)
 	switch {
+	case ctx.GlobalBool(EIP3155Flag.Name):
+		standard = vm.NewStandardJSONLogger(config, os.Stderr)
+		tracer = standard
+
 	case ctx.GlobalBool(MachineFlag.Name):
 		tracer = vm.NewJSONLogger(config, os.Stderr)
 
@@ -91,7 +96,7 @@ func stateTestCmd(ctx *cli.Context) error {
 	// Iterate over all the tests, run them and aggregate the results
 	cfg := vm.Config{
 		Tracer: tracer,
-		Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
+		Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name) || standard != nil,
 	}
 	results := make([]StatetestResult, 0, len(tests))
 	for key, test := range tests {
@@ -100,7 +105,7 @@ func stateTestCmd(ctx *cli.Context) error {
 			result := &StatetestResult{Name: key, Fork: st.Fork, Pass: true}
 			_, state, err := test.Run(st, cfg, false)
 			// print state root for evmlab tracing
-			if ctx.GlobalBool(MachineFlag.Name) && state != nil {
+			if ctx.GlobalBool(MachineFlag.Name) && standard == nil && state != nil {
 				fmt.Fprintf(os.Stderr, "{\"stateRoot\": \"%x\"}\n", state.IntermediateRoot(false))
 			}
 			if err != nil {
@@ -111,6 +116,12 @@ func stateTestCmd(ctx *cli.Context) error {
 					result.State = &dump
 				}
 			}
+			// Close the standard trace with the summary, including the outcome of the test
+			if standard != nil && state != nil {
+				if err := standard.WriteSummary(state.IntermediateRoot(false), st.Fork, &result.Pass); err != nil {
+					return err
+				}
+			}
 
 			results = append(results, *result)
 
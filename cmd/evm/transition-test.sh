@@ -63,12 +63,19 @@ There are a few (not many) errors that can occur, those are defined below.
 - Block history is not supplied, but needed for a \`BLOCKHASH\` operation. If \`BLOCKHASH\`
   is invoked targeting a block which history has not been provided for, the program will
   exit with code \`4\`.
+- Poststate mismatch: the state resulting from a replayed transaction differs from the
+  expected one. Exit code \`5\`.
 
 #### IO errors (\`10\`-\`20\`)
 
 - Invalid input json: the supplied data could not be marshalled.
   The program will exit with code \`10\`
 - IO problems: failure to load or save files, the program will exit with code \`11\`
+- Invalid input RLP: the supplied transactions or ommers could not be decoded, the
+  program will exit with code \`12\`
+- Invalid block builder configuration: e.g. both sealing engines specified, or a
+  sealing parameter overwriting a provided header field. Exit code \`13\`
+- Sealing failure: the block could not be sealed, the program will exit with code \`14\`
 
 EOF
 
@@ -189,3 +196,211 @@ echo ""
 echo "In order to meaningfully chain invocations, one would need to provide meaningful new \`env\`, otherwise the"
 echo "actual blocknumber (exposed to the EVM) would not increase."
 echo ""
+
+cat << 'EOF'
+## EVM block builder tool
+
+The `evm block-builder` (or `b11r`) tool assembles a block from its parts, and
+optionally seals it. It takes
+
+1. A block header (`--input.header`), in which the transaction and ommer hashes
+   may be omitted, in which case they are computed. If they are given and do
+   not match the body, the tool fails,
+2. The transactions, as an RLP list (`--input.txs`), in the same format which is
+   output by `evm t8n --output.body`,
+3. The ommers, as a json list of RLP encoded headers (`--input.ommers`),
+4. Optionally, the sealing parameters: either `--seal.ethash` (with the DAG dir
+   and pow mode given via `--seal.ethash.dir` and `--seal.ethash.mode`), or
+   `--seal.clique`, pointing to a json file containing the `secretKey` of the
+   signer, and optionally the `voted` address, whether to `authorize` it, and
+   the 32 byte `vanity`.
+
+The output is the RLP encoded block along with its hash. Any input may also be
+given via `stdin`, as a json object with the fields `header`, `txs`, `ommers` and
+`clique`.
+
+Example, chaining a state transition into the block builder:
+```
+./evm t8n --input.alloc=./testdata/1/alloc.json --input.txs=./testdata/1/txs.json --input.env=./testdata/1/env.json --output.body=txs.rlp
+./evm b11r --input.header=./testdata/20/header.json --input.txs=txs.rlp --input.ommers=./testdata/20/ommers.json --seal.clique=./testdata/20/clique.json --output.block=stdout
+```
+Output:
+```json
+{
+ "block": {
+  "rlp": "0xf90708f90254a0d6d785d33cbecf30f30d07e00e226af58f72efdf385d46bc3e6326c23b11e34e...",
+  "hash": "0x3ff8b0697cb7cdbfeb872a074c419351a66064cb42aa2f72152079c271097e91"
+ }
+}
+```
+
+## EVM transaction tool
+
+The `evm transaction` (or `t9n`) tool validates raw transactions against the rules
+of a fork. It takes an RLP encoded list of transactions (`--input.txs`, in the same
+format as output by `evm t8n --output.body`), a fork name (`--state.fork`) and a
+chain id (`--state.chainid`), and outputs for each transaction
+
+- the `address` of the sender,
+- the transaction `hash`,
+- the `intrinsicGas` the transaction requires,
+- or the `error` why the transaction is invalid.
+
+Example, where the fork does not support access list transactions yet:
+```
+./evm t9n --input.txs=./testdata/22/txs.rlp --state.fork=Istanbul
+```
+```json
+[
+ {
+  "address": "0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b",
+  "hash": "0xc710c0d855c4d7bdca359443ac8bf8711d6263877d96b61d789cbe0a3e02bab1",
+  "intrinsicGas": "0x5208"
+ },
+ {
+  "error": "intrinsic gas too low: have 53000, want 53020",
+  "address": "0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b",
+  "hash": "0xc1d8db6d7223c8d755d777618a66f6e51fbe6270b88299750780b8a8c62cb734",
+  "intrinsicGas": "0xcf1c"
+ },
+ {
+  "error": "transaction type not supported",
+  "hash": "0x31accf9063e3f1c49d57848f613302925a95d39dccbc56885aca2c97a84d060b"
+ },
+ {
+  "error": "intrinsic gas too low: have 20000, want 21016",
+  "address": "0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b",
+  "hash": "0x404dcf6b47435e40a73b226cacb9fa3705db1075fcfe5956e2ef08c025d8e9bb",
+  "intrinsicGas": "0x5218"
+ }
+]
+```
+
+## Blockchain tests
+
+The `evm blocktest` command runs the blockchain tests in the given file, and
+prints a json list of the results, one per test. The `--json` and `--debug` flags
+enable tracing of all the transactions executed, in json or plain text format,
+against `stderr`.
+
+```
+./evm blocktest ./testdata/21/blocktest.json
+```
+```json
+[
+  {
+    "name": "storeBlockNumber_Istanbul",
+    "pass": true,
+    "fork": "Istanbul"
+  }
+]
+```
+
+## Transaction replay
+
+The `evm replay` command re-executes a single transaction on top of the state it
+accessed, in the format produced by the `prestateTracer` of
+`debug_traceTransaction`. This makes it possible to reproduce, trace and debug a
+transaction seen on a live network without syncing its chain. It takes
+
+- the prestate (`--input.alloc`), where the `balance`, `nonce`, `code` and `storage`
+  of the accounts are all optional,
+- the block environment (`--input.env`), as for `evm t8n`,
+- the transaction (`--input.tx`), either as json, e.g. as returned by
+  `eth_getTransactionByHash`, or as hex encoded RLP.
+
+The resulting alloc and result are written like for `evm t8n`. The transaction can
+be traced with `--trace`, writing the json logs to `trace-0-<txhash>.jsonl`, or with
+any of the built-in or custom javascript tracers given by `--tracer`, writing the
+tracer result to `trace-0-<txhash>.json`.
+
+If an expected poststate is given with `--input.poststate`, in the same format as the
+prestate, the resulting state is compared with it. Only the fields present in the
+expected poststate are checked. All the differences are printed to `stderr`, and
+the program exits with code `5` if any was found.
+
+```
+./evm replay --input.alloc=./testdata/23/prestate.json --input.env=./testdata/23/env.json --input.tx=./testdata/23/tx.json --input.poststate=./testdata/23/poststate.json --state.fork=Berlin --tracer=callTracer
+```
+```
+INFO [10-19|01:28:14.730] Poststate matches                        accounts=2
+```
+Expecting a different storage value and nonce instead:
+```
+0x000000000000000000000000000000000000aaaa: storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch: have 0x0000000000000000000000000000000000000000000000000000000000000003, want 0x0000000000000000000000000000000000000000000000000000000000000004
+0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b: nonce mismatch: have 1, want 2
+ERROR(5): poststate mismatch: 2 differences
+```
+
+## Standard traces
+
+Besides the geth-specific json traces, the `evm` can output traces in the format
+specified by [EIP-3155](https://eips.ethereum.org/EIPS/eip-3155), which other clients
+support too, so that their traces can be compared by diffing tools. Every step
+is a json object with the state of the call frame before the operation: the memory
+and its size, the stack, the return data of the last call and the refund counter.
+The memory, stack and return data can be left out as for the other traces.
+
+The trace of every transaction is closed by a summary line, with the state root
+after the transaction, the output and the gas used by the execution, and any error.
+The standard format is selected with
+
+- `--eip3155` for `evm run` and `evm statetest`, which implies `--json`. The summary
+  of a state test also contains the fork and whether the test passed,
+- `--trace.eip3155` along with `--trace` for `evm t8n` and `evm replay`,
+- `{"eip3155": true}` in the config of `debug_standardTraceBlockToFile` and
+  `debug_standardTraceBadBlockToFile`.
+
+```
+./evm --eip3155 --nomemory statetest ./testdata/24/statetest.json
+```
+```
+{"pc":0,"op":96,"gas":"0x13498","gasCost":"0x3","memSize":0,"stack":[],"returnData":"0x","depth":1,"refund":0,"opName":"PUSH1"}
+{"pc":2,"op":84,"gas":"0x13495","gasCost":"0x834","memSize":0,"stack":["0x1"],"returnData":"0x","depth":1,"refund":0,"opName":"SLOAD"}
+{"pc":3,"op":96,"gas":"0x12c61","gasCost":"0x3","memSize":0,"stack":["0x2"],"returnData":"0x","depth":1,"refund":0,"opName":"PUSH1"}
+{"pc":5,"op":1,"gas":"0x12c5e","gasCost":"0x3","memSize":0,"stack":["0x2","0x1"],"returnData":"0x","depth":1,"refund":0,"opName":"ADD"}
+{"pc":6,"op":96,"gas":"0x12c5b","gasCost":"0x3","memSize":0,"stack":["0x3"],"returnData":"0x","depth":1,"refund":0,"opName":"PUSH1"}
+{"pc":8,"op":85,"gas":"0x12c58","gasCost":"0xb54","memSize":0,"stack":["0x3","0x1"],"returnData":"0x","depth":1,"refund":0,"opName":"SSTORE"}
+{"pc":9,"op":0,"gas":"0x12104","gasCost":"0x0","memSize":0,"stack":[],"returnData":"0x","depth":1,"refund":0,"opName":"STOP"}
+{"stateRoot":"0xf071ead478668397a2d3b7084494282919526106c9677e95591bf331abc5f26b","output":"0x","gasUsed":"0x1394","pass":true,"fork":"Berlin"}
+[
+  {
+    "name": "sstoreCounter",
+    "pass": true,
+    "fork": "Berlin"
+  }
+]
+```
+## Filling state tests
+
+The `evm fill` command turns filler files into state tests, which can be run by
+`evm statetest`. A filler defines the pre-state, the block environment, and a
+transaction with lists of data, gas limits and values, whose every combination is
+executed. The `expect` sections select combinations by their `indexes` (`-1` or no
+index selects all of them), for the forks given in `network`, either by name or
+compared with one, e.g. `>=Istanbul`. For each of them, the resulting state has to
+match the `result`: only the fields given are checked, except the storage which has
+to match exactly, and `shouldnotexist: true` requires an account not to exist.
+Only the combinations covered by an expect section are filled.
+
+Fillers are JSON or YAML files, containing tests by name. Numbers are hex or
+decimal, of any size. The code of accounts and the transaction data are hex,
+optionally prefixed by `:raw`, or assembly prefixed by `:asm`, one instruction
+per line. The filled tests are printed, or written to a `<name>.json` file, with
+the `Filler` suffix trimmed from the name of the filler file, in the directory
+given by `--output.dir`.
+
+```
+./evm fill --output.dir=/tmp ./testdata/25/sstoreValueFiller.yml
+./evm statetest /tmp/sstoreValue.json
+```
+If an expectation is not met, e.g. expecting `0x08` instead of `0x07` in the first
+section, all the failures are reported and no test is written:
+```
+./testdata/25/sstoreValueFiller.yml: failed to fill sstoreValue:
+Istanbul d0 g0 v1: 0x000000000000000000000000000000000000aaaa: storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch: have 0x0000000000000000000000000000000000000000000000000000000000000007, want 0x0000000000000000000000000000000000000000000000000000000000000008
+Istanbul d1 g0 v1: 0x000000000000000000000000000000000000aaaa: storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch: have 0x0000000000000000000000000000000000000000000000000000000000000007, want 0x0000000000000000000000000000000000000000000000000000000000000008
+Berlin d0 g0 v1: 0x000000000000000000000000000000000000aaaa: storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch: have 0x0000000000000000000000000000000000000000000000000000000000000007, want 0x0000000000000000000000000000000000000000000000000000000000000008
+Berlin d1 g0 v1: 0x000000000000000000000000000000000000aaaa: storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch: have 0x0000000000000000000000000000000000000000000000000000000000000007, want 0x0000000000000000000000000000000000000000000000000000000000000008
+```
+EOF
@@ -0,0 +1,365 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	replayReportFlag = cli.StringFlag{
+		Name:  "report",
+		Usage: "File to write the JSON report of the per-block timings to",
+	}
+	replayBenchmarkCommand = cli.Command{
+		Action:    utils.MigrateFlags(replayBenchmark),
+		Name:      "replay-benchmark",
+		Usage:     "Benchmark the import of a chain segment on top of a state snapshot",
+		ArgsUsage: "<filename> <sourceChaindataDir> <sourceAncientDir>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.CacheDatabaseFlag,
+			utils.CacheGCFlag,
+			utils.CacheNoPrefetchFlag,
+			utils.GCModeFlag,
+			utils.SnapshotFlag,
+			utils.FakePoWFlag,
+			utils.VMParallelFlag,
+			utils.MainnetFlag,
+			utils.RopstenFlag,
+			utils.RinkebyFlag,
+			utils.GoerliFlag,
+			replayReportFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The replay-benchmark command imports the blocks of an RLP file, as exported by
+"geth export", into a scratch chain database given by --datadir, which must not
+contain a chain yet, reporting the time spent importing every block broken down
+into execution, state access, validation, trie hashing, snapshot and commit.
+
+The scratch chain is seeded from the database of a stopped node: the genesis
+block, the ancestors of the first block to import, and the state of its parent,
+which is regenerated from the state snapshot of the node. The snapshot has to
+cover the parent, i.e. the node has to be stopped at most 128 blocks after it.
+The genesis is written from the network selected by the network flags, and has
+to match the one of the source.
+
+Expensive metrics are always collected. Comparing the reports of runs with the
+same flags and inputs measures the effect of EVM and state changes.`,
+	}
+)
+
+// replayTimings is the time spent importing blocks, grouped by phase.
+type replayTimings struct {
+	Txs         int           `json:"txs"`
+	GasUsed     uint64        `json:"gasUsed"`
+	Execution   time.Duration `json:"execution"`
+	StateAccess time.Duration `json:"stateAccess"`
+	Validation  time.Duration `json:"validation"`
+	TrieHashing time.Duration `json:"trieHashing"`
+	Snapshot    time.Duration `json:"snapshot"`
+	Commit      time.Duration `json:"commit"`
+	Total       time.Duration `json:"total"`
+}
+
+// add accumulates the timings of other blocks.
+func (t *replayTimings) add(other replayTimings) {
+	t.Txs += other.Txs
+	t.GasUsed += other.GasUsed
+	t.Execution += other.Execution
+	t.StateAccess += other.StateAccess
+	t.Validation += other.Validation
+	t.TrieHashing += other.TrieHashing
+	t.Snapshot += other.Snapshot
+	t.Commit += other.Commit
+	t.Total += other.Total
+}
+
+// replayBlockStats is the time spent importing a single block.
+type replayBlockStats struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+	replayTimings
+}
+
+// newReplayBlockStats groups the timings of a block import.
+func newReplayBlockStats(ev core.BlockInsertEvent) replayBlockStats {
+	return replayBlockStats{
+		Number: ev.Block.NumberU64(),
+		Hash:   ev.Block.Hash(),
+		replayTimings: replayTimings{
+			Txs:         len(ev.Block.Transactions()),
+			GasUsed:     ev.Block.GasUsed(),
+			Execution:   ev.Execution,
+			StateAccess: ev.AccountReads + ev.StorageReads + ev.AccountUpdates + ev.StorageUpdates,
+			Validation:  ev.Validation,
+			TrieHashing: ev.AccountHashes + ev.StorageHashes,
+			Snapshot:    ev.SnapshotAccountReads + ev.SnapshotStorageReads + ev.SnapshotCommits,
+			Commit:      ev.Write + ev.AccountCommits + ev.StorageCommits,
+			Total:       ev.Total,
+		},
+	}
+}
+
+// replayReport is the outcome of a replay benchmark, with durations in nanoseconds.
+type replayReport struct {
+	First  uint64             `json:"first"`
+	Last   uint64             `json:"last"`
+	Mgasps float64            `json:"mgasps"`
+	Totals replayTimings      `json:"totals"`
+	Blocks []replayBlockStats `json:"blocks"`
+}
+
+func replayBenchmark(ctx *cli.Context) error {
+	if len(ctx.Args()) < 3 {
+		utils.Fatalf("This command requires a chain file and the source chaindata and ancient directories.")
+	}
+	// Make the state reads, updates, hashes and commits measured
+	metrics.EnabledExpensive = true
+
+	first, err := readFirstBlock(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Failed to read chain file: %v", err)
+	}
+	if first.NumberU64() == 0 {
+		utils.Fatalf("The chain file has to start after the genesis block")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	// Seed the scratch database with the parent of the chain segment
+	db := utils.MakeChainDatabase(ctx, stack)
+	if rawdb.ReadCanonicalHash(db, 0) != (common.Hash{}) {
+		utils.Fatalf("The datadir already contains a chain, a scratch one is required")
+	}
+	source, err := rawdb.NewLevelDBDatabaseWithFreezer(ctx.Args().Get(1), ctx.GlobalInt(utils.CacheFlag.Name)/2, 256, ctx.Args().Get(2), "")
+	if err != nil {
+		utils.Fatalf("Failed to open source database: %v", err)
+	}
+	start := time.Now()
+	err = seedReplayChain(source, db, utils.MakeGenesis(ctx), first.ParentHash(), first.NumberU64()-1, ctx.GlobalBool(utils.SnapshotFlag.Name))
+	source.Close()
+	if err != nil {
+		utils.Fatalf("Failed to seed scratch chain: %v", err)
+	}
+	log.Info("Seeded scratch chain", "number", first.NumberU64()-1, "hash", first.ParentHash(), "elapsed", common.PrettyDuration(time.Since(start)))
+	db.Close()
+
+	// Import the chain segment, collecting the timings of every block
+	chain, db := utils.MakeChain(ctx, stack, false)
+	defer db.Close()
+
+	var (
+		events = make(chan core.BlockInsertEvent, 1024)
+		sub    = chain.SubscribeBlockInsertEvent(events)
+		done   = make(chan struct{})
+		report = new(replayReport)
+	)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case ev := <-events:
+				report.Blocks = append(report.Blocks, newReplayBlockStats(ev))
+			case <-sub.Err():
+				for {
+					select {
+					case ev := <-events:
+						report.Blocks = append(report.Blocks, newReplayBlockStats(ev))
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	start = time.Now()
+	importErr := utils.ImportChain(chain, ctx.Args().First())
+	sub.Unsubscribe()
+	<-done
+	chain.Stop()
+
+	if importErr != nil {
+		log.Error("Import error", "err", importErr)
+	}
+	fmt.Printf("Import done in %v.\n\n", time.Since(start))
+	if len(report.Blocks) == 0 {
+		return errors.New("no blocks imported")
+	}
+	// Aggregate and print the timings of the blocks, writing the report if requested
+	report.First = report.Blocks[0].Number
+	report.Last = report.Blocks[len(report.Blocks)-1].Number
+	for _, block := range report.Blocks {
+		report.Totals.add(block.replayTimings)
+	}
+	if report.Totals.Total > 0 {
+		report.Mgasps = float64(report.Totals.GasUsed) * 1000 / float64(report.Totals.Total)
+	}
+	totals := report.Totals
+	fmt.Printf("Blocks:        %d (%d - %d), %d txs, %.3f Mgas\n", len(report.Blocks), report.First, report.Last, totals.Txs, float64(totals.GasUsed)/1000000)
+	fmt.Printf("Throughput:    %.3f Mgas/s\n", report.Mgasps)
+	for _, phase := range []struct {
+		name string
+		time time.Duration
+	}{
+		{"Execution", totals.Execution},
+		{"State access", totals.StateAccess},
+		{"Validation", totals.Validation},
+		{"Trie hashing", totals.TrieHashing},
+		{"Snapshot", totals.Snapshot},
+		{"Commit", totals.Commit},
+		{"Total", totals.Total},
+	} {
+		fmt.Printf("%-14s %v (%.1f%%)\n", phase.name+":", phase.time, float64(phase.time)*100/float64(totals.Total))
+	}
+	if path := ctx.String(replayReportFlag.Name); path != "" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, out, 0644); err != nil {
+			utils.Fatalf("Failed to write report: %v", err)
+		}
+	}
+	return importErr
+}
+
+// readFirstBlock decodes the first block of an exported chain file.
+func readFirstBlock(fn string) (*types.Block, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return nil, err
+		}
+	}
+	block := new(types.Block)
+	if err := rlp.NewStream(reader, 0).Decode(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// seedReplayChain writes the genesis, copies the chain config and the ancestors
+// of the given block needed to import its descendants from the source database,
+// and regenerates its state from the snapshot of the source, making it the head.
+func seedReplayChain(source ethdb.Database, db ethdb.Database, genesis *core.Genesis, hash common.Hash, number uint64, snapshots bool) error {
+	_, genesisHash, err := core.SetupGenesisBlock(db, genesis)
+	if err != nil {
+		return err
+	}
+	if stored := rawdb.ReadCanonicalHash(source, 0); stored != genesisHash {
+		return fmt.Errorf("genesis mismatch: source %x, network %x", stored, genesisHash)
+	}
+	config := rawdb.ReadChainConfig(source, genesisHash)
+	if config == nil {
+		return errors.New("missing chain config in source database")
+	}
+	rawdb.WriteChainConfig(db, genesisHash, config)
+
+	// Copy the recent ancestors, which the BLOCKHASH opcode and the uncle checks
+	// may access, or all of them back to the last checkpoint for clique
+	from := uint64(0)
+	if number > 256 {
+		from = number - 256
+	}
+	if config.Clique != nil && config.Clique.Epoch > 0 {
+		if checkpoint := number - number%config.Clique.Epoch; checkpoint < from {
+			from = checkpoint
+		}
+	}
+	copyBlock := func(hash common.Hash, number uint64) error {
+		block := rawdb.ReadBlock(source, hash, number)
+		if block == nil {
+			return fmt.Errorf("missing block %d [%x] in source database", number, hash)
+		}
+		td := rawdb.ReadTd(source, hash, number)
+		if td == nil {
+			return fmt.Errorf("missing total difficulty of block %d [%x] in source database", number, hash)
+		}
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteTd(db, hash, number, td)
+		rawdb.WriteCanonicalHash(db, hash, number)
+		return nil
+	}
+	parent := rawdb.ReadHeader(source, hash, number)
+	if parent == nil {
+		return fmt.Errorf("missing parent block %d [%x] in source database", number, hash)
+	}
+	for n, h := number, hash; n >= from && n > 0; n-- {
+		if err := copyBlock(h, n); err != nil {
+			return err
+		}
+		h = rawdb.ReadHeader(db, h, n).ParentHash
+	}
+	// Regenerate the state of the parent from the source snapshot
+	head := rawdb.ReadHeadBlockHash(source)
+	headNumber := rawdb.ReadHeaderNumber(source, head)
+	if headNumber == nil {
+		return errors.New("missing head block in source database")
+	}
+	headRoot := rawdb.ReadHeader(source, head, *headNumber).Root
+	snaps, err := snapshot.New(source, trie.NewDatabase(source), 256, headRoot, false, false, false)
+	if err != nil {
+		return fmt.Errorf("failed to load source snapshot: %v", err)
+	}
+	if err := snapshot.GenerateTrie(snaps, parent.Root, source, db); err != nil {
+		return fmt.Errorf("failed to regenerate state: %v", err)
+	}
+	// Generate the snapshot of the scratch chain upfront, not to benchmark it
+	if snapshots {
+		snaps, err := snapshot.New(db, trie.NewDatabase(db), 256, parent.Root, false, true, false)
+		if err != nil {
+			return fmt.Errorf("failed to generate snapshot: %v", err)
+		}
+		if _, err := snaps.Journal(parent.Root); err != nil {
+			return fmt.Errorf("failed to journal snapshot: %v", err)
+		}
+	}
+	rawdb.WriteHeadHeaderHash(db, hash)
+	rawdb.WriteHeadFastBlockHash(db, hash)
+	rawdb.WriteHeadBlockHash(db, hash)
+	return nil
+}
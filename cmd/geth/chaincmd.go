@@ -47,6 +47,7 @@ var (
 		ArgsUsage: "<genesisPath>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
+			utils.StateSchemeFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
@@ -96,6 +97,7 @@ The dumpgenesis command dumps the genesis block configuration in JSON format to
 			utils.MetricsInfluxDBPasswordFlag,
 			utils.MetricsInfluxDBTagsFlag,
 			utils.TxLookupLimitFlag,
+			utils.StateHistoryFlag,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
@@ -122,6 +124,48 @@ Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
 be gzipped.`,
+	}
+	importHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(importHistory),
+		Name:      "import-history",
+		Usage:     "Import history archives into the ancient store",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+			utils.GCModeFlag,
+			utils.TxLookupLimitFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The import-history command imports the blocks, receipts and total difficulties
+of the archives of the selected network found in the given directory, as written
+by export-history. Every archive is verified against its accumulator root, and
+against the accumulators.txt listing of the directory if there is one, before
+its blocks are inserted.
+
+The blocks are written straight into the ancient store without being executed,
+advancing the fast sync head so that a fresh node can be seeded from local files
+and then synced from the network.`,
+	}
+	exportHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(exportHistory),
+		Name:      "export-history",
+		Usage:     "Export the ancient store into history archives",
+		ArgsUsage: "<dir> <blockNumFirst> <blockNumLast>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The export-history command writes the blocks, receipts and total difficulties in
+the given range of the ancient store into the given directory, one archive per
+epoch of 8192 blocks. The first block must be the start of an epoch. Each archive
+is named after the network, the epoch and its accumulator root, which is also
+listed in the accumulators.txt file of the directory.`,
 	}
 	importPreimagesCommand = cli.Command{
 		Action:    utils.MigrateFlags(importPreimages),
@@ -191,6 +235,24 @@ The first argument must be the directory containing the blockchain to download f
 The arguments are interpreted as block numbers or hashes.
 Use "ethereum dump 0" to dump the genesis block.`,
 	}
+	stateDiffCommand = cli.Command{
+		Action:    utils.MigrateFlags(dumpStateDiff),
+		Name:      "statediff",
+		Usage:     "Dump the state changes of a range of blocks",
+		ArgsUsage: "<firstBlockNum> [<lastBlockNum>]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The statediff command writes the state changes of each block in the given range
+to stdout, one JSON object per line and block: the previous and new values of
+every modified account along with its changed storage slots. Each block is
+re-executed to resolve the addresses and slots of its changes, the state of every
+block in the range and of the parent of the first one must be available.`,
+	}
 )
 
 // initGenesis will initialise the given JSON format genesis file and writes it as
@@ -220,6 +282,11 @@ func initGenesis(ctx *cli.Context) error {
 		if err != nil {
 			utils.Fatalf("Failed to open database: %v", err)
 		}
+		if name == "chaindata" && ctx.GlobalString(utils.StateSchemeFlag.Name) == rawdb.PathScheme {
+			if err := core.EnablePathScheme(chaindb); err != nil {
+				utils.Fatalf("Failed to enable path-based state scheme: %v", err)
+			}
+		}
 		_, hash, err := core.SetupGenesisBlock(chaindb, genesis)
 		if err != nil {
 			utils.Fatalf("Failed to write genesis block: %v", err)
@@ -356,6 +423,52 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// importHistory imports the history archives of the specified directory.
+func importHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, db := utils.MakeChain(ctx, stack, false)
+	defer db.Close()
+
+	start := time.Now()
+	err := utils.ImportHistory(chain, ctx.Args().First())
+	chain.Stop()
+	if err != nil {
+		utils.Fatalf("Import error: %v\n", err)
+	}
+	fmt.Printf("Import done in %v\n", time.Since(start))
+	return nil
+}
+
+// exportHistory exports a range of the ancient store into history archives in
+// the specified directory.
+func exportHistory(ctx *cli.Context) error {
+	if len(ctx.Args()) != 3 {
+		utils.Fatalf("This command requires three arguments.")
+	}
+	first, ferr := strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+	last, lerr := strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+	if ferr != nil || lerr != nil {
+		utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	start := time.Now()
+	if err := utils.ExportHistory(db, ctx.Args().First(), first, last); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
 // importPreimages imports preimage data from the specified file.
 func importPreimages(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
@@ -468,7 +581,7 @@ func dump(ctx *cli.Context) error {
 			fmt.Println("{}")
 			utils.Fatalf("block not found")
 		} else {
-			state, err := state.New(block.Root(), state.NewDatabase(chainDb), nil)
+			state, err := state.New(block.Root(), state.NewDatabaseForChain(chainDb, chain.Config(), nil), nil)
 			if err != nil {
 				utils.Fatalf("could not create new state: %v", err)
 			}
@@ -489,6 +602,51 @@ func dump(ctx *cli.Context) error {
 	return nil
 }
 
+func dumpStateDiff(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		utils.Fatalf("This command requires one or two arguments.")
+	}
+	first, err := strconv.ParseUint(ctx.Args().Get(0), 10, 64)
+	if err != nil {
+		utils.Fatalf("Invalid first block number: %v", err)
+	}
+	last := first
+	if ctx.NArg() == 2 {
+		if last, err = strconv.ParseUint(ctx.Args().Get(1), 10, 64); err != nil {
+			utils.Fatalf("Invalid last block number: %v", err)
+		}
+	}
+	if first == 0 || last < first {
+		utils.Fatalf("Invalid block range %d-%d", first, last)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, chainDb := utils.MakeChain(ctx, stack, true)
+	defer chainDb.Close()
+
+	encoder := json.NewEncoder(os.Stdout)
+	for number := first; number <= last; number++ {
+		block := chain.GetBlockByNumber(number)
+		if block == nil {
+			return fmt.Errorf("block %d not found", number)
+		}
+		diff, err := chain.StateDiff(block)
+		if err != nil {
+			return fmt.Errorf("failed to diff block %d: %v", number, err)
+		}
+		err = encoder.Encode(struct {
+			Number uint64      `json:"number"`
+			Hash   common.Hash `json:"hash"`
+			*state.StateDiff
+		}{number, block.Hash(), diff})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // hashish returns true for strings that look like hashes.
 func hashish(x string) bool {
 	_, err := strconv.Atoi(x)
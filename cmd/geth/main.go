@@ -94,6 +94,8 @@ var (
 		utils.GCModeFlag,
 		utils.SnapshotFlag,
 		utils.TxLookupLimitFlag,
+		utils.StateSchemeFlag,
+		utils.StateHistoryFlag,
 		utils.LightServeFlag,
 		utils.LightIngressFlag,
 		utils.LightEgressFlag,
@@ -152,6 +154,7 @@ var (
 		utils.GpoMaxGasPriceFlag,
 		utils.EWASMInterpreterFlag,
 		utils.EVMInterpreterFlag,
+		utils.VMParallelFlag,
 		configFileFlag,
 	}
 
@@ -183,6 +186,7 @@ var (
 		utils.InsecureUnlockAllowedFlag,
 		utils.RPCGlobalGasCapFlag,
 		utils.RPCGlobalTxFeeCapFlag,
+		utils.RPCGlobalProofReexecFlag,
 		utils.AllowUnprotectedTxs,
 	}
 
@@ -210,11 +214,15 @@ func init() {
 		initCommand,
 		importCommand,
 		exportCommand,
+		importHistoryCommand,
+		exportHistoryCommand,
 		importPreimagesCommand,
 		exportPreimagesCommand,
 		copydbCommand,
+		replayBenchmarkCommand,
 		removedbCommand,
 		dumpCommand,
+		stateDiffCommand,
 		dumpGenesisCommand,
 		// See accountcmd.go:
 		accountCommand,
@@ -237,6 +245,8 @@ func init() {
 		utils.ShowDeprecated,
 		// See snapshot.go
 		snapshotCommand,
+		// See witnesscmd.go
+		witnessCommand,
 	}
 	sort.Sort(cli.CommandsByName(app.Commands))
 
@@ -0,0 +1,164 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	witnessCommand = cli.Command{
+		Name:        "witness",
+		Usage:       "A set of commands for stateless block execution",
+		Category:    "BLOCKCHAIN COMMANDS",
+		Description: "",
+		Subcommands: []cli.Command{
+			{
+				Name:      "create",
+				Usage:     "Create the execution witness of a block",
+				ArgsUsage: "<block number or hash> <witness file>",
+				Action:    utils.MigrateFlags(createWitness),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+				},
+				Description: `
+geth witness create <block> <file>
+will execute the given block and write the RLP encoded witness needed to execute
+it without the state database to the file: the headers, trie nodes and contract
+codes accessed. The state of the parent block must be available and stored with
+the hash-based scheme.
+`,
+			},
+			{
+				Name:      "verify",
+				Usage:     "Execute a block statelessly using its execution witness",
+				ArgsUsage: "<block number or hash> <witness file>",
+				Action:    utils.MigrateFlags(verifyWitness),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.RopstenFlag,
+					utils.RinkebyFlag,
+					utils.GoerliFlag,
+				},
+				Description: `
+geth witness verify <block> <file>
+will execute the given block using only the state contained in the witness file
+and check the resulting state root, receipts and gas usage against the block.
+`,
+			},
+		},
+	}
+)
+
+// witnessBlock retrieves the block specified by number or hash.
+func witnessBlock(chain *core.BlockChain, arg string) (*types.Block, error) {
+	var block *types.Block
+	if len(arg) == 2+2*common.HashLength {
+		block = chain.GetBlockByHash(common.HexToHash(arg))
+	} else {
+		number, err := strconv.ParseUint(arg, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block number %q", arg)
+		}
+		block = chain.GetBlockByNumber(number)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %s not found", arg)
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not executable")
+	}
+	return block, nil
+}
+
+func createWitness(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		utils.Fatalf("This command requires two arguments.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, chaindb := utils.MakeChain(ctx, stack, true)
+	defer chaindb.Close()
+
+	block, err := witnessBlock(chain, ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	witness, err := chain.ExecutionWitness(block)
+	if err != nil {
+		log.Error("Failed to create witness", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return err
+	}
+	blob, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(ctx.Args().Get(1), blob, 0644); err != nil {
+		return err
+	}
+	log.Info("Created witness", "number", block.Number(), "hash", block.Hash(), "headers", len(witness.Headers),
+		"codes", len(witness.Codes), "nodes", len(witness.State), "size", common.StorageSize(len(blob)))
+	return nil
+}
+
+func verifyWitness(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		utils.Fatalf("This command requires two arguments.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	chain, chaindb := utils.MakeChain(ctx, stack, true)
+	defer chaindb.Close()
+
+	block, err := witnessBlock(chain, ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	blob, err := ioutil.ReadFile(ctx.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	var witness core.Witness
+	if err := rlp.DecodeBytes(blob, &witness); err != nil {
+		return fmt.Errorf("invalid witness: %v", err)
+	}
+	root, err := core.ExecuteStateless(chain.Config(), chain.Engine(), block, &witness)
+	if err != nil {
+		log.Error("Stateless execution failed", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return err
+	}
+	log.Info("Verified block statelessly", "number", block.Number(), "hash", block.Hash(), "root", root)
+	return nil
+}
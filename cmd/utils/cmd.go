@@ -21,9 +21,13 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -36,14 +40,21 @@ import (
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/debug"
+	"github.com/ethereum/go-ethereum/internal/era"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
 	"gopkg.in/urfave/cli.v1"
 )
 
 const (
 	importBatchSize = 2500
+
+	// historyAccumulatorsFile is the checksum file of a history directory, listing
+	// the accumulator root of each archive in it.
+	historyAccumulatorsFile = "accumulators.txt"
 )
 
 // Fatalf formats a message to standard error and exits the program.
@@ -269,6 +280,333 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	return nil
 }
 
+// historyNetworkName returns the network name prefixing the history archives of
+// the chain with the given genesis hash.
+func historyNetworkName(genesis common.Hash) string {
+	switch genesis {
+	case params.MainnetGenesisHash:
+		return "mainnet"
+	case params.RopstenGenesisHash:
+		return "ropsten"
+	case params.RinkebyGenesisHash:
+		return "rinkeby"
+	case params.GoerliGenesisHash:
+		return "goerli"
+	default:
+		return fmt.Sprintf("%x", genesis[:4])
+	}
+}
+
+// readHistoryAccumulators reads the accumulator roots listed in the checksum file
+// of a history directory, keyed by archive name.
+func readHistoryAccumulators(dir string) (map[string]common.Hash, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(dir, historyAccumulatorsFile))
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]common.Hash)
+	for i, line := range strings.Split(string(blob), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || len(common.FromHex(fields[0])) != common.HashLength {
+			return nil, fmt.Errorf("invalid accumulator listing at line %d", i+1)
+		}
+		roots[fields[1]] = common.HexToHash(fields[0])
+	}
+	return roots, nil
+}
+
+// writeHistoryAccumulators writes the checksum file of a history directory.
+func writeHistoryAccumulators(dir string, roots map[string]common.Hash) error {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var listing strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&listing, "%#x %s\n", roots[name], name)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, historyAccumulatorsFile), []byte(listing.String()), 0644)
+}
+
+// ExportHistory exports the frozen blocks, receipts and total difficulties in
+// the range [first, last] into one archive per epoch in the specified directory.
+// The first block must be the start of an epoch, any archive already present for
+// an exported epoch is replaced.
+func ExportHistory(db ethdb.Database, dir string, first, last uint64) error {
+	if first%era.EpochSize != 0 {
+		return fmt.Errorf("first block %d not at the start of an epoch of %d blocks", first, era.EpochSize)
+	}
+	if first > last {
+		return fmt.Errorf("invalid range: first block %d beyond last %d", first, last)
+	}
+	frozen, err := db.Ancients()
+	if err != nil {
+		return err
+	}
+	if last >= frozen {
+		return fmt.Errorf("last block %d not in the ancient store of %d blocks", last, frozen)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	roots, err := readHistoryAccumulators(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if roots == nil {
+		roots = make(map[string]common.Hash)
+	}
+	network := historyNetworkName(rawdb.ReadCanonicalHash(db, 0))
+	log.Info("Exporting history", "dir", dir, "network", network, "first", first, "last", last)
+
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for epoch := first / era.EpochSize; epoch <= last/era.EpochSize; epoch++ {
+		f, err := ioutil.TempFile(dir, ".export-*")
+		if err != nil {
+			return err
+		}
+		root, err := exportEpoch(db, f, epoch, last)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return fmt.Errorf("epoch %d: %v", epoch, err)
+		}
+		// Drop the previous archive of the epoch before moving the new one in place
+		stale, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s-%05d-*.era1", network, epoch)))
+		for _, path := range stale {
+			os.Remove(path)
+			delete(roots, filepath.Base(path))
+		}
+		name := era.Filename(network, epoch, root)
+		if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		roots[name] = root
+
+		if time.Since(logged) > 8*time.Second || epoch == last/era.EpochSize {
+			log.Info("Exported history archive", "file", name, "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	return writeHistoryAccumulators(dir, roots)
+}
+
+// exportEpoch writes the canonical blocks of an epoch up to the last requested
+// one into an archive, returning its accumulator root.
+func exportEpoch(db ethdb.Database, w io.Writer, epoch uint64, last uint64) (common.Hash, error) {
+	var (
+		builder = era.NewBuilder(w)
+		end     = (epoch+1)*era.EpochSize - 1
+	)
+	if end > last {
+		end = last
+	}
+	for number := epoch * era.EpochSize; number <= end; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return common.Hash{}, fmt.Errorf("canonical hash of block %d missing", number)
+		}
+		block := rawdb.ReadBlock(db, hash, number)
+		if block == nil {
+			return common.Hash{}, fmt.Errorf("block %d missing", number)
+		}
+		receipts := rawdb.ReadRawReceipts(db, hash, number)
+		if receipts == nil || len(receipts) != len(block.Transactions()) {
+			return common.Hash{}, fmt.Errorf("receipts of block %d missing", number)
+		}
+		// The stored receipts lack the transaction type needed for their
+		// consensus encoding
+		for i, tx := range block.Transactions() {
+			receipts[i].Type = tx.Type()
+		}
+		td := rawdb.ReadTd(db, hash, number)
+		if td == nil {
+			return common.Hash{}, fmt.Errorf("total difficulty of block %d missing", number)
+		}
+		if err := builder.Add(block, receipts, td); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return builder.Finalize()
+}
+
+// ImportHistory imports the history archives of the chain's network found in
+// the specified directory into the ancient store. Each archive is verified
+// against its accumulator root, and against the checksum file of the directory
+// if there is one, before its blocks are inserted.
+func ImportHistory(chain *core.BlockChain, dir string) error {
+	// Watch for Ctrl-C while the import is running.
+	// If a signal is received, the import will stop at the next batch.
+	interrupt := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during import, stopping at next batch")
+		}
+		close(stop)
+	}()
+	checkInterrupt := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	network := historyNetworkName(chain.Genesis().Hash())
+	paths, err := filepath.Glob(filepath.Join(dir, network+"-*.era1"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no %s history archives in %s", network, dir)
+	}
+	sort.Strings(paths)
+
+	roots, err := readHistoryAccumulators(dir)
+	switch {
+	case os.IsNotExist(err):
+		log.Warn("History accumulator listing missing, only checking archives against themselves", "file", historyAccumulatorsFile)
+	case err != nil:
+		return err
+	}
+	log.Info("Importing history", "dir", dir, "network", network, "archives", len(paths))
+
+	// Import the blocks up to the end of the last archive into the ancient store
+	last, err := era.Open(paths[len(paths)-1])
+	if err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(paths[len(paths)-1]), err)
+	}
+	ancientLimit := last.Start() + last.Count() - 1
+	last.Close()
+
+	var (
+		start = time.Now()
+		next  = chain.CurrentFastBlock().NumberU64() + 1
+	)
+	for _, path := range paths {
+		if checkInterrupt() {
+			return fmt.Errorf("interrupted")
+		}
+		name := filepath.Base(path)
+		e, err := era.Open(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		err = importEpoch(chain, e, network, name, roots, &next, ancientLimit, checkInterrupt)
+		e.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	log.Info("Imported history", "head", next-1, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// importEpoch verifies a history archive and inserts the blocks of it beyond
+// the current fast sync head, advancing next past the imported ones.
+func importEpoch(chain *core.BlockChain, e *era.Era, network, name string, roots map[string]common.Hash, next *uint64, ancientLimit uint64, checkInterrupt func() bool) error {
+	root, err := e.Verify()
+	if err != nil {
+		return err
+	}
+	if e.Start()%era.EpochSize != 0 {
+		return fmt.Errorf("archive starts at block %d, not at the start of an epoch", e.Start())
+	}
+	if want := era.Filename(network, e.Start()/era.EpochSize, root); want != name {
+		return fmt.Errorf("archive name does not match its content, want %s", want)
+	}
+	if roots != nil {
+		want, ok := roots[name]
+		if !ok {
+			return fmt.Errorf("archive not in %s", historyAccumulatorsFile)
+		}
+		if want != root {
+			return fmt.Errorf("accumulator mismatch: have %x, want %x", root, want)
+		}
+	}
+	end := e.Start() + e.Count()
+	if e.Start() > *next {
+		return fmt.Errorf("missing history: next block %d, archive starts at %d", *next, e.Start())
+	}
+	// Blocks already present must match the archived ones
+	for number := e.Start(); number < end && number < *next; number++ {
+		header, err := e.GetHeaderByNumber(number)
+		if err != nil {
+			return err
+		}
+		if local := chain.GetHeaderByNumber(number); local == nil || local.Hash() != header.Hash() {
+			return fmt.Errorf("block %d [%x…] does not match the local chain", number, header.Hash().Bytes()[:4])
+		}
+	}
+	for *next < end {
+		if checkInterrupt() {
+			return fmt.Errorf("interrupted")
+		}
+		var (
+			headers  []*types.Header
+			blocks   types.Blocks
+			receipts []types.Receipts
+			tds      []*big.Int
+		)
+		for number := *next; number < end && len(blocks) < importBatchSize; number++ {
+			block, err := e.GetBlockByNumber(number)
+			if err != nil {
+				return err
+			}
+			if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+				return fmt.Errorf("block %d: transaction root mismatch: have %x, want %x", number, hash, block.TxHash())
+			}
+			if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+				return fmt.Errorf("block %d: uncle hash mismatch: have %x, want %x", number, hash, block.UncleHash())
+			}
+			blockReceipts, err := e.GetReceiptsByNumber(number)
+			if err != nil {
+				return err
+			}
+			if hash := types.DeriveSha(blockReceipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+				return fmt.Errorf("block %d: receipt root mismatch: have %x, want %x", number, hash, block.ReceiptHash())
+			}
+			td, err := e.GetTotalDifficultyByNumber(number)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, block.Header())
+			blocks = append(blocks, block)
+			receipts = append(receipts, blockReceipts)
+			tds = append(tds, td)
+		}
+		if _, err := chain.InsertHeaderChain(headers, 100); err != nil {
+			return err
+		}
+		for i, block := range blocks {
+			if td := chain.GetTd(block.Hash(), block.NumberU64()); td == nil || td.Cmp(tds[i]) != 0 {
+				return fmt.Errorf("block %d: total difficulty mismatch: have %v, want %v", block.NumberU64(), td, tds[i])
+			}
+		}
+		if _, err := chain.InsertReceiptChain(blocks, receipts, ancientLimit); err != nil {
+			return err
+		}
+		*next += uint64(len(blocks))
+	}
+	log.Info("Imported history archive", "file", name, "root", root, "blocks", e.Count())
+	return nil
+}
+
 // ImportPreimages imports a batch of exported hash preimages into the database.
 func ImportPreimages(db ethdb.Database, fn string) error {
 	log.Info("Importing preimages", "file", fn)
@@ -212,6 +212,16 @@ var (
 		Name:  "snapshot",
 		Usage: `Enables snapshot-database mode (default = enable)`,
 	}
+	StateSchemeFlag = cli.StringFlag{
+		Name:  "state.scheme",
+		Usage: `Scheme to use for storing the state on a fresh database ("hash", "path")`,
+		Value: ethconfig.Defaults.StateScheme,
+	}
+	StateHistoryFlag = cli.Uint64Flag{
+		Name:  "state.history",
+		Usage: "Number of recent blocks to retain state histories for with the path-based scheme (0 = entire chain)",
+		Value: ethconfig.Defaults.StateHistory,
+	}
 	TxLookupLimitFlag = cli.Uint64Flag{
 		Name:  "txlookuplimit",
 		Usage: "Number of recent blocks to maintain transactions index for (default = about one year, 0 = entire chain)",
@@ -494,6 +504,11 @@ var (
 		Usage: "Sets a cap on transaction fee (in ether) that can be sent via the RPC APIs (0 = no cap)",
 		Value: ethconfig.Defaults.RPCTxFeeCap,
 	}
+	RPCGlobalProofReexecFlag = cli.Uint64Flag{
+		Name:  "rpc.proofreexec",
+		Usage: "Maximum number of blocks re-executed to regenerate pruned state for eth_getProof, at most 128 (0 = disabled)",
+		Value: ethconfig.Defaults.RPCProofReexec,
+	}
 	// Logging and debug settings
 	EthStatsURLFlag = cli.StringFlag{
 		Name:  "ethstats",
@@ -748,6 +763,10 @@ var (
 		Usage: "External EVM configuration (default = built-in interpreter)",
 		Value: "",
 	}
+	VMParallelFlag = cli.BoolFlag{
+		Name:  "vm.parallel",
+		Usage: "Execute the transactions of imported blocks speculatively in parallel (experimental)",
+	}
 )
 
 // MakeDataDir retrieves the currently requested data directory, terminating
@@ -1488,6 +1507,18 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.GlobalIsSet(GCModeFlag.Name) {
 		cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
 	}
+	if scheme := ctx.GlobalString(StateSchemeFlag.Name); scheme != rawdb.HashScheme && scheme != rawdb.PathScheme {
+		Fatalf("--%s must be either '%s' or '%s'", StateSchemeFlag.Name, rawdb.HashScheme, rawdb.PathScheme)
+	}
+	if ctx.GlobalIsSet(StateSchemeFlag.Name) {
+		cfg.StateScheme = ctx.GlobalString(StateSchemeFlag.Name)
+	}
+	if ctx.GlobalIsSet(StateHistoryFlag.Name) {
+		cfg.StateHistory = ctx.GlobalUint64(StateHistoryFlag.Name)
+	}
+	if ctx.GlobalIsSet(BloomFilterSizeFlag.Name) {
+		cfg.PruneBloomSize = ctx.GlobalUint64(BloomFilterSizeFlag.Name)
+	}
 	if ctx.GlobalIsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
 	}
@@ -1539,6 +1570,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.GlobalIsSet(EVMInterpreterFlag.Name) {
 		cfg.EVMInterpreter = ctx.GlobalString(EVMInterpreterFlag.Name)
 	}
+	if ctx.GlobalIsSet(VMParallelFlag.Name) {
+		cfg.ParallelExecution = ctx.GlobalBool(VMParallelFlag.Name)
+	}
 	if ctx.GlobalIsSet(RPCGlobalGasCapFlag.Name) {
 		cfg.RPCGasCap = ctx.GlobalUint64(RPCGlobalGasCapFlag.Name)
 	}
@@ -1550,6 +1584,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.GlobalIsSet(RPCGlobalTxFeeCapFlag.Name) {
 		cfg.RPCTxFeeCap = ctx.GlobalFloat64(RPCGlobalTxFeeCapFlag.Name)
 	}
+	if ctx.GlobalIsSet(RPCGlobalProofReexecFlag.Name) {
+		cfg.RPCProofReexec = ctx.GlobalUint64(RPCGlobalProofReexecFlag.Name)
+	}
 	if ctx.GlobalIsSet(NoDiscoverFlag.Name) {
 		cfg.EthDiscoveryURLs, cfg.SnapDiscoveryURLs = []string{}, []string{}
 	} else if ctx.GlobalIsSet(DNSDiscoveryFlag.Name) {
@@ -1826,6 +1863,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readOnly bool) (chain *core.B
 		TrieTimeLimit:       ethconfig.Defaults.TrieTimeout,
 		SnapshotLimit:       ethconfig.Defaults.SnapshotCache,
 		Preimages:           ctx.GlobalBool(CachePreimagesFlag.Name),
+		ParallelExecution:   ctx.GlobalBool(VMParallelFlag.Name),
+		StateHistory:        ctx.GlobalUint64(StateHistoryFlag.Name),
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
@@ -0,0 +1,273 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// historyTester is a chain with all its blocks frozen into the ancient store,
+// along with the directory its history is exported to.
+type historyTester struct {
+	gspec  *core.Genesis
+	db     ethdb.Database
+	blocks []*types.Block
+	root   string // Temporary directory of all the databases and exports
+	dir    string
+}
+
+func newHistoryTester(t *testing.T, n int) *historyTester {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				// Emits an empty log
+				contract: {Balance: new(big.Int), Code: common.Hex2Bytes("60006000a0")},
+			},
+		}
+		signer = types.LatestSigner(params.TestChainConfig)
+	)
+	gendb := rawdb.NewMemoryDatabase()
+	genesis := gspec.MustCommit(gendb)
+	blocks, receipts := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, n, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+		if i%2 == 0 {
+			tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{byte(i), 0x01}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	// Freeze the entire chain
+	chain, db := newHistoryChain(t, gspec, root)
+	defer chain.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := chain.InsertReceiptChain(blocks, receipts, uint64(len(blocks))); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	return &historyTester{
+		gspec:  gspec,
+		db:     db,
+		blocks: append([]*types.Block{genesis}, blocks...),
+		root:   root,
+		dir:    filepath.Join(root, "history"),
+	}
+}
+
+func (ht *historyTester) close() {
+	ht.db.Close()
+	os.RemoveAll(ht.root)
+}
+
+// newHistoryChain creates an empty chain backed by a fresh database, with its
+// freezer in the given directory.
+func newHistoryChain(t *testing.T, gspec *core.Genesis, dir string) (*core.BlockChain, ethdb.Database) {
+	frdir, err := ioutil.TempDir(dir, "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temp freezer dir: %v", err)
+	}
+	db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	gspec.MustCommit(db)
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	return chain, db
+}
+
+// Tests that the exported history can be imported into an empty database,
+// reproducing the headers, total difficulties and receipts of the chain.
+func TestHistoryExportImport(t *testing.T) {
+	ht := newHistoryTester(t, 64)
+	defer ht.close()
+
+	head := ht.blocks[len(ht.blocks)-1]
+	if err := ExportHistory(ht.db, ht.dir, 0, head.NumberU64()); err != nil {
+		t.Fatalf("failed to export history: %v", err)
+	}
+	chain, db := newHistoryChain(t, ht.gspec, ht.root)
+	defer db.Close()
+	defer chain.Stop()
+
+	if err := ImportHistory(chain, ht.dir); err != nil {
+		t.Fatalf("failed to import history: %v", err)
+	}
+	if have := chain.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head header mismatch: have %x, want %x", have, head.Hash())
+	}
+	if have := chain.CurrentFastBlock().Hash(); have != head.Hash() {
+		t.Errorf("head fast block mismatch: have %x, want %x", have, head.Hash())
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(len(ht.blocks)) {
+		t.Errorf("frozen block count mismatch: have %d, want %d", frozen, len(ht.blocks))
+	}
+	for _, block := range ht.blocks {
+		number, hash := block.NumberU64(), block.Hash()
+		if have, want := chain.GetTd(hash, number), rawdb.ReadTd(ht.db, hash, number); have == nil || have.Cmp(want) != 0 {
+			t.Errorf("block %d: td mismatch: have %v, want %v", number, have, want)
+		}
+		receipts := rawdb.ReadReceipts(db, hash, number, chain.Config())
+		if len(receipts) != len(block.Transactions()) {
+			t.Fatalf("block %d: receipt count mismatch: have %d, want %d", number, len(receipts), len(block.Transactions()))
+		}
+		if have := types.DeriveSha(receipts, trie.NewStackTrie(nil)); have != block.ReceiptHash() {
+			t.Errorf("block %d: receipt root mismatch: have %x, want %x", number, have, block.ReceiptHash())
+		}
+	}
+	// Importing the same history again is a noop
+	if err := ImportHistory(chain, ht.dir); err != nil {
+		t.Fatalf("failed to reimport history: %v", err)
+	}
+}
+
+// Tests that history archives not matching their name or the accumulator listing
+// of their directory, or which are corrupted, are rejected.
+func TestHistoryImportVerification(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, dir string, archive string)
+		err    string
+	}{
+		{
+			name: "tampered archive",
+			modify: func(t *testing.T, dir string, archive string) {
+				blob, err := ioutil.ReadFile(filepath.Join(dir, archive))
+				if err != nil {
+					t.Fatal(err)
+				}
+				blob[len(blob)/2] ^= 0xff
+				if err := ioutil.WriteFile(filepath.Join(dir, archive), blob, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "renamed archive",
+			modify: func(t *testing.T, dir string, archive string) {
+				// Rename the archive and its listing entry consistently
+				prefix := common.FromHex(strings.TrimSuffix(archive, ".era1")[len(archive)-len("00000000.era1"):])
+				renamed := era.Filename(strings.Split(archive, "-")[0], 0, common.Hash{^prefix[0]})
+				if err := os.Rename(filepath.Join(dir, archive), filepath.Join(dir, renamed)); err != nil {
+					t.Fatal(err)
+				}
+				roots, err := readHistoryAccumulators(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				roots[renamed] = roots[archive]
+				delete(roots, archive)
+				if err := writeHistoryAccumulators(dir, roots); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "archive name does not match its content",
+		},
+		{
+			name: "listing mismatch",
+			modify: func(t *testing.T, dir string, archive string) {
+				roots, err := readHistoryAccumulators(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				roots[archive] = common.Hash{0x01}
+				if err := writeHistoryAccumulators(dir, roots); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "accumulator mismatch",
+		},
+		{
+			name: "listing missing archive",
+			modify: func(t *testing.T, dir string, archive string) {
+				if err := writeHistoryAccumulators(dir, map[string]common.Hash{}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: fmt.Sprintf("archive not in %s", historyAccumulatorsFile),
+		},
+	}
+	ht := newHistoryTester(t, 16)
+	defer ht.close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(ht.root, "history")
+			if err != nil {
+				t.Fatalf("failed to create history dir: %v", err)
+			}
+
+			if err := ExportHistory(ht.db, dir, 0, ht.blocks[len(ht.blocks)-1].NumberU64()); err != nil {
+				t.Fatalf("failed to export history: %v", err)
+			}
+			archives, _ := filepath.Glob(filepath.Join(dir, "*.era1"))
+			if len(archives) != 1 {
+				t.Fatalf("archive count mismatch: have %d, want 1", len(archives))
+			}
+			test.modify(t, dir, filepath.Base(archives[0]))
+
+			chain, db := newHistoryChain(t, ht.gspec, ht.root)
+			defer db.Close()
+			defer chain.Stop()
+
+			err = ImportHistory(chain, dir)
+			if err == nil {
+				t.Fatal("corrupted history imported")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("import error mismatch: have %q, want %q", err, test.err)
+			}
+			if head := chain.CurrentFastBlock().NumberU64(); head != 0 {
+				t.Errorf("blocks imported from corrupted history: head %d", head)
+			}
+		})
+	}
+}
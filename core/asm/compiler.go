@@ -18,32 +18,67 @@ package asm
 
 import (
 	"fmt"
-	"math/big"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
 )
 
 // Compiler contains information about the parsed source
 // and holds the tokens for the program.
+//
+// Besides instructions and label definitions, the source
+// may contain the following directives:
+//
+//	#define NAME expression      defines a constant
+//	#macro NAME(param, ...)      starts the definition of a macro,
+//	...                          invoked as NAME(arg, ...)
+//	#end                         ends the definition of a macro
+//	#include "file"              includes the source of a file
+//
+// The operands of pushes and jumps are expressions of numbers,
+// labels and constants, e.g. "PUSH @end - @start".
 type Compiler struct {
 	tokens []token
 	binary []interface{}
 
-	labels map[string]int
+	labels    map[string]int
+	constants map[string][]token
+	macros    map[string]*macro
+
+	macro     *macro          // macro being defined, collecting the lines of its body
+	expanding map[string]bool // macros being expanded, used to detect recursion
+	includes  []string        // files being included, used to detect cycles
+	errors    []error         // errors found while feeding the tokens
+
+	sourceMap SourceMap
+	location  SourceLocation // location of the line being compiled
 
 	pc, pos int
 
 	debug bool
 }
 
+// macro is a named sequence of lines which is expanded in place of its
+// invocations, with the parameters replaced by the arguments of the invocation.
+type macro struct {
+	def    token
+	params []string
+	body   [][]token
+}
+
 // newCompiler returns a new allocated compiler.
 func NewCompiler(debug bool) *Compiler {
 	return &Compiler{
-		labels: make(map[string]int),
-		debug:  debug,
+		labels:    make(map[string]int),
+		constants: make(map[string][]token),
+		macros:    make(map[string]*macro),
+		expanding: make(map[string]bool),
+		sourceMap: make(SourceMap),
+		debug:     debug,
 	}
 }
 
@@ -56,36 +91,317 @@ func NewCompiler(debug bool) *Compiler {
 // of the jump dests. The labels can than be used in the
 // second stage to push labels and determine the right
 // position.
+//
+// The directives of the program are handled in this pass
+// as well: constants are substituted, macros expanded and
+// included files fed in place of the include.
 func (c *Compiler) Feed(ch <-chan token) {
-	var prev token
+	var (
+		start token
+		line  []token
+	)
 	for i := range ch {
 		switch i.typ {
-		case number:
-			num := math.MustParseBig256(i.text).Bytes()
-			if len(num) == 0 {
-				num = []byte{0}
+		case lineStart:
+			start, line = i, nil
+		case lineEnd, eof:
+			c.feedLine(start, line)
+			line = nil
+		default:
+			line = append(line, i)
+		}
+	}
+	if c.macro != nil {
+		c.errors = append(c.errors, sourceErr(c.macro.def, "unterminated macro %s", c.macro.def.text))
+		c.macro = nil
+	}
+	if c.debug && len(c.includes) == 0 {
+		fmt.Fprintln(os.Stderr, "found", len(c.labels), "labels")
+	}
+}
+
+// feedLine handles a single line of the program, given as the tokens between
+// the start and the end of the line.
+func (c *Compiler) feedLine(start token, line []token) {
+	// Lines within a macro definition are collected as its body
+	if c.macro != nil {
+		if len(line) > 0 && line[0].typ == directive {
+			switch line[0].text {
+			case "end":
+				if len(line) > 1 {
+					c.errors = append(c.errors, compileErr(line[1], line[1].text, lineEnd.String()))
+				}
+				c.macros[c.macro.def.text] = c.macro
+				c.macro = nil
+				return
+			case "macro":
+				c.errors = append(c.errors, sourceErr(line[0], "nested macro definition"))
+				return
+			}
+		}
+		c.macro.body = append(c.macro.body, line)
+		return
+	}
+	if len(line) == 0 {
+		return
+	}
+	switch first := line[0]; {
+	case first.typ == directive:
+		c.feedDirective(first, line[1:])
+		return
+	case first.typ == element && c.macros[first.text] != nil:
+		c.expandMacro(c.macros[first.text], first, line[1:])
+		return
+	}
+	line = append(line[:1:1], c.expandConstants(line[1:])...)
+
+	// Advance the program counter by the size of the instruction
+	switch first := line[0]; first.typ {
+	case labelDef:
+		if _, ok := c.labels[first.text]; ok {
+			c.errors = append(c.errors, sourceErr(first, "label %s redefined", first.text))
+			return
+		}
+		c.labels[first.text] = c.pc
+		c.pc++
+	case element:
+		c.pc++
+		if size, ok := explicitPushSize(first.text); ok || isPush(first.text) || (isJump(first.text) && len(line) > 1) {
+			size, err := operandSize(line[1:], size)
+			if err != nil {
+				c.errors = append(c.errors, err)
+				return
 			}
-			c.pc += len(num)
-		case stringValue:
-			c.pc += len(i.text) - 2
-		case element:
-			c.pc++
-		case labelDef:
-			c.labels[i.text] = c.pc
-			c.pc++
-		case label:
-			c.pc += 4
-			if prev.typ == element && isJump(prev.text) {
+			c.pc += size
+			if isJump(first.text) {
+				// the push of the jump destination
 				c.pc++
 			}
 		}
+	}
+	c.tokens = append(c.tokens, start)
+	c.tokens = append(c.tokens, line...)
+	c.tokens = append(c.tokens, token{typ: lineEnd, lineno: start.lineno, file: start.file})
+}
+
+// feedDirective handles a preprocessor directive with the given arguments.
+func (c *Compiler) feedDirective(dir token, args []token) {
+	switch dir.text {
+	case "define":
+		// #define NAME expression
+		if len(args) < 2 || args[0].typ != element {
+			c.errors = append(c.errors, sourceErr(dir, "expected #define NAME value"))
+			return
+		}
+		if err := c.checkName(args[0]); err != nil {
+			c.errors = append(c.errors, err)
+			return
+		}
+		c.constants[args[0].text] = c.expandConstants(args[1:])
+
+	case "macro":
+		// #macro NAME or #macro NAME(param, ...)
+		m, err := parseMacro(dir, args)
+		if err == nil {
+			err = c.checkName(m.def)
+		}
+		if err != nil {
+			c.errors = append(c.errors, err)
+			// Collect the body anyway, so it is not compiled as code
+			m = &macro{def: dir}
+		}
+		c.macro = m
+
+	case "include":
+		// #include "file"
+		if len(args) != 1 || args[0].typ != stringValue {
+			c.errors = append(c.errors, sourceErr(dir, "expected #include \"file\""))
+			return
+		}
+		c.include(dir, args[0].text[1:len(args[0].text)-1])
+
+	case "end":
+		c.errors = append(c.errors, sourceErr(dir, "#end without #macro"))
 
-		c.tokens = append(c.tokens, i)
-		prev = i
+	default:
+		c.errors = append(c.errors, sourceErr(dir, "unknown directive #%s", dir.text))
 	}
-	if c.debug {
-		fmt.Fprintln(os.Stderr, "found", len(c.labels), "labels")
+}
+
+// checkName returns an error if the name of the given constant or macro
+// definition is already in use.
+func (c *Compiler) checkName(name token) error {
+	if isPush(name.text) || isJump(name.text) || isInstruction(name.text) {
+		return sourceErr(name, "%s is an instruction", name.text)
+	}
+	if _, ok := c.constants[name.text]; ok {
+		return sourceErr(name, "%s redefined", name.text)
+	}
+	if _, ok := c.macros[name.text]; ok {
+		return sourceErr(name, "%s redefined", name.text)
+	}
+	return nil
+}
+
+// expandConstants replaces the constants in the given tokens by their values.
+func (c *Compiler) expandConstants(tokens []token) []token {
+	var expanded []token
+	for _, tok := range tokens {
+		if value, ok := c.constants[tok.text]; ok && tok.typ == element {
+			expanded = append(expanded, substitute(value, tok)...)
+			continue
+		}
+		expanded = append(expanded, tok)
+	}
+	return expanded
+}
+
+// parseMacro parses the name and parameters of a macro definition.
+func parseMacro(dir token, args []token) (*macro, error) {
+	if len(args) == 0 || args[0].typ != element {
+		return nil, sourceErr(dir, "expected #macro NAME(params)")
+	}
+	m := &macro{def: args[0]}
+
+	params, err := splitArgs(args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+	for _, param := range params {
+		if len(param) != 1 || param[0].typ != element {
+			return nil, compileErr(param[0], param[0].text, "parameter name")
+		}
+		m.params = append(m.params, param[0].text)
+	}
+	return m, nil
+}
+
+// expandMacro feeds the body of the macro in place of its invocation, with the
+// parameters replaced by the given arguments. All the lines of the body are
+// attributed to the line of the invocation.
+func (c *Compiler) expandMacro(m *macro, call token, args []token) {
+	if c.expanding[m.def.text] {
+		c.errors = append(c.errors, sourceErr(call, "recursive invocation of macro %s", call.text))
+		return
+	}
+	values, err := splitArgs(call, c.expandConstants(args))
+	if err != nil {
+		c.errors = append(c.errors, err)
+		return
+	}
+	if len(values) != len(m.params) {
+		c.errors = append(c.errors, sourceErr(call, "macro %s takes %d arguments, got %d", call.text, len(m.params), len(values)))
+		return
+	}
+	c.expanding[m.def.text] = true
+	defer delete(c.expanding, m.def.text)
+
+	start := token{typ: lineStart, lineno: call.lineno, file: call.file}
+	for _, line := range m.body {
+		var expanded []token
+		for _, tok := range line {
+			if tok.typ == element {
+				if i := indexOf(m.params, tok.text); i >= 0 {
+					expanded = append(expanded, substitute(values[i], call)...)
+					continue
+				}
+			}
+			tok.lineno, tok.file = call.lineno, call.file
+			expanded = append(expanded, tok)
+		}
+		c.feedLine(start, expanded)
+	}
+}
+
+// include feeds the program in the given file in place of the include directive.
+// Relative paths are resolved from the directory of the including file.
+func (c *Compiler) include(dir token, path string) {
+	if !filepath.IsAbs(path) && dir.file != "" {
+		path = filepath.Join(filepath.Dir(dir.file), path)
+	}
+	for _, file := range c.includes {
+		if file == path {
+			c.errors = append(c.errors, sourceErr(dir, "recursive include of %s", path))
+			return
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.errors = append(c.errors, sourceErr(dir, "%v", err))
+		return
+	}
+	c.includes = append(c.includes, path)
+	c.Feed(LexFile(path, src, c.debug))
+	c.includes = c.includes[:len(c.includes)-1]
+}
+
+// splitArgs splits the parenthesized, comma separated arguments of a macro
+// definition or invocation. No arguments at all are equal to "()".
+func splitArgs(name token, tokens []token) ([][]token, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	if tokens[0].typ != operator || tokens[0].text != "(" {
+		return nil, compileErr(tokens[0], tokens[0].text, "(")
+	}
+	var (
+		args  [][]token
+		arg   []token
+		depth int
+	)
+	for i, tok := range tokens[1:] {
+		switch {
+		case tok.typ == operator && tok.text == "(":
+			depth++
+		case tok.typ == operator && tok.text == ")" && depth > 0:
+			depth--
+		case tok.typ == operator && tok.text == ")":
+			if i+2 < len(tokens) {
+				return nil, compileErr(tokens[i+2], tokens[i+2].text, lineEnd.String())
+			}
+			if len(arg) > 0 || len(args) > 0 {
+				if len(arg) == 0 {
+					return nil, compileErr(tok, tok.text, "argument")
+				}
+				args = append(args, arg)
+			}
+			return args, nil
+		case tok.typ == comma && depth == 0:
+			if len(arg) == 0 {
+				return nil, compileErr(tok, tok.text, "argument")
+			}
+			args, arg = append(args, arg), nil
+			continue
+		}
+		arg = append(arg, tok)
+	}
+	return nil, sourceErr(name, "missing ) in arguments of %s", name.text)
+}
+
+// substitute returns the tokens of a constant or macro argument to insert in
+// place of the given token, parenthesized if needed to retain their precedence.
+func substitute(value []token, at token) []token {
+	var tokens []token
+	if len(value) > 1 {
+		tokens = append(tokens, token{typ: operator, text: "(", lineno: at.lineno, file: at.file})
+	}
+	for _, tok := range value {
+		tok.lineno, tok.file = at.lineno, at.file
+		tokens = append(tokens, tok)
+	}
+	if len(value) > 1 {
+		tokens = append(tokens, token{typ: operator, text: ")", lineno: at.lineno, file: at.file})
 	}
+	return tokens
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
 }
 
 // Compile compiles the current tokens and returns a
@@ -95,7 +411,11 @@ func (c *Compiler) Feed(ch <-chan token) {
 // compile is the second stage in the compile phase
 // which compiles the tokens to EVM instructions.
 func (c *Compiler) Compile() (string, []error) {
-	var errors []error
+	errors := c.errors
+	// the program counter is recomputed while compiling
+	// to build the source map.
+	c.pc = 0
+
 	// continue looping over the tokens until
 	// the stack has been exhausted.
 	for c.pos < len(c.tokens) {
@@ -117,6 +437,12 @@ func (c *Compiler) Compile() (string, []error) {
 	return bin, errors
 }
 
+// SourceMap returns the source locations of the instructions of the compiled
+// program. Instructions generated by a macro are mapped to its invocation.
+func (c *Compiler) SourceMap() SourceMap {
+	return c.sourceMap
+}
+
 // next returns the next token and increments the
 // position.
 func (c *Compiler) next() token {
@@ -125,6 +451,16 @@ func (c *Compiler) next() token {
 	return token
 }
 
+// operand returns the tokens following an instruction up
+// to the end of the line.
+func (c *Compiler) operand() []token {
+	start := c.pos
+	for c.tokens[c.pos].typ != lineEnd {
+		c.pos++
+	}
+	return c.tokens[start:c.pos]
+}
+
 // compileLine compiles a single line instruction e.g.
 // "push 1", "jump @label".
 func (c *Compiler) compileLine() error {
@@ -132,6 +468,7 @@ func (c *Compiler) compileLine() error {
 	if n.typ != lineStart {
 		return compileErr(n, n.typ.String(), lineStart.String())
 	}
+	c.location = SourceLocation{File: n.file, Line: n.lineno + 1}
 
 	lvalue := c.next()
 	switch lvalue.typ {
@@ -139,6 +476,7 @@ func (c *Compiler) compileLine() error {
 		return nil
 	case element:
 		if err := c.compileElement(lvalue); err != nil {
+			c.skipLine()
 			return err
 		}
 	case labelDef:
@@ -146,85 +484,126 @@ func (c *Compiler) compileLine() error {
 	case lineEnd:
 		return nil
 	default:
+		c.skipLine()
 		return compileErr(lvalue, lvalue.text, fmt.Sprintf("%v or %v", labelDef, element))
 	}
 
 	if n := c.next(); n.typ != lineEnd {
+		c.skipLine()
 		return compileErr(n, n.text, lineEnd.String())
 	}
 
 	return nil
 }
 
-// compileNumber compiles the number to bytes
-func (c *Compiler) compileNumber(element token) (int, error) {
-	num := math.MustParseBig256(element.text).Bytes()
-	if len(num) == 0 {
-		num = []byte{0}
+// skipLine advances the position past the end of the
+// current line after an error.
+func (c *Compiler) skipLine() {
+	for c.pos < len(c.tokens) && c.tokens[c.pos-1].typ != lineEnd {
+		c.pos++
 	}
-	c.pushBin(num)
-	return len(num), nil
 }
 
 // compileElement compiles the element (push & label or both)
 // to a binary representation and may error if incorrect statements
 // where fed.
 func (c *Compiler) compileElement(element token) error {
+	operand := c.operand()
+
 	// check for a jump. jumps must be read and compiled
 	// from right to left.
 	if isJump(element.text) {
-		rvalue := c.next()
-		switch rvalue.typ {
-		case number:
-			// TODO figure out how to return the error properly
-			c.compileNumber(rvalue)
-		case stringValue:
-			// strings are quoted, remove them.
-			c.pushBin(rvalue.text[1 : len(rvalue.text)-2])
-		case label:
-			c.pushBin(vm.PUSH4)
-			pos := big.NewInt(int64(c.labels[rvalue.text])).Bytes()
-			pos = append(make([]byte, 4-len(pos)), pos...)
-			c.pushBin(pos)
-		case lineEnd:
-			c.pos--
-		default:
-			return compileErr(rvalue, rvalue.text, "number, string or label")
+		if len(operand) > 0 {
+			if err := c.compilePush(operand, 0); err != nil {
+				return err
+			}
 		}
 		// push the operation
 		c.pushBin(toBinary(element.text))
 		return nil
-	} else if isPush(element.text) {
-		// handle pushes. pushes are read from left to right.
-		var value []byte
-
-		rvalue := c.next()
-		switch rvalue.typ {
-		case number:
-			value = math.MustParseBig256(rvalue.text).Bytes()
-			if len(value) == 0 {
-				value = []byte{0}
-			}
-		case stringValue:
-			value = []byte(rvalue.text[1 : len(rvalue.text)-1])
-		case label:
-			value = big.NewInt(int64(c.labels[rvalue.text])).Bytes()
-			value = append(make([]byte, 4-len(value)), value...)
-		default:
-			return compileErr(rvalue, rvalue.text, "number, string or label")
+	}
+	// handle pushes. pushes are read from left to right.
+	if size, ok := explicitPushSize(element.text); ok || isPush(element.text) {
+		if len(operand) == 0 {
+			return compileErr(element, lineEnd.String(), "number, string, label or expression")
 		}
+		return c.compilePush(operand, size)
+	}
+	if !isInstruction(element.text) {
+		return sourceErr(element, "unknown instruction %s", element.text)
+	}
+	if len(operand) > 0 {
+		return compileErr(operand[0], operand[0].text, lineEnd.String())
+	}
+	c.pushBin(toBinary(element.text))
+	return nil
+}
 
-		if len(value) > 32 {
-			return fmt.Errorf("%d type error: unsupported string or number with size > 32", rvalue.lineno)
+// compilePush pushes the value of the operand, using the
+// given push size if not zero or the smallest one otherwise.
+func (c *Compiler) compilePush(operand []token, size int) error {
+	value, err := pushValue(operand, size, c.labels)
+	if err != nil {
+		return err
+	}
+	c.pushBin(vm.OpCode(int(vm.PUSH1) - 1 + len(value)))
+	c.pushBin(value)
+	return nil
+}
+
+// operandSize returns the size of the value pushed for the
+// given operand in the first pass, when the positions of the
+// labels are not all known yet.
+func operandSize(operand []token, size int) (int, error) {
+	if len(operand) == 0 {
+		// reported in the second pass
+		return size, nil
+	}
+	if hasLabel(operand) {
+		if size == 0 {
+			size = 4
 		}
+		return size, nil
+	}
+	value, err := pushValue(operand, size, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
 
-		c.pushBin(vm.OpCode(int(vm.PUSH1) - 1 + len(value)))
-		c.pushBin(value)
+// pushValue returns the value pushed for the given operand,
+// either a string or an expression. Values depending on the
+// labels are pushed as 4 bytes unless the size was given
+// explicitly with any of push(N).
+func pushValue(operand []token, size int, labels map[string]int) ([]byte, error) {
+	var value []byte
+	if len(operand) == 1 && operand[0].typ == stringValue {
+		// strings are quoted, remove them.
+		value = []byte(operand[0].text[1 : len(operand[0].text)-1])
 	} else {
-		c.pushBin(toBinary(element.text))
+		num, err := evaluate(operand, labels)
+		if err != nil {
+			return nil, err
+		}
+		value = num.Bytes()
+		if size == 0 && hasLabel(operand) {
+			size = 4
+		}
 	}
-
-	return nil
+	if len(value) == 0 {
+		value = []byte{0}
+	}
+	if size == 0 {
+		if len(value) > 32 {
+			return nil, sourceErr(operand[0], "unsupported string or number with size > 32")
+		}
+		return value, nil
+	}
+	if len(value) > size {
+		return nil, sourceErr(operand[0], "value of %d bytes exceeds push size %d", len(value), size)
+	}
+	return common.LeftPadBytes(value, size), nil
 }
 
 // compileLabel pushes a jumpdest to the binary slice.
@@ -237,6 +616,13 @@ func (c *Compiler) pushBin(v interface{}) {
 	if c.debug {
 		fmt.Printf("%d: %v\n", len(c.binary), v)
 	}
+	switch v := v.(type) {
+	case vm.OpCode:
+		c.sourceMap[uint64(c.pc)] = c.location
+		c.pc++
+	case []byte:
+		c.pc += len(v)
+	}
 	c.binary = append(c.binary, v)
 }
 
@@ -246,25 +632,49 @@ func isPush(op string) bool {
 	return strings.ToUpper(op) == "PUSH"
 }
 
+// explicitPushSize returns the size of the value pushed
+// by the string op if it is any of push(N).
+func explicitPushSize(op string) (int, bool) {
+	if code := toBinary(op); code.IsPush() {
+		return int(code-vm.PUSH1) + 1, true
+	}
+	return 0, false
+}
+
 // isJump returns whether the string op is jump(i)
 func isJump(op string) bool {
 	return strings.ToUpper(op) == "JUMPI" || strings.ToUpper(op) == "JUMP"
 }
 
+// isInstruction returns whether the string op is a valid
+// instruction name.
+func isInstruction(op string) bool {
+	return toBinary(op) != vm.STOP || strings.ToUpper(op) == "STOP"
+}
+
 // toBinary converts text to a vm.OpCode
 func toBinary(text string) vm.OpCode {
 	return vm.StringToOp(strings.ToUpper(text))
 }
 
+// position formats the position of a token in the source.
+func position(file string, lineno int) string {
+	if file == "" {
+		return fmt.Sprintf("%d", lineno+1)
+	}
+	return fmt.Sprintf("%s:%d", file, lineno+1)
+}
+
 type compileError struct {
 	got  string
 	want string
 
 	lineno int
+	file   string
 }
 
 func (err compileError) Error() string {
-	return fmt.Sprintf("%d syntax error: unexpected %v, expected %v", err.lineno, err.got, err.want)
+	return fmt.Sprintf("%s syntax error: unexpected %v, expected %v", position(err.file, err.lineno), err.got, err.want)
 }
 
 func compileErr(c token, got, want string) error {
@@ -272,5 +682,27 @@ func compileErr(c token, got, want string) error {
 		got:    got,
 		want:   want,
 		lineno: c.lineno,
+		file:   c.file,
+	}
+}
+
+// sourceError is a semantic error of the program, e.g.
+// an undefined label or a value overflowing a push.
+type sourceError struct {
+	msg string
+
+	lineno int
+	file   string
+}
+
+func (err sourceError) Error() string {
+	return fmt.Sprintf("%s error: %s", position(err.file, err.lineno), err.msg)
+}
+
+func sourceErr(c token, format string, args ...interface{}) error {
+	return sourceError{
+		msg:    fmt.Sprintf(format, args...),
+		lineno: c.lineno,
+		file:   c.file,
 	}
 }
@@ -17,6 +17,11 @@
 package asm
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -54,6 +59,60 @@ func TestCompiler(t *testing.T) {
 `,
 			output: "6300000006565b",
 		},
+		{
+			input: `
+	PUSH2 1
+	PUSH 0x0100
+	JUMP 3
+`,
+			output: "6100016101006003" + "56",
+		},
+		{
+			input: `
+	#define SIZE 0x20
+	#define TWICE SIZE * 2
+	PUSH TWICE + 1
+	PUSH (SIZE + 1) * 2
+	PUSH 7 % 4 - 10 / 5
+`,
+			output: "6041604260" + "01",
+		},
+		{
+			input: `
+	start:
+	PUSH @end - @start
+	PUSH1 @end
+	end:
+`,
+			output: "5b630000000860085b",
+		},
+		{
+			input: `
+	#macro mstore_at(offset, value)
+	PUSH value
+	PUSH offset
+	MSTORE
+	#end
+	#macro ret32
+	PUSH 32
+	PUSH 0
+	RETURN
+	#end
+	mstore_at(0, 1 + 1)
+	ret32
+`,
+			output: "600260005260206000f3",
+		},
+		{
+			input: `
+	#macro loop(dest)
+	JUMP dest
+	#end
+	again:
+	loop(@again)
+`,
+			output: "5b630000000056",
+		},
 	}
 	for _, test := range tests {
 		ch := Lex([]byte(test.input), false)
@@ -69,3 +128,124 @@ func TestCompiler(t *testing.T) {
 		}
 	}
 }
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input, err string
+	}{
+		{
+			input: "PUSH @missing\n",
+			err:   "1 error: undefined label @missing",
+		},
+		{
+			input: "PUSH 1 / (2 - 2)\n",
+			err:   "1 error: division by zero",
+		},
+		{
+			input: "PUSH 1 - 2\n",
+			err:   "1 error: negative value -1",
+		},
+		{
+			input: "PUSH1 0x100\n",
+			err:   "1 error: value of 2 bytes exceeds push size 1",
+		},
+		{
+			input: "\nPUSH SIZE\n",
+			err:   "2 error: undefined constant SIZE",
+		},
+		{
+			input: "foo\n",
+			err:   "1 error: unknown instruction foo",
+		},
+		{
+			input: "ADD 1\n",
+			err:   "1 syntax error: unexpected 1, expected end of line",
+		},
+		{
+			input: "a:\na:\n",
+			err:   "2 error: label a redefined",
+		},
+		{
+			input: "#define ADD 1\n",
+			err:   "1 error: ADD is an instruction",
+		},
+		{
+			input: "#macro m(a)\nPUSH a\n#end\nm(1, 2)\n",
+			err:   "4 error: macro m takes 1 arguments, got 2",
+		},
+		{
+			input: "#macro m\nm\n#end\nm\n",
+			err:   "4 error: recursive invocation of macro m",
+		},
+		{
+			input: "#macro m\nSTOP\n",
+			err:   "1 error: unterminated macro m",
+		},
+		{
+			input: "PUSH 1 $\n",
+			err:   "1 syntax error: unexpected $, expected operator",
+		},
+	}
+	for _, test := range tests {
+		c := NewCompiler(false)
+		c.Feed(Lex([]byte(test.input), false))
+		_, errs := c.Compile()
+		if len(errs) != 1 {
+			t.Errorf("input %q: expected one error, got %v", test.input, errs)
+			continue
+		}
+		if errs[0].Error() != test.err {
+			t.Errorf("input %q: error mismatch: got %q, want %q", test.input, errs[0], test.err)
+		}
+	}
+}
+
+func TestCompilerIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.easm":       "#include \"lib/defs.easm\"\nstore(SLOT)\nSTOP\n",
+		"lib/defs.easm":   "#define SLOT 2\n#include \"macros.easm\"\n",
+		"lib/macros.easm": "#macro store(slot)\nPUSH 1\nPUSH slot\nSSTORE\n#end\n",
+		"loop.easm":       "#include \"loop.easm\"\n",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	main := filepath.Join(dir, "main.easm")
+	c := NewCompiler(false)
+	c.Feed(LexFile(main, []byte(files["main.easm"]), false))
+	output, errs := c.Compile()
+	if len(errs) != 0 {
+		t.Fatalf("compile error: %v", errs)
+	}
+	if want := "600160025500"; output != want {
+		t.Errorf("incorrect output: got %s, want %s", output, want)
+	}
+	// The instructions of the expanded macro map to the invocation
+	want := SourceMap{
+		0: {File: main, Line: 2},
+		2: {File: main, Line: 2},
+		4: {File: main, Line: 2},
+		5: {File: main, Line: 3},
+	}
+	if have := c.SourceMap(); !reflect.DeepEqual(have, want) {
+		t.Errorf("source map mismatch: have %v, want %v", have, want)
+	}
+	// Files including themselves are rejected
+	loop := filepath.Join(dir, "loop.easm")
+	c = NewCompiler(false)
+	c.Feed(LexFile(loop, []byte(files["loop.easm"]), false))
+	if _, errs := c.Compile(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "recursive include") {
+		t.Errorf("expected recursive include error, got %v", errs)
+	}
+}
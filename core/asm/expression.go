@@ -0,0 +1,178 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package asm
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// expression evaluates the arithmetic expressions used as operands of the push
+// and jump instructions, e.g. "@end - @start" or "(SIZE + 1) * 32".
+//
+// The grammar, in order of increasing precedence:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = primary { ("*" | "/" | "%") primary }
+//	primary = number | label | "(" expr ")"
+type expression struct {
+	tokens []token
+	pos    int
+
+	labels map[string]int
+}
+
+// evaluate computes the value of the expression in the given tokens, resolving
+// the labels with the given positions. The value has to fit in a 256 bit word.
+func evaluate(tokens []token, labels map[string]int) (*big.Int, error) {
+	e := &expression{tokens: tokens, labels: labels}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("missing expression")
+	}
+	value, err := e.expr()
+	if err != nil {
+		return nil, err
+	}
+	if e.pos < len(e.tokens) {
+		return nil, compileErr(e.tokens[e.pos], e.tokens[e.pos].text, "operator")
+	}
+	if value.Sign() < 0 {
+		return nil, sourceErr(tokens[0], "negative value %v", value)
+	}
+	if value.BitLen() > 256 {
+		return nil, sourceErr(tokens[0], "value %#x exceeds 256 bits", value)
+	}
+	return value, nil
+}
+
+// hasLabel returns whether the expression in the given tokens refers to a label,
+// in which case its value is only known once all the labels have been defined.
+func hasLabel(tokens []token) bool {
+	for _, tok := range tokens {
+		if tok.typ == label {
+			return true
+		}
+	}
+	return false
+}
+
+// peek returns whether the next token is one of the given operators.
+func (e *expression) peek(ops ...string) bool {
+	if e.pos >= len(e.tokens) || e.tokens[e.pos].typ != operator {
+		return false
+	}
+	for _, op := range ops {
+		if e.tokens[e.pos].text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *expression) expr() (*big.Int, error) {
+	x, err := e.term()
+	if err != nil {
+		return nil, err
+	}
+	for e.peek("+", "-") {
+		op := e.tokens[e.pos].text
+		e.pos++
+
+		y, err := e.term()
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			x.Add(x, y)
+		} else {
+			x.Sub(x, y)
+		}
+	}
+	return x, nil
+}
+
+func (e *expression) term() (*big.Int, error) {
+	x, err := e.primary()
+	if err != nil {
+		return nil, err
+	}
+	for e.peek("*", "/", "%") {
+		op := e.tokens[e.pos]
+		e.pos++
+
+		y, err := e.primary()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case op.text == "*":
+			x.Mul(x, y)
+		case y.Sign() == 0:
+			return nil, sourceErr(op, "division by zero")
+		case op.text == "/":
+			x.Quo(x, y)
+		default:
+			x.Rem(x, y)
+		}
+	}
+	return x, nil
+}
+
+func (e *expression) primary() (*big.Int, error) {
+	if e.pos >= len(e.tokens) {
+		last := e.tokens[len(e.tokens)-1]
+		return nil, compileErr(last, lineEnd.String(), "number, label or (")
+	}
+	tok := e.tokens[e.pos]
+	e.pos++
+
+	switch {
+	case tok.typ == number:
+		value, ok := math.ParseBig256(tok.text)
+		if !ok {
+			return nil, sourceErr(tok, "invalid number %s", tok.text)
+		}
+		return value, nil
+
+	case tok.typ == label:
+		pos, ok := e.labels[tok.text]
+		if !ok {
+			return nil, sourceErr(tok, "undefined label @%s", tok.text)
+		}
+		return big.NewInt(int64(pos)), nil
+
+	case tok.typ == operator && tok.text == "(":
+		value, err := e.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !e.peek(")") {
+			if e.pos < len(e.tokens) {
+				return nil, compileErr(e.tokens[e.pos], e.tokens[e.pos].text, ")")
+			}
+			return nil, compileErr(tok, lineEnd.String(), ")")
+		}
+		e.pos++
+		return value, nil
+
+	case tok.typ == element:
+		return nil, sourceErr(tok, "undefined constant %s", tok.text)
+	}
+	return nil, compileErr(tok, tok.text, "number, label or (")
+}
@@ -68,6 +68,20 @@ func TestLexer(t *testing.T) {
 			input:  "@label123",
 			tokens: []token{{typ: lineStart}, {typ: label, text: "label123"}, {typ: eof}},
 		},
+		{
+			input: "@end-(@start+1),2",
+			tokens: []token{{typ: lineStart}, {typ: label, text: "end"}, {typ: operator, text: "-"}, {typ: operator, text: "("},
+				{typ: label, text: "start"}, {typ: operator, text: "+"}, {typ: number, text: "1"}, {typ: operator, text: ")"},
+				{typ: comma, text: ","}, {typ: number, text: "2"}, {typ: eof}},
+		},
+		{
+			input:  "#define SIZE 32",
+			tokens: []token{{typ: lineStart}, {typ: directive, text: "define"}, {typ: element, text: "SIZE"}, {typ: number, text: "32"}, {typ: eof}},
+		},
+		{
+			input:  "PUSH $",
+			tokens: []token{{typ: lineStart}, {typ: element, text: "PUSH"}, {typ: invalidStatement, text: "$"}, {typ: eof}},
+		},
 	}
 
 	for _, test := range tests {
@@ -36,6 +36,7 @@ type token struct {
 	typ    tokenType
 	lineno int
 	text   string
+	file   string
 }
 
 // tokenType are the different types the lexer
@@ -52,6 +53,9 @@ const (
 	labelDef                          // label definition is emitted when a new label is found
 	number                            // number is emitted when a number is found
 	stringValue                       // stringValue is emitted when a string has been found
+	operator                          // operator is emitted when an arithmetic operator or parenthesis is found
+	comma                             // comma is emitted when an argument separator is found
+	directive                         // directive is emitted when a preprocessor directive is found
 
 	Numbers            = "1234567890"                                           // characters representing any decimal number
 	HexadecimalNumbers = Numbers + "aAbBcCdDeEfF"                               // characters representing any hexadecimal
@@ -76,6 +80,9 @@ var stringtokenTypes = []string{
 	labelDef:         "label definition",
 	number:           "number",
 	stringValue:      "string",
+	operator:         "operator",
+	comma:            "comma",
+	directive:        "directive",
 }
 
 // lexer is the basic construct for parsing
@@ -83,6 +90,7 @@ var stringtokenTypes = []string{
 // Tokens are interpreted by the compiler.
 type lexer struct {
 	input string // input contains the source code of the program
+	file  string // file is the name of the source file, if any
 
 	tokens chan token // tokens is used to deliver tokens to the listener
 	state  stateFn    // the current state function
@@ -96,9 +104,17 @@ type lexer struct {
 // lex lexes the program by name with the given source. It returns a
 // channel on which the tokens are delivered.
 func Lex(source []byte, debug bool) <-chan token {
+	return LexFile("", source, debug)
+}
+
+// LexFile lexes the program with the given source, tagging the tokens with the
+// name of the file they were read from. The file name is used to resolve the
+// includes of the program and to build its source map.
+func LexFile(file string, source []byte, debug bool) <-chan token {
 	ch := make(chan token)
 	l := &lexer{
 		input:  string(source),
+		file:   file,
 		tokens: ch,
 		state:  lexLine,
 		debug:  debug,
@@ -182,7 +198,7 @@ func (l *lexer) blob() string {
 
 // Emits a new token on to token channel for processing
 func (l *lexer) emit(t tokenType) {
-	token := token{t, l.lineno, l.blob()}
+	token := token{t, l.lineno, l.blob(), l.file}
 
 	if l.debug {
 		fmt.Fprintf(os.Stderr, "%04d: (%-20v) %s\n", token.lineno, token.typ, token.text)
@@ -215,7 +231,17 @@ func lexLine(l *lexer) stateFn {
 			return lexLabel
 		case r == '"':
 			return lexInsideString
+		case r == '#':
+			l.ignore()
+			return lexDirective
+		case strings.ContainsRune("+-*/%()", r):
+			l.emit(operator)
+		case r == ',':
+			l.emit(comma)
+		case r == 0:
+			return nil
 		default:
+			l.emit(invalidStatement)
 			return nil
 		}
 	}
@@ -241,6 +267,16 @@ func lexLabel(l *lexer) stateFn {
 	return lexLine
 }
 
+// lexDirective parses the name of a preprocessor directive, e.g. "define" for
+// "#define", emits it and returns the lex text state function.
+func lexDirective(l *lexer) stateFn {
+	l.acceptRun(Alpha)
+
+	l.emit(directive)
+
+	return lexLine
+}
+
 // lexInsideString lexes the inside of a string until
 // the state function finds the closing quote.
 // It returns the lex text state function.
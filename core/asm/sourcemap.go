@@ -0,0 +1,38 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package asm
+
+import "fmt"
+
+// SourceLocation is the position in the source of the line an instruction was
+// compiled from.
+type SourceLocation struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"` // Line number, starting at 1
+}
+
+// String implements fmt.Stringer, formatting the location as file:line.
+func (loc SourceLocation) String() string {
+	if loc.File == "" {
+		return fmt.Sprintf("line %d", loc.Line)
+	}
+	return fmt.Sprintf("%s:%d", loc.File, loc.Line)
+}
+
+// SourceMap maps the program counters of the instructions of a compiled program
+// to their locations in the source.
+type SourceMap map[uint64]SourceLocation
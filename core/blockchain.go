@@ -129,6 +129,8 @@ type CacheConfig struct {
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
 	SnapshotLimit       int           // Memory allowance (MB) to use for caching snapshot entries in memory
 	Preimages           bool          // Whether to store preimage of trie key to the disk
+	StateHistory        uint64        // Number of recent state histories to retain with the path-based scheme (0 = all)
+	ParallelExecution   bool          // Whether to execute block transactions speculatively in parallel
 
 	SnapshotWait bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
 }
@@ -166,6 +168,8 @@ type BlockChain struct {
 	triegc *prque.Prque   // Priority queue mapping block numbers to tries to gc
 	gcproc time.Duration  // Accumulates canonical block processing for trie dumping
 
+	stateWritten uint64 // Number of the block whose state was persisted last with the path-based scheme
+
 	// txLookupLimit is the maximum number of blocks from head whose tx indices
 	// are reserved:
 	//  * 0:   means no limit and regenerate any missing indexes
@@ -180,6 +184,8 @@ type BlockChain struct {
 	chainHeadFeed event.Feed
 	logsFeed      event.Feed
 	blockProcFeed event.Feed
+	insertFeed    event.Feed
+	insertSubs    int32 // Number of BlockInsertEvent subscribers (atomic)
 	scope         event.SubscriptionScope
 	genesisBlock  *types.Block
 
@@ -219,6 +225,33 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if cacheConfig == nil {
 		cacheConfig = defaultCacheConfig
 	}
+	if err := vm.ValidatePrecompiles(chainConfig); err != nil {
+		return nil, err
+	}
+	// Snapshots are generated from and verified against Merkle Patricia tries.
+	if chainConfig.BinaryTrie && cacheConfig.SnapshotLimit > 0 {
+		log.Warn("Disabling snapshots, not supported with binary tries")
+		config := *cacheConfig
+		config.SnapshotLimit = 0
+		cacheConfig = &config
+	}
+	// The path-based scheme only retains the latest state on disk, overwriting the
+	// nodes of the previous ones, which is not compatible with archive nodes and
+	// with snapshots generated from older states.
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		if cacheConfig.TrieDirtyDisabled {
+			return nil, errors.New("path-based state scheme is not supported by archive nodes")
+		}
+		if chainConfig.BinaryTrie {
+			return nil, errors.New("path-based state scheme is not supported with binary tries")
+		}
+		if cacheConfig.SnapshotLimit > 0 {
+			log.Warn("Disabling snapshots, not supported with the path-based state scheme")
+			config := *cacheConfig
+			config.SnapshotLimit = 0
+			cacheConfig = &config
+		}
+	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	receiptsCache, _ := lru.New(receiptsCacheLimit)
@@ -231,10 +264,11 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		cacheConfig: cacheConfig,
 		db:          db,
 		triegc:      prque.New(nil),
-		stateCache: state.NewDatabaseWithConfig(db, &trie.Config{
-			Cache:     cacheConfig.TrieCleanLimit,
-			Journal:   cacheConfig.TrieCleanJournal,
-			Preimages: cacheConfig.Preimages,
+		stateCache: state.NewDatabaseForChain(db, chainConfig, &trie.Config{
+			Cache:        cacheConfig.TrieCleanLimit,
+			Journal:      cacheConfig.TrieCleanJournal,
+			Preimages:    cacheConfig.Preimages,
+			StateHistory: cacheConfig.StateHistory,
 		}),
 		quit:           make(chan struct{}),
 		shouldPreserve: shouldPreserve,
@@ -250,6 +284,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	bc.validator = NewBlockValidator(chainConfig, bc, engine)
 	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
 	bc.processor = NewStateProcessor(chainConfig, bc, engine)
+	if cacheConfig.ParallelExecution {
+		bc.processor = NewParallelStateProcessor(chainConfig, bc, engine)
+	}
 
 	var err error
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.insertStopped)
@@ -308,6 +345,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Nothing is cached in memory yet, so the head state is the persisted one
+	bc.stateWritten = bc.CurrentBlock().NumberU64()
+
 	// Ensure that a previous crash in SetHead doesn't leave extra ancients
 	if frozen, err := bc.db.Ancients(); err == nil && frozen > 0 {
 		var (
@@ -521,7 +561,7 @@ func (bc *BlockChain) SetHeadBeyondRoot(head uint64, root common.Hash) (uint64,
 					if root != (common.Hash{}) && !beyondRoot && newHeadBlock.Root() == root {
 						beyondRoot, rootNumber = true, newHeadBlock.NumberU64()
 					}
-					if _, err := state.New(newHeadBlock.Root(), bc.stateCache, bc.snaps); err != nil {
+					if _, err := state.New(newHeadBlock.Root(), bc.stateCache, bc.snaps); err != nil && !bc.recoverState(newHeadBlock) {
 						log.Trace("Block state missing, rewinding further", "number", newHeadBlock.NumberU64(), "hash", newHeadBlock.Hash())
 						if pivot == nil || newHeadBlock.NumberU64() > *pivot {
 							parent := bc.GetBlock(newHeadBlock.ParentHash(), newHeadBlock.NumberU64()-1)
@@ -863,12 +903,54 @@ func (bc *BlockChain) HasFastBlock(hash common.Hash, number uint64) bool {
 	return rawdb.HasReceipts(bc.db, hash, number)
 }
 
+// recoverState tries to roll the persistent state back to the one of the given
+// block using the state histories of the path-based scheme.
+func (bc *BlockChain) recoverState(block *types.Block) bool {
+	triedb := bc.stateCache.TrieDB()
+	if !triedb.Recoverable(block.Root()) {
+		return false
+	}
+	if err := triedb.Rollback(block.Root()); err != nil {
+		log.Error("Failed to roll back state", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return false
+	}
+	log.Info("Rolled back persistent state", "number", block.Number(), "hash", block.Hash(), "root", block.Root())
+	bc.stateWritten = block.NumberU64()
+	return true
+}
+
 // HasState checks if state trie is fully present in the database or not.
 func (bc *BlockChain) HasState(hash common.Hash) bool {
 	_, err := bc.stateCache.OpenTrie(hash)
 	return err == nil
 }
 
+// PinState retains the state with the given root in the trie database, even once
+// it leaves the window of recent states, until it's unpinned. It's used by online
+// pruning to keep its target state available while marking it.
+func (bc *BlockChain) PinState(root common.Hash) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	bc.stateCache.TrieDB().Reference(root, common.Hash{})
+}
+
+// UnpinState releases a state retained by PinState, optionally persisting it to
+// disk before.
+func (bc *BlockChain) UnpinState(root common.Hash, persist bool) error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	triedb := bc.stateCache.TrieDB()
+	if persist {
+		if err := triedb.Commit(root, true, nil); err != nil {
+			return err
+		}
+	}
+	triedb.Dereference(root)
+	return nil
+}
+
 // HasBlockAndState checks if a block and associated state trie is fully present
 // in the database or not, caching it if present.
 func (bc *BlockChain) HasBlockAndState(hash common.Hash, number uint64) bool {
@@ -1017,7 +1099,34 @@ func (bc *BlockChain) Stop() {
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
 	//  - HEAD-1:   So we don't do large reorgs if our HEAD becomes an uncle
 	//  - HEAD-127: So we have a hard limit on the number of blocks reexecuted
-	if !bc.cacheConfig.TrieDirtyDisabled {
+	if !bc.cacheConfig.TrieDirtyDisabled && bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		// Only a single state can be persisted with the path-based scheme. The
+		// cached states are written block by block up to the head, retaining a
+		// state history for each so that none need to be reprocessed.
+		triedb := bc.stateCache.TrieDB()
+		if head := bc.CurrentBlock(); head.NumberU64() > bc.stateWritten {
+			log.Info("Writing cached state to disk", "block", head.Number(), "hash", head.Hash(), "root", head.Root(), "blocks", head.NumberU64()-bc.stateWritten)
+			// States must be persisted in order, stop at the first one missing.
+			for number := bc.stateWritten + 1; number <= head.NumberU64(); number++ {
+				header := bc.GetHeaderByNumber(number)
+				if header == nil {
+					log.Error("Missing header of cached state", "number", number)
+					break
+				}
+				if err := triedb.Commit(header.Root, false, nil); err != nil {
+					log.Error("Failed to commit recent state trie", "number", number, "err", err)
+					break
+				}
+				bc.stateWritten = number
+			}
+			if bc.stateWritten < head.NumberU64() {
+				log.Error("Cached state only partially written to disk", "persisted", bc.stateWritten, "head", head.Number())
+			}
+		}
+		for !bc.triegc.Empty() {
+			triedb.Dereference(bc.triegc.PopItem().(common.Hash))
+		}
+	} else if !bc.cacheConfig.TrieDirtyDisabled {
 		triedb := bc.stateCache.TrieDB()
 
 		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
@@ -1555,8 +1664,21 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 			// Find the next state trie we need to commit
 			chosen := current - TriesInMemory
 
-			// If we exceeded out time allowance, flush an entire trie to disk
-			if bc.gcproc > bc.cacheConfig.TrieTimeLimit {
+			// The path-based scheme can't flush individual nodes, so the state is
+			// persisted block by block once it leaves the in-memory window.
+			if triedb.Scheme() == rawdb.PathScheme {
+				if chosen > bc.stateWritten {
+					if header := bc.GetHeaderByNumber(chosen); header == nil {
+						log.Warn("Reorg in progress, trie commit postponed", "number", chosen)
+					} else {
+						if err := triedb.Commit(header.Root, false, nil); err != nil {
+							return NonStatTy, err
+						}
+						bc.stateWritten = chosen
+					}
+				}
+			} else if bc.gcproc > bc.cacheConfig.TrieTimeLimit {
+				// If we exceeded out time allowance, flush an entire trie to disk
 				// If the header is missing (canonical chain behind), we're reorging a low
 				// diff sidechain. Suspend committing until this operation is completed.
 				header := bc.GetHeaderByNumber(chosen)
@@ -1916,7 +2038,8 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		trieproc := statedb.SnapshotAccountReads + statedb.AccountReads + statedb.AccountUpdates
 		trieproc += statedb.SnapshotStorageReads + statedb.StorageReads + statedb.StorageUpdates
 
-		blockExecutionTimer.Update(time.Since(substart) - trieproc - triehash)
+		execution := time.Since(substart) - trieproc - triehash
+		blockExecutionTimer.Update(execution)
 
 		// Validate the state using the default validator
 		substart = time.Now()
@@ -1931,7 +2054,8 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		accountHashTimer.Update(statedb.AccountHashes) // Account hashes are complete, we can mark them
 		storageHashTimer.Update(statedb.StorageHashes) // Storage hashes are complete, we can mark them
 
-		blockValidationTimer.Update(time.Since(substart) - (statedb.AccountHashes + statedb.StorageHashes - triehash))
+		validation := time.Since(substart) - (statedb.AccountHashes + statedb.StorageHashes - triehash)
+		blockValidationTimer.Update(validation)
 
 		// Write the block to the chain and get the status.
 		substart = time.Now()
@@ -1945,9 +2069,32 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		storageCommitTimer.Update(statedb.StorageCommits)   // Storage commits are complete, we can mark them
 		snapshotCommitTimer.Update(statedb.SnapshotCommits) // Snapshot commits are complete, we can mark them
 
-		blockWriteTimer.Update(time.Since(substart) - statedb.AccountCommits - statedb.StorageCommits - statedb.SnapshotCommits)
+		write := time.Since(substart) - statedb.AccountCommits - statedb.StorageCommits - statedb.SnapshotCommits
+		blockWriteTimer.Update(write)
 		blockInsertTimer.UpdateSince(start)
 
+		// The event is sent with the chain locked, skip it if nobody listens.
+		if atomic.LoadInt32(&bc.insertSubs) > 0 {
+			bc.insertFeed.Send(BlockInsertEvent{
+				Block:                block,
+				Execution:            execution,
+				Validation:           validation,
+				Write:                write,
+				Total:                time.Since(start),
+				AccountReads:         statedb.AccountReads,
+				StorageReads:         statedb.StorageReads,
+				AccountUpdates:       statedb.AccountUpdates,
+				StorageUpdates:       statedb.StorageUpdates,
+				SnapshotAccountReads: statedb.SnapshotAccountReads,
+				SnapshotStorageReads: statedb.SnapshotStorageReads,
+				AccountHashes:        statedb.AccountHashes,
+				StorageHashes:        statedb.StorageHashes,
+				AccountCommits:       statedb.AccountCommits,
+				StorageCommits:       statedb.StorageCommits,
+				SnapshotCommits:      statedb.SnapshotCommits,
+			})
+		}
+
 		switch status {
 		case CanonStatTy:
 			log.Debug("Inserted new block", "number", block.Number(), "hash", block.Hash(),
@@ -2545,6 +2692,27 @@ func (bc *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscript
 	return bc.scope.Track(bc.logsFeed.Subscribe(ch))
 }
 
+// SubscribeBlockInsertEvent registers a subscription of BlockInsertEvent. The
+// events are sent synchronously while the chain is locked for insertion, so the
+// channel must be buffered and drained promptly, otherwise it stalls the import.
+// The events are only produced while there are subscribers.
+func (bc *BlockChain) SubscribeBlockInsertEvent(ch chan<- BlockInsertEvent) event.Subscription {
+	sub := bc.insertFeed.Subscribe(ch)
+	atomic.AddInt32(&bc.insertSubs, 1)
+
+	return bc.scope.Track(event.NewSubscription(func(quit <-chan struct{}) error {
+		defer atomic.AddInt32(&bc.insertSubs, -1)
+		defer sub.Unsubscribe()
+
+		select {
+		case err := <-sub.Err():
+			return err
+		case <-quit:
+			return nil
+		}
+	}))
+}
+
 // SubscribeBlockProcessingEvent registers a subscription of bool where true means
 // block processing has started while false means it has stopped.
 func (bc *BlockChain) SubscribeBlockProcessingEvent(ch chan<- bool) event.Subscription {
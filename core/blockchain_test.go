@@ -24,6 +24,7 @@ import (
 	"math/rand"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -201,6 +202,42 @@ func TestLastBlock(t *testing.T) {
 	}
 }
 
+// Tests that the timings of every inserted block are posted in order.
+func TestBlockInsertEvent(t *testing.T) {
+	_, blockchain, err := newCanonical(ethash.NewFaker(), 0, true)
+	if err != nil {
+		t.Fatalf("failed to create pristine chain: %v", err)
+	}
+	defer blockchain.Stop()
+
+	events := make(chan BlockInsertEvent, 8)
+	sub := blockchain.SubscribeBlockInsertEvent(events)
+	defer sub.Unsubscribe()
+
+	blocks := makeBlockChain(blockchain.CurrentBlock(), 4, ethash.NewFaker(), blockchain.db, 0)
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	for i, block := range blocks {
+		select {
+		case ev := <-events:
+			if ev.Block.Hash() != block.Hash() {
+				t.Errorf("event %d: block mismatch: have %x, want %x", i, ev.Block.Hash(), block.Hash())
+			}
+			if ev.Total <= 0 || ev.Total < ev.Execution+ev.Validation+ev.Write {
+				t.Errorf("event %d: invalid total time %v", i, ev.Total)
+			}
+		default:
+			t.Fatalf("missing event for block %d", i)
+		}
+	}
+	// No events are produced once unsubscribed
+	sub.Unsubscribe()
+	if subs := atomic.LoadInt32(&blockchain.insertSubs); subs != 0 {
+		t.Fatalf("subscriber count mismatch after unsubscribing: have %d, want 0", subs)
+	}
+}
+
 // Tests that given a starting canonical chain of a given size, it can be extended
 // with various length chains.
 func TestExtendCanonicalHeaders(t *testing.T) { testExtendCanonical(t, false) }
@@ -3108,3 +3145,155 @@ func TestEIP2718Transition(t *testing.T) {
 
 	}
 }
+
+// Tests that chains configured to use binary state tries can be imported, and
+// that their blocks can be executed statelessly.
+func TestBinaryTrieChain(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		config   = *params.TestChainConfig
+	)
+	config.BinaryTrie = true
+
+	gspec := &Genesis{
+		Config: &config,
+		Alloc: GenesisAlloc{
+			addr: {Balance: big.NewInt(params.Ether)},
+			// Increments slot 0
+			contract: {Balance: new(big.Int), Code: common.Hex2Bytes("600054600101600055")},
+		},
+	}
+	db := rawdb.NewMemoryDatabase()
+	genesis := gspec.MustCommit(db)
+
+	mpt := *gspec
+	mpt.Config = params.TestChainConfig
+	if genesis.Root() == mpt.ToBlock(nil).Root() {
+		t.Fatalf("binary genesis root equals Merkle Patricia root")
+	}
+	signer := types.LatestSigner(&config)
+	blocks, _ := GenerateChain(&config, genesis, ethash.NewFaker(), db, 5, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{byte(i + 1)}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	chaindb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(chaindb)
+
+	chain, err := NewBlockChain(chaindb, nil, &config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if chain.snaps != nil {
+		t.Fatalf("snapshots enabled with binary tries")
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	state, err := chain.State()
+	if err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+	if counter := state.GetState(contract, common.Hash{}); counter != common.BigToHash(big.NewInt(5)) {
+		t.Fatalf("counter mismatch: have %x, want 5", counter)
+	}
+	for i, block := range blocks {
+		witness, err := chain.ExecutionWitness(block)
+		if err != nil {
+			t.Fatalf("block %d: failed to create witness: %v", i, err)
+		}
+		if _, err := ExecuteStateless(&config, ethash.NewFaker(), block, witness); err != nil {
+			t.Fatalf("block %d: stateless execution failed: %v", i, err)
+		}
+	}
+}
+
+// Tests that a chain using the path-based state scheme persists only the state
+// of a single block, and that it can roll back to older states using the state
+// histories.
+func TestPathSchemeChain(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				// Increments slot 0
+				contract: {Balance: new(big.Int), Code: common.Hex2Bytes("600054600101600055")},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+		signer  = types.LatestSigner(params.TestChainConfig)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 2*TriesInMemory+10, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	chaindb := rawdb.NewMemoryDatabase()
+	if err := EnablePathScheme(chaindb); err != nil {
+		t.Fatalf("failed to enable path scheme: %v", err)
+	}
+	gspec.MustCommit(chaindb)
+
+	if _, err := NewBlockChain(chaindb, &CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil); err == nil {
+		t.Fatalf("archive mode allowed with the path-based scheme")
+	}
+	chain, err := NewBlockChain(chaindb, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The state of the block leaving the in-memory window should be persisted
+	if have, want := rawdb.ReadPersistentStateRoot(chaindb), blocks[len(blocks)-1-TriesInMemory].Root(); have != want {
+		t.Fatalf("persistent root mismatch: have %x, want %x", have, want)
+	}
+	chain.Stop()
+
+	head := blocks[len(blocks)-1]
+	if have := rawdb.ReadPersistentStateRoot(chaindb); have != head.Root() {
+		t.Fatalf("head state not persisted on stop: have %x, want %x", have, head.Root())
+	}
+	checkCounter := func(chain *BlockChain, want uint64) {
+		t.Helper()
+
+		state, err := chain.State()
+		if err != nil {
+			t.Fatalf("failed to open head state: %v", err)
+		}
+		if counter := state.GetState(contract, common.Hash{}); counter != common.BigToHash(new(big.Int).SetUint64(want)) {
+			t.Fatalf("counter mismatch: have %x, want %d", counter, want)
+		}
+	}
+	// Reopen the chain and ensure the head state is available, but no older ones
+	chain, err = NewBlockChain(chaindb, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if chain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head block mismatch: have %d, want %d", chain.CurrentBlock().Number(), head.Number())
+	}
+	checkCounter(chain, head.NumberU64())
+	if chain.HasState(blocks[len(blocks)-2].Root()) {
+		t.Fatalf("stale state retained")
+	}
+	// Rewind the chain, rolling the state back using the histories
+	if err := chain.SetHead(head.NumberU64() - 20); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if have, want := chain.CurrentBlock().NumberU64(), head.NumberU64()-20; have != want {
+		t.Fatalf("rewound head mismatch: have %d, want %d", have, want)
+	}
+	checkCounter(chain, head.NumberU64()-20)
+}
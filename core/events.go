@@ -17,6 +17,8 @@
 package core
 
 import (
+	"time"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -41,3 +43,28 @@ type ChainSideEvent struct {
 }
 
 type ChainHeadEvent struct{ Block *types.Block }
+
+// BlockInsertEvent is posted when a block has been executed and written during
+// chain insertion, with the time spent in each phase of the import as measured
+// for the chain metrics. The state access times are only measured if expensive
+// metrics are enabled, otherwise they are accounted to the phases.
+type BlockInsertEvent struct {
+	Block *types.Block
+
+	Execution  time.Duration // Transaction execution, without state access and hashing
+	Validation time.Duration // State validation, without trie hashing
+	Write      time.Duration // Block and state write, without the commits
+	Total      time.Duration // Whole import of the block
+
+	AccountReads         time.Duration // Account reads from the trie
+	StorageReads         time.Duration // Storage reads from the trie
+	AccountUpdates       time.Duration // Account updates in the trie
+	StorageUpdates       time.Duration // Storage updates in the trie
+	SnapshotAccountReads time.Duration // Account reads from the snapshot
+	SnapshotStorageReads time.Duration // Storage reads from the snapshot
+	AccountHashes        time.Duration // Account trie hashing
+	StorageHashes        time.Duration // Storage trie hashing
+	AccountCommits       time.Duration // Account trie commits
+	StorageCommits       time.Duration // Storage trie commits
+	SnapshotCommits      time.Duration // Snapshot commits
+}
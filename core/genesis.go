@@ -42,7 +42,10 @@ import (
 //go:generate gencodec -type Genesis -field-override genesisSpecMarshaling -out gen_genesis.go
 //go:generate gencodec -type GenesisAccount -field-override genesisAccountMarshaling -out gen_genesis_account.go
 
-var errGenesisNoConfig = errors.New("genesis has no chain configuration")
+var (
+	errGenesisNoConfig   = errors.New("genesis has no chain configuration")
+	errBinaryTrieChanged = errors.New("binary trie setting cannot be changed on a non-empty chain")
+)
 
 // Genesis specifies the header fields, state of a genesis block. It also defines hard
 // fork switch-over blocks through the chain configuration.
@@ -176,9 +179,10 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, genesis *Genesis, override
 		return genesis.Config, block.Hash(), nil
 	}
 	// We have the genesis block in database(perhaps in ancient database)
-	// but the corresponding state is missing.
+	// but the corresponding state is missing. The path-based scheme retains
+	// the latest state only, so a missing genesis state is expected there.
 	header := rawdb.ReadHeader(db, stored, 0)
-	if _, err := state.New(header.Root, state.NewDatabaseWithConfig(db, nil), nil); err != nil {
+	if rawdb.ReadStateScheme(db) == rawdb.HashScheme && !hasState(db, stored, header.Root) {
 		if genesis == nil {
 			genesis = DefaultGenesisBlock()
 		}
@@ -193,6 +197,16 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, genesis *Genesis, override
 		}
 		return genesis.Config, block.Hash(), nil
 	}
+	// The state of a chain is committed with the trie type configured at its
+	// genesis, the binary trie setting cannot be changed once blocks are imported.
+	if genesis != nil {
+		storedcfg := rawdb.ReadChainConfig(db, stored)
+		if storedcfg != nil && storedcfg.BinaryTrie != genesis.Config.BinaryTrie {
+			if height := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db)); height == nil || *height != 0 {
+				return genesis.Config, stored, errBinaryTrieChanged
+			}
+		}
+	}
 	// Check whether the genesis block is already written.
 	if genesis != nil {
 		hash := genesis.ToBlock(nil).Hash()
@@ -234,6 +248,26 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, genesis *Genesis, override
 	return newcfg, stored, nil
 }
 
+// hasState reports whether the state with the given root is present.
+func hasState(db ethdb.Database, genesis common.Hash, root common.Hash) bool {
+	_, err := state.New(root, state.NewDatabaseForChain(db, rawdb.ReadChainConfig(db, genesis), nil), nil)
+	return err == nil
+}
+
+// EnablePathScheme configures a fresh database to persist the state with the
+// path-based scheme. Databases already initialized with the hash-based scheme
+// are rejected, enabling the scheme on a path-based database is a noop.
+func EnablePathScheme(db ethdb.Database) error {
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		return nil
+	}
+	if stored := rawdb.ReadCanonicalHash(db, 0); stored != (common.Hash{}) {
+		return errors.New("database already initialized with the hash-based state scheme")
+	}
+	rawdb.WritePersistentStateRoot(db, types.EmptyRootHash)
+	return nil
+}
+
 func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 	switch {
 	case g != nil:
@@ -259,7 +293,7 @@ func (g *Genesis) ToBlock(db ethdb.Database) *types.Block {
 	if db == nil {
 		db = rawdb.NewMemoryDatabase()
 	}
-	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabaseForChain(db, g.Config, nil), nil)
 	for addr, account := range g.Alloc {
 		statedb.AddBalance(addr, account.Balance)
 		statedb.SetCode(addr, account.Code)
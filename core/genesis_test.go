@@ -53,6 +53,8 @@ func TestSetupGenesis(t *testing.T) {
 		oldcustomg = customg
 	)
 	oldcustomg.Config = &params.ChainConfig{HomesteadBlock: big.NewInt(2)}
+	binaryg := customg
+	binaryg.Config = &params.ChainConfig{HomesteadBlock: big.NewInt(3), BinaryTrie: true}
 	tests := []struct {
 		name       string
 		fn         func(ethdb.Database) (*params.ChainConfig, common.Hash, error)
@@ -138,6 +140,24 @@ func TestSetupGenesis(t *testing.T) {
 				RewindTo:     1,
 			},
 		},
+		{
+			name: "binary trie changed in populated DB",
+			fn: func(db ethdb.Database) (*params.ChainConfig, common.Hash, error) {
+				// Import a few blocks on top of the genesis, then reopen the
+				// database with the binary trie setting flipped.
+				genesis := customg.MustCommit(db)
+
+				bc, _ := NewBlockChain(db, nil, customg.Config, ethash.NewFullFaker(), vm.Config{}, nil, nil)
+				defer bc.Stop()
+
+				blocks, _ := GenerateChain(customg.Config, genesis, ethash.NewFaker(), db, 4, nil)
+				bc.InsertChain(blocks)
+				return SetupGenesisBlock(db, &binaryg)
+			},
+			wantHash:   customghash,
+			wantConfig: binaryg.Config,
+			wantErr:    errBinaryTrieChanged,
+		},
 	}
 
 	for _, test := range tests {
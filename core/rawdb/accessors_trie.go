@@ -0,0 +1,189 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// The trie node storage schemes supported by the database.
+const (
+	// HashScheme stores trie nodes keyed by their hash, retaining all the states
+	// ever written until pruned offline.
+	HashScheme = "hash"
+
+	// PathScheme stores trie nodes keyed by their owner and path in the trie,
+	// retaining only the latest state along with a set of reverse diffs.
+	PathScheme = "path"
+)
+
+// ReadStateScheme returns the trie node storage scheme used by the database.
+func ReadStateScheme(db ethdb.KeyValueReader) string {
+	if data, _ := db.Get(persistentStateRootKey); len(data) != 0 {
+		return PathScheme
+	}
+	return HashScheme
+}
+
+// ReadPersistentStateRoot retrieves the root of the state persisted with the
+// path-based scheme.
+func ReadPersistentStateRoot(db ethdb.KeyValueReader) common.Hash {
+	data, _ := db.Get(persistentStateRootKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WritePersistentStateRoot stores the root of the state persisted with the
+// path-based scheme.
+func WritePersistentStateRoot(db ethdb.KeyValueWriter, root common.Hash) {
+	if err := db.Put(persistentStateRootKey, root[:]); err != nil {
+		log.Crit("Failed to store persistent state root", "err", err)
+	}
+}
+
+// ReadTrieNodeByPath retrieves the trie node at the given path of the trie
+// belonging to the given owner. The owner of the account trie is the zero hash.
+func ReadTrieNodeByPath(db ethdb.KeyValueReader, owner common.Hash, path []byte) []byte {
+	data, _ := db.Get(trieNodePathKey(owner, path))
+	return data
+}
+
+// WriteTrieNodeByPath stores the trie node at the given path of the trie
+// belonging to the given owner.
+func WriteTrieNodeByPath(db ethdb.KeyValueWriter, owner common.Hash, path []byte, node []byte) {
+	if err := db.Put(trieNodePathKey(owner, path), node); err != nil {
+		log.Crit("Failed to store trie node", "err", err)
+	}
+}
+
+// DeleteTrieNodeByPath deletes the trie node at the given path of the trie
+// belonging to the given owner.
+func DeleteTrieNodeByPath(db ethdb.KeyValueWriter, owner common.Hash, path []byte) {
+	if err := db.Delete(trieNodePathKey(owner, path)); err != nil {
+		log.Crit("Failed to delete trie node", "err", err)
+	}
+}
+
+// IterateStorageTrieNodes returns an iterator over all the path-keyed nodes of
+// the storage trie belonging to the given account. The keys of the iterator are
+// the database keys, the path starts after the first 1+32 bytes.
+func IterateStorageTrieNodes(db ethdb.Iteratee, owner common.Hash) ethdb.Iterator {
+	return db.NewIterator(trieNodePathKey(owner, nil), nil)
+}
+
+// ReadStateHistoryRange retrieves the ids of the last pruned and the latest
+// stored state histories.
+func ReadStateHistoryRange(db ethdb.KeyValueReader) (uint64, uint64) {
+	var tail, head uint64
+	if data, _ := db.Get(stateHistoryTailKey); len(data) == 8 {
+		tail = binary.BigEndian.Uint64(data)
+	}
+	if data, _ := db.Get(stateHistoryHeadKey); len(data) == 8 {
+		head = binary.BigEndian.Uint64(data)
+	}
+	return tail, head
+}
+
+// WriteStateHistoryRange stores the ids of the last pruned and the latest stored
+// state histories.
+func WriteStateHistoryRange(db ethdb.KeyValueWriter, tail, head uint64) {
+	if err := db.Put(stateHistoryTailKey, encodeBlockNumber(tail)); err != nil {
+		log.Crit("Failed to store state history tail", "err", err)
+	}
+	if err := db.Put(stateHistoryHeadKey, encodeBlockNumber(head)); err != nil {
+		log.Crit("Failed to store state history head", "err", err)
+	}
+}
+
+// ReadStateHistory retrieves the RLP encoded trie nodes of the state history with
+// the given id.
+func ReadStateHistory(db ethdb.KeyValueReader, id uint64) []byte {
+	data, _ := db.Get(stateHistoryKey(id))
+	return data
+}
+
+// WriteStateHistory stores the RLP encoded trie nodes of the state history with
+// the given id.
+func WriteStateHistory(db ethdb.KeyValueWriter, id uint64, history []byte) {
+	if err := db.Put(stateHistoryKey(id), history); err != nil {
+		log.Crit("Failed to store state history", "err", err)
+	}
+}
+
+// DeleteStateHistory deletes the trie nodes of the state history with the given id.
+func DeleteStateHistory(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(stateHistoryKey(id)); err != nil {
+		log.Crit("Failed to delete state history", "err", err)
+	}
+}
+
+// ReadStateHistoryMeta retrieves the persistent state roots before and after the
+// commit reverted by the state history with the given id.
+func ReadStateHistoryMeta(db ethdb.KeyValueReader, id uint64) (common.Hash, common.Hash, bool) {
+	data, _ := db.Get(stateHistoryMetaKey(id))
+	if len(data) != 2*common.HashLength {
+		return common.Hash{}, common.Hash{}, false
+	}
+	return common.BytesToHash(data[:common.HashLength]), common.BytesToHash(data[common.HashLength:]), true
+}
+
+// WriteStateHistoryMeta stores the persistent state roots before and after the
+// commit reverted by the state history with the given id.
+func WriteStateHistoryMeta(db ethdb.KeyValueWriter, id uint64, parent, root common.Hash) {
+	if err := db.Put(stateHistoryMetaKey(id), append(parent.Bytes(), root.Bytes()...)); err != nil {
+		log.Crit("Failed to store state history meta", "err", err)
+	}
+}
+
+// DeleteStateHistoryMeta deletes the state roots of the state history with the
+// given id.
+func DeleteStateHistoryMeta(db ethdb.KeyValueWriter, id uint64) {
+	if err := db.Delete(stateHistoryMetaKey(id)); err != nil {
+		log.Crit("Failed to delete state history meta", "err", err)
+	}
+}
+
+// ReadStateHistoryID retrieves the id of the latest state history reverting the
+// persistent state to the given root.
+func ReadStateHistoryID(db ethdb.KeyValueReader, parent common.Hash) (uint64, bool) {
+	data, _ := db.Get(stateHistoryIndexKey(parent))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// WriteStateHistoryID stores the id of the latest state history reverting the
+// persistent state to the given root.
+func WriteStateHistoryID(db ethdb.KeyValueWriter, parent common.Hash, id uint64) {
+	if err := db.Put(stateHistoryIndexKey(parent), encodeBlockNumber(id)); err != nil {
+		log.Crit("Failed to store state history index", "err", err)
+	}
+}
+
+// DeleteStateHistoryID deletes the state history index entry of the given root.
+func DeleteStateHistoryID(db ethdb.KeyValueWriter, parent common.Hash) {
+	if err := db.Delete(stateHistoryIndexKey(parent)); err != nil {
+		log.Crit("Failed to delete state history index", "err", err)
+	}
+}
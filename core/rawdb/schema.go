@@ -69,6 +69,16 @@ var (
 	// badBlockKey tracks the list of bad blocks seen by local
 	badBlockKey = []byte("InvalidBlock")
 
+	// persistentStateRootKey tracks the root of the state persisted by the path-based
+	// trie node storage scheme. Its presence marks the database as using the scheme.
+	persistentStateRootKey = []byte("PersistentStateRoot")
+
+	// stateHistoryHeadKey tracks the id of the latest stored state history.
+	stateHistoryHeadKey = []byte("StateHistoryHead")
+
+	// stateHistoryTailKey tracks the id of the last pruned state history.
+	stateHistoryTailKey = []byte("StateHistoryTail")
+
 	// uncleanShutdownKey tracks the list of local crashes
 	uncleanShutdownKey = []byte("unclean-shutdown") // config prefix for the db
 
@@ -81,11 +91,16 @@ var (
 	blockBodyPrefix     = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
 
-	txLookupPrefix        = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
-	bloomBitsPrefix       = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
-	SnapshotAccountPrefix = []byte("a") // SnapshotAccountPrefix + account hash -> account trie value
-	SnapshotStoragePrefix = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
-	CodePrefix            = []byte("c") // CodePrefix + code hash -> account code
+	txLookupPrefix          = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
+	bloomBitsPrefix         = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	SnapshotAccountPrefix   = []byte("a") // SnapshotAccountPrefix + account hash -> account trie value
+	SnapshotStoragePrefix   = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
+	CodePrefix              = []byte("c") // CodePrefix + code hash -> account code
+	TrieNodeAccountPrefix   = []byte("A") // TrieNodeAccountPrefix + hex path -> account trie node
+	TrieNodeStoragePrefix   = []byte("O") // TrieNodeStoragePrefix + account hash + hex path -> storage trie node
+	stateHistoryPrefix      = []byte("R") // stateHistoryPrefix + id (uint64 big endian) -> state history trie nodes
+	stateHistoryMetaPrefix  = []byte("M") // stateHistoryMetaPrefix + id (uint64 big endian) -> parent root + root
+	stateHistoryIndexPrefix = []byte("I") // stateHistoryIndexPrefix + parent root -> state history id (uint64 big endian)
 
 	preimagePrefix = []byte("secure-key-")      // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("ethereum-config-") // config prefix for the db
@@ -194,6 +209,30 @@ func storageSnapshotsKey(accountHash common.Hash) []byte {
 	return append(SnapshotStoragePrefix, accountHash.Bytes()...)
 }
 
+// trieNodePathKey = TrieNodeAccountPrefix + hex path, if the owner is empty
+// trieNodePathKey = TrieNodeStoragePrefix + account hash + hex path, otherwise
+func trieNodePathKey(owner common.Hash, path []byte) []byte {
+	if owner == (common.Hash{}) {
+		return append(append([]byte{}, TrieNodeAccountPrefix...), path...)
+	}
+	return append(append(append([]byte{}, TrieNodeStoragePrefix...), owner.Bytes()...), path...)
+}
+
+// stateHistoryKey = stateHistoryPrefix + id (uint64 big endian)
+func stateHistoryKey(id uint64) []byte {
+	return append(stateHistoryPrefix, encodeBlockNumber(id)...)
+}
+
+// stateHistoryMetaKey = stateHistoryMetaPrefix + id (uint64 big endian)
+func stateHistoryMetaKey(id uint64) []byte {
+	return append(stateHistoryMetaPrefix, encodeBlockNumber(id)...)
+}
+
+// stateHistoryIndexKey = stateHistoryIndexPrefix + parent root
+func stateHistoryIndexKey(parent common.Hash) []byte {
+	return append(stateHistoryIndexPrefix, parent.Bytes()...)
+}
+
 // bloomBitsKey = bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash
 func bloomBitsKey(bit uint, section uint64, hash common.Hash) []byte {
 	key := append(append(bloomBitsPrefix, make([]byte, 10)...), hash.Bytes()...)
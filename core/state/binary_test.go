@@ -0,0 +1,125 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// databaseConstructor creates the state database a test runs on.
+type databaseConstructor func(ethdb.Database) Database
+
+// newBinaryDatabase creates a state database storing the state in binary tries.
+func newBinaryDatabase(db ethdb.Database) Database {
+	return NewBinaryDatabase(db, nil)
+}
+
+// Runs the state tests not depending on the Merkle Patricia trie with the state
+// stored in binary tries.
+func TestBinaryTries(t *testing.T) {
+	tests := []struct {
+		name string
+		test func(*testing.T, databaseConstructor)
+	}{
+		{"UpdateLeaks", testUpdateLeaks},
+		{"IntermediateLeaks", testIntermediateLeaks},
+		{"Copy", testCopy},
+		{"SnapshotRandom", testSnapshotRandom},
+		{"TouchDelete", testTouchDelete},
+		{"CopyOfCopy", testCopyOfCopy},
+		{"CopyCommitCopy", testCopyCommitCopy},
+		{"CopyCopyCommitCopy", testCopyCopyCommitCopy},
+		{"DeleteCreateRevert", testDeleteCreateRevert},
+		{"MissingTrieNodes", testMissingTrieNodes},
+		{"StateDBAccessList", testStateDBAccessList},
+		{"Null", testNull},
+		{"Snapshot", testSnapshot},
+		{"SnapshotEmpty", testSnapshotEmpty},
+		{"Snapshot2", testSnapshot2},
+		{"NodeIteratorCoverage", testNodeIteratorCoverage},
+		{"Diff", testDiff},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) { tt.test(t, newBinaryDatabase) })
+	}
+}
+
+// Tests that binary tries commit to the state with different roots than Merkle
+// Patricia tries, and that accounts can be proven against them.
+func TestBinaryStateRoot(t *testing.T) {
+	var (
+		addr     = common.Address{0x01}
+		mpt, _   = New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		binary   = NewBinaryDatabase(rawdb.NewMemoryDatabase(), nil)
+		state, _ = New(common.Hash{}, binary, nil)
+	)
+	for _, s := range []*StateDB{mpt, state} {
+		s.SetBalance(addr, big.NewInt(42))
+		s.SetState(addr, common.Hash{1}, common.Hash{2})
+	}
+	root, err := state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if want := mpt.IntermediateRoot(false); root == want {
+		t.Fatalf("binary state root equals Merkle Patricia root %x", root)
+	}
+	state, _ = New(root, binary, nil)
+	if balance := state.GetBalance(addr); balance.Uint64() != 42 {
+		t.Fatalf("balance mismatch: have %v, want 42", balance)
+	}
+	if _, ok := state.trie.(*trie.BinaryTrie); !ok {
+		t.Fatalf("wrong trie type %T", state.trie)
+	}
+	// Prove the account and its storage slot.
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		t.Fatalf("failed to prove account: %v", err)
+	}
+	proofDb := memorydb.New()
+	for _, node := range proof {
+		hash := sha256.Sum256(node)
+		proofDb.Put(hash[:], node)
+	}
+	enc, err := trie.VerifyBinaryProof(root, crypto.Keccak256(addr[:]), proofDb)
+	if err != nil || enc == nil {
+		t.Fatalf("invalid account proof: %v", err)
+	}
+	proof, err = state.GetStorageProof(addr, common.Hash{1})
+	if err != nil {
+		t.Fatalf("failed to prove storage: %v", err)
+	}
+	for _, node := range proof {
+		hash := sha256.Sum256(node)
+		proofDb.Put(hash[:], node)
+	}
+	obj := state.getStateObject(addr)
+	enc, err = trie.VerifyBinaryProof(obj.data.Root, crypto.Keccak256(common.Hash{1}.Bytes()), proofDb)
+	if err != nil || enc == nil {
+		t.Fatalf("invalid storage proof: %v", err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -57,7 +58,8 @@ type Database interface {
 	TrieDB() *trie.Database
 }
 
-// Trie is a Ethereum Merkle Patricia trie.
+// Trie is a Ethereum state trie, committing to its contents either as a Merkle
+// Patricia trie or as a binary Merkle trie.
 type Trie interface {
 	// GetKey returns the sha3 preimage of a hashed key that was previously used
 	// to store a value.
@@ -121,24 +123,50 @@ func NewDatabaseWithConfig(db ethdb.Database, config *trie.Config) Database {
 	}
 }
 
+// NewBinaryDatabase creates a backing store for state whose account and storage
+// tries are binary Merkle tries instead of Merkle Patricia tries. Apart from the
+// trie nodes and root hashes, the stored state is the same.
+func NewBinaryDatabase(db ethdb.Database, config *trie.Config) Database {
+	cdb := NewDatabaseWithConfig(db, config).(*cachingDB)
+	cdb.binary = true
+	return cdb
+}
+
+// NewDatabaseForChain creates a backing store for state using the trie type the
+// given chain configuration requires.
+func NewDatabaseForChain(db ethdb.Database, chainConfig *params.ChainConfig, config *trie.Config) Database {
+	if chainConfig != nil && chainConfig.BinaryTrie {
+		return NewBinaryDatabase(db, config)
+	}
+	return NewDatabaseWithConfig(db, config)
+}
+
 type cachingDB struct {
 	db            *trie.Database
 	codeSizeCache *lru.Cache
 	codeCache     *fastcache.Cache
+	binary        bool // Whether binary tries are used instead of Merkle Patricia ones
 }
 
 // OpenTrie opens the main account trie at a specific root hash.
 func (db *cachingDB) OpenTrie(root common.Hash) (Trie, error) {
-	tr, err := trie.NewSecure(root, db.db)
-	if err != nil {
-		return nil, err
-	}
-	return tr, nil
+	return db.openTrie(common.Hash{}, root)
 }
 
 // OpenStorageTrie opens the storage trie of an account.
 func (db *cachingDB) OpenStorageTrie(addrHash, root common.Hash) (Trie, error) {
-	tr, err := trie.NewSecure(root, db.db)
+	return db.openTrie(addrHash, root)
+}
+
+func (db *cachingDB) openTrie(owner, root common.Hash) (Trie, error) {
+	if db.binary {
+		tr, err := trie.NewBinary(root, db.db)
+		if err != nil {
+			return nil, err
+		}
+		return tr, nil
+	}
+	tr, err := trie.NewSecureWithOwner(owner, root, db.db)
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +178,8 @@ func (db *cachingDB) CopyTrie(t Trie) Trie {
 	switch t := t.(type) {
 	case *trie.SecureTrie:
 		return t.Copy()
+	case *trie.BinaryTrie:
+		return t.Copy()
 	default:
 		panic(fmt.Errorf("unknown trie type %T", t))
 	}
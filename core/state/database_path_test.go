@@ -0,0 +1,117 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// countStorageNodes returns the number of path-keyed storage trie nodes of the
+// given account.
+func countStorageNodes(db ethdb.KeyValueStore, addr common.Address) int {
+	it := rawdb.IterateStorageTrieNodes(db, crypto.Keccak256Hash(addr[:]))
+	defer it.Release()
+
+	var nodes int
+	for it.Next() {
+		nodes++
+	}
+	return nodes
+}
+
+func TestPathSchemeStorage(t *testing.T) {
+	var (
+		diskdb = rawdb.NewMemoryDatabase()
+		kept   = common.Address{0x01}
+		clear  = common.Address{0x02}
+		killed = common.Address{0x03}
+		twins  = []common.Address{{0x04}, {0x05}}
+	)
+	rawdb.WritePersistentStateRoot(diskdb, emptyRoot)
+	db := NewDatabase(diskdb)
+
+	state, _ := New(common.Hash{}, db, nil)
+	for _, addr := range append([]common.Address{kept, clear, killed}, twins...) {
+		state.SetNonce(addr, 1)
+		for i := byte(1); i <= 20; i++ {
+			state.SetState(addr, common.Hash{i}, common.Hash{i})
+		}
+	}
+	prevRoot, _ := state.Commit(false)
+	if err := db.TrieDB().Commit(prevRoot, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	for _, addr := range append([]common.Address{kept, clear, killed}, twins...) {
+		if countStorageNodes(diskdb, addr) == 0 {
+			t.Fatalf("storage of %x not persisted", addr)
+		}
+	}
+	// Clear the storage of an account and delete another one
+	state, _ = New(prevRoot, db, nil)
+	for i := byte(1); i <= 20; i++ {
+		state.SetState(clear, common.Hash{i}, common.Hash{})
+	}
+	state.Suicide(killed)
+	state.SetState(kept, common.Hash{1}, common.Hash{0xff})
+
+	root, _ := state.Commit(true)
+	if err := db.TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	for _, addr := range []common.Address{clear, killed} {
+		if nodes := countStorageNodes(diskdb, addr); nodes != 0 {
+			t.Errorf("obsolete storage of %x retained: %d nodes", addr, nodes)
+		}
+	}
+	check := func(root common.Hash, want map[common.Address]map[common.Hash]common.Hash) {
+		state, err := New(root, NewDatabase(diskdb), nil)
+		if err != nil {
+			t.Fatalf("failed to open state: %v", err)
+		}
+		for addr, slots := range want {
+			for slot, value := range slots {
+				if have := state.GetState(addr, slot); have != value {
+					t.Errorf("slot %x of %x mismatch: have %x, want %x", slot, addr, have, value)
+				}
+			}
+		}
+		if err := state.Error(); err != nil {
+			t.Fatalf("failed to read state: %v", err)
+		}
+	}
+	check(root, map[common.Address]map[common.Hash]common.Hash{
+		kept:     {{1}: {0xff}, {2}: {2}},
+		clear:    {{1}: {}},
+		killed:   {{1}: {}},
+		twins[0]: {{1}: {1}, {20}: {20}},
+		twins[1]: {{1}: {1}, {20}: {20}},
+	})
+	// Roll back to the previous state, restoring the deleted storage
+	if err := db.TrieDB().Rollback(prevRoot); err != nil {
+		t.Fatalf("failed to roll back state: %v", err)
+	}
+	check(prevRoot, map[common.Address]map[common.Hash]common.Hash{
+		kept:   {{1}: {1}, {2}: {2}},
+		clear:  {{1}: {1}},
+		killed: {{20}: {20}},
+	})
+}
@@ -0,0 +1,273 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// StateDiff represents the changes between two states.
+type StateDiff struct {
+	PrevRoot common.Hash    `json:"prevRoot"`
+	PostRoot common.Hash    `json:"postRoot"`
+	Accounts []*AccountDiff `json:"accounts"`
+}
+
+// AccountDiff represents the changes of a single account, ordered by the hash of
+// the address. The address is only known if it was resolved by the keys passed to
+// Diff or if the preimage of the key is available.
+type AccountDiff struct {
+	Address *common.Address `json:"address,omitempty"`
+	Key     common.Hash     `json:"key"`
+	Prev    *DiffAccount    `json:"prev"` // nil if the account was created
+	Post    *DiffAccount    `json:"post"` // nil if the account was deleted
+	Storage []*StorageDiff  `json:"storage,omitempty"`
+}
+
+// DiffAccount is the state of an account before or after a change. The code is
+// only included in the post state, if it changed.
+type DiffAccount struct {
+	Balance  *hexutil.Big   `json:"balance"`
+	Nonce    hexutil.Uint64 `json:"nonce"`
+	CodeHash common.Hash    `json:"codeHash"`
+	Root     common.Hash    `json:"root"`
+	Code     hexutil.Bytes  `json:"code,omitempty"`
+}
+
+// StorageDiff represents the change of a single storage slot. Slots that don't
+// exist have a zero value. The slot is only known if it was resolved by the keys
+// passed to Diff or if the preimage of the key is available.
+type StorageDiff struct {
+	Slot *common.Hash `json:"slot,omitempty"`
+	Key  common.Hash  `json:"key"`
+	Prev common.Hash  `json:"prev"`
+	Post common.Hash  `json:"post"`
+}
+
+// DiffKeys maps the hashed trie keys of a state diff back to the account addresses
+// and storage slots they were derived from.
+type DiffKeys struct {
+	Accounts map[common.Hash]common.Address
+	Slots    map[common.Hash]map[common.Hash]common.Hash // Keyed by the hash of the address
+}
+
+// DiffKeys returns the keys of all accounts and storage slots accessed through the
+// state, which resolve the keys of the changes made by executing on top of it
+// without relying on recorded preimages.
+func (s *StateDB) DiffKeys() *DiffKeys {
+	keys := &DiffKeys{
+		Accounts: make(map[common.Hash]common.Address, len(s.stateObjects)),
+		Slots:    make(map[common.Hash]map[common.Hash]common.Hash),
+	}
+	for addr, obj := range s.stateObjects {
+		keys.Accounts[obj.addrHash] = addr
+
+		slots := make(map[common.Hash]common.Hash)
+		for _, storage := range []Storage{obj.originStorage, obj.pendingStorage, obj.dirtyStorage} {
+			for slot := range storage {
+				slots[crypto.Keccak256Hash(slot[:])] = slot
+			}
+		}
+		if len(slots) > 0 {
+			keys.Slots[obj.addrHash] = slots
+		}
+	}
+	return keys
+}
+
+// leafDiff is a key whose value differs between two tries.
+type leafDiff struct {
+	key        []byte
+	prev, post []byte // nil if not present in the trie
+}
+
+// diffLeaves returns the leaves which differ between the two tries, ordered by
+// their key.
+func diffLeaves(prev, post Trie) ([]*leafDiff, error) {
+	var (
+		diffs []*leafDiff
+		index = make(map[string]*leafDiff)
+	)
+	added, _ := trie.NewDifferenceIterator(prev.NodeIterator(nil), post.NodeIterator(nil))
+	it := trie.NewIterator(added)
+	for it.Next() {
+		diff := &leafDiff{key: common.CopyBytes(it.Key), post: common.CopyBytes(it.Value)}
+		diffs = append(diffs, diff)
+		index[string(diff.key)] = diff
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	removed, _ := trie.NewDifferenceIterator(post.NodeIterator(nil), prev.NodeIterator(nil))
+	it = trie.NewIterator(removed)
+	for it.Next() {
+		if diff := index[string(it.Key)]; diff != nil {
+			diff.prev = common.CopyBytes(it.Value)
+			continue
+		}
+		diffs = append(diffs, &leafDiff{key: common.CopyBytes(it.Key), prev: common.CopyBytes(it.Value)})
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	sort.Slice(diffs, func(i, j int) bool { return bytes.Compare(diffs[i].key, diffs[j].key) < 0 })
+	return diffs, nil
+}
+
+// Diff computes the changes of all accounts and storage slots between the two
+// given state roots, by iterating over the differing nodes of the tries. The keys
+// of the changes are resolved through the given keys if any, falling back to the
+// recorded preimages.
+func Diff(db Database, prevRoot, postRoot common.Hash, keys *DiffKeys) (*StateDiff, error) {
+	prevTrie, err := db.OpenTrie(prevRoot)
+	if err != nil {
+		return nil, err
+	}
+	postTrie, err := db.OpenTrie(postRoot)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := diffLeaves(prevTrie, postTrie)
+	if err != nil {
+		return nil, err
+	}
+	result := &StateDiff{PrevRoot: prevRoot, PostRoot: postRoot, Accounts: make([]*AccountDiff, 0, len(leaves))}
+	for _, leaf := range leaves {
+		diff := &AccountDiff{Key: common.BytesToHash(leaf.key)}
+		if addr, ok := keys.account(diff.Key); ok {
+			diff.Address = &addr
+		} else if preimage := postTrie.GetKey(leaf.key); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			diff.Address = &addr
+		} else if preimage := prevTrie.GetKey(leaf.key); preimage != nil {
+			addr := common.BytesToAddress(preimage)
+			diff.Address = &addr
+		}
+		var prev, post Account
+		prev.Root, post.Root = emptyRoot, emptyRoot
+		if leaf.prev != nil {
+			if err := rlp.DecodeBytes(leaf.prev, &prev); err != nil {
+				return nil, fmt.Errorf("invalid account %x: %v", leaf.key, err)
+			}
+			diff.Prev = newDiffAccount(&prev)
+		}
+		if leaf.post != nil {
+			if err := rlp.DecodeBytes(leaf.post, &post); err != nil {
+				return nil, fmt.Errorf("invalid account %x: %v", leaf.key, err)
+			}
+			diff.Post = newDiffAccount(&post)
+
+			if !bytes.Equal(post.CodeHash, emptyCodeHash) && (leaf.prev == nil || !bytes.Equal(post.CodeHash, prev.CodeHash)) {
+				code, err := db.ContractCode(diff.Key, common.BytesToHash(post.CodeHash))
+				if err != nil {
+					return nil, fmt.Errorf("missing code %x: %v", post.CodeHash, err)
+				}
+				diff.Post.Code = code
+			}
+		}
+		if prev.Root != post.Root {
+			if diff.Storage, err = diffStorage(db, diff.Key, prev.Root, post.Root, keys); err != nil {
+				return nil, err
+			}
+		}
+		result.Accounts = append(result.Accounts, diff)
+	}
+	return result, nil
+}
+
+// diffStorage computes the changed storage slots of an account.
+func diffStorage(db Database, addrHash, prevRoot, postRoot common.Hash, keys *DiffKeys) ([]*StorageDiff, error) {
+	prevTrie, err := db.OpenStorageTrie(addrHash, prevRoot)
+	if err != nil {
+		return nil, err
+	}
+	postTrie, err := db.OpenStorageTrie(addrHash, postRoot)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := diffLeaves(prevTrie, postTrie)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]*StorageDiff, 0, len(leaves))
+	for _, leaf := range leaves {
+		diff := &StorageDiff{Key: common.BytesToHash(leaf.key)}
+		if slot, ok := keys.slot(addrHash, diff.Key); ok {
+			diff.Slot = &slot
+		} else if preimage := postTrie.GetKey(leaf.key); preimage != nil {
+			slot := common.BytesToHash(preimage)
+			diff.Slot = &slot
+		} else if preimage := prevTrie.GetKey(leaf.key); preimage != nil {
+			slot := common.BytesToHash(preimage)
+			diff.Slot = &slot
+		}
+		if diff.Prev, err = decodeSlot(leaf.prev); err != nil {
+			return nil, err
+		}
+		if diff.Post, err = decodeSlot(leaf.post); err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// account returns the address hashing to the given key, if known.
+func (k *DiffKeys) account(key common.Hash) (common.Address, bool) {
+	if k == nil {
+		return common.Address{}, false
+	}
+	addr, ok := k.Accounts[key]
+	return addr, ok
+}
+
+// slot returns the storage slot of an account hashing to the given key, if known.
+func (k *DiffKeys) slot(addrHash, key common.Hash) (common.Hash, bool) {
+	if k == nil {
+		return common.Hash{}, false
+	}
+	slot, ok := k.Slots[addrHash][key]
+	return slot, ok
+}
+
+func newDiffAccount(account *Account) *DiffAccount {
+	return &DiffAccount{
+		Balance:  (*hexutil.Big)(account.Balance),
+		Nonce:    hexutil.Uint64(account.Nonce),
+		CodeHash: common.BytesToHash(account.CodeHash),
+		Root:     account.Root,
+	}
+}
+
+func decodeSlot(enc []byte) (common.Hash, error) {
+	if enc == nil {
+		return common.Hash{}, nil
+	}
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(content), nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+func TestDiff(t *testing.T) { testDiff(t, NewDatabase) }
+
+func testDiff(t *testing.T, newDB databaseConstructor) {
+	var (
+		db       = newDB(rawdb.NewMemoryDatabase())
+		state, _ = New(common.Hash{}, db, nil)
+
+		unchanged = common.Address{0x01}
+		changed   = common.Address{0x02}
+		deleted   = common.Address{0x03}
+		created   = common.Address{0x04}
+	)
+	for i := byte(0); i < 50; i++ {
+		state.AddBalance(common.Address{0xff, i}, big.NewInt(int64(i)+1))
+	}
+	state.AddBalance(unchanged, big.NewInt(1))
+	state.SetState(unchanged, common.Hash{1}, common.Hash{1})
+	state.AddBalance(changed, big.NewInt(10))
+	state.SetState(changed, common.Hash{1}, common.Hash{1})
+	state.SetState(changed, common.Hash{2}, common.Hash{2})
+	state.SetState(deleted, common.Hash{1}, common.Hash{3})
+	state.SetCode(deleted, []byte{0x01})
+
+	prevRoot, _ := state.Commit(false)
+	db.TrieDB().Commit(prevRoot, false, nil)
+
+	state.SetNonce(changed, 1)
+	state.SetState(changed, common.Hash{1}, common.Hash{})
+	state.SetState(changed, common.Hash{2}, common.Hash{4})
+	state.SetState(changed, common.Hash{3}, common.Hash{5})
+	state.Suicide(deleted)
+	state.SetCode(created, []byte{0x02})
+	state.AddBalance(created, big.NewInt(2))
+
+	postRoot, _ := state.Commit(true)
+	db.TrieDB().Commit(postRoot, false, nil)
+
+	diff, err := Diff(db, prevRoot, postRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.PrevRoot != prevRoot || diff.PostRoot != postRoot {
+		t.Fatalf("root mismatch")
+	}
+	accounts := make(map[common.Address]*AccountDiff)
+	for _, account := range diff.Accounts {
+		if account.Address == nil {
+			t.Fatalf("missing address for key %x", account.Key)
+		}
+		if account.Key != crypto.Keccak256Hash(account.Address[:]) {
+			t.Fatalf("key mismatch for %x", account.Address)
+		}
+		accounts[*account.Address] = account
+	}
+	if len(accounts) != 3 {
+		t.Fatalf("wrong number of changed accounts: have %d, want 3", len(accounts))
+	}
+	// Check the changed account along with its storage.
+	account := accounts[changed]
+	if account.Prev == nil || account.Post == nil {
+		t.Fatalf("changed account reported as created or deleted")
+	}
+	if account.Prev.Nonce != 0 || account.Post.Nonce != 1 || account.Post.Balance.ToInt().Int64() != 10 || account.Post.Code != nil {
+		t.Errorf("invalid account change: prev %+v, post %+v", account.Prev, account.Post)
+	}
+	want := []StorageDiff{
+		{Slot: &common.Hash{1}, Prev: common.Hash{1}, Post: common.Hash{}},
+		{Slot: &common.Hash{2}, Prev: common.Hash{2}, Post: common.Hash{4}},
+		{Slot: &common.Hash{3}, Prev: common.Hash{}, Post: common.Hash{5}},
+	}
+	if len(account.Storage) != len(want) {
+		t.Fatalf("wrong number of changed slots: have %d, want %d", len(account.Storage), len(want))
+	}
+	for _, slot := range account.Storage {
+		w := want[slot.Slot[0]-1]
+		if slot.Prev != w.Prev || slot.Post != w.Post || slot.Key != crypto.Keccak256Hash(w.Slot[:]) {
+			t.Errorf("slot %x: have %x -> %x, want %x -> %x", slot.Slot, slot.Prev, slot.Post, w.Prev, w.Post)
+		}
+	}
+	// Check the deleted and the created account.
+	account = accounts[deleted]
+	if account.Post != nil || account.Prev == nil || len(account.Storage) != 1 || account.Storage[0].Prev != (common.Hash{3}) {
+		t.Errorf("invalid deletion: %+v", account)
+	}
+	account = accounts[created]
+	if account.Prev != nil || account.Post == nil || string(account.Post.Code) != "\x02" || account.Post.Balance.ToInt().Int64() != 2 {
+		t.Errorf("invalid creation: %+v", account)
+	}
+	// Diffing the other way around reverses the changes.
+	reverse, err := Diff(db, postRoot, prevRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, account := range reverse.Accounts {
+		if (account.Prev == nil) != (diff.Accounts[i].Post == nil) || len(account.Storage) != len(diff.Accounts[i].Storage) {
+			t.Errorf("reverse diff mismatch for %x", account.Address)
+		}
+	}
+}
+
+// Tests that the keys of a diff are resolved through the state the changes were
+// made on, without relying on recorded preimages.
+func TestDiffKeysWithoutPreimages(t *testing.T) {
+	var (
+		db       = NewDatabaseWithConfig(rawdb.NewMemoryDatabase(), &trie.Config{Preimages: false})
+		state, _ = New(common.Hash{}, db, nil)
+
+		changed = common.Address{0x01}
+		created = common.Address{0x02}
+	)
+	state.AddBalance(changed, big.NewInt(1))
+	state.SetState(changed, common.Hash{1}, common.Hash{1})
+	prevRoot, _ := state.Commit(false)
+	db.TrieDB().Commit(prevRoot, false, nil)
+
+	// Make the changes on a fresh state, as executing a block would.
+	state, _ = New(prevRoot, db, nil)
+	state.SetState(changed, common.Hash{1}, common.Hash{2})
+	state.SetState(changed, common.Hash{2}, common.Hash{3})
+	state.AddBalance(created, big.NewInt(2))
+	postRoot, _ := state.Commit(true)
+	db.TrieDB().Commit(postRoot, false, nil)
+
+	diff, err := Diff(db, prevRoot, postRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range diff.Accounts {
+		if account.Address != nil {
+			t.Fatalf("address %x resolved without preimages", account.Address)
+		}
+	}
+	diff, err = Diff(db, prevRoot, postRoot, state.DiffKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Accounts) != 2 {
+		t.Fatalf("wrong number of changed accounts: have %d, want 2", len(diff.Accounts))
+	}
+	for _, account := range diff.Accounts {
+		if account.Address == nil || (*account.Address != changed && *account.Address != created) {
+			t.Fatalf("unresolved or unexpected account %x", account.Key)
+		}
+		if *account.Address != changed {
+			continue
+		}
+		if len(account.Storage) != 2 {
+			t.Fatalf("wrong number of changed slots: have %d, want 2", len(account.Storage))
+		}
+		for _, slot := range account.Storage {
+			if slot.Slot == nil || slot.Key != crypto.Keccak256Hash(slot.Slot[:]) {
+				t.Errorf("unresolved slot %x", slot.Key)
+			}
+		}
+	}
+}
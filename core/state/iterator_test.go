@@ -21,13 +21,16 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/ethdb"
 )
 
 // Tests that the node iterator indeed walks over the entire database contents.
-func TestNodeIteratorCoverage(t *testing.T) {
+func TestNodeIteratorCoverage(t *testing.T) { testNodeIteratorCoverage(t, NewDatabase) }
+
+func testNodeIteratorCoverage(t *testing.T, newDB databaseConstructor) {
 	// Create some arbitrary test state to iterate
-	db, root, _ := makeTestState()
+	db, root, _ := makeTestStateWithDatabase(newDB(rawdb.NewMemoryDatabase()))
 	db.TrieDB().Commit(root, false, nil)
 
 	state, err := New(root, db, nil)
@@ -0,0 +1,444 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/snapshot"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// onlineRecheckInterval is the time interval to wait between two checks of the
+// chain head while the target state is not buried deep enough for sweeping.
+var onlineRecheckInterval = 3 * time.Second
+
+var (
+	// errPruningRunning is returned if online pruning is requested while another
+	// round is still in progress.
+	errPruningRunning = errors.New("state pruning already running")
+
+	// errPruningAborted is returned if online pruning was stopped before it
+	// could finish.
+	errPruningAborted = errors.New("state pruning aborted")
+
+	// errSnapshotHoldBroken is returned if the snapshot layers accumulated while
+	// marking the target state exceeded the memory allowance.
+	errSnapshotHoldBroken = errors.New("snapshot layers exceeded memory allowance while marking")
+)
+
+// The phases of an online pruning round.
+const (
+	PhaseIdle     = "idle"     // No pruning started yet
+	PhaseMarking  = "marking"  // Marking the nodes of the target state
+	PhaseWaiting  = "waiting"  // Waiting for the target state to be buried deep enough
+	PhaseSweeping = "sweeping" // Deleting the unmarked nodes from the database
+	PhaseDone     = "done"     // Pruning finished successfully
+	PhaseFailed   = "failed"   // Pruning failed or was aborted
+)
+
+// OnlineConfig contains the settings of the online state pruner.
+type OnlineConfig struct {
+	BloomSize uint64        // Megabytes of memory allocated to the bloom filter of live nodes
+	HoldSize  uint64        // Megabytes of snapshot diff layers accumulated at most while marking
+	Depth     uint64        // Number of blocks the target state must be buried under before sweeping
+	BatchSize int           // Number of stale trie nodes deleted in a single batch
+	Throttle  time.Duration // Time to pause between two deletion batches
+}
+
+// OnlineChain defines the blockchain methods needed by the online pruner.
+type OnlineChain interface {
+	// CurrentBlock retrieves the current head block of the canonical chain.
+	CurrentBlock() *types.Block
+
+	// StateCache returns the caching database underpinning the blockchain.
+	StateCache() state.Database
+
+	// PinState retains the state with the given root in memory until unpinned.
+	PinState(root common.Hash)
+
+	// UnpinState releases a pinned state, optionally persisting it to disk.
+	UnpinState(root common.Hash, persist bool) error
+
+	// Snapshots returns the state snapshot tree, nil if the snapshot is disabled.
+	Snapshots() *snapshot.Tree
+}
+
+// OnlineProgress is the progress report of an online pruning round.
+type OnlineProgress struct {
+	Phase    string      `json:"phase"`           // Current phase of the pruning round
+	Root     common.Hash `json:"root"`            // Root of the target state retained
+	Number   uint64      `json:"number"`          // Number of the block the target state belongs to
+	Marked   uint64      `json:"marked"`          // Number of trie nodes marked in the target state
+	Scanned  uint64      `json:"scanned"`         // Number of database entries checked by the sweeper
+	Deleted  uint64      `json:"deleted"`         // Number of stale trie nodes deleted
+	Progress float64     `json:"progress"`        // Approximate fraction of the database swept
+	Started  time.Time   `json:"started"`         // Time the pruning round started
+	Elapsed  string      `json:"elapsed"`         // Time spent on the pruning round
+	Error    string      `json:"error,omitempty"` // Failure of the last round, if any
+}
+
+// OnlinePruner deletes the stale state from the database in the background,
+// while the node keeps importing blocks and serving requests.
+//
+// A pruning round works in three phases:
+//
+// - a bloom filter is installed as marker in the trie database, recording all
+//   the trie nodes flushed to disk from then on. The head state is selected as
+//   the target and pinned in memory, after which all its nodes are marked.
+// - the target state is persisted and the pruner waits until it is buried deep
+//   enough, so no state older than it is used anymore.
+// - the database is swept in throttled batches, deleting all the trie nodes not
+//   marked. The deletions are synchronized with the trie database flushes, so
+//   the nodes written concurrently are never lost.
+//
+// The target state is marked by regenerating its tries from the snapshot, whose
+// layers are held from being flattened meanwhile. The round fails if the layers
+// accumulated while marking exceed the configured memory allowance.
+//
+// Similarly to the offline pruner, the false positives of the bloom filter leave
+// a small amount of dangling nodes in the database, and the states older than
+// the target are not available anymore after the round.
+type OnlinePruner struct {
+	db     ethdb.Database
+	chain  OnlineChain
+	config OnlineConfig
+
+	progress OnlineProgress
+	running  bool
+	quit     chan struct{}
+	term     chan struct{}
+	lock     sync.RWMutex
+}
+
+// NewOnlinePruner creates an online state pruner operating on the given chain.
+func NewOnlinePruner(db ethdb.Database, chain OnlineChain, config OnlineConfig) (*OnlinePruner, error) {
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		return nil, errors.New("online pruning is not supported by the path-based state scheme")
+	}
+	if config.BloomSize < 256 {
+		log.Warn("Sanitizing bloomfilter size", "provided(MB)", config.BloomSize, "updated(MB)", 256)
+		config.BloomSize = 256
+	}
+	if config.HoldSize == 0 {
+		config.HoldSize = 512
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10000
+	}
+	return &OnlinePruner{
+		db:       db,
+		chain:    chain,
+		config:   config,
+		progress: OnlineProgress{Phase: PhaseIdle},
+	}, nil
+}
+
+// Start launches a new pruning round in the background.
+func (p *OnlinePruner) Start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.running {
+		return errPruningRunning
+	}
+	if p.chain.Snapshots() == nil {
+		return errors.New("online pruning requires the state snapshot")
+	}
+	bloom, err := newStateBloomWithSize(p.config.BloomSize)
+	if err != nil {
+		return err
+	}
+	p.running = true
+	p.progress = OnlineProgress{Phase: PhaseMarking, Started: time.Now()}
+	p.quit, p.term = make(chan struct{}), make(chan struct{})
+
+	go p.run(bloom, p.quit, p.term)
+	return nil
+}
+
+// Stop aborts the running pruning round, if any, and waits for it to terminate.
+func (p *OnlinePruner) Stop() {
+	p.lock.Lock()
+	if !p.running {
+		p.lock.Unlock()
+		return
+	}
+	select {
+	case <-p.quit:
+	default:
+		close(p.quit)
+	}
+	term := p.term
+	p.lock.Unlock()
+
+	<-term
+}
+
+// Progress returns the progress of the current or the last pruning round.
+func (p *OnlinePruner) Progress() OnlineProgress {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	progress := p.progress
+	if p.running {
+		progress.Elapsed = common.PrettyDuration(time.Since(progress.Started)).String()
+	}
+	return progress
+}
+
+// update modifies the progress report of the running round.
+func (p *OnlinePruner) update(fn func(progress *OnlineProgress)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	fn(&p.progress)
+}
+
+// run executes a pruning round, recording its outcome in the progress report.
+func (p *OnlinePruner) run(bloom *stateBloom, quit chan struct{}, term chan struct{}) {
+	defer close(term)
+
+	triedb := p.chain.StateCache().TrieDB()
+	triedb.SetMarker(bloom)
+	defer triedb.SetMarker(nil)
+
+	err := p.prune(bloom, quit)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.running = false
+	p.progress.Elapsed = common.PrettyDuration(time.Since(p.progress.Started)).String()
+	if err != nil {
+		log.Error("Online state pruning failed", "err", err)
+		p.progress.Phase, p.progress.Error = PhaseFailed, err.Error()
+		return
+	}
+	p.progress.Phase = PhaseDone
+	log.Info("Online state pruning successful", "deleted", p.progress.Deleted, "elapsed", p.progress.Elapsed)
+}
+
+// prune marks the head state and sweeps all the trie nodes not belonging to it.
+// The marker needs to be installed before calling it, so that every node of the
+// newer states is either in the target state or gets marked when flushed.
+func (p *OnlinePruner) prune(bloom *stateBloom, quit chan struct{}) error {
+	head := p.chain.CurrentBlock()
+	root, number := head.Root(), head.NumberU64()
+
+	p.update(func(progress *OnlineProgress) {
+		progress.Root, progress.Number = root, number
+	})
+	log.Info("Marking state for online pruning", "number", number, "root", root)
+
+	// Retain the target state while marking it, it's usually still in memory
+	// and would be dereferenced once leaving the window of recent states.
+	p.chain.PinState(root)
+	if err := p.mark(bloom, root, quit); err != nil {
+		if err := p.chain.UnpinState(root, false); err != nil {
+			log.Error("Failed to release pruning target state", "root", root, "err", err)
+		}
+		return err
+	}
+	// Persist the target state, so a crash after the sweep always finds a
+	// complete state newer than the pruned ones.
+	if err := p.chain.UnpinState(root, true); err != nil {
+		return err
+	}
+	if err := extractGenesis(p.db, bloom); err != nil {
+		return err
+	}
+	// Wait until the target state is buried deep enough, the older states might
+	// still be in use by the chain until then.
+	p.update(func(progress *OnlineProgress) { progress.Phase = PhaseWaiting })
+	for p.chain.CurrentBlock().NumberU64() < number+p.config.Depth {
+		select {
+		case <-time.After(onlineRecheckInterval):
+		case <-quit:
+			return errPruningAborted
+		}
+	}
+	if rawdb.ReadCanonicalHash(p.db, number) != head.Hash() {
+		return fmt.Errorf("target state of block %d reorged", number)
+	}
+	p.update(func(progress *OnlineProgress) { progress.Phase = PhaseSweeping })
+	return p.sweep(bloom, quit)
+}
+
+// mark adds all the trie nodes of the given state to the bloom filter, along with
+// its contract codes, by regenerating the tries from the snapshot.
+func (p *OnlinePruner) mark(bloom *stateBloom, root common.Hash, quit chan struct{}) error {
+	// Hold the snapshot layers while iterating them, the target one would be
+	// flattened into the disk layer otherwise as the chain progresses.
+	snaptree := p.chain.Snapshots()
+	broken, release := snaptree.Hold(p.config.HoldSize * 1024 * 1024)
+	defer release()
+
+	var (
+		writer = &markWriter{bloom: bloom}
+		abort  = make(chan struct{})
+		done   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(3 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				marked := atomic.LoadUint64(&writer.marked)
+				p.update(func(progress *OnlineProgress) { progress.Marked = marked })
+			case <-quit:
+				close(abort)
+				return
+			case <-broken:
+				close(abort)
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := snapshot.GenerateTrieWithAbort(snaptree, root, p.db, writer, abort)
+	close(done)
+	wg.Wait()
+
+	marked := atomic.LoadUint64(&writer.marked)
+	p.update(func(progress *OnlineProgress) { progress.Marked = marked })
+	select {
+	case <-broken:
+		return errSnapshotHoldBroken
+	default:
+	}
+	if err == snapshot.ErrTrieGenerationAborted {
+		return errPruningAborted
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Marked state for online pruning", "nodes", marked)
+	return nil
+}
+
+// markWriter is a write-only database adding the keys of the trie nodes and the
+// contract codes written into it to the bloom filter, counting them.
+type markWriter struct {
+	bloom  *stateBloom
+	marked uint64 // Number of entries marked, accessed atomically
+}
+
+// Put implements ethdb.KeyValueWriter, marking the key in the bloom filter.
+func (w *markWriter) Put(key []byte, value []byte) error {
+	atomic.AddUint64(&w.marked, 1)
+	return w.bloom.Put(key, value)
+}
+
+// Delete implements ethdb.KeyValueWriter, it's not supported.
+func (w *markWriter) Delete(key []byte) error { panic("not supported") }
+
+// sweep iterates over the database and deletes all the trie nodes which are not
+// marked in the bloom filter, in throttled batches.
+func (p *OnlinePruner) sweep(bloom *stateBloom, quit chan struct{}) error {
+	var (
+		triedb  = p.chain.StateCache().TrieDB()
+		keep    = func(hash common.Hash) bool { ok, _ := bloom.Contain(hash[:]); return ok }
+		stale   = make([]common.Hash, 0, p.config.BatchSize)
+		scanned uint64
+		deleted uint64
+		logged  = time.Now()
+		iter    = p.db.NewIterator(nil, nil)
+	)
+	defer func() { iter.Release() }()
+
+	flush := func(next []byte) error {
+		count, err := triedb.Prune(stale, keep)
+		if err != nil {
+			return err
+		}
+		deleted += uint64(count)
+		stale = stale[:0]
+
+		var progress float64
+		if len(next) >= 8 {
+			progress = float64(binary.BigEndian.Uint64(next[:8])) / math.MaxUint64
+		}
+		p.update(func(report *OnlineProgress) {
+			report.Scanned, report.Deleted, report.Progress = scanned, deleted, progress
+		})
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning state data online", "scanned", scanned, "deleted", deleted, "progress", fmt.Sprintf("%.2f%%", progress*100))
+			logged = time.Now()
+		}
+		// Recreate the iterator after every batch in order to allow the
+		// underlying compactor to delete the entries, pausing to leave
+		// the database to the chain.
+		iter.Release()
+		select {
+		case <-time.After(p.config.Throttle):
+		case <-quit:
+			return errPruningAborted
+		}
+		iter = p.db.NewIterator(nil, next)
+		return nil
+	}
+	for iter.Next() {
+		key := iter.Key()
+		scanned++
+
+		// Only the trie nodes are deleted, contract codes are left untouched
+		if len(key) != common.HashLength {
+			continue
+		}
+		if keep(common.BytesToHash(key)) {
+			continue
+		}
+		stale = append(stale, common.BytesToHash(key))
+		if len(stale) >= p.config.BatchSize {
+			if err := flush(append(common.CopyBytes(key), 0x00)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		count, err := triedb.Prune(stale, keep)
+		if err != nil {
+			return err
+		}
+		deleted += uint64(count)
+	}
+	p.update(func(report *OnlineProgress) {
+		report.Scanned, report.Deleted, report.Progress = scanned, deleted, 1
+	})
+	return nil
+}
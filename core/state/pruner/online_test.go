@@ -0,0 +1,137 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that online pruning deletes the stale state while blocks are imported,
+// retaining all the states from the target onwards.
+func TestOnlinePruning(t *testing.T) {
+	onlineRecheckInterval = 10 * time.Millisecond
+
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				// Stores the block number in the slot of the block number
+				contract: {Balance: new(big.Int), Code: common.Hex2Bytes("434355")},
+			},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+		signer  = types.LatestSigner(params.TestChainConfig)
+	)
+	blocks, _ := core.GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), db, 60, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{byte(i), 0x01}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	// Import the first blocks in archive mode to accumulate stale state on disk
+	chaindb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(chaindb)
+
+	archive, err := core.NewBlockChain(chaindb, &core.CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create archive chain: %v", err)
+	}
+	if _, err := archive.InsertChain(blocks[:20]); err != nil {
+		t.Fatalf("failed to import archive blocks: %v", err)
+	}
+	archive.Stop()
+
+	// Prune the state while importing the remaining blocks
+	chain, err := core.NewBlockChain(chaindb, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks[20:30]); err != nil {
+		t.Fatalf("failed to import blocks: %v", err)
+	}
+	pruner, err := NewOnlinePruner(chaindb, chain, OnlineConfig{Depth: 10, BatchSize: 16})
+	if err != nil {
+		t.Fatalf("failed to create pruner: %v", err)
+	}
+	pruner.config.BloomSize = 1
+
+	if err := pruner.Start(); err != nil {
+		t.Fatalf("failed to start pruning: %v", err)
+	}
+	if err := pruner.Start(); err != errPruningRunning {
+		t.Fatalf("concurrent pruning error mismatch: have %v, want %v", err, errPruningRunning)
+	}
+	for _, block := range blocks[30:] {
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to import block %d: %v", block.NumberU64(), err)
+		}
+	}
+	for pruner.Progress().Phase != PhaseDone {
+		if progress := pruner.Progress(); progress.Phase == PhaseFailed {
+			t.Fatalf("pruning failed: %v", progress.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	progress := pruner.Progress()
+	if progress.Number != 30 || progress.Root != blocks[29].Root() {
+		t.Errorf("target mismatch: have %d %x, want 30 %x", progress.Number, progress.Root, blocks[29].Root())
+	}
+	if progress.Deleted == 0 || progress.Marked == 0 {
+		t.Errorf("nothing pruned: marked %d, deleted %d", progress.Marked, progress.Deleted)
+	}
+	chain.Stop()
+
+	// The archived states should be gone, the target and the head one retained
+	for _, block := range blocks[:19] {
+		if blob := rawdb.ReadTrieNode(chaindb, block.Root()); len(blob) != 0 {
+			t.Errorf("stale state of block %d retained", block.NumberU64())
+		}
+	}
+	for _, block := range []*types.Block{blocks[29], blocks[len(blocks)-1]} {
+		statedb, err := state.New(block.Root(), state.NewDatabase(chaindb), nil)
+		if err != nil {
+			t.Fatalf("state of block %d missing: %v", block.NumberU64(), err)
+		}
+		it := state.NewNodeIterator(statedb)
+		for it.Next() {
+		}
+		if it.Error != nil {
+			t.Fatalf("state of block %d incomplete: %v", block.NumberU64(), it.Error)
+		}
+		number := common.BigToHash(block.Number())
+		if value := statedb.GetState(contract, number); value != number {
+			t.Errorf("slot of block %d mismatch: have %x", block.NumberU64(), value)
+		}
+	}
+}
@@ -91,6 +91,10 @@ var (
 	// while the generation is not finished yet.
 	ErrNotConstructed = errors.New("snapshot is not constructed")
 
+	// ErrTrieGenerationAborted is returned if the regeneration of a trie from the
+	// snapshot was aborted before it finished.
+	ErrTrieGenerationAborted = errors.New("trie generation aborted")
+
 	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
 	// that forms a cycle in the snapshot tree.
 	errSnapshotCycle = errors.New("snapshot cycle")
@@ -166,6 +170,7 @@ type Tree struct {
 	triedb *trie.Database           // In-memory cache to access the trie through
 	cache  int                      // Megabytes permitted to use for read caches
 	layers map[common.Hash]snapshot // Collection of all known layers
+	holds  map[chan struct{}]uint64 // Holds preventing the layers from being flattened, with their memory allowance
 	lock   sync.RWMutex
 }
 
@@ -327,6 +332,11 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	// Leave all the layers untouched while held, iterators rely on them. Holds
+	// whose memory allowance was exceeded are broken, flattening as usual.
+	if t.breakHolds(); len(t.holds) > 0 {
+		return nil
+	}
 	// Flattening the bottom-most diff layer requires special casing since there's
 	// no child to rewire to the grandparent. In that case we can fake a temporary
 	// child for the capping and then remove it.
@@ -379,6 +389,52 @@ func (t *Tree) Cap(root common.Hash, layers int) error {
 	return nil
 }
 
+// Hold prevents the layers of the tree from being flattened until released, so
+// that iterations over long-lived layers don't become stale. The diff layers are
+// accumulated in memory meanwhile, up to the given allowance in bytes: once it is
+// exceeded, the hold is broken and the returned channel closed.
+//
+// The returned function releases the hold, the layers accumulated meanwhile are
+// flattened by the next Cap.
+func (t *Tree) Hold(limit uint64) (<-chan struct{}, func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.holds == nil {
+		t.holds = make(map[chan struct{}]uint64)
+	}
+	broken := make(chan struct{})
+	t.holds[broken] = limit
+
+	return broken, func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		delete(t.holds, broken)
+	}
+}
+
+// breakHolds breaks all the holds whose memory allowance is exceeded by the
+// diff layers of the tree. The tree lock must be held by the caller.
+func (t *Tree) breakHolds() {
+	if len(t.holds) == 0 {
+		return
+	}
+	var memory uint64
+	for _, layer := range t.layers {
+		if diff, ok := layer.(*diffLayer); ok {
+			memory += diff.memory
+		}
+	}
+	for broken, limit := range t.holds {
+		if memory > limit {
+			log.Warn("Snapshot hold exceeded memory allowance", "memory", common.StorageSize(memory), "limit", common.StorageSize(limit))
+			close(broken)
+			delete(t.holds, broken)
+		}
+	}
+}
+
 // cap traverses downwards the diff tree until the number of allowed layers are
 // crossed. All diffs beyond the permitted number are flattened downwards. If the
 // layer limit is reached, memory cap is also enforced (but not before).
@@ -128,6 +128,76 @@ func TestDiskLayerExternalInvalidationFullFlatten(t *testing.T) {
 	}
 }
 
+// Tests that holding the snapshot tree prevents its layers from being flattened,
+// keeping the external references valid until released.
+func TestHoldPreventsFlattening(t *testing.T) {
+	// Create an empty base layer and a snapshot tree out of it
+	base := &diskLayer{
+		diskdb: rawdb.NewMemoryDatabase(),
+		root:   common.HexToHash("0x01"),
+		cache:  fastcache.New(1024 * 500),
+	}
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base.root: base,
+		},
+	}
+	accounts := map[common.Hash][]byte{
+		common.HexToHash("0xa1"): randomAccount(),
+	}
+	if err := snaps.Update(common.HexToHash("0x02"), common.HexToHash("0x01"), nil, accounts, nil); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	ref := snaps.Snapshot(common.HexToHash("0x02")).(*diffLayer)
+
+	// Capping a held tree leaves all the layers untouched
+	broken, release := snaps.Hold(ref.memory)
+	if err := snaps.Cap(common.HexToHash("0x02"), 0); err != nil {
+		t.Fatalf("failed to cap held tree: %v", err)
+	}
+	if ref.Stale() || base.Stale() {
+		t.Fatalf("layers flattened while held")
+	}
+	if n := len(snaps.layers); n != 2 {
+		t.Errorf("held layer count mismatch: have %d, want %d", n, 2)
+	}
+	select {
+	case <-broken:
+		t.Fatalf("hold broken within memory allowance")
+	default:
+	}
+	// Once released, the layers are flattened again
+	release()
+	if err := snaps.Cap(common.HexToHash("0x02"), 0); err != nil {
+		t.Fatalf("failed to merge diff layer onto disk: %v", err)
+	}
+	if !base.Stale() {
+		t.Errorf("diff layer not flattened after release")
+	}
+	if n := len(snaps.layers); n != 1 {
+		t.Errorf("released layer count mismatch: have %d, want %d", n, 1)
+	}
+	// Holds exceeding their memory allowance are broken and the layers flattened
+	if err := snaps.Update(common.HexToHash("0x03"), common.HexToHash("0x02"), nil, accounts, nil); err != nil {
+		t.Fatalf("failed to create a diff layer: %v", err)
+	}
+	ref = snaps.Snapshot(common.HexToHash("0x03")).(*diffLayer)
+	broken, release = snaps.Hold(ref.memory - 1)
+	defer release()
+
+	if err := snaps.Cap(common.HexToHash("0x03"), 0); err != nil {
+		t.Fatalf("failed to cap held tree: %v", err)
+	}
+	select {
+	case <-broken:
+	default:
+		t.Fatalf("hold not broken beyond memory allowance")
+	}
+	if n := len(snaps.layers); n != 1 {
+		t.Errorf("broken hold layer count mismatch: have %d, want %d", n, 1)
+	}
+}
+
 // Tests that if a disk layer becomes stale, no active external references will
 // be returned with junk data. This version of the test retains the bottom diff
 // layer to check the usual mode of operation where the accumulator is retained.
@@ -209,7 +279,7 @@ func TestDiffLayerExternalInvalidationPartialFlatten(t *testing.T) {
 	if n := len(snaps.layers); n != 4 {
 		t.Errorf("pre-cap layer count mismatch: have %d, want %d", n, 4)
 	}
-	ref := snaps.Snapshot(common.HexToHash("0x02"))
+	ref := snaps.Snapshot(common.HexToHash("0x02")).(*diffLayer)
 
 	// Doing a Cap operation with many allowed layers should be a no-op
 	exp := len(snaps.layers)
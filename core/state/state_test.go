@@ -34,9 +34,9 @@ type stateTest struct {
 	state *StateDB
 }
 
-func newStateTest() *stateTest {
+func newStateTest(newDB databaseConstructor) *stateTest {
 	db := rawdb.NewMemoryDatabase()
-	sdb, _ := New(common.Hash{}, NewDatabase(db), nil)
+	sdb, _ := New(common.Hash{}, newDB(db), nil)
 	return &stateTest{db: db, state: sdb}
 }
 
@@ -89,8 +89,10 @@ func TestDump(t *testing.T) {
 	}
 }
 
-func TestNull(t *testing.T) {
-	s := newStateTest()
+func TestNull(t *testing.T) { testNull(t, NewDatabase) }
+
+func testNull(t *testing.T, newDB databaseConstructor) {
+	s := newStateTest(newDB)
 	address := common.HexToAddress("0x823140710bf13990e4500136726d8b55")
 	s.state.CreateAccount(address)
 	//value := common.FromHex("0x823140710bf13990e4500136726d8b55")
@@ -107,12 +109,14 @@ func TestNull(t *testing.T) {
 	}
 }
 
-func TestSnapshot(t *testing.T) {
+func TestSnapshot(t *testing.T) { testSnapshot(t, NewDatabase) }
+
+func testSnapshot(t *testing.T, newDB databaseConstructor) {
 	stateobjaddr := toAddr([]byte("aa"))
 	var storageaddr common.Hash
 	data1 := common.BytesToHash([]byte{42})
 	data2 := common.BytesToHash([]byte{43})
-	s := newStateTest()
+	s := newStateTest(newDB)
 
 	// snapshot the genesis state
 	genesis := s.state.Snapshot()
@@ -142,13 +146,17 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
-func TestSnapshotEmpty(t *testing.T) {
-	s := newStateTest()
+func TestSnapshotEmpty(t *testing.T) { testSnapshotEmpty(t, NewDatabase) }
+
+func testSnapshotEmpty(t *testing.T, newDB databaseConstructor) {
+	s := newStateTest(newDB)
 	s.state.RevertToSnapshot(s.state.Snapshot())
 }
 
-func TestSnapshot2(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+func TestSnapshot2(t *testing.T) { testSnapshot2(t, NewDatabase) }
+
+func testSnapshot2(t *testing.T, newDB databaseConstructor) {
+	state, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 
 	stateobjaddr0 := toAddr([]byte("so0"))
 	stateobjaddr1 := toAddr([]byte("so1"))
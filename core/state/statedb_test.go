@@ -36,10 +36,12 @@ import (
 
 // Tests that updating a state trie does not leak any database writes prior to
 // actually committing the state.
-func TestUpdateLeaks(t *testing.T) {
+func TestUpdateLeaks(t *testing.T) { testUpdateLeaks(t, NewDatabase) }
+
+func testUpdateLeaks(t *testing.T, newDB databaseConstructor) {
 	// Create an empty state database
 	db := rawdb.NewMemoryDatabase()
-	state, _ := New(common.Hash{}, NewDatabase(db), nil)
+	state, _ := New(common.Hash{}, newDB(db), nil)
 
 	// Update it with some accounts
 	for i := byte(0); i < 255; i++ {
@@ -69,12 +71,14 @@ func TestUpdateLeaks(t *testing.T) {
 
 // Tests that no intermediate state of an object is stored into the database,
 // only the one right before the commit.
-func TestIntermediateLeaks(t *testing.T) {
+func TestIntermediateLeaks(t *testing.T) { testIntermediateLeaks(t, NewDatabase) }
+
+func testIntermediateLeaks(t *testing.T, newDB databaseConstructor) {
 	// Create two state databases, one transitioning to the final state, the other final from the beginning
 	transDb := rawdb.NewMemoryDatabase()
 	finalDb := rawdb.NewMemoryDatabase()
-	transState, _ := New(common.Hash{}, NewDatabase(transDb), nil)
-	finalState, _ := New(common.Hash{}, NewDatabase(finalDb), nil)
+	transState, _ := New(common.Hash{}, newDB(transDb), nil)
+	finalState, _ := New(common.Hash{}, newDB(finalDb), nil)
 
 	modify := func(state *StateDB, addr common.Address, i, tweak byte) {
 		state.SetBalance(addr, big.NewInt(int64(11*i)+int64(tweak)))
@@ -147,9 +151,11 @@ func TestIntermediateLeaks(t *testing.T) {
 // TestCopy tests that copying a StateDB object indeed makes the original and
 // the copy independent of each other. This test is a regression test against
 // https://github.com/ethereum/go-ethereum/pull/15549.
-func TestCopy(t *testing.T) {
+func TestCopy(t *testing.T) { testCopy(t, NewDatabase) }
+
+func testCopy(t *testing.T, newDB databaseConstructor) {
 	// Create a random state test to copy and modify "independently"
-	orig, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	orig, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 
 	for i := byte(0); i < 255; i++ {
 		obj := orig.GetOrNewStateObject(common.BytesToAddress([]byte{i}))
@@ -210,9 +216,11 @@ func TestCopy(t *testing.T) {
 	}
 }
 
-func TestSnapshotRandom(t *testing.T) {
+func TestSnapshotRandom(t *testing.T) { testSnapshotRandom(t, NewDatabase) }
+
+func testSnapshotRandom(t *testing.T, newDB databaseConstructor) {
 	config := &quick.Config{MaxCount: 1000}
-	err := quick.Check((*snapshotTest).run, config)
+	err := quick.Check(func(test *snapshotTest) bool { return test.run(newDB) }, config)
 	if cerr, ok := err.(*quick.CheckError); ok {
 		test := cerr.In[0].(*snapshotTest)
 		t.Errorf("%v:\n%s", test.err, test)
@@ -396,10 +404,10 @@ func (test *snapshotTest) String() string {
 	return out.String()
 }
 
-func (test *snapshotTest) run() bool {
+func (test *snapshotTest) run(newDB databaseConstructor) bool {
 	// Run all actions and create snapshots.
 	var (
-		state, _     = New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		state, _     = New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 		snapshotRevs = make([]int, len(test.snapshots))
 		sindex       = 0
 	)
@@ -470,8 +478,10 @@ func (test *snapshotTest) checkEqual(state, checkstate *StateDB) error {
 	return nil
 }
 
-func TestTouchDelete(t *testing.T) {
-	s := newStateTest()
+func TestTouchDelete(t *testing.T) { testTouchDelete(t, NewDatabase) }
+
+func testTouchDelete(t *testing.T, newDB databaseConstructor) {
+	s := newStateTest(newDB)
 	s.state.GetOrNewStateObject(common.Address{})
 	root, _ := s.state.Commit(false)
 	s.state, _ = New(root, s.state.db, s.state.snaps)
@@ -490,8 +500,10 @@ func TestTouchDelete(t *testing.T) {
 
 // TestCopyOfCopy tests that modified objects are carried over to the copy, and the copy of the copy.
 // See https://github.com/ethereum/go-ethereum/pull/15225#issuecomment-380191512
-func TestCopyOfCopy(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+func TestCopyOfCopy(t *testing.T) { testCopyOfCopy(t, NewDatabase) }
+
+func testCopyOfCopy(t *testing.T, newDB databaseConstructor) {
+	state, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 	addr := common.HexToAddress("aaaa")
 	state.SetBalance(addr, big.NewInt(42))
 
@@ -507,8 +519,10 @@ func TestCopyOfCopy(t *testing.T) {
 // leading to corrupted subsequent copies.
 //
 // See https://github.com/ethereum/go-ethereum/issues/20106.
-func TestCopyCommitCopy(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+func TestCopyCommitCopy(t *testing.T) { testCopyCommitCopy(t, NewDatabase) }
+
+func testCopyCommitCopy(t *testing.T, newDB databaseConstructor) {
+	state, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 
 	// Create an account and check if the retrieved balance is correct
 	addr := common.HexToAddress("0xaffeaffeaffeaffeaffeaffeaffeaffeaffeaffe")
@@ -579,8 +593,10 @@ func TestCopyCommitCopy(t *testing.T) {
 // leading to corrupted subsequent copies.
 //
 // See https://github.com/ethereum/go-ethereum/issues/20106.
-func TestCopyCopyCommitCopy(t *testing.T) {
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+func TestCopyCopyCommitCopy(t *testing.T) { testCopyCopyCommitCopy(t, NewDatabase) }
+
+func testCopyCopyCommitCopy(t *testing.T, newDB databaseConstructor) {
+	state, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 
 	// Create an account and check if the retrieved balance is correct
 	addr := common.HexToAddress("0xaffeaffeaffeaffeaffeaffeaffeaffeaffeaffe")
@@ -668,9 +684,11 @@ func TestCopyCopyCommitCopy(t *testing.T) {
 // The original StateDB implementation flushed dirty objects to the tries after
 // each transaction, so this works ok. The rework accumulated writes in memory
 // first, but the journal wiped the entire state object on create-revert.
-func TestDeleteCreateRevert(t *testing.T) {
+func TestDeleteCreateRevert(t *testing.T) { testDeleteCreateRevert(t, NewDatabase) }
+
+func testDeleteCreateRevert(t *testing.T, newDB databaseConstructor) {
 	// Create an initial state with a single contract
-	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	state, _ := New(common.Hash{}, newDB(rawdb.NewMemoryDatabase()), nil)
 
 	addr := toAddr([]byte("so"))
 	state.SetBalance(addr, big.NewInt(1))
@@ -698,11 +716,13 @@ func TestDeleteCreateRevert(t *testing.T) {
 // TestMissingTrieNodes tests that if the StateDB fails to load parts of the trie,
 // the Commit operation fails with an error
 // If we are missing trie nodes, we should not continue writing to the trie
-func TestMissingTrieNodes(t *testing.T) {
+func TestMissingTrieNodes(t *testing.T) { testMissingTrieNodes(t, NewDatabase) }
+
+func testMissingTrieNodes(t *testing.T, newDB databaseConstructor) {
 
 	// Create an initial state with a few accounts
 	memDb := rawdb.NewMemoryDatabase()
-	db := NewDatabase(memDb)
+	db := newDB(memDb)
 	var root common.Hash
 	state, _ := New(common.Hash{}, db, nil)
 	addr := toAddr([]byte("so"))
@@ -742,7 +762,9 @@ func TestMissingTrieNodes(t *testing.T) {
 	}
 }
 
-func TestStateDBAccessList(t *testing.T) {
+func TestStateDBAccessList(t *testing.T) { testStateDBAccessList(t, NewDatabase) }
+
+func testStateDBAccessList(t *testing.T, newDB databaseConstructor) {
 	// Some helpers
 	addr := func(a string) common.Address {
 		return common.HexToAddress(a)
@@ -752,7 +774,7 @@ func TestStateDBAccessList(t *testing.T) {
 	}
 
 	memDb := rawdb.NewMemoryDatabase()
-	db := NewDatabase(memDb)
+	db := newDB(memDb)
 	state, _ := New(common.Hash{}, db, nil)
 	state.accessList = newAccessList()
 
@@ -40,8 +40,13 @@ type testAccount struct {
 
 // makeTestState create a sample test state to test node-wise reconstruction.
 func makeTestState() (Database, common.Hash, []*testAccount) {
+	return makeTestStateWithDatabase(NewDatabase(rawdb.NewMemoryDatabase()))
+}
+
+// makeTestStateWithDatabase fills the given empty state database with a sample
+// test state.
+func makeTestStateWithDatabase(db Database) (Database, common.Hash, []*testAccount) {
 	// Create an empty state
-	db := NewDatabase(rawdb.NewMemoryDatabase())
 	state, _ := New(common.Hash{}, db, nil)
 
 	// Fill it with some arbitrary data
@@ -0,0 +1,94 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the state diff of a block resolves the addresses and slots of all
+// changes, although the chain doesn't record preimages.
+func TestStateDiff(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		signer   = types.LatestSigner(params.TestChainConfig)
+		db       = rawdb.NewMemoryDatabase()
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				// Stores the block number in slot NUMBER.
+				contract: {Balance: new(big.Int), Code: common.Hex2Bytes("4343550000")},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		gendb   = rawdb.NewMemoryDatabase()
+	)
+	gspec.MustCommit(gendb)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0xcb})
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	chain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if _, err := chain.StateDiff(genesis); err == nil {
+		t.Fatal("expected error diffing the genesis block")
+	}
+	diff, err := chain.StateDiff(blocks[1])
+	if err != nil {
+		t.Fatalf("failed to diff block: %v", err)
+	}
+	changed := make(map[common.Address]int)
+	for _, account := range diff.Accounts {
+		if account.Address == nil {
+			t.Fatalf("unresolved account %x", account.Key)
+		}
+		changed[*account.Address] = len(account.Storage)
+		for _, slot := range account.Storage {
+			if slot.Slot == nil {
+				t.Fatalf("account %x: unresolved slot %x", account.Address, slot.Key)
+			}
+			if *slot.Slot != common.BigToHash(big.NewInt(2)) || slot.Post != common.BigToHash(big.NewInt(2)) {
+				t.Errorf("account %x: unexpected slot change %x: %x -> %x", account.Address, slot.Slot, slot.Prev, slot.Post)
+			}
+		}
+	}
+	if len(changed) != 3 || changed[contract] != 1 {
+		t.Fatalf("unexpected changes: %v", changed)
+	}
+	for _, want := range []common.Address{addr, {0xcb}} {
+		if _, ok := changed[want]; !ok {
+			t.Errorf("missing change of %x", want)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sort"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	parallelTxMeter       = metrics.NewRegisteredMeter("chain/parallel/txs", nil)
+	parallelConflictMeter = metrics.NewRegisteredMeter("chain/parallel/conflicts", nil)
+)
+
+// ParallelStateProcessor is a Processor executing the transactions of a block
+// speculatively in parallel.
+//
+// Every transaction is run on its own copy of the state at the beginning of the
+// block, recording the accounts and storage slots it reads and modifies. The
+// results are then merged into the real state in block order. A transaction which
+// read anything modified by one of its predecessors is re-executed serially on top
+// of the merged state instead. Balance increases of accounts whose balance isn't
+// read, such as fee payments to the coinbase, commute and don't cause conflicts.
+//
+// The produced state and receipts are identical to those of StateProcessor.
+//
+// ParallelStateProcessor implements Processor.
+type ParallelStateProcessor struct {
+	config  *params.ChainConfig // Chain configuration options
+	bc      *BlockChain         // Canonical block chain
+	engine  consensus.Engine    // Consensus engine used for block rewards
+	serial  *StateProcessor     // Fallback processor for blocks not worth parallelising
+	threads int                 // Number of transactions to execute concurrently
+}
+
+// NewParallelStateProcessor initialises a new ParallelStateProcessor.
+func NewParallelStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine) *ParallelStateProcessor {
+	return &ParallelStateProcessor{
+		config:  config,
+		bc:      bc,
+		engine:  engine,
+		serial:  NewStateProcessor(config, bc, engine),
+		threads: runtime.NumCPU(),
+	}
+}
+
+// speculativeResult is the outcome of executing a transaction on a copy of the
+// state at the beginning of the block.
+type speculativeResult struct {
+	result  *ExecutionResult
+	err     error
+	access  *accessRecorder
+	changes []*accountChange
+	logs    []*types.Log
+	done    chan struct{}
+}
+
+// Process processes the state changes according to the Ethereum rules by running
+// the transaction messages using the statedb and applying any rewards to both
+// the processor (coinbase) and any included uncles.
+//
+// Pre-Byzantium blocks, blocks with a single transaction and traced executions are
+// processed serially.
+func (p *ParallelStateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	// Intermediate state roots can't be computed from merged changes and tracers
+	// expect to see transactions in order, leave those to the serial processor.
+	if !p.config.IsByzantium(block.Number()) || !p.config.IsEIP158(block.Number()) || cfg.Debug || len(block.Transactions()) < 2 || p.threads < 2 {
+		return p.serial.Process(block, statedb, cfg)
+	}
+	var (
+		receipts types.Receipts
+		usedGas  uint64
+		header   = block.Header()
+		allLogs  []*types.Log
+		gp       = new(GasPool).AddGas(block.GasLimit())
+		txs      = block.Transactions()
+		signer   = types.MakeSigner(p.config, header.Number)
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	msgs := make([]types.Message, len(txs))
+	for i, tx := range txs {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		msgs[i] = msg
+	}
+	// Execute all transactions speculatively on copies of the initial state.
+	var (
+		results = make([]*speculativeResult, len(txs))
+		jobs    = make(chan int, len(txs))
+		abort   int32
+	)
+	for i := range txs {
+		results[i] = &speculativeResult{done: make(chan struct{})}
+		jobs <- i
+	}
+	close(jobs)
+
+	copies := make([]*state.StateDB, len(txs))
+	for i := range txs {
+		copies[i] = statedb.Copy()
+	}
+	defer atomic.StoreInt32(&abort, 1)
+
+	threads := p.threads
+	if threads > len(txs) {
+		threads = len(txs)
+	}
+	for n := 0; n < threads; n++ {
+		go func() {
+			for i := range jobs {
+				if atomic.LoadInt32(&abort) == 0 {
+					p.speculate(block, txs[i], msgs[i], i, copies[i], cfg, results[i])
+				}
+				copies[i] = nil
+				close(results[i].done)
+			}
+		}()
+	}
+	// Merge the results in order, re-executing the transactions which conflict
+	// with a predecessor.
+	var (
+		written = make(writeSet)
+		vmenv   = vm.NewEVM(NewEVMBlockContext(header, p.bc, nil), vm.TxContext{}, statedb, p.config, cfg)
+	)
+	for i, tx := range txs {
+		res := results[i]
+		<-res.done
+
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		parallelTxMeter.Mark(1)
+
+		var result *ExecutionResult
+		if res.err == nil && msgs[i].Gas() <= gp.Gas() && !written.conflicts(res.access) {
+			if err := gp.SubGas(res.result.UsedGas); err != nil {
+				return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+			}
+			applyChanges(statedb, res.changes)
+			for _, log := range res.logs {
+				statedb.AddLog(&types.Log{
+					Address:     log.Address,
+					Topics:      log.Topics,
+					Data:        log.Data,
+					BlockNumber: log.BlockNumber,
+				})
+			}
+			for hash, preimage := range res.access.preimages {
+				statedb.AddPreimage(hash, preimage)
+			}
+			statedb.Finalise(true)
+			written.add(res.changes)
+			result = res.result
+		} else {
+			parallelConflictMeter.Mark(1)
+
+			access := newAccessRecorder(statedb)
+			vmenv.Reset(NewEVMTxContext(msgs[i]), access)
+
+			var err error
+			if result, err = ApplyMessage(vmenv, msgs[i], gp); err != nil {
+				return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+			}
+			statedb.Finalise(true)
+			written.add(access.changes())
+		}
+		usedGas += result.UsedGas
+
+		receipt := newReceipt(header, statedb, tx, msgs[i], result, nil, usedGas)
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles())
+
+	return receipts, allLogs, usedGas, nil
+}
+
+// speculate executes a transaction on its own copy of the state at the beginning
+// of the block, recording its accesses.
+func (p *ParallelStateProcessor) speculate(block *types.Block, tx *types.Transaction, msg types.Message, index int, statedb *state.StateDB, cfg vm.Config, res *speculativeResult) {
+	statedb.Prepare(tx.Hash(), block.Hash(), index)
+
+	// The block context caches hashes and can't be shared between goroutines.
+	res.access = newAccessRecorder(statedb)
+	vmenv := vm.NewEVM(NewEVMBlockContext(block.Header(), p.bc, nil), NewEVMTxContext(msg), res.access, p.config, cfg)
+
+	res.result, res.err = ApplyMessage(vmenv, msg, new(GasPool).AddGas(block.GasLimit()))
+	if res.err != nil {
+		return
+	}
+	statedb.Finalise(true)
+	res.changes = res.access.changes()
+	res.logs = statedb.GetLogs(tx.Hash())
+}
+
+// Parts of an account a transaction can depend on.
+const (
+	fieldBalance uint8 = 1 << iota
+	fieldNonce
+	fieldCode
+	fieldStorage // Entire storage, i.e. iteration or account destruction
+
+	fieldAll = fieldBalance | fieldNonce | fieldCode | fieldStorage
+)
+
+// accountAccess tracks the accesses of a transaction to an account.
+type accountAccess struct {
+	read    uint8 // Fields the transaction depends on
+	mutated bool  // Whether any field was modified, possibly reverted later
+	created bool  // Whether the account was (re)created, clearing its storage
+
+	exists   bool // Account state before the transaction
+	balance  *big.Int
+	nonce    uint64
+	codeHash common.Hash
+
+	slots map[common.Hash]*slotAccess
+}
+
+// slotAccess tracks the accesses of a transaction to a storage slot.
+type slotAccess struct {
+	read    bool
+	written bool
+	value   common.Hash // Value before the transaction
+}
+
+// accessRecorder is a vm.StateDB recording the accounts and storage slots read and
+// written by a single transaction.
+type accessRecorder struct {
+	*state.StateDB
+	accounts  map[common.Address]*accountAccess
+	preimages map[common.Hash][]byte
+}
+
+func newAccessRecorder(statedb *state.StateDB) *accessRecorder {
+	return &accessRecorder{
+		StateDB:   statedb,
+		accounts:  make(map[common.Address]*accountAccess),
+		preimages: make(map[common.Hash][]byte),
+	}
+}
+
+// account returns the access record of an account, capturing its initial state on
+// first access.
+func (r *accessRecorder) account(addr common.Address) *accountAccess {
+	if acc, ok := r.accounts[addr]; ok {
+		return acc
+	}
+	acc := &accountAccess{
+		exists:   r.StateDB.Exist(addr),
+		balance:  new(big.Int).Set(r.StateDB.GetBalance(addr)),
+		nonce:    r.StateDB.GetNonce(addr),
+		codeHash: r.StateDB.GetCodeHash(addr),
+		slots:    make(map[common.Hash]*slotAccess),
+	}
+	r.accounts[addr] = acc
+	return acc
+}
+
+// slot returns the access record of a storage slot, capturing its initial value on
+// first access.
+func (r *accessRecorder) slot(addr common.Address, key common.Hash) *slotAccess {
+	acc := r.account(addr)
+	if slot, ok := acc.slots[key]; ok {
+		return slot
+	}
+	slot := &slotAccess{value: r.StateDB.GetCommittedState(addr, key)}
+	acc.slots[key] = slot
+	return slot
+}
+
+func (r *accessRecorder) read(addr common.Address, fields uint8) {
+	r.account(addr).read |= fields
+}
+
+func (r *accessRecorder) mutate(addr common.Address) {
+	r.account(addr).mutated = true
+}
+
+func (r *accessRecorder) CreateAccount(addr common.Address) {
+	acc := r.account(addr)
+	acc.mutated, acc.created = true, true
+	r.StateDB.CreateAccount(addr)
+}
+
+func (r *accessRecorder) SubBalance(addr common.Address, amount *big.Int) {
+	r.mutate(addr)
+	r.StateDB.SubBalance(addr, amount)
+}
+
+func (r *accessRecorder) AddBalance(addr common.Address, amount *big.Int) {
+	r.mutate(addr)
+	r.StateDB.AddBalance(addr, amount)
+}
+
+func (r *accessRecorder) GetBalance(addr common.Address) *big.Int {
+	r.read(addr, fieldBalance)
+	return r.StateDB.GetBalance(addr)
+}
+
+func (r *accessRecorder) GetNonce(addr common.Address) uint64 {
+	r.read(addr, fieldNonce)
+	return r.StateDB.GetNonce(addr)
+}
+
+func (r *accessRecorder) SetNonce(addr common.Address, nonce uint64) {
+	r.mutate(addr)
+	r.StateDB.SetNonce(addr, nonce)
+}
+
+func (r *accessRecorder) GetCodeHash(addr common.Address) common.Hash {
+	r.read(addr, fieldCode)
+	return r.StateDB.GetCodeHash(addr)
+}
+
+func (r *accessRecorder) GetCode(addr common.Address) []byte {
+	r.read(addr, fieldCode)
+	return r.StateDB.GetCode(addr)
+}
+
+func (r *accessRecorder) SetCode(addr common.Address, code []byte) {
+	r.mutate(addr)
+	r.StateDB.SetCode(addr, code)
+}
+
+func (r *accessRecorder) GetCodeSize(addr common.Address) int {
+	r.read(addr, fieldCode)
+	return r.StateDB.GetCodeSize(addr)
+}
+
+func (r *accessRecorder) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	r.slot(addr, key).read = true
+	return r.StateDB.GetCommittedState(addr, key)
+}
+
+func (r *accessRecorder) GetState(addr common.Address, key common.Hash) common.Hash {
+	r.slot(addr, key).read = true
+	return r.StateDB.GetState(addr, key)
+}
+
+func (r *accessRecorder) SetState(addr common.Address, key, value common.Hash) {
+	r.slot(addr, key).written = true
+	r.mutate(addr)
+	r.StateDB.SetState(addr, key, value)
+}
+
+func (r *accessRecorder) Suicide(addr common.Address) bool {
+	r.read(addr, fieldAll)
+	r.mutate(addr)
+	return r.StateDB.Suicide(addr)
+}
+
+func (r *accessRecorder) Exist(addr common.Address) bool {
+	r.read(addr, fieldAll)
+	return r.StateDB.Exist(addr)
+}
+
+func (r *accessRecorder) Empty(addr common.Address) bool {
+	r.read(addr, fieldAll)
+	return r.StateDB.Empty(addr)
+}
+
+func (r *accessRecorder) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) error {
+	r.read(addr, fieldStorage)
+	return r.StateDB.ForEachStorage(addr, cb)
+}
+
+func (r *accessRecorder) AddPreimage(hash common.Hash, preimage []byte) {
+	r.preimages[hash] = common.CopyBytes(preimage)
+	r.StateDB.AddPreimage(hash, preimage)
+}
+
+// accountChange is the net modification of an account by a transaction.
+type accountChange struct {
+	address common.Address
+	deleted bool // Account was destroyed, all other fields are unset
+	created bool // Account was (re)created, storage has to be cleared first
+
+	balance *big.Int // New balance if the transaction depends on the old one
+	delta   *big.Int // Balance difference otherwise
+	nonce   *uint64
+	code    []byte
+	setCode bool
+	storage map[common.Hash]common.Hash
+}
+
+// changes returns the net modifications of the recorded transaction. It must be
+// called after the state has been finalised.
+//
+// Destroying an account depends on its entire state, because an account touched
+// while empty is deleted as well, so it is recorded as a read of all fields.
+func (r *accessRecorder) changes() []*accountChange {
+	addrs := make([]common.Address, 0, len(r.accounts))
+	for addr, acc := range r.accounts {
+		if acc.mutated {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	var changes []*accountChange
+	for _, addr := range addrs {
+		acc := r.accounts[addr]
+		if !r.StateDB.Exist(addr) {
+			if acc.exists {
+				acc.read |= fieldAll
+				changes = append(changes, &accountChange{address: addr, deleted: true})
+			}
+			continue
+		}
+		change := &accountChange{address: addr, created: acc.created}
+		if balance := r.StateDB.GetBalance(addr); acc.read&fieldBalance != 0 {
+			if balance.Cmp(acc.balance) != 0 || acc.created {
+				change.balance = new(big.Int).Set(balance)
+			}
+		} else if delta := new(big.Int).Sub(balance, acc.balance); delta.Sign() != 0 {
+			change.delta = delta
+		}
+		if nonce := r.StateDB.GetNonce(addr); nonce != acc.nonce || acc.created {
+			change.nonce = &nonce
+		}
+		if r.StateDB.GetCodeHash(addr) != acc.codeHash || acc.created {
+			change.code, change.setCode = r.StateDB.GetCode(addr), true
+		}
+		for key, slot := range acc.slots {
+			if !slot.written {
+				continue
+			}
+			if value := r.StateDB.GetState(addr, key); value != slot.value || acc.created {
+				if change.storage == nil {
+					change.storage = make(map[common.Hash]common.Hash)
+				}
+				change.storage[key] = value
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// applyChanges applies the modifications of a transaction to the state.
+func applyChanges(statedb *state.StateDB, changes []*accountChange) {
+	for _, change := range changes {
+		addr := change.address
+		if change.deleted {
+			statedb.Suicide(addr)
+			continue
+		}
+		if change.created && statedb.Exist(addr) {
+			statedb.CreateAccount(addr)
+		}
+		switch {
+		case change.balance != nil:
+			statedb.SetBalance(addr, change.balance)
+		case change.delta != nil && change.delta.Sign() > 0:
+			statedb.AddBalance(addr, change.delta)
+		case change.delta != nil:
+			statedb.SubBalance(addr, new(big.Int).Neg(change.delta))
+		}
+		if change.nonce != nil {
+			statedb.SetNonce(addr, *change.nonce)
+		}
+		if change.setCode {
+			statedb.SetCode(addr, change.code)
+		}
+		for key, value := range change.storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+}
+
+// writeSet is the set of account fields and storage slots modified by the already
+// merged transactions of a block.
+type writeSet map[common.Address]*accountWrites
+
+type accountWrites struct {
+	fields uint8
+	slots  map[common.Hash]struct{}
+}
+
+// add records the modifications of a transaction.
+func (w writeSet) add(changes []*accountChange) {
+	for _, change := range changes {
+		writes, ok := w[change.address]
+		if !ok {
+			writes = &accountWrites{slots: make(map[common.Hash]struct{})}
+			w[change.address] = writes
+		}
+		if change.deleted || change.created {
+			writes.fields |= fieldAll
+		}
+		if change.balance != nil || change.delta != nil {
+			writes.fields |= fieldBalance
+		}
+		if change.nonce != nil {
+			writes.fields |= fieldNonce
+		}
+		if change.setCode {
+			writes.fields |= fieldCode
+		}
+		for key := range change.storage {
+			writes.slots[key] = struct{}{}
+		}
+	}
+}
+
+// conflicts reports whether a transaction executed on the initial state of the
+// block read anything modified by the already merged transactions.
+func (w writeSet) conflicts(access *accessRecorder) bool {
+	for addr, acc := range access.accounts {
+		writes, ok := w[addr]
+		if !ok {
+			continue
+		}
+		if acc.read&writes.fields != 0 {
+			return true
+		}
+		if acc.read&fieldStorage != 0 && len(writes.slots) > 0 {
+			return true
+		}
+		for key, slot := range acc.slots {
+			if !slot.read {
+				continue
+			}
+			if writes.fields&fieldStorage != 0 {
+				return true
+			}
+			if _, ok := writes.slots[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the parallel processor produces the same state and receipts as the
+// serial one for blocks mixing independent and conflicting transactions.
+func TestParallelStateProcessor(t *testing.T) {
+	var (
+		config   = params.TestChainConfig
+		signer   = types.LatestSigner(config)
+		coinbase = common.Address{0xcb}
+		shared   = common.Address{0xaa}
+
+		counter      = common.Address{0x01, 0x01} // Increments slot 0
+		logger       = common.Address{0x01, 0x02} // Logs the caller and sets its slot
+		balanceOf    = common.Address{0x01, 0x03} // Stores the coinbase balance
+		selfdestruct = common.Address{0x01, 0x04} // Destructs itself to the caller
+
+		keys  []*ecdsa.PrivateKey
+		alloc = GenesisAlloc{
+			counter:      {Balance: new(big.Int), Code: common.Hex2Bytes("600054600101600055")},
+			logger:       {Balance: new(big.Int), Code: common.Hex2Bytes("3360006000a160013355")},
+			balanceOf:    {Balance: new(big.Int), Code: common.Hex2Bytes("413160005500")},
+			selfdestruct: {Balance: big.NewInt(1000), Code: common.Hex2Bytes("33ff")},
+		}
+		// Init code storing a value and deploying a single STOP.
+		initcode = common.Hex2Bytes("602a60005560016000f3")
+	)
+	for i := 0; i < 6; i++ {
+		key, _ := crypto.GenerateKey()
+		keys = append(keys, key)
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		gspec   = &Genesis{Config: config, Alloc: alloc}
+		genesis = gspec.MustCommit(db)
+		nonces  = make([]uint64, len(keys))
+	)
+	blocks, _ := GenerateChain(config, genesis, ethash.NewFaker(), db, 10, func(i int, b *BlockGen) {
+		b.SetCoinbase(coinbase)
+		for s, key := range keys {
+			send := func(to *common.Address, value int64, data []byte) {
+				var tx *types.Transaction
+				if to == nil {
+					tx = types.NewContractCreation(nonces[s], big.NewInt(value), 100000, big.NewInt(int64(s%2)), data)
+				} else {
+					tx = types.NewTransaction(nonces[s], *to, big.NewInt(value), 100000, big.NewInt(int64(s%2)), data)
+				}
+				tx, _ = types.SignTx(tx, signer, key)
+				b.AddTx(tx)
+				nonces[s]++
+			}
+			switch (i + s) % 5 {
+			case 0:
+				send(&counter, 0, nil)
+			case 1:
+				send(&logger, 0, nil)
+			case 2:
+				fresh := common.BigToAddress(big.NewInt(int64(1000 + i*len(keys) + s)))
+				send(&shared, 1000, nil)
+				send(&fresh, 1, nil)
+			case 3:
+				send(&balanceOf, 0, nil)
+			case 4:
+				send(nil, 0, initcode)
+				send(&selfdestruct, 1, nil)
+			}
+		}
+	})
+	// Process every block with the parallel processor on top of the parent state.
+	serial, _ := NewBlockChain(db, nil, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer serial.Stop()
+	if _, err := serial.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain serially: %v", err)
+	}
+	processor := NewParallelStateProcessor(config, serial, ethash.NewFaker())
+	processor.threads = 4
+
+	parent := genesis
+	for i, block := range blocks {
+		statedb, err := state.New(parent.Root(), serial.stateCache, nil)
+		if err != nil {
+			t.Fatalf("block %d: failed to open parent state: %v", i, err)
+		}
+		have, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d: failed to process: %v", i, err)
+		}
+		if usedGas != block.GasUsed() {
+			t.Errorf("block %d: gas used mismatch: have %d, want %d", i, usedGas, block.GasUsed())
+		}
+		if root := statedb.IntermediateRoot(true); root != block.Root() {
+			t.Errorf("block %d: state root mismatch: have %x, want %x", i, root, block.Root())
+		}
+		statedb, _ = state.New(parent.Root(), serial.stateCache, nil)
+		want, _, _, err := NewStateProcessor(config, serial, ethash.NewFaker()).Process(block, statedb, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d: failed to process serially: %v", i, err)
+		}
+		haveJSON, _ := json.Marshal(have)
+		wantJSON, _ := json.Marshal(want)
+		if string(haveJSON) != string(wantJSON) {
+			t.Errorf("block %d: receipt mismatch:\nhave %s\nwant %s", i, haveJSON, wantJSON)
+		}
+		parent = block
+	}
+	// Import the chain into a blockchain executing blocks in parallel.
+	pdb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(pdb)
+
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.ParallelExecution = true
+	parallel, _ := NewBlockChain(pdb, &cacheConfig, config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer parallel.Stop()
+
+	parallel.processor.(*ParallelStateProcessor).threads = 4
+	if _, err := parallel.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain in parallel: %v", err)
+	}
+	for i, block := range blocks {
+		haveJSON, _ := json.Marshal(parallel.GetReceiptsByHash(block.Hash()))
+		wantJSON, _ := json.Marshal(serial.GetReceiptsByHash(block.Hash()))
+		if string(haveJSON) != string(wantJSON) {
+			t.Errorf("block %d: stored receipt mismatch:\nhave %s\nwant %s", i, haveJSON, wantJSON)
+		}
+	}
+}
+
+// Tests that invalid transactions are reported by the parallel processor just
+// like by the serial one.
+func TestParallelStateProcessorErrors(t *testing.T) {
+	var (
+		signer     = types.HomesteadSigner{}
+		testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr       = crypto.PubkeyToAddress(testKey.PublicKey)
+		db         = rawdb.NewMemoryDatabase()
+		gspec      = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+		}
+		genesis       = gspec.MustCommit(db)
+		blockchain, _ = NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	)
+	defer blockchain.Stop()
+
+	processor := NewParallelStateProcessor(gspec.Config, blockchain, ethash.NewFaker())
+	processor.threads = 4
+
+	var makeTx = func(nonce uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), params.TxGas, nil, nil), signer, testKey)
+		return tx
+	}
+	for i, txs := range [][]*types.Transaction{
+		{makeTx(0), makeTx(0)},
+		{makeTx(0), makeTx(2)},
+	} {
+		block := GenerateBadBlock(genesis, ethash.NewFaker(), txs)
+
+		statedb, _ := state.New(genesis.Root(), blockchain.stateCache, nil)
+		_, _, _, want := NewStateProcessor(gspec.Config, blockchain, ethash.NewFaker()).Process(block, statedb, vm.Config{})
+
+		statedb, _ = state.New(genesis.Root(), blockchain.stateCache, nil)
+		_, _, _, have := processor.Process(block, statedb, vm.Config{})
+		if want == nil || have == nil || have.Error() != want.Error() {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
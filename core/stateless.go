@@ -0,0 +1,283 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Witness contains everything needed to execute a block without access to the
+// state database: the trie nodes and contract codes touched while executing the
+// block and computing its state root, and the headers of the parent and of all
+// ancestors whose hash was accessed.
+type Witness struct {
+	Headers []*types.Header // Parent header first, followed by older ones by decreasing number
+	Codes   [][]byte        // Contract codes, sorted
+	State   [][]byte        // RLP encoded trie nodes of the account and storage tries, sorted
+}
+
+// Root returns the state root the witness proves parts of.
+func (w *Witness) Root() common.Hash {
+	return w.Headers[0].Root
+}
+
+// witnessRecorder is a database recording every trie node and contract code read
+// through it. Trie nodes are retrieved from the given trie database, so that nodes
+// not flushed to disk yet are included.
+type witnessRecorder struct {
+	ethdb.Database
+	triedb *trie.Database
+
+	lock  sync.Mutex
+	nodes map[common.Hash][]byte
+	codes map[common.Hash][]byte
+}
+
+// Get implements ethdb.KeyValueReader, recording trie nodes and contract codes.
+func (r *witnessRecorder) Get(key []byte) ([]byte, error) {
+	if ok, hash := rawdb.IsCodeKey(key); ok {
+		code, err := r.Database.Get(key)
+		if err == nil {
+			r.lock.Lock()
+			r.codes[common.BytesToHash(hash)] = code
+			r.lock.Unlock()
+		}
+		return code, err
+	}
+	if len(key) == common.HashLength {
+		blob, err := r.triedb.Node(common.BytesToHash(key))
+		if err == nil {
+			r.lock.Lock()
+			r.nodes[common.BytesToHash(key)] = blob
+			r.lock.Unlock()
+		}
+		return blob, err
+	}
+	return r.Database.Get(key)
+}
+
+// headerRecorder is a chain recording every header retrieved from it.
+type headerRecorder struct {
+	processorChain
+
+	lock    sync.Mutex
+	headers map[common.Hash]*types.Header
+}
+
+func (r *headerRecorder) record(header *types.Header) *types.Header {
+	if header != nil {
+		r.lock.Lock()
+		r.headers[header.Hash()] = header
+		r.lock.Unlock()
+	}
+	return header
+}
+
+func (r *headerRecorder) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return r.record(r.processorChain.GetHeader(hash, number))
+}
+
+func (r *headerRecorder) GetHeaderByNumber(number uint64) *types.Header {
+	return r.record(r.processorChain.GetHeaderByNumber(number))
+}
+
+func (r *headerRecorder) GetHeaderByHash(hash common.Hash) *types.Header {
+	return r.record(r.processorChain.GetHeaderByHash(hash))
+}
+
+// errWitnessPathScheme is returned when creating an execution witness on a chain
+// storing its state with the path-based scheme, which can't resolve trie nodes
+// by hash.
+var errWitnessPathScheme = errors.New("execution witnesses are not supported by the path-based state scheme")
+
+// ExecutionWitness executes the given block on top of its parent state, which
+// must be available, and returns the witness needed to execute it statelessly.
+// Witnesses can only be created with the hash-based state scheme.
+func (bc *BlockChain) ExecutionWitness(block *types.Block) (*Witness, error) {
+	if bc.stateCache.TrieDB().Scheme() == rawdb.PathScheme {
+		return nil, errWitnessPathScheme
+	}
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	// Execute the block with the snapshot disabled, to have all state accessed
+	// through the tries.
+	recorder := &witnessRecorder{
+		Database: bc.db,
+		triedb:   bc.stateCache.TrieDB(),
+		nodes:    make(map[common.Hash][]byte),
+		codes:    make(map[common.Hash][]byte),
+	}
+	statedb, err := state.New(parent.Root, state.NewDatabaseForChain(recorder, bc.chainConfig, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	statedb.SetOrderedUpdates()
+	chain := &headerRecorder{processorChain: bc, headers: make(map[common.Hash]*types.Header)}
+	processor := &StateProcessor{config: bc.chainConfig, bc: chain, engine: bc.engine}
+
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if err := statedb.Error(); err != nil {
+		return nil, err
+	}
+	// Validating the block computes the new state root, recording the trie nodes
+	// needed for updating the tries as well.
+	validator := &BlockValidator{config: bc.chainConfig}
+	if err := validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return nil, err
+	}
+	witness := &Witness{Headers: []*types.Header{parent}}
+	for hash, header := range chain.headers {
+		if hash != parent.Hash() {
+			witness.Headers = append(witness.Headers, header)
+		}
+	}
+	sort.Slice(witness.Headers[1:], func(i, j int) bool {
+		return witness.Headers[i+1].Number.Cmp(witness.Headers[j+1].Number) > 0
+	})
+	for _, code := range recorder.codes {
+		witness.Codes = append(witness.Codes, code)
+	}
+	for _, node := range recorder.nodes {
+		witness.State = append(witness.State, node)
+	}
+	sort.Slice(witness.Codes, func(i, j int) bool { return bytes.Compare(witness.Codes[i], witness.Codes[j]) < 0 })
+	sort.Slice(witness.State, func(i, j int) bool { return bytes.Compare(witness.State[i], witness.State[j]) < 0 })
+	return witness, nil
+}
+
+// witnessChain is a chain consisting of the headers contained in a witness.
+type witnessChain struct {
+	config    *params.ChainConfig
+	engine    consensus.Engine
+	head      *types.Header
+	headers   map[common.Hash]*types.Header
+	canonical map[uint64]*types.Header
+}
+
+func newWitnessChain(config *params.ChainConfig, engine consensus.Engine, witness *Witness) *witnessChain {
+	chain := &witnessChain{
+		config:    config,
+		engine:    engine,
+		head:      witness.Headers[0],
+		headers:   make(map[common.Hash]*types.Header),
+		canonical: make(map[uint64]*types.Header),
+	}
+	for _, header := range witness.Headers {
+		chain.headers[header.Hash()] = header
+	}
+	// Only headers linked to the parent can be retrieved by number.
+	for header := chain.head; header != nil; header = chain.headers[header.ParentHash] {
+		chain.canonical[header.Number.Uint64()] = header
+		if header.Number.Sign() == 0 {
+			break
+		}
+	}
+	return chain
+}
+
+func (c *witnessChain) Config() *params.ChainConfig  { return c.config }
+func (c *witnessChain) Engine() consensus.Engine     { return c.engine }
+func (c *witnessChain) CurrentHeader() *types.Header { return c.head }
+
+func (c *witnessChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c *witnessChain) GetHeaderByNumber(number uint64) *types.Header {
+	return c.canonical[number]
+}
+
+func (c *witnessChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// ExecuteStateless executes a block using only the state contained in the given
+// witness and verifies the resulting state root, receipts and gas usage against
+// the block header. The block header itself is not verified.
+//
+// It returns the post-state root, which is only known to be correct if the parent
+// header of the witness is.
+func ExecuteStateless(config *params.ChainConfig, engine consensus.Engine, block *types.Block, witness *Witness) (common.Hash, error) {
+	if len(witness.Headers) == 0 {
+		return common.Hash{}, errors.New("witness without parent header")
+	}
+	if hash := witness.Headers[0].Hash(); hash != block.ParentHash() {
+		return common.Hash{}, fmt.Errorf("witness parent mismatch: have %#x, want %#x", hash, block.ParentHash())
+	}
+	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+		return common.Hash{}, fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	// Assemble an in-memory database from the witness.
+	db := rawdb.NewMemoryDatabase()
+	for _, node := range witness.State {
+		if config.BinaryTrie {
+			hash := sha256.Sum256(node)
+			db.Put(hash[:], node)
+		} else {
+			db.Put(crypto.Keccak256(node), node)
+		}
+	}
+	for _, code := range witness.Codes {
+		rawdb.WriteCode(db, crypto.Keccak256Hash(code), code)
+	}
+	statedb, err := state.New(witness.Root(), state.NewDatabaseForChain(db, config, nil), nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	statedb.SetOrderedUpdates()
+	processor := &StateProcessor{config: config, bc: newWitnessChain(config, engine, witness), engine: engine}
+
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := statedb.Error(); err != nil {
+		return common.Hash{}, fmt.Errorf("incomplete witness: %v", err)
+	}
+	validator := &BlockValidator{config: config}
+	if err := validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return common.Hash{}, err
+	}
+	if err := statedb.Error(); err != nil {
+		return common.Hash{}, fmt.Errorf("incomplete witness: %v", err)
+	}
+	return block.Root(), nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that blocks can be executed statelessly using the witness generated by the
+// blockchain, and that incomplete witnesses are rejected.
+func TestExecutionWitness(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr     = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.Address{0xc0}
+		signer   = types.LatestSigner(params.TestChainConfig)
+		db       = rawdb.NewMemoryDatabase()
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				addr: {Balance: big.NewInt(params.Ether)},
+				// Stores the hash of block NUMBER-3 in slot 0, sets slot NUMBER and
+				// clears slot NUMBER-2 again.
+				contract: {Balance: new(big.Int), Code: common.Hex2Bytes("6003430340600055600143556000600243035500")},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		gendb   = rawdb.NewMemoryDatabase()
+	)
+	gspec.MustCommit(gendb)
+
+	chain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer chain.Stop()
+
+	// Generate the blocks one by one, BLOCKHASH needs the ancestors in the chain.
+	var blocks []*types.Block
+	for parent, i := genesis, 0; i < 6; i++ {
+		generated, _ := GenerateChain(gspec.Config, parent, ethash.NewFaker(), gendb, 1, func(_ int, b *BlockGen) {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), contract, new(big.Int), 100000, big.NewInt(1), nil), signer, key)
+			b.AddTxWithChain(chain, tx)
+			tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{byte(i + 1)}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+			b.AddTxWithChain(chain, tx)
+		})
+		if _, err := chain.InsertChain(generated); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		parent = generated[0]
+		blocks = append(blocks, parent)
+	}
+	for i, block := range blocks {
+		witness, err := chain.ExecutionWitness(block)
+		if err != nil {
+			t.Fatalf("block %d: failed to create witness: %v", i, err)
+		}
+		if len(witness.Codes) != 1 {
+			t.Errorf("block %d: witness contains %d codes, want 1", i, len(witness.Codes))
+		}
+		// The hash of block NUMBER-3 is the parent hash of block NUMBER-2.
+		if i >= 3 && len(witness.Headers) != 2 {
+			t.Errorf("block %d: witness contains %d headers, want 2", i, len(witness.Headers))
+		}
+		// Execute the block with the decoded witness.
+		blob, err := rlp.EncodeToBytes(witness)
+		if err != nil {
+			t.Fatalf("block %d: failed to encode witness: %v", i, err)
+		}
+		var decoded Witness
+		if err := rlp.DecodeBytes(blob, &decoded); err != nil {
+			t.Fatalf("block %d: failed to decode witness: %v", i, err)
+		}
+		root, err := ExecuteStateless(gspec.Config, ethash.NewFaker(), block, &decoded)
+		if err != nil {
+			t.Fatalf("block %d: stateless execution failed: %v", i, err)
+		}
+		if root != block.Root() {
+			t.Fatalf("block %d: state root mismatch: have %x, want %x", i, root, block.Root())
+		}
+		// Every trie node and header of the witness is needed.
+		for j := range witness.State {
+			incomplete := *witness
+			incomplete.State = append(append([][]byte{}, witness.State[:j]...), witness.State[j+1:]...)
+			if _, err := ExecuteStateless(gspec.Config, ethash.NewFaker(), block, &incomplete); err == nil {
+				t.Errorf("block %d: no error without trie node %d", i, j)
+			}
+		}
+		if len(witness.Headers) > 1 {
+			incomplete := *witness
+			incomplete.Headers = witness.Headers[:len(witness.Headers)-1]
+			if _, err := ExecuteStateless(gspec.Config, ethash.NewFaker(), block, &incomplete); err == nil {
+				t.Errorf("block %d: no error without oldest header", i)
+			}
+		}
+		if i > 0 {
+			if _, err := ExecuteStateless(gspec.Config, ethash.NewFaker(), blocks[i-1], witness); err == nil {
+				t.Errorf("block %d: no error for witness of wrong block", i)
+			}
+		}
+	}
+}
+
+// Tests that creating witnesses is rejected on chains storing their state with the
+// path-based scheme, whose trie nodes can't be resolved by hash.
+func TestExecutionWitnessPathScheme(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		signer = types.LatestSigner(params.TestChainConfig)
+		gspec  = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+		}
+		db      = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	chaindb := rawdb.NewMemoryDatabase()
+	if err := EnablePathScheme(chaindb); err != nil {
+		t.Fatalf("failed to enable path scheme: %v", err)
+	}
+	gspec.MustCommit(chaindb)
+
+	chain, err := NewBlockChain(chaindb, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for i, block := range blocks {
+		if _, err := chain.ExecutionWitness(block); err != errWitnessPathScheme {
+			t.Errorf("block %d: error mismatch: have %v, want %v", i, err, errWitnessPathScheme)
+		}
+	}
+}
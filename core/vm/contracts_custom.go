@@ -0,0 +1,119 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// PrecompileContext is the environment a StatefulPrecompiledContract is run in.
+type PrecompileContext struct {
+	EVM      *EVM           // Gives access to the StateDB, block and transaction context
+	Caller   common.Address // Account invoking the precompile (the caller's sender for delegate calls)
+	Address  common.Address // Account the call executes in (the caller for code and delegate calls)
+	Value    *big.Int       // Value of the call (the caller's value for delegate calls, zero for static calls)
+	ReadOnly bool           // Whether state modifications are forbidden
+}
+
+// StatefulPrecompiledContract is a precompiled contract with access to the calling
+// context. The EVM invokes RunWithContext instead of Run for such contracts.
+//
+// Implementations must return ErrWriteProtection rather than modify the state if
+// the context is read-only. State changes are reverted if an error is returned.
+type StatefulPrecompiledContract interface {
+	PrecompiledContract
+	RunWithContext(ctx *PrecompileContext, input []byte) ([]byte, error)
+}
+
+var (
+	customPrecompilesLock sync.RWMutex
+	customPrecompiles     = make(map[string]PrecompiledContract)
+)
+
+// RegisterPrecompiledContract makes a custom precompiled contract available under
+// the given name. Contracts are activated at an address by the Precompiles field of
+// the chain configuration.
+//
+// Registration must happen before the node is started, typically from an init
+// function. It panics if the name is already taken.
+func RegisterPrecompiledContract(name string, p PrecompiledContract) {
+	customPrecompilesLock.Lock()
+	defer customPrecompilesLock.Unlock()
+
+	if _, exist := customPrecompiles[name]; exist {
+		panic(fmt.Sprintf("precompiled contract %q already registered", name))
+	}
+	customPrecompiles[name] = p
+}
+
+// customPrecompile returns the custom precompiled contract registered by name.
+func customPrecompile(name string) (PrecompiledContract, bool) {
+	customPrecompilesLock.RLock()
+	defer customPrecompilesLock.RUnlock()
+
+	p, ok := customPrecompiles[name]
+	return p, ok
+}
+
+// ValidatePrecompiles checks that every custom precompiled contract scheduled by
+// the chain configuration is registered and doesn't collide with a builtin one.
+func ValidatePrecompiles(config *params.ChainConfig) error {
+	for addr, p := range config.Precompiles {
+		if _, ok := PrecompiledContractsBerlin[addr]; ok {
+			return fmt.Errorf("custom precompile %q at %x shadows a builtin contract", p.Name, addr)
+		}
+		if _, ok := customPrecompile(p.Name); !ok {
+			return fmt.Errorf("custom precompile %q at %x is not registered", p.Name, addr)
+		}
+	}
+	return nil
+}
+
+// runPrecompiledContract runs a precompiled contract, providing the call context to
+// stateful contracts. A nil value denotes a call without value.
+func (evm *EVM) runPrecompiledContract(p PrecompiledContract, caller, addr common.Address, value *big.Int, readOnly bool, input []byte, suppliedGas uint64) (ret []byte, remainingGas uint64, err error) {
+	sp, ok := p.(StatefulPrecompiledContract)
+	if !ok {
+		return RunPrecompiledContract(p, input, suppliedGas)
+	}
+	gasCost := sp.RequiredGas(input)
+	if suppliedGas < gasCost {
+		return nil, 0, ErrOutOfGas
+	}
+	suppliedGas -= gasCost
+
+	if in, ok := evm.interpreter.(*EVMInterpreter); ok && in.readOnly {
+		readOnly = true
+	}
+	if value == nil {
+		value = new(big.Int)
+	}
+	ctx := &PrecompileContext{
+		EVM:      evm,
+		Caller:   caller,
+		Address:  addr,
+		Value:    value,
+		ReadOnly: readOnly,
+	}
+	output, err := sp.RunWithContext(ctx, input)
+	return output, suppliedGas, err
+}
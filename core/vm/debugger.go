@@ -0,0 +1,362 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// debugMode is the way the debugger resumes the execution after a command.
+type debugMode int
+
+const (
+	debugStep     debugMode = iota // Stop at the next instruction
+	debugNext                      // Stop at the next instruction in the same or a parent frame
+	debugOut                       // Stop at the next instruction in a parent frame
+	debugContinue                  // Stop at the next breakpoint only
+)
+
+// debugBreakpoint is a condition stopping the execution when met by an instruction
+// about to execute. Only one of the fields is set.
+type debugBreakpoint struct {
+	pc    *uint64
+	op    *OpCode
+	depth *int
+}
+
+func (b *debugBreakpoint) matches(pc uint64, op OpCode, depth int) bool {
+	switch {
+	case b.pc != nil:
+		return *b.pc == pc
+	case b.op != nil:
+		return *b.op == op
+	default:
+		return *b.depth == depth
+	}
+}
+
+func (b *debugBreakpoint) String() string {
+	switch {
+	case b.pc != nil:
+		return fmt.Sprintf("pc %d", *b.pc)
+	case b.op != nil:
+		return fmt.Sprintf("op %v", *b.op)
+	default:
+		return fmt.Sprintf("depth %d", *b.depth)
+	}
+}
+
+// Debugger is an EVM tracer stopping the execution before instructions to read and
+// run debugging commands, e.g. to step through the code, set breakpoints or inspect
+// the stack, memory, storage and return data of the current call frame. Commands are
+// read line by line, so the debugger can be driven by a script as well. Once the
+// commands are exhausted, the execution runs to completion.
+type Debugger struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	breakpoints []*debugBreakpoint
+	slots       map[common.Address]map[common.Hash]struct{} // Storage slots accessed by each contract
+
+	mode  debugMode // Condition to stop at, besides the breakpoints
+	count int       // Number of instructions to stop at before prompting
+	depth int       // Depth of the frame the execution was resumed in
+	last  string    // Last command, repeated by an empty line
+	done  bool      // Whether the commands are exhausted
+}
+
+// NewDebugger creates a new EVM tracer reading commands from the given input and
+// writing the results to the given output. The execution stops at the first
+// instruction.
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:    bufio.NewScanner(in),
+		out:   out,
+		slots: make(map[common.Address]map[common.Hash]struct{}),
+		mode:  debugStep,
+		count: 1,
+	}
+}
+
+// CaptureStart implements the Tracer interface, announcing the call or creation.
+func (d *Debugger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	kind := "call"
+	if create {
+		kind = "create"
+	}
+	fmt.Fprintf(d.out, "%s from %x to %x, input 0x%x, gas %d, value %v\n", kind, from, to, input, gas, value)
+	return nil
+}
+
+// CaptureState implements the Tracer interface, prompting for commands if the
+// instruction about to execute meets the stop condition or any breakpoint.
+func (d *Debugger) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, rData []byte, contract *Contract, depth int, err error) error {
+	// Track the storage slots accessed to be able to list them
+	if (op == SLOAD || op == SSTORE) && stack.len() > 0 {
+		slots, ok := d.slots[contract.Address()]
+		if !ok {
+			slots = make(map[common.Hash]struct{})
+			d.slots[contract.Address()] = slots
+		}
+		slots[common.Hash(stack.Back(0).Bytes32())] = struct{}{}
+	}
+	if d.done || !d.stop(pc, op, depth) {
+		return nil
+	}
+	fmt.Fprintf(d.out, "%-16spc=%08d gas=%v cost=%v depth=%d\n", op, pc, gas, cost, depth)
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			d.done = true
+			return nil
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "step", "s", "next", "n":
+			// Resume for a number of instructions, stepping over calls with next
+			d.mode, d.count, d.depth = debugStep, 1, depth
+			if args[0] == "next" || args[0] == "n" {
+				d.mode = debugNext
+			}
+			if len(args) > 1 {
+				count, err := strconv.Atoi(args[1])
+				if err != nil || count < 1 {
+					fmt.Fprintf(d.out, "invalid count %s\n", args[1])
+					continue
+				}
+				d.count = count
+			}
+			return nil
+
+		case "out", "o":
+			d.mode, d.depth = debugOut, depth
+			return nil
+
+		case "continue", "c":
+			d.mode = debugContinue
+			return nil
+
+		case "break", "b":
+			if breakpoint := d.parseBreakpoint(args[1:]); breakpoint != nil {
+				d.breakpoints = append(d.breakpoints, breakpoint)
+				fmt.Fprintf(d.out, "breakpoint %d at %v\n", len(d.breakpoints), breakpoint)
+			}
+
+		case "delete", "d":
+			if len(args) < 2 {
+				d.breakpoints = nil
+				continue
+			}
+			index, err := strconv.Atoi(args[1])
+			if err != nil || index < 1 || index > len(d.breakpoints) {
+				fmt.Fprintf(d.out, "no breakpoint %s\n", args[1])
+				continue
+			}
+			d.breakpoints = append(d.breakpoints[:index-1], d.breakpoints[index:]...)
+
+		case "breakpoints":
+			for i, breakpoint := range d.breakpoints {
+				fmt.Fprintf(d.out, "%d: %v\n", i+1, breakpoint)
+			}
+
+		case "where", "w":
+			fmt.Fprintf(d.out, "address %x, caller %x, value %v, depth %d\n", contract.Address(), contract.Caller(), contract.Value(), depth)
+			fmt.Fprintf(d.out, "%-16spc=%08d gas=%v cost=%v\n", op, pc, gas, cost)
+
+		case "stack":
+			data := stack.Data()
+			for i := len(data) - 1; i >= 0; i-- {
+				fmt.Fprintf(d.out, "%08d  %x\n", len(data)-i-1, math.PaddedBigBytes(data[i].ToBig(), 32))
+			}
+
+		case "memory", "mem":
+			data := memory.Data()
+			if len(args) > 1 {
+				offset, size, ok := d.parseRange(args[1:], uint64(len(data)))
+				if !ok {
+					continue
+				}
+				data = data[offset : offset+size]
+			}
+			fmt.Fprint(d.out, hex.Dump(data))
+
+		case "storage":
+			if len(args) > 1 {
+				slot := common.HexToHash(args[1])
+				fmt.Fprintf(d.out, "%x: %x\n", slot, env.StateDB.GetState(contract.Address(), slot))
+				continue
+			}
+			// List the slots accessed so far, in order
+			var slots []common.Hash
+			for slot := range d.slots[contract.Address()] {
+				slots = append(slots, slot)
+			}
+			sort.Slice(slots, func(i, j int) bool { return bytes.Compare(slots[i][:], slots[j][:]) < 0 })
+			for _, slot := range slots {
+				fmt.Fprintf(d.out, "%x: %x\n", slot, env.StateDB.GetState(contract.Address(), slot))
+			}
+
+		case "returndata", "ret":
+			fmt.Fprint(d.out, hex.Dump(rData))
+
+		case "quit", "q":
+			// Abort the execution, skipping the remaining commands
+			env.Cancel()
+			d.done = true
+			return nil
+
+		case "help", "h":
+			fmt.Fprint(d.out, debuggerHelp)
+
+		default:
+			fmt.Fprintf(d.out, "unknown command %s, try help\n", args[0])
+		}
+	}
+}
+
+// CaptureFault implements the Tracer interface, reporting the error.
+func (d *Debugger) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	fmt.Fprintf(d.out, "fault at pc=%08d %v, depth %d: %v\n", pc, op, depth, err)
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface, reporting the outcome.
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	fmt.Fprintf(d.out, "finished with output 0x%x, gas used %d", output, gasUsed)
+	if err != nil {
+		fmt.Fprintf(d.out, ", error: %v", err)
+	}
+	fmt.Fprintln(d.out)
+	return nil
+}
+
+// stop returns whether the execution has to stop at the instruction about to
+// execute.
+func (d *Debugger) stop(pc uint64, op OpCode, depth int) bool {
+	for _, breakpoint := range d.breakpoints {
+		if breakpoint.matches(pc, op, depth) {
+			return true
+		}
+	}
+	switch d.mode {
+	case debugStep:
+		d.count--
+		return d.count <= 0
+	case debugNext:
+		if depth <= d.depth {
+			d.count--
+		}
+		return d.count <= 0
+	case debugOut:
+		return depth < d.depth
+	}
+	return false
+}
+
+// parseBreakpoint parses the arguments of a break command.
+func (d *Debugger) parseBreakpoint(args []string) *debugBreakpoint {
+	if len(args) != 2 {
+		fmt.Fprintln(d.out, "usage: break pc <pc> | op <opcode> | depth <depth>")
+		return nil
+	}
+	switch args[0] {
+	case "pc":
+		pc, ok := math.ParseUint64(args[1])
+		if !ok {
+			fmt.Fprintf(d.out, "invalid pc %s\n", args[1])
+			return nil
+		}
+		return &debugBreakpoint{pc: &pc}
+
+	case "op":
+		name := strings.ToUpper(args[1])
+		op := StringToOp(name)
+		if op == STOP && name != "STOP" {
+			fmt.Fprintf(d.out, "invalid opcode %s\n", args[1])
+			return nil
+		}
+		return &debugBreakpoint{op: &op}
+
+	case "depth":
+		depth, err := strconv.Atoi(args[1])
+		if err != nil || depth < 1 {
+			fmt.Fprintf(d.out, "invalid depth %s\n", args[1])
+			return nil
+		}
+		return &debugBreakpoint{depth: &depth}
+	}
+	fmt.Fprintf(d.out, "invalid breakpoint kind %s\n", args[0])
+	return nil
+}
+
+// parseRange parses the offset and optional size of a memory command, checking
+// them against the size of the memory.
+func (d *Debugger) parseRange(args []string, limit uint64) (uint64, uint64, bool) {
+	offset, ok := math.ParseUint64(args[0])
+	if !ok || offset > limit {
+		fmt.Fprintf(d.out, "invalid offset %s\n", args[0])
+		return 0, 0, false
+	}
+	size := limit - offset
+	if len(args) > 1 {
+		if size, ok = math.ParseUint64(args[1]); !ok || size > limit-offset {
+			fmt.Fprintf(d.out, "invalid size %s\n", args[1])
+			return 0, 0, false
+		}
+	}
+	return offset, size, true
+}
+
+const debuggerHelp = `step, s [n]          execute the next n instructions, entering calls
+next, n [n]          execute the next n instructions, stepping over calls
+out, o               execute until the current call returns
+continue, c          execute until the next breakpoint
+break, b pc <pc>     stop before the instruction at the given pc
+break, b op <op>     stop before the given opcode
+break, b depth <n>   stop before any instruction at the given call depth
+breakpoints          list the breakpoints
+delete, d [n]        delete breakpoint n, or all of them
+where, w             show the current call frame and instruction
+stack                show the stack, top first
+memory, mem [o [n]]  show the memory, or n bytes of it from offset o
+storage [slot]       show a storage slot, or all the ones accessed
+returndata, ret      show the return data of the last call
+quit, q              abort the execution
+help, h              show this help
+`
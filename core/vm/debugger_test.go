@@ -0,0 +1,102 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestDebugger(t *testing.T) {
+	var (
+		caller = common.BytesToAddress([]byte("caller"))
+		callee = common.BytesToAddress([]byte("callee"))
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(caller, append(append([]byte{
+		byte(PUSH1), 0, byte(DUP1), byte(DUP1), byte(DUP1), byte(DUP1), // out size, out offset, in size, in offset, value
+		byte(PUSH20),
+	}, callee.Bytes()...),
+		byte(GAS), byte(CALL), byte(POP), byte(STOP),
+	))
+	statedb.SetCode(callee, []byte{
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), byte(PUSH1), 0x42, byte(PUSH1), 0, byte(MSTORE8), byte(STOP),
+	})
+	script := strings.Join([]string{
+		"next 2",          // step over the pushes of the caller
+		"break op sstore", // stop in the callee
+		"continue",
+		"stack",
+		"step",
+		"storage",
+		"next",
+		"", // repeated next
+		"step",
+		"memory 0 1",
+		"out", // back in the caller after the call
+		"where",
+		"breakpoints",
+		"delete 1",
+		"bogus",
+	}, "\n")
+	var out bytes.Buffer
+	debugger := NewDebugger(strings.NewReader(script), &out)
+	vmctx := BlockContext{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: new(big.Int),
+	}
+	vmenv := NewEVM(vmctx, TxContext{}, statedb, params.TestChainConfig, Config{Debug: true, Tracer: debugger})
+	if _, _, err := vmenv.Call(AccountRef(common.Address{}), caller, nil, 1000000, new(big.Int)); err != nil {
+		t.Fatal(err)
+	}
+	// Check the output of the commands, in order
+	want := []string{
+		"PUSH1           pc=00000000 gas=1000000 cost=3 depth=1\n",
+		"> DUP1            pc=00000003 gas=999994 cost=3 depth=1\n",
+		"> breakpoint 1 at op SSTORE\n",
+		"> SSTORE          pc=00000004 gas=981790 cost=22100 depth=2\n",
+		"> 00000000  0000000000000000000000000000000000000000000000000000000000000000\n" +
+			"00000001  0000000000000000000000000000000000000000000000000000000000000001\n",
+		"> PUSH1           pc=00000005 gas=959690 cost=3 depth=2\n",
+		"> 0000000000000000000000000000000000000000000000000000000000000000: 0000000000000000000000000000000000000000000000000000000000000001\n",
+		"> PUSH1           pc=00000007 gas=959687 cost=3 depth=2\n",
+		"> MSTORE8         pc=00000009 gas=959684 cost=6 depth=2\n",
+		"> STOP            pc=00000010 gas=959678 cost=0 depth=2\n",
+		"> 00000000  42 ",
+		"> POP             pc=00000029 gas=975262 cost=2 depth=1\n",
+		"> address 000000000000000000000000000063616c6c6572, caller 0000000000000000000000000000000000000000, value 0, depth 1\n",
+		"> 1: op SSTORE\n",
+		"> > unknown command bogus, try help\n",
+		"finished with output 0x, gas used 24740\n",
+	}
+	output := out.String()
+	for _, line := range want {
+		index := strings.Index(output, line)
+		if index < 0 {
+			t.Fatalf("missing output %q in:\n%s", line, out.String())
+		}
+		output = output[index+len(line):]
+	}
+}
@@ -45,16 +45,22 @@ type (
 // ActivePrecompiles returns the addresses of the precompiles enabled with the current
 // configuration
 func (evm *EVM) ActivePrecompiles() []common.Address {
+	var builtin []common.Address
 	switch {
 	case evm.chainRules.IsBerlin:
-		return PrecompiledAddressesBerlin
+		builtin = PrecompiledAddressesBerlin
 	case evm.chainRules.IsIstanbul:
-		return PrecompiledAddressesIstanbul
+		builtin = PrecompiledAddressesIstanbul
 	case evm.chainRules.IsByzantium:
-		return PrecompiledAddressesByzantium
+		builtin = PrecompiledAddressesByzantium
 	default:
-		return PrecompiledAddressesHomestead
+		builtin = PrecompiledAddressesHomestead
 	}
+	if len(evm.chainConfig.Precompiles) == 0 {
+		return builtin
+	}
+	custom := evm.chainConfig.ActivePrecompiles(evm.Context.BlockNumber)
+	return append(append(make([]common.Address, 0, len(builtin)+len(custom)), builtin...), custom...)
 }
 
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
@@ -69,8 +75,15 @@ func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
 	default:
 		precompiles = PrecompiledContractsHomestead
 	}
-	p, ok := precompiles[addr]
-	return p, ok
+	if p, ok := precompiles[addr]; ok {
+		return p, true
+	}
+	if len(evm.chainConfig.Precompiles) > 0 {
+		if name, ok := evm.chainConfig.ActivePrecompile(addr, evm.Context.BlockNumber); ok {
+			return customPrecompile(name)
+		}
+	}
+	return nil, false
 }
 
 // run runs the given contract and takes care of running precompiles with a fallback to the byte code interpreter.
@@ -257,7 +270,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	}
 
 	if isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, caller.Address(), addr, value, false, input, gas)
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
@@ -313,9 +326,10 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	}
 	var snapshot = evm.StateDB.Snapshot()
 
-	// It is allowed to call precompiles, even via delegatecall
+	// It is allowed to call precompiles, even via delegatecall. Stateful ones run
+	// in the context of the caller, like the code of any other contract would.
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, caller.Address(), caller.Address(), value, false, input, gas)
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and set the code that is to be used by the EVM.
@@ -349,9 +363,14 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	}
 	var snapshot = evm.StateDB.Snapshot()
 
-	// It is allowed to call precompiles, even via delegatecall
+	// It is allowed to call precompiles, even via delegatecall. Stateful ones run
+	// in the context of the caller, inheriting the sender and value of its frame.
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		parent, value := caller.Address(), (*big.Int)(nil)
+		if contract, ok := caller.(*Contract); ok {
+			parent, value = contract.CallerAddress, contract.value
+		}
+		ret, gas, err = evm.runPrecompiledContract(p, parent, caller.Address(), value, false, input, gas)
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and make initialise the delegate values
@@ -395,7 +414,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	evm.StateDB.AddBalance(addr, big0)
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, caller.Address(), addr, nil, true, input, gas)
 	} else {
 		// At this point, we use a copy of address. If we don't, the go compiler will
 		// leak the 'contract' to the outer scope, and make allocation for 'contract'
@@ -38,6 +38,8 @@ type Config struct {
 	EVMInterpreter   string // External EVM interpreter options
 
 	ExtraEips []int // Additional EIPS that are to be enabled
+
+	DisableCodeAnalysis bool // Disables superinstructions and block-wise static gas charging
 }
 
 // Interpreter is used to run Ethereum based contracts and will utilise the
@@ -88,6 +90,9 @@ type EVMInterpreter struct {
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	analyse bool   // Whether to run code using the cached code analysis
+	tableID uint64 // Identifier of the jump table for the code analysis cache
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -115,6 +120,11 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 		default:
 			jt = frontierInstructionSet
 		}
+		// The operations are shared with the global instruction sets, so copy
+		// them before the extra eips modify them in-place.
+		if len(cfg.ExtraEips) > 0 {
+			jt = copyJumpTable(&jt)
+		}
 		for i, eip := range cfg.ExtraEips {
 			if err := EnableEIP(eip, &jt); err != nil {
 				// Disable it, so caller can check if it's activated or not
@@ -125,10 +135,17 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 		cfg.JumpTable = jt
 	}
 
-	return &EVMInterpreter{
+	in := &EVMInterpreter{
 		evm: evm,
 		cfg: cfg,
 	}
+	// Tracers expect every instruction to be executed and charged individually,
+	// so the code analysis is only used without them.
+	if !cfg.Debug && !cfg.DisableCodeAnalysis {
+		in.analyse = true
+		in.tableID = jumpTableID((*JumpTable)(&in.cfg.JumpTable))
+	}
+	return in
 }
 
 // Run loops and evaluates the contract's code with the given input data and returns
@@ -178,7 +195,16 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		gasCopy uint64 // for Tracer to log gas remaining before execution
 		logged  bool   // deferred Tracer should ignore already logged steps
 		res     []byte // result of the opcode execution function
+
+		analysis *codeAnalysis // superinstructions and block gas, nil if disabled
+		blockEnd uint64        // end of the block whose static gas is already charged
 	)
+	if in.analyse {
+		analysis = sharedAnalysisCache.analysis(contract, (*JumpTable)(&in.cfg.JumpTable), in.tableID)
+		if contract.analysis == nil {
+			contract.analysis = analysis.bitmap
+		}
+	}
 	// Don't move this deferrred function, it's placed before the capturestate-deferred method,
 	// so that it get's executed _after_: the capturestate needs the stacks before
 	// they are returned to the pools
@@ -206,6 +232,10 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	for {
 		steps++
 		if steps%1000 == 0 && atomic.LoadInt32(&in.evm.abort) != 0 {
+			if pc < blockEnd {
+				// Give back the gas charged for the rest of the block.
+				contract.Gas += uint64(analysis.gas[pc])
+			}
 			break
 		}
 		if in.cfg.Debug {
@@ -213,6 +243,24 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			logged, pcCopy, gasCopy = false, pc, contract.Gas
 		}
 
+		if analysis != nil && pc < uint64(len(analysis.gas)) {
+			// Charge the static gas of a new block at once if possible and run
+			// superinstructions within charged blocks.
+			if pc >= blockEnd {
+				if gas := uint64(analysis.gas[pc]); gas > 0 && contract.Gas >= gas {
+					contract.Gas -= gas
+					blockEnd = uint64(analysis.ends[pc])
+				}
+			}
+			if pc < blockEnd && analysis.fused[pc] != fusedNone {
+				if ok, jumped := runFused(analysis.fused[pc], &pc, callContext); ok {
+					if jumped {
+						blockEnd = 0
+					}
+					continue
+				}
+			}
+		}
 		// Get the operation from the jump table and validate the stack to ensure there are
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
@@ -239,7 +287,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		}
 		// Static portion of gas
 		cost = operation.constantGas // For tracing
-		if !contract.UseGas(operation.constantGas) {
+		if pc >= blockEnd && !contract.UseGas(operation.constantGas) {
 			return nil, ErrOutOfGas
 		}
 
@@ -296,6 +344,8 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			return res, nil
 		case !operation.jumps:
 			pc++
+		default:
+			blockEnd = 0
 		}
 	}
 	return nil, nil
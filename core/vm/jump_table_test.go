@@ -0,0 +1,41 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that enabling extra eips on an interpreter doesn't modify the global
+// instruction sets, affecting other interpreters of the same fork.
+func TestExtraEipsCopyJumpTable(t *testing.T) {
+	var (
+		sload  = istanbulInstructionSet[SLOAD].constantGas
+		config = *params.TestChainConfig
+	)
+	config.BerlinBlock = nil
+
+	evm := NewEVM(BlockContext{BlockNumber: params.TestChainConfig.IstanbulBlock}, TxContext{}, nil, &config, Config{ExtraEips: []int{2200, 2929}})
+	if gas := evm.interpreter.(*EVMInterpreter).cfg.JumpTable[SLOAD].constantGas; gas != 0 {
+		t.Fatalf("extra eips not enabled: SLOAD constant gas %d", gas)
+	}
+	if gas := istanbulInstructionSet[SLOAD].constantGas; gas != sload {
+		t.Fatalf("global instruction set modified: SLOAD constant gas %d, want %d", gas, sload)
+	}
+}
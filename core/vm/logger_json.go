@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
@@ -93,3 +94,140 @@ func (l *JSONLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration,
 	}
 	return l.encoder.Encode(endLog{common.Bytes2Hex(output), math.HexOrDecimal64(gasUsed), t, ""})
 }
+
+// StandardJSONLogger is an EVM tracer printing the execution steps as JSON objects
+// in the format specified by EIP-3155, to be compared with the traces of other
+// clients. Every step has the same fields in all the call frames, and the trace of
+// a transaction is closed by a summary written with WriteSummary, once the state
+// root after the transaction is known.
+type StandardJSONLogger struct {
+	encoder *json.Encoder
+	cfg     *LogConfig
+
+	memSizes []int // Memory size after the last step of each call frame
+
+	output  []byte // Output of the last execution
+	gasUsed uint64 // Gas used by the last execution
+	err     error  // Error of the last execution
+}
+
+// standardLog is an execution step in the EIP-3155 format, with the state of the
+// call frame before the step. The memory, stack and return data are left out if
+// disabled in the config.
+type standardLog struct {
+	Pc         uint64              `json:"pc"`
+	Op         OpCode              `json:"op"`
+	Gas        math.HexOrDecimal64 `json:"gas"`
+	GasCost    math.HexOrDecimal64 `json:"gasCost"`
+	Memory     *hexutil.Bytes      `json:"memory,omitempty"`
+	MemorySize int                 `json:"memSize"`
+	Stack      *[]string           `json:"stack,omitempty"`
+	ReturnData *hexutil.Bytes      `json:"returnData,omitempty"`
+	Depth      int                 `json:"depth"`
+	Refund     math.HexOrDecimal64 `json:"refund"`
+	OpName     string              `json:"opName"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// standardSummary is the summary closing the trace of a transaction in the
+// EIP-3155 format.
+type standardSummary struct {
+	StateRoot common.Hash         `json:"stateRoot"`
+	Output    hexutil.Bytes       `json:"output"`
+	GasUsed   math.HexOrDecimal64 `json:"gasUsed"`
+	Pass      *bool               `json:"pass,omitempty"`
+	Fork      string              `json:"fork,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// NewStandardJSONLogger creates a new EVM tracer that prints execution steps in the
+// EIP-3155 format into the provided stream.
+func NewStandardJSONLogger(cfg *LogConfig, writer io.Writer) *StandardJSONLogger {
+	l := &StandardJSONLogger{encoder: json.NewEncoder(writer), cfg: cfg}
+	if l.cfg == nil {
+		l.cfg = &LogConfig{}
+	}
+	return l
+}
+
+// CaptureStart implements the Tracer interface, resetting the outcome of any
+// previous execution.
+func (l *StandardJSONLogger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	l.memSizes = l.memSizes[:0]
+	l.output, l.gasUsed, l.err = nil, 0, nil
+	return nil
+}
+
+// CaptureState outputs the execution step on the logger.
+func (l *StandardJSONLogger) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, rData []byte, contract *Contract, depth int, err error) error {
+	// The memory is already expanded for the step, report it as it was before,
+	// i.e. after the previous step of the same call frame
+	for len(l.memSizes) > depth {
+		l.memSizes = l.memSizes[:len(l.memSizes)-1]
+	}
+	for len(l.memSizes) < depth {
+		l.memSizes = append(l.memSizes, 0)
+	}
+	memSize := l.memSizes[depth-1]
+	l.memSizes[depth-1] = memory.Len()
+
+	log := standardLog{
+		Pc:         pc,
+		Op:         op,
+		Gas:        math.HexOrDecimal64(gas),
+		GasCost:    math.HexOrDecimal64(cost),
+		MemorySize: memSize,
+		Depth:      depth,
+		Refund:     math.HexOrDecimal64(env.StateDB.GetRefund()),
+		OpName:     op.String(),
+	}
+	if !l.cfg.DisableMemory {
+		mem := hexutil.Bytes(memory.Data()[:memSize])
+		log.Memory = &mem
+	}
+	if !l.cfg.DisableStack {
+		items := make([]string, len(stack.Data()))
+		for i, item := range stack.Data() {
+			items[i] = item.Hex()
+		}
+		log.Stack = &items
+	}
+	if !l.cfg.DisableReturnData {
+		data := hexutil.Bytes(rData)
+		log.ReturnData = &data
+	}
+	if err != nil {
+		log.Error = err.Error()
+	}
+	return l.encoder.Encode(log)
+}
+
+// CaptureFault implements the Tracer interface. The failing step has already been
+// output, so the error is only reported in the summary.
+func (l *StandardJSONLogger) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is triggered at end of execution, keeping its outcome for the summary.
+func (l *StandardJSONLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	l.output, l.gasUsed, l.err = common.CopyBytes(output), gasUsed, err
+	return nil
+}
+
+// WriteSummary outputs the summary closing the trace of a transaction, with the
+// given state root after it and the output, gas used and error of the execution.
+// The fork and whether the transaction passed a test are left out if empty.
+func (l *StandardJSONLogger) WriteSummary(root common.Hash, fork string, pass *bool) error {
+	summary := standardSummary{
+		StateRoot: root,
+		Output:    l.output,
+		GasUsed:   math.HexOrDecimal64(l.gasUsed),
+		Pass:      pass,
+		Fork:      fork,
+	}
+	if l.err != nil {
+		summary.Error = l.err.Error()
+	}
+	l.output, l.gasUsed, l.err = nil, 0, nil
+	return l.encoder.Encode(summary)
+}
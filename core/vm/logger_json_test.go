@@ -0,0 +1,100 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests the standard JSON logger against golden traces, covering nested calls,
+// return data, refunds and failing frames.
+func TestStandardJSONLogger(t *testing.T) {
+	var (
+		caller  = common.BytesToAddress([]byte("caller"))
+		callee  = common.BytesToAddress([]byte("callee"))
+		invalid = common.BytesToAddress([]byte("invalid"))
+	)
+	call := func(target common.Address) []byte {
+		// out size, out offset, in size, in offset, value, address, gas, call
+		code := []byte{byte(PUSH1), 0x20, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH20)}
+		return append(append(code, target.Bytes()...), byte(GAS), byte(CALL), byte(POP))
+	}
+	var code []byte
+	code = append(code, byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE)) // Set and clear a slot for a refund
+	code = append(code, byte(PUSH1), 0, byte(PUSH1), 0, byte(SSTORE))
+	code = append(code, call(callee)...)
+	code = append(code, call(invalid)...)
+	code = append(code, byte(PUSH1), 0x20, byte(PUSH1), 0, byte(RETURN))
+
+	tests := []struct {
+		name   string
+		config *LogConfig
+		golden string
+	}{
+		{"full", nil, "testdata/standard_trace.jsonl"},
+		{"disabled", &LogConfig{DisableMemory: true, DisableStack: true, DisableReturnData: true}, "testdata/standard_trace_disabled.jsonl"},
+	}
+	for _, test := range tests {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.SetCode(caller, code)
+		statedb.SetCode(callee, []byte{byte(PUSH1), 0x2a, byte(PUSH1), 0, byte(MSTORE), byte(PUSH1), 0x20, byte(PUSH1), 0, byte(RETURN)})
+		statedb.AddAddressToAccessList(caller)
+		statedb.SetCode(invalid, []byte{byte(PUSH1), 0, 0xfe}) // Invalid opcode
+
+		var out bytes.Buffer
+		logger := NewStandardJSONLogger(test.config, &out)
+		vmctx := BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: new(big.Int),
+		}
+		vmenv := NewEVM(vmctx, TxContext{}, statedb, params.TestChainConfig, Config{Debug: true, Tracer: logger})
+		if _, _, err := vmenv.Call(AccountRef(common.Address{}), caller, nil, 100000, new(big.Int)); err != nil {
+			t.Fatalf("%s: execution failed: %v", test.name, err)
+		}
+		pass := true
+		if err := logger.WriteSummary(statedb.IntermediateRoot(true), "Berlin", &pass); err != nil {
+			t.Fatalf("%s: failed writing summary: %v", test.name, err)
+		}
+		want, err := ioutil.ReadFile(test.golden)
+		if err != nil {
+			t.Fatalf("%s: failed reading golden trace: %v", test.name, err)
+		}
+		haveLines, wantLines := strings.Split(out.String(), "\n"), strings.Split(string(want), "\n")
+		for i := 0; i < len(haveLines) || i < len(wantLines); i++ {
+			var have, want string
+			if i < len(haveLines) {
+				have = haveLines[i]
+			}
+			if i < len(wantLines) {
+				want = wantLines[i]
+			}
+			if have != want {
+				t.Fatalf("%s: line %d mismatch\nhave: %s\nwant: %s", test.name, i+1, have, want)
+			}
+		}
+	}
+}
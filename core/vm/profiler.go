@@ -0,0 +1,394 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Profiler is an EVM tracer aggregating the execution count, gas usage and wall
+// time of every executed instruction. The collected data can be written out in the
+// pprof format with every sample carrying the executed opcode on top of the call
+// stack of contract addresses and program counters leading to it.
+//
+// Gas and time are exclusive: the cost of a call instruction doesn't include the
+// execution of the callee, except for calls to precompiles and accounts without
+// code. Gas burnt by a failed call is attributed to the call instruction.
+type Profiler struct {
+	frames  []*profileFrame           // Call stack of the currently executing contracts
+	samples map[string]*profileSample // Aggregated samples keyed by stack and opcode
+	start   time.Time                 // Time the profiler was created at
+}
+
+// profileFrame is a contract execution tracked by the profiler.
+type profileFrame struct {
+	address common.Address
+	step    *profileStep // Last instruction executed by the frame, nil before the first one
+	gas     uint64       // Gas used by completed instructions, including child calls
+	time    time.Duration
+}
+
+// profileStep is an executed instruction whose cost is not yet known.
+type profileStep struct {
+	pc        uint64
+	op        OpCode
+	gas       uint64 // Gas available before the instruction
+	cost      uint64 // Cost reported by the interpreter
+	start     time.Time
+	childGas  uint64 // Gas used by calls made by the instruction
+	childTime time.Duration
+}
+
+// profileSample is the aggregated cost of an instruction at a given call stack.
+type profileSample struct {
+	stack []profileLocation // Leaf first
+	op    OpCode
+	count int64
+	gas   int64
+	time  int64
+}
+
+// profileLocation is a program counter within a contract.
+type profileLocation struct {
+	address common.Address
+	pc      uint64
+}
+
+// NewProfiler creates a new EVM tracer collecting an execution profile.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		samples: make(map[string]*profileSample),
+		start:   time.Now(),
+	}
+}
+
+// CaptureStart implements the Tracer interface.
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface, accounting the cost of the previous
+// instruction of the current frame once the next one is about to execute.
+func (p *Profiler) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, rData []byte, contract *Contract, depth int, err error) error {
+	now := time.Now()
+
+	// Close the frames of calls which returned since the last instruction.
+	for len(p.frames) > depth {
+		p.popFrame(now)
+	}
+	if len(p.frames) == depth {
+		if frame := p.frames[depth-1]; frame.step != nil {
+			used := frame.step.gas - gas
+			if gas > frame.step.gas {
+				used = 0
+			}
+			p.finishStep(depth-1, used, now)
+		}
+	} else {
+		address := contract.Address()
+		if contract.CodeAddr != nil {
+			address = *contract.CodeAddr
+		}
+		p.frames = append(p.frames, &profileFrame{address: address})
+	}
+	p.frames[depth-1].step = &profileStep{pc: pc, op: op, gas: gas, cost: cost, start: now}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface.
+func (p *Profiler) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface, closing all open frames.
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	now := time.Now()
+	for len(p.frames) > 0 {
+		p.popFrame(now)
+	}
+	return nil
+}
+
+// popFrame closes the innermost frame, charging its last instruction with the cost
+// reported by the interpreter and its total usage to the calling instruction.
+func (p *Profiler) popFrame(now time.Time) {
+	index := len(p.frames) - 1
+	if step := p.frames[index].step; step != nil {
+		p.finishStep(index, step.cost, now)
+	}
+	frame := p.frames[index]
+	p.frames = p.frames[:index]
+
+	if index > 0 {
+		if parent := p.frames[index-1].step; parent != nil {
+			parent.childGas += frame.gas
+			parent.childTime += frame.time
+		}
+	}
+}
+
+// finishStep records the last instruction of the given frame, which used the given
+// amount of gas including all child calls.
+func (p *Profiler) finishStep(index int, used uint64, now time.Time) {
+	var (
+		frame   = p.frames[index]
+		step    = frame.step
+		elapsed = now.Sub(step.start)
+	)
+	frame.step = nil
+	frame.gas += used
+	frame.time += elapsed
+
+	gas, self := used, elapsed-step.childTime
+	if gas < step.childGas {
+		gas = 0
+	} else {
+		gas -= step.childGas
+	}
+	if self < 0 {
+		self = 0
+	}
+	// Assemble the call stack, the caller frames are at their call instructions.
+	key := make([]byte, 0, (index+1)*(common.AddressLength+8)+1)
+	for i := index; i >= 0; i-- {
+		pc := step.pc
+		if i != index {
+			pc = p.frames[i].step.pc
+		}
+		key = append(key, p.frames[i].address[:]...)
+		key = appendUint64(key, pc)
+	}
+	key = append(key, byte(step.op))
+
+	sample, ok := p.samples[string(key)]
+	if !ok {
+		sample = &profileSample{stack: make([]profileLocation, 0, index+1), op: step.op}
+		for i := index; i >= 0; i-- {
+			pc := step.pc
+			if i != index {
+				pc = p.frames[i].step.pc
+			}
+			sample.stack = append(sample.stack, profileLocation{p.frames[i].address, pc})
+		}
+		p.samples[string(key)] = sample
+	}
+	sample.count++
+	sample.gas += int64(gas)
+	sample.time += int64(self)
+}
+
+// WriteProfile writes the collected samples as a gzip-compressed pprof protobuf.
+// Every sample contains the execution count, gas and wall time of an opcode, its
+// stack lists the executing contract addresses with the program counter as line
+// number.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.encodeProfile()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Protobuf field numbers of the pprof profile format.
+const (
+	pprofSampleType        = 1
+	pprofSample            = 2
+	pprofLocation          = 4
+	pprofFunction          = 5
+	pprofStringTable       = 6
+	pprofTimeNanos         = 9
+	pprofDurationNanos     = 10
+	pprofDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID      = 1
+	locationAddress = 3
+	locationLine    = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID       = 1
+	functionName     = 2
+	functionFilename = 4
+)
+
+// encodeProfile serializes the collected samples in the pprof protobuf format.
+func (p *Profiler) encodeProfile() []byte {
+	var (
+		out   protoBuffer
+		index = map[string]int64{"": 0}
+		table = []string{""}
+
+		functions = make(map[string]uint64)
+		locations = make(map[profileLocation]uint64)
+		opcodes   = make(map[OpCode]uint64)
+		nextLoc   = uint64(1)
+	)
+	str := func(s string) int64 {
+		if id, ok := index[s]; ok {
+			return id
+		}
+		index[s] = int64(len(table))
+		table = append(table, s)
+		return index[s]
+	}
+	function := func(name string) uint64 {
+		if id, ok := functions[name]; ok {
+			return id
+		}
+		id := uint64(len(functions) + 1)
+		functions[name] = id
+
+		var msg protoBuffer
+		msg.uint64(functionID, id)
+		msg.int64(functionName, str(name))
+		msg.int64(functionFilename, str(name))
+		out.message(pprofFunction, &msg)
+		return id
+	}
+	location := func(address uint64, fn uint64, line int64) uint64 {
+		id := nextLoc
+		nextLoc++
+
+		var lineMsg, msg protoBuffer
+		lineMsg.uint64(lineFunctionID, fn)
+		lineMsg.int64(lineLine, line)
+		msg.uint64(locationID, id)
+		msg.uint64(locationAddress, address)
+		msg.message(locationLine, &lineMsg)
+		out.message(pprofLocation, &msg)
+		return id
+	}
+	for _, typ := range [][2]string{{"samples", "count"}, {"gas", "gas"}, {"time", "nanoseconds"}} {
+		var msg protoBuffer
+		msg.int64(valueTypeType, str(typ[0]))
+		msg.int64(valueTypeUnit, str(typ[1]))
+		out.message(pprofSampleType, &msg)
+	}
+	// Emit the samples in a deterministic order.
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		sample := p.samples[key]
+		ids := make([]uint64, 0, len(sample.stack)+1)
+
+		id, ok := opcodes[sample.op]
+		if !ok {
+			id = location(0, function(sample.op.String()), 0)
+			opcodes[sample.op] = id
+		}
+		ids = append(ids, id)
+		for _, loc := range sample.stack {
+			id, ok := locations[loc]
+			if !ok {
+				id = location(loc.pc, function(loc.address.Hex()), int64(loc.pc))
+				locations[loc] = id
+			}
+			ids = append(ids, id)
+		}
+		var msg protoBuffer
+		msg.packedUint64(sampleLocationID, ids)
+		msg.packedInt64(sampleValue, []int64{sample.count, sample.gas, sample.time})
+		out.message(pprofSample, &msg)
+	}
+	out.int64(pprofTimeNanos, p.start.UnixNano())
+	out.int64(pprofDurationNanos, int64(time.Since(p.start)))
+
+	out.int64(pprofDefaultSampleType, str("gas"))
+
+	for _, s := range table {
+		out.string(pprofStringTable, s)
+	}
+	return out
+}
+
+// protoBuffer is a minimal protobuf encoder.
+type protoBuffer []byte
+
+func (b *protoBuffer) key(field int, wireType int) {
+	*b = appendVarint(*b, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.key(field, 0)
+	*b = appendVarint(*b, v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.key(field, 2)
+	*b = appendVarint(*b, uint64(len(s)))
+	*b = append(*b, s...)
+}
+
+func (b *protoBuffer) message(field int, msg *protoBuffer) {
+	b.key(field, 2)
+	*b = appendVarint(*b, uint64(len(*msg)))
+	*b = append(*b, *msg...)
+}
+
+func (b *protoBuffer) packedUint64(field int, vs []uint64) {
+	var packed []byte
+	for _, v := range vs {
+		packed = appendVarint(packed, v)
+	}
+	b.key(field, 2)
+	*b = appendVarint(*b, uint64(len(packed)))
+	*b = append(*b, packed...)
+}
+
+func (b *protoBuffer) packedInt64(field int, vs []int64) {
+	us := make([]uint64, len(vs))
+	for i, v := range vs {
+		us[i] = uint64(v)
+	}
+	b.packedUint64(field, us)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestProfiler(t *testing.T) {
+	var (
+		caller = common.BytesToAddress([]byte("caller"))
+		callee = common.BytesToAddress([]byte("callee"))
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(caller, []byte{
+		byte(PUSH1), 0, byte(DUP1), byte(DUP1), byte(DUP1), byte(DUP1), // out size, out offset, in size, in offset, value
+		byte(PUSH20),
+	})
+	statedb.SetCode(caller, append(append(statedb.GetCode(caller), callee.Bytes()...),
+		byte(GAS), byte(CALL), byte(POP), byte(STOP),
+	))
+	statedb.SetCode(callee, []byte{
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), byte(STOP),
+	})
+	profiler := NewProfiler()
+	vmctx := BlockContext{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: new(big.Int),
+	}
+	vmenv := NewEVM(vmctx, TxContext{}, statedb, params.TestChainConfig, Config{Debug: true, Tracer: profiler})
+
+	gas := uint64(1000000)
+	_, left, err := vmenv.Call(AccountRef(common.Address{}), caller, nil, gas, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, sample := range profiler.samples {
+		total += sample.gas
+		if sample.count != 1 {
+			t.Errorf("%v executed %d times, want 1", sample.op, sample.count)
+		}
+		switch sample.op {
+		case SSTORE:
+			want := []profileLocation{{callee, 4}, {caller, 28}}
+			if len(sample.stack) != len(want) || sample.stack[0] != want[0] || sample.stack[1] != want[1] {
+				t.Errorf("wrong SSTORE stack: %v", sample.stack)
+			}
+			if want := params.SstoreSetGasEIP2200 + ColdSloadCostEIP2929; sample.gas != int64(want) {
+				t.Errorf("wrong SSTORE gas: have %d, want %d", sample.gas, want)
+			}
+		case CALL:
+			if sample.gas != int64(ColdAccountAccessCostEIP2929) {
+				t.Errorf("wrong exclusive CALL gas: have %d, want %d", sample.gas, ColdAccountAccessCostEIP2929)
+			}
+		}
+	}
+	if len(profiler.samples) != 14 {
+		t.Errorf("wrong number of samples: have %d, want 14", len(profiler.samples))
+	}
+	if total != int64(gas-left) {
+		t.Errorf("profiled gas mismatch: have %d, want %d", total, gas-left)
+	}
+	// Check that the profile is written as valid gzip data.
+	var buf bytes.Buffer
+	if err := profiler.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) == 0 || raw[0] != pprofSampleType<<3|2 {
+		t.Fatalf("invalid profile encoding: %x", raw)
+	}
+}
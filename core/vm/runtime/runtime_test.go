@@ -502,6 +502,63 @@ func BenchmarkSimpleLoop(b *testing.B) {
 	//benchmarkNonModifyingCode(10000000, loopingCode, "loop-10M", b)
 }
 
+// BenchmarkCodeAnalysis compares the interpreter with and without superinstructions
+// and block-wise static gas charging.
+func BenchmarkCodeAnalysis(b *testing.B) {
+	// Counts down from 100000, exercising PUSH+JUMPI, DUP+SWAP and SWAP+POP.
+	countdown := []byte{
+		byte(vm.PUSH3), 0x01, 0x86, 0xa0,
+		byte(vm.JUMPDEST), // [ count ]
+		byte(vm.PUSH1), 1,
+		byte(vm.SWAP1),
+		byte(vm.SUB),
+		byte(vm.DUP1), byte(vm.SWAP1), // no-op pair
+		byte(vm.SWAP1), byte(vm.POP),
+		byte(vm.DUP1),
+		byte(vm.PUSH1), 4,
+		byte(vm.JUMPI),
+	}
+	// Jumps back and forth between two labels until out of gas.
+	pingpong := []byte{
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 5,
+		byte(vm.JUMP),
+		0xfe, // INVALID
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0,
+		byte(vm.JUMP),
+	}
+	for _, disable := range []bool{false, true} {
+		name := "analysis"
+		if disable {
+			name = "no-analysis"
+		}
+		b.Run("countdown/"+name, func(b *testing.B) {
+			benchmarkCode(b, countdown, 100000000, vm.Config{DisableCodeAnalysis: disable})
+		})
+		b.Run("pingpong-10M/"+name, func(b *testing.B) {
+			benchmarkCode(b, pingpong, 10000000, vm.Config{DisableCodeAnalysis: disable})
+		})
+	}
+}
+
+func benchmarkCode(b *testing.B, code []byte, gas uint64, config vm.Config) {
+	cfg := &Config{GasLimit: gas, EVMConfig: config}
+	setDefaults(cfg)
+	cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	destination := common.BytesToAddress([]byte("contract"))
+	cfg.State.SetCode(destination, code)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := Call(destination, nil, cfg); err != nil && err != vm.ErrOutOfGas {
+			b.Fatal(err)
+		}
+	}
+}
+
 // TestEip2929Cases contains various testcases that are used for
 // EIP-2929 about gas repricings
 func TestEip2929Cases(t *testing.T) {
@@ -613,3 +670,205 @@ func TestEip2929Cases(t *testing.T) {
 			"account (cheap)", code)
 	}
 }
+
+// testCounterPrecompile is a stateful precompile counting its invocations in its
+// own storage and returning the caller address.
+type testCounterPrecompile struct{}
+
+func (testCounterPrecompile) RequiredGas(input []byte) uint64 { return 100 }
+
+func (testCounterPrecompile) Run(input []byte) ([]byte, error) {
+	panic("stateful precompile run without context")
+}
+
+func (testCounterPrecompile) RunWithContext(ctx *vm.PrecompileContext, input []byte) ([]byte, error) {
+	if ctx.ReadOnly {
+		return nil, vm.ErrWriteProtection
+	}
+	count := ctx.EVM.StateDB.GetState(ctx.Address, common.Hash{}).Big()
+	ctx.EVM.StateDB.SetState(ctx.Address, common.Hash{}, common.BigToHash(count.Add(count, common.Big1)))
+	return common.LeftPadBytes(ctx.Caller.Bytes(), 32), nil
+}
+
+func init() {
+	vm.RegisterPrecompiledContract("test-counter", testCounterPrecompile{})
+}
+
+func TestCustomPrecompile(t *testing.T) {
+	var (
+		precompile = common.HexToAddress("0x0100")
+		caller     = common.HexToAddress("0x0a")
+		static     = common.HexToAddress("0x0b")
+	)
+	config := *params.AllEthashProtocolChanges
+	config.Precompiles = map[common.Address]*params.PrecompileConfig{
+		precompile: {Name: "test-counter", Block: big.NewInt(5)},
+	}
+	if err := vm.ValidatePrecompiles(&config); err != nil {
+		t.Fatal(err)
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	// Forwards the call to the precompile and returns its output.
+	statedb.SetCode(caller, []byte{
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH2), 0x01, 0x00, byte(vm.GAS), byte(vm.CALL),
+		byte(vm.POP), byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	})
+	// Invokes the precompile via STATICCALL and returns the success flag.
+	statedb.SetCode(static, []byte{
+		byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH2), 0x01, 0x00, byte(vm.GAS), byte(vm.STATICCALL),
+		byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	})
+	counter := func() uint64 {
+		return statedb.GetState(precompile, common.Hash{}).Big().Uint64()
+	}
+	cfg := func(number int64) *Config {
+		return &Config{ChainConfig: &config, State: statedb, BlockNumber: big.NewInt(number), Origin: common.HexToAddress("0x1337")}
+	}
+
+	// Before activation the address is an ordinary empty account.
+	ret, _, err := Call(precompile, nil, cfg(4))
+	if err != nil || len(ret) != 0 || counter() != 0 {
+		t.Fatalf("inactive precompile: ret %x, err %v, counter %d", ret, err, counter())
+	}
+	// After activation, both direct and nested calls reach the precompile.
+	ret, _, err = Call(precompile, nil, cfg(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common.BytesToAddress(ret) != common.HexToAddress("0x1337") || counter() != 1 {
+		t.Fatalf("direct call: ret %x, counter %d", ret, counter())
+	}
+	ret, _, err = Call(caller, nil, cfg(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common.BytesToAddress(ret) != caller || counter() != 2 {
+		t.Fatalf("nested call: ret %x, counter %d", ret, counter())
+	}
+	// Static calls are read-only, the precompile refuses to run.
+	ret, _, err = Call(static, nil, cfg(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).SetBytes(ret).Sign() != 0 || counter() != 2 {
+		t.Fatalf("static call: ret %x, counter %d", ret, counter())
+	}
+	// The precompile is warm in the access list.
+	env := NewEnv(cfg(5))
+	found := false
+	for _, addr := range env.ActivePrecompiles() {
+		found = found || addr == precompile
+	}
+	if !found {
+		t.Fatal("custom precompile missing from active precompiles")
+	}
+}
+
+// testContextPrecompile is a stateful precompile counting its invocations in the
+// storage of the account it executes in and returning its call context.
+type testContextPrecompile struct{}
+
+func (testContextPrecompile) RequiredGas(input []byte) uint64 { return 100 }
+
+func (testContextPrecompile) Run(input []byte) ([]byte, error) {
+	panic("stateful precompile run without context")
+}
+
+func (testContextPrecompile) RunWithContext(ctx *vm.PrecompileContext, input []byte) ([]byte, error) {
+	if ctx.ReadOnly {
+		return nil, vm.ErrWriteProtection
+	}
+	count := ctx.EVM.StateDB.GetState(ctx.Address, common.Hash{}).Big()
+	ctx.EVM.StateDB.SetState(ctx.Address, common.Hash{}, common.BigToHash(count.Add(count, common.Big1)))
+
+	ret := common.LeftPadBytes(ctx.Address.Bytes(), 32)
+	ret = append(ret, common.LeftPadBytes(ctx.Caller.Bytes(), 32)...)
+	return append(ret, common.LeftPadBytes(ctx.Value.Bytes(), 32)...), nil
+}
+
+func init() {
+	vm.RegisterPrecompiledContract("test-context", testContextPrecompile{})
+}
+
+// Tests that stateful precompiles invoked via CALLCODE and DELEGATECALL execute in
+// the context of the calling contract.
+func TestCustomPrecompileCallContext(t *testing.T) {
+	var (
+		precompile = common.HexToAddress("0x0101")
+		origin     = common.HexToAddress("0x1337")
+		callcode   = common.HexToAddress("0x0a")
+		delegate   = common.HexToAddress("0x0b")
+	)
+	config := *params.AllEthashProtocolChanges
+	config.Precompiles = map[common.Address]*params.PrecompileConfig{
+		precompile: {Name: "test-context", Block: big.NewInt(0)},
+	}
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(origin, big.NewInt(1000))
+	statedb.AddBalance(callcode, big.NewInt(1000))
+
+	// Invokes the precompile via CALLCODE with a value of 7 and returns its output.
+	statedb.SetCode(callcode, []byte{
+		byte(vm.PUSH1), 96, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 7,
+		byte(vm.PUSH2), 0x01, 0x01, byte(vm.GAS), byte(vm.CALLCODE),
+		byte(vm.POP), byte(vm.PUSH1), 96, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	})
+	// Invokes the precompile via DELEGATECALL and returns its output.
+	statedb.SetCode(delegate, []byte{
+		byte(vm.PUSH1), 96, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH2), 0x01, 0x01, byte(vm.GAS), byte(vm.DELEGATECALL),
+		byte(vm.POP), byte(vm.PUSH1), 96, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	})
+	tests := []struct {
+		contract common.Address
+		value    int64
+		caller   common.Address
+		want     int64
+	}{
+		{contract: callcode, value: 3, caller: callcode, want: 7},
+		{contract: delegate, value: 5, caller: origin, want: 5},
+	}
+	for i, tt := range tests {
+		cfg := &Config{ChainConfig: &config, State: statedb, Origin: origin, Value: big.NewInt(tt.value)}
+		ret, _, err := Call(tt.contract, nil, cfg)
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v", i, err)
+		}
+		if len(ret) != 96 {
+			t.Fatalf("test %d: invalid output %x", i, ret)
+		}
+		if addr := common.BytesToAddress(ret[:32]); addr != tt.contract {
+			t.Errorf("test %d: address mismatch: have %x, want %x", i, addr, tt.contract)
+		}
+		if caller := common.BytesToAddress(ret[32:64]); caller != tt.caller {
+			t.Errorf("test %d: caller mismatch: have %x, want %x", i, caller, tt.caller)
+		}
+		if value := new(big.Int).SetBytes(ret[64:]); value.Int64() != tt.want {
+			t.Errorf("test %d: value mismatch: have %v, want %d", i, value, tt.want)
+		}
+		if count := statedb.GetState(tt.contract, common.Hash{}).Big(); count.Uint64() != 1 {
+			t.Errorf("test %d: calling contract storage not updated: %v", i, count)
+		}
+	}
+	if count := statedb.GetState(precompile, common.Hash{}).Big(); count.Sign() != 0 {
+		t.Errorf("precompile storage modified: %v", count)
+	}
+}
+
+func TestValidatePrecompiles(t *testing.T) {
+	config := *params.AllEthashProtocolChanges
+	config.Precompiles = map[common.Address]*params.PrecompileConfig{
+		common.HexToAddress("0x0100"): {Name: "unknown", Block: big.NewInt(0)},
+	}
+	if err := vm.ValidatePrecompiles(&config); err == nil {
+		t.Fatal("expected error for unregistered precompile")
+	}
+	config.Precompiles = map[common.Address]*params.PrecompileConfig{
+		common.HexToAddress("0x01"): {Name: "test-counter", Block: big.NewInt(0)},
+	}
+	if err := vm.ValidatePrecompiles(&config); err == nil {
+		t.Fatal("expected error for precompile shadowing ecrecover")
+	}
+}
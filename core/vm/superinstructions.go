@@ -0,0 +1,280 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// analysisCacheSize is the approximate amount of memory in bytes the shared code
+// analysis cache may use.
+const analysisCacheSize = 64 * 1024 * 1024
+
+// Superinstructions, i.e. common instruction pairs executed in a single step.
+const (
+	fusedNone      byte = iota
+	fusedPushJump       // PUSHn JUMP
+	fusedPushJumpi      // PUSHn JUMPI
+	fusedDupSwap        // DUPn SWAPm
+	fusedSwapPop        // SWAPn POP
+)
+
+// codeAnalysis is the result of analysing a piece of code for a given jump table.
+//
+// The instructions of the code are split into blocks of consecutive instructions
+// with constant gas cost only, ending at jumps, halts and instructions with a
+// dynamic cost. The interpreter charges the static gas of the remaining block in
+// one go when entering it, as long as enough gas is available; otherwise every
+// instruction is charged individually, so running out of gas mid-block behaves
+// exactly as without the analysis.
+type codeAnalysis struct {
+	bitmap bitvec   // JUMPDEST analysis
+	gas    []uint32 // Static gas of the block from each instruction to its end
+	ends   []uint32 // End of the block of each instruction, zero outside of blocks
+	fused  []byte   // Superinstruction starting at each instruction
+}
+
+// size returns the approximate memory used by the analysis.
+func (a *codeAnalysis) size() int {
+	return len(a.bitmap) + 4*len(a.gas) + 4*len(a.ends) + len(a.fused)
+}
+
+// analyseCode computes the analysis of the given code for the given jump table.
+func analyseCode(code []byte, jt *JumpTable) *codeAnalysis {
+	a := &codeAnalysis{
+		bitmap: codeBitmap(code),
+		gas:    make([]uint32, len(code)),
+		ends:   make([]uint32, len(code)),
+		fused:  make([]byte, len(code)),
+	}
+	// Collect the instruction offsets, skipping push data.
+	pcs := make([]uint32, 0, len(code))
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		pcs = append(pcs, uint32(pc))
+		if op := OpCode(code[pc]); op >= PUSH1 && op <= PUSH32 {
+			pc += uint64(op - PUSH1 + 1)
+		}
+	}
+	// Accumulate the static gas of the blocks backwards.
+	var blockGas, blockEnd uint64
+	for i := len(pcs) - 1; i >= 0; i-- {
+		var (
+			pc   = pcs[i]
+			op   = OpCode(code[pc])
+			next = uint64(len(code))
+		)
+		if i+1 < len(pcs) {
+			next = uint64(pcs[i+1])
+		}
+		if op >= PUSH1 && op <= PUSH32 {
+			// A truncated push at the end of the code runs past it.
+			next = uint64(pc) + uint64(op-PUSH1) + 2
+		}
+		operation := jt[op]
+		if !staticOperation(op, operation) {
+			blockGas, blockEnd = 0, 0
+			continue
+		}
+		if operation.jumps || operation.halts || blockEnd == 0 || blockGas+operation.constantGas > math.MaxUint32 {
+			blockGas, blockEnd = 0, next
+		}
+		blockGas += operation.constantGas
+		a.gas[pc], a.ends[pc] = uint32(blockGas), uint32(blockEnd)
+
+		// Fuse the instruction with its successor if both are in the same block.
+		if operation.jumps || operation.halts || next >= uint64(len(code)) || a.ends[next] != uint32(blockEnd) {
+			continue
+		}
+		switch nextOp := OpCode(code[next]); {
+		case op >= PUSH1 && op <= PUSH32 && nextOp == JUMP:
+			a.fused[pc] = fusedPushJump
+		case op >= PUSH1 && op <= PUSH32 && nextOp == JUMPI:
+			a.fused[pc] = fusedPushJumpi
+		case op >= DUP1 && op <= DUP16 && nextOp >= SWAP1 && nextOp <= SWAP16:
+			a.fused[pc] = fusedDupSwap
+		case op >= SWAP1 && op <= SWAP16 && nextOp == POP:
+			a.fused[pc] = fusedSwapPop
+		}
+	}
+	return a
+}
+
+// staticOperation reports whether an instruction has a constant gas cost and
+// doesn't depend on the gas left, so it can be part of a block.
+func staticOperation(op OpCode, operation *operation) bool {
+	return operation != nil && operation.dynamicGas == nil && operation.memorySize == nil &&
+		!operation.writes && !operation.reverts && op != GAS
+}
+
+// runFused executes the superinstruction at pc, reporting whether it was executed
+// and whether it jumped. If the stack doesn't allow executing both instructions
+// or a jump destination is invalid, nothing is done and the instructions have to
+// be executed individually, which produces the appropriate error.
+func runFused(kind byte, pc *uint64, callContext *callCtx) (ok bool, jumped bool) {
+	var (
+		stack = callContext.stack
+		code  = callContext.contract.Code
+		op    = OpCode(code[*pc])
+	)
+	switch kind {
+	case fusedPushJump:
+		if stack.len() >= int(params.StackLimit) {
+			return false, false
+		}
+		size := uint64(op - PUSH1 + 1)
+		var dest uint256.Int
+		dest.SetBytes(code[*pc+1 : *pc+1+size])
+		if !callContext.contract.validJumpdest(&dest) {
+			return false, false
+		}
+		*pc = dest.Uint64()
+		return true, true
+
+	case fusedPushJumpi:
+		if stack.len() < 1 || stack.len() >= int(params.StackLimit) {
+			return false, false
+		}
+		size := uint64(op - PUSH1 + 1)
+		if stack.peek().IsZero() {
+			stack.pop()
+			*pc += size + 2
+			return true, false
+		}
+		var dest uint256.Int
+		dest.SetBytes(code[*pc+1 : *pc+1+size])
+		if !callContext.contract.validJumpdest(&dest) {
+			return false, false
+		}
+		stack.pop()
+		*pc = dest.Uint64()
+		return true, true
+
+	case fusedDupSwap:
+		dup, swap := int(op-DUP1+1), int(OpCode(code[*pc+1])-SWAP1+1)
+		if stack.len() < dup || stack.len() < swap || stack.len() >= int(params.StackLimit) {
+			return false, false
+		}
+		stack.dup(dup)
+		stack.swap(swap + 1)
+		*pc += 2
+		return true, false
+
+	case fusedSwapPop:
+		swap := int(op - SWAP1 + 1)
+		if stack.len() < swap+1 {
+			return false, false
+		}
+		stack.swap(swap + 1)
+		stack.pop()
+		*pc += 2
+		return true, false
+	}
+	return false, false
+}
+
+// jumpTableID returns an identifier of the properties of a jump table relevant to
+// code analysis.
+func jumpTableID(jt *JumpTable) uint64 {
+	var (
+		hasher = fnv.New64a()
+		buf    [9]byte
+	)
+	for op, operation := range jt {
+		if !staticOperation(OpCode(op), operation) {
+			continue
+		}
+		buf[0] = byte(op)
+		if operation.jumps || operation.halts {
+			buf[0] |= 0x80
+		}
+		binary.BigEndian.PutUint64(buf[1:], operation.constantGas)
+		hasher.Write(buf[:])
+	}
+	return hasher.Sum64()
+}
+
+// analysisKey identifies a cached code analysis.
+type analysisKey struct {
+	codeHash common.Hash
+	table    uint64
+}
+
+// analysisCache is a size-bounded LRU cache of code analyses shared by all EVM
+// instances.
+type analysisCache struct {
+	lock    sync.Mutex
+	entries map[analysisKey]*list.Element
+	order   *list.List // Most recently used first
+	size    int
+	limit   int
+}
+
+type analysisEntry struct {
+	key      analysisKey
+	analysis *codeAnalysis
+}
+
+var sharedAnalysisCache = newAnalysisCache(analysisCacheSize)
+
+func newAnalysisCache(limit int) *analysisCache {
+	return &analysisCache{
+		entries: make(map[analysisKey]*list.Element),
+		order:   list.New(),
+		limit:   limit,
+	}
+}
+
+// analysis returns the analysis of the contract's code, computing it if it is not
+// cached yet. Code without a hash (i.e. initcode) is analysed without caching.
+func (c *analysisCache) analysis(contract *Contract, jt *JumpTable, table uint64) *codeAnalysis {
+	if contract.CodeHash == (common.Hash{}) {
+		return analyseCode(contract.Code, jt)
+	}
+	key := analysisKey{contract.CodeHash, table}
+
+	c.lock.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.lock.Unlock()
+		return elem.Value.(*analysisEntry).analysis
+	}
+	c.lock.Unlock()
+
+	a := analyseCode(contract.Code, jt)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.entries[key]; !ok && a.size() <= c.limit {
+		c.entries[key] = c.order.PushFront(&analysisEntry{key, a})
+		c.size += a.size()
+		for c.size > c.limit {
+			oldest := c.order.Back()
+			entry := c.order.Remove(oldest).(*analysisEntry)
+			delete(c.entries, entry.key)
+			c.size -= entry.analysis.size()
+		}
+	}
+	return a
+}
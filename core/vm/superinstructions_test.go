@@ -0,0 +1,113 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestAnalyseCode(t *testing.T) {
+	code := []byte{
+		byte(PUSH1), 0x01, byte(DUP1), byte(SWAP1), // 3 + 3 + 3
+		byte(SLOAD),            // dynamic gas in berlin, ends the block
+		byte(SWAP1), byte(POP), // 3 + 2
+		byte(PUSH1), 0x0d, byte(JUMPI), // 3 + 10, ends the block
+		byte(JUMPDEST), byte(STOP), // 1 + 0
+		byte(JUMPDEST), byte(PUSH2), 0x00, // truncated push
+	}
+	a := analyseCode(code, &berlinInstructionSet)
+
+	wantGas := map[int]uint32{0: 9, 2: 6, 3: 3, 4: 0, 5: 18, 6: 15, 7: 13, 9: 10, 10: 1, 11: 0, 12: 4, 13: 3}
+	wantEnds := map[int]uint32{0: 4, 2: 4, 3: 4, 4: 0, 5: 10, 6: 10, 7: 10, 9: 10, 10: 12, 11: 12, 12: 16, 13: 16}
+	for pc := range wantGas {
+		if a.gas[pc] != wantGas[pc] || a.ends[pc] != wantEnds[pc] {
+			t.Errorf("pc %d: block gas %d end %d, want gas %d end %d", pc, a.gas[pc], a.ends[pc], wantGas[pc], wantEnds[pc])
+		}
+	}
+	wantFused := map[int]byte{2: fusedDupSwap, 5: fusedSwapPop, 7: fusedPushJumpi}
+	for pc, kind := range a.fused {
+		if kind != wantFused[pc] {
+			t.Errorf("pc %d: superinstruction %d, want %d", pc, kind, wantFused[pc])
+		}
+	}
+}
+
+// randomProgram generates code made of instructions covered by the code analysis,
+// mixed with a few instructions with dynamic gas cost.
+func randomProgram(rng *rand.Rand, size int) []byte {
+	var code []byte
+	for len(code) < size {
+		switch n := rng.Intn(20); {
+		case n < 4:
+			code = append(code, byte(PUSH1), byte(rng.Intn(size)))
+		case n < 5:
+			code = append(code, byte(PUSH2), 0, byte(rng.Intn(size)))
+		case n < 8:
+			code = append(code, byte(DUP1)+byte(rng.Intn(4)))
+		case n < 11:
+			code = append(code, byte(SWAP1)+byte(rng.Intn(4)))
+		case n < 12:
+			code = append(code, byte(POP))
+		case n < 13:
+			code = append(code, byte(JUMPDEST))
+		case n < 14:
+			code = append(code, byte(JUMP))
+		case n < 15:
+			code = append(code, byte(JUMPI))
+		default:
+			ops := []OpCode{ADD, SUB, ISZERO, GAS, PC, SSTORE, MSTORE, SLOAD, STOP, 0xfe}
+			code = append(code, byte(ops[rng.Intn(len(ops))]))
+		}
+	}
+	return code
+}
+
+func TestCodeAnalysisEquivalence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	address := common.BytesToAddress([]byte("contract"))
+
+	run := func(code []byte, gas uint64, disable bool) string {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.SetCode(address, code)
+		statedb.AddAddressToAccessList(address)
+		vmctx := BlockContext{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: new(big.Int),
+		}
+		vmenv := NewEVM(vmctx, TxContext{}, statedb, params.TestChainConfig, Config{DisableCodeAnalysis: disable})
+		ret, left, err := vmenv.Call(AccountRef(common.Address{}), address, nil, gas, new(big.Int))
+		return fmt.Sprintf("ret %x left %d err %v root %x", ret, left, err, statedb.IntermediateRoot(true))
+	}
+	for i := 0; i < 2000; i++ {
+		code := randomProgram(rng, 8+rng.Intn(64))
+		for _, gas := range []uint64{10, 50, 200, 5000, 100000} {
+			want, have := run(code, gas, true), run(code, gas, false)
+			if have != want {
+				t.Fatalf("program %x with %d gas:\nhave %s\nwant %s", code, gas, have, want)
+			}
+		}
+	}
+}
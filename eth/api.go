@@ -26,6 +26,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -33,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/state/pruner"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -205,6 +207,31 @@ func (api *PrivateAdminAPI) ExportChain(file string, first *uint64, last *uint64
 	return true, nil
 }
 
+// PruneState starts deleting the stale state from the database in the background,
+// retaining the state of the current head block and the ones imported afterwards.
+func (api *PrivateAdminAPI) PruneState() (bool, error) {
+	if api.eth.pruner == nil {
+		return false, errors.New("online state pruning is not supported in archive mode or with the path-based state scheme")
+	}
+	if atomic.LoadUint32(&api.eth.handler.fastSync) == 1 {
+		return false, errors.New("state pruning is not allowed during fast or snap sync")
+	}
+	if err := api.eth.pruner.Start(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneStateProgress returns the progress of the running or the last online state
+// pruning round.
+func (api *PrivateAdminAPI) PruneStateProgress() (*pruner.OnlineProgress, error) {
+	if api.eth.pruner == nil {
+		return nil, errors.New("online state pruning is not supported in archive mode or with the path-based state scheme")
+	}
+	progress := api.eth.pruner.Progress()
+	return &progress, nil
+}
+
 func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	for _, b := range bs {
 		if !chain.HasBlock(b.Hash(), b.NumberU64()) {
@@ -543,3 +570,40 @@ func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Bloc
 	}
 	return dirty, nil
 }
+
+// StateDiff returns the changes the given block made to the state: the previous
+// and new values of every modified account along with its changed storage slots.
+// The block is re-executed on top of its parent state, which must be available,
+// to resolve the addresses and slots of the changes.
+func (api *PrivateDebugAPI) StateDiff(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDiff, error) {
+	block, err := api.eth.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	return api.eth.blockchain.StateDiff(block)
+}
+
+// ExecutionWitness returns the RLP encoded witness needed to execute the given
+// block statelessly: the headers, trie nodes and contract codes it accesses. The
+// state of the parent block must be available and stored with the hash-based
+// scheme.
+func (api *PrivateDebugAPI) ExecutionWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	block, err := api.eth.APIBackend.BlockByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("block not found")
+	}
+	if block.NumberU64() == 0 {
+		return nil, errors.New("genesis is not executable")
+	}
+	witness, err := api.eth.blockchain.ExecutionWitness(block)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(witness)
+}
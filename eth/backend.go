@@ -68,6 +68,7 @@ type Ethereum struct {
 	txPool             *core.TxPool
 	blockchain         *core.BlockChain
 	handler            *handler
+	pruner             *pruner.OnlinePruner // Background state pruner, nil if unsupported
 	ethDialCandidates  enode.Iterator
 	snapDialCandidates enode.Iterator
 
@@ -126,6 +127,15 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.StateScheme == rawdb.PathScheme {
+		if err := core.EnablePathScheme(chainDb); err != nil {
+			return nil, err
+		}
+	}
+	if rawdb.ReadStateScheme(chainDb) == rawdb.PathScheme && config.SyncMode != downloader.FullSync {
+		log.Warn("Path-based state scheme only supports full sync", "provided", config.SyncMode, "updated", downloader.FullSync)
+		config.SyncMode = downloader.FullSync
+	}
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlockWithOverride(chainDb, config.Genesis, config.OverrideBerlin)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
@@ -181,6 +191,8 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			TrieTimeLimit:       config.TrieTimeout,
 			SnapshotLimit:       config.SnapshotCache,
 			Preimages:           config.Preimages,
+			StateHistory:        config.StateHistory,
+			ParallelExecution:   config.ParallelExecution,
 		}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve, &config.TxLookupLimit)
@@ -195,6 +207,19 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	}
 	eth.bloomIndexer.Start(eth.blockchain)
 
+	if !config.NoPruning && rawdb.ReadStateScheme(chainDb) == rawdb.HashScheme {
+		eth.pruner, err = pruner.NewOnlinePruner(chainDb, eth.blockchain, pruner.OnlineConfig{
+			BloomSize: config.PruneBloomSize,
+			HoldSize:  512,
+			Depth:     core.TriesInMemory,
+			BatchSize: 10000,
+			Throttle:  100 * time.Millisecond,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = stack.ResolvePath(config.TxPool.Journal)
 	}
@@ -540,6 +565,9 @@ func (s *Ethereum) Stop() error {
 	s.handler.Stop()
 
 	// Then stop everything else.
+	if s.pruner != nil {
+		s.pruner.Stop()
+	}
 	s.bloomIndexer.Close()
 	close(s.closeBloomHandler)
 	s.txPool.Stop()
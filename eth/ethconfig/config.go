@@ -30,6 +30,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/eth/downloader"
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -76,6 +77,9 @@ var Defaults = Config{
 	TrieDirtyCache:          256,
 	TrieTimeout:             60 * time.Minute,
 	SnapshotCache:           102,
+	StateScheme:             rawdb.HashScheme,
+	StateHistory:            90000,
+	PruneBloomSize:          2048,
 	Miner: miner.Config{
 		GasFloor: 8000000,
 		GasCeil:  8000000,
@@ -162,6 +166,12 @@ type Config struct {
 	SnapshotCache           int
 	Preimages               bool
 
+	// State storage options
+	StateScheme  string `toml:",omitempty"` // Scheme used to store trie nodes on fresh databases ("hash" or "path")
+	StateHistory uint64 `toml:",omitempty"` // Number of recent blocks to retain state histories for with the path-based scheme (0 = all)
+
+	PruneBloomSize uint64 `toml:",omitempty"` // Megabytes of memory allocated to the bloom filter of online state pruning
+
 	// Mining options
 	Miner miner.Config
 
@@ -177,6 +187,9 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Enables speculative parallel execution of block transactions
+	ParallelExecution bool
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 
@@ -193,6 +206,10 @@ type Config struct {
 	// send-transction variants. The unit is ether.
 	RPCTxFeeCap float64 `toml:",omitempty"`
 
+	// RPCProofReexec is the number of blocks re-executed at most to regenerate
+	// pruned historical state for proofs, capped at 128 (0 = disabled).
+	RPCProofReexec uint64 `toml:",omitempty"`
+
 	// Checkpoint is a hardcoded checkpoint which can be nil.
 	Checkpoint *params.TrustedCheckpoint `toml:",omitempty"`
 
@@ -41,7 +41,7 @@ func (eth *Ethereum) stateAtBlock(block *types.Block, reexec uint64) (statedb *s
 	}
 	// Otherwise try to reexec blocks until we find a state or reach our limit
 	origin := block.NumberU64()
-	database := state.NewDatabaseWithConfig(eth.chainDb, &trie.Config{Cache: 16, Preimages: true})
+	database := state.NewDatabaseForChain(eth.chainDb, eth.blockchain.Config(), &trie.Config{Cache: 16, Preimages: true})
 
 	for i := uint64(0); i < reexec; i++ {
 		if block.NumberU64() == 0 {
@@ -129,7 +129,7 @@ func (eth *Ethereum) statesInRange(fromBlock, toBlock *types.Block, reexec uint6
 		parent   common.Hash
 		start    = time.Now()
 		refs     = []common.Hash{fromBlock.Root()}
-		database = state.NewDatabaseWithConfig(eth.chainDb, &trie.Config{Cache: 16, Preimages: true})
+		database = state.NewDatabaseForChain(eth.chainDb, eth.blockchain.Config(), &trie.Config{Cache: 16, Preimages: true})
 	)
 	// Release all resources(including the states referenced by `stateAtBlock`)
 	// if error is returned.
@@ -53,6 +53,10 @@ const (
 	// and reexecute to produce missing historical state necessary to run a specific
 	// trace.
 	defaultTraceReexec = uint64(128)
+
+	// profilerTracer is the tracer name selecting the native opcode profiler, which
+	// returns a gzip-compressed pprof profile instead of a JSON trace.
+	profilerTracer = "profiler"
 )
 
 // Backend interface provides the common API services (that are provided by
@@ -165,8 +169,9 @@ type TraceConfig struct {
 // StdTraceConfig holds extra parameters to standard-json trace functions.
 type StdTraceConfig struct {
 	vm.LogConfig
-	Reexec *uint64
-	TxHash common.Hash
+	Reexec  *uint64
+	TxHash  common.Hash
+	EIP3155 bool // Whether to output the traces in the EIP-3155 format
 }
 
 // txTraceResult is the result of a single transaction trace.
@@ -554,10 +559,12 @@ func (api *API) standardTraceBlockToFile(ctx context.Context, block *types.Block
 	var (
 		logConfig vm.LogConfig
 		txHash    common.Hash
+		eip3155   bool
 	)
 	if config != nil {
 		logConfig = config.LogConfig
 		txHash = config.TxHash
+		eip3155 = config.EIP3155
 	}
 	logConfig.Debug = true
 
@@ -594,6 +601,7 @@ func (api *API) standardTraceBlockToFile(ctx context.Context, block *types.Block
 			vmConf    vm.Config
 			dump      *os.File
 			writer    *bufio.Writer
+			standard  *vm.StandardJSONLogger
 			err       error
 		)
 		// If the transaction needs tracing, swap out the configs
@@ -616,10 +624,18 @@ func (api *API) standardTraceBlockToFile(ctx context.Context, block *types.Block
 				Tracer:                  vm.NewJSONLogger(&logConfig, writer),
 				EnablePreimageRecording: true,
 			}
+			if eip3155 {
+				standard = vm.NewStandardJSONLogger(&logConfig, writer)
+				vmConf.Tracer = standard
+			}
 		}
 		// Execute the transaction and flush any traces to disk
 		vmenv := vm.NewEVM(vmctx, txContext, statedb, chainConfig, vmConf)
 		_, err = core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()))
+		if err == nil && standard != nil {
+			// Close the trace with the summary, which needs the state root after the transaction
+			err = standard.WriteSummary(statedb.IntermediateRoot(vmenv.ChainConfig().IsEIP158(block.Number())), "", nil)
+		}
 		if writer != nil {
 			writer.Flush()
 		}
@@ -727,6 +743,9 @@ func (api *API) traceTx(ctx context.Context, message core.Message, vmctx vm.Bloc
 		txContext = core.NewEVMTxContext(message)
 	)
 	switch {
+	case config != nil && config.Tracer != nil && *config.Tracer == profilerTracer:
+		tracer = vm.NewProfiler()
+
 	case config != nil && config.Tracer != nil:
 		// Define a meaningful timeout of a single transaction trace
 		timeout := defaultTraceTimeout
@@ -779,6 +798,13 @@ func (api *API) traceTx(ctx context.Context, message core.Message, vmctx vm.Bloc
 	case *Tracer:
 		return tracer.GetResult()
 
+	case *vm.Profiler:
+		var profile bytes.Buffer
+		if err := tracer.WriteProfile(&profile); err != nil {
+			return nil, err
+		}
+		return hexutil.Bytes(profile.Bytes()), nil
+
 	default:
 		panic(fmt.Sprintf("bad tracer type %T", tracer))
 	}
@@ -18,11 +18,14 @@ package tracers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"os"
 	"reflect"
 	"sort"
 	"testing"
@@ -354,6 +357,93 @@ func TestTraceTransaction(t *testing.T) {
 	}
 }
 
+func TestTraceTransactionProfile(t *testing.T) {
+	t.Parallel()
+
+	accounts := newAccounts(1)
+	contract := common.HexToAddress("0xc0de")
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		// SSTORE(0, 1)
+		contract: {Balance: new(big.Int), Code: []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE)}},
+	}}
+	target := common.Hash{}
+	api := NewAPI(newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), contract, big.NewInt(0), 100000, big.NewInt(0), nil), types.HomesteadSigner{}, accounts[0].key)
+		b.AddTx(tx)
+		target = tx.Hash()
+	}))
+	tracer := "profiler"
+	result, err := api.TraceTransaction(context.Background(), target, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		t.Fatalf("Failed to trace transaction %v", err)
+	}
+	profile, ok := result.(hexutil.Bytes)
+	if !ok {
+		t.Fatalf("unexpected result type %T", result)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(profile))
+	if err != nil {
+		t.Fatalf("invalid profile: %v", err)
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("invalid profile: %v", err)
+	}
+	if !bytes.Contains(raw, []byte("SSTORE")) || !bytes.Contains(raw, []byte(contract.Hex())) {
+		t.Fatalf("profile lacks the executed instructions")
+	}
+}
+
+func TestStandardTraceBlockToFile(t *testing.T) {
+	t.Parallel()
+
+	// Use a fixed sender, so that the state roots of the traces are deterministic
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0xc0de")
+	genesis := &core.Genesis{Alloc: core.GenesisAlloc{
+		sender: {Balance: big.NewInt(params.Ether)},
+		// MSTORE(0, SLOAD(0) + 1), SSTORE(0, MLOAD(0))
+		contract: {Balance: new(big.Int), Code: []byte{
+			byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.PUSH1), 1, byte(vm.ADD), byte(vm.PUSH1), 0, byte(vm.MSTORE),
+			byte(vm.PUSH1), 0, byte(vm.MLOAD), byte(vm.PUSH1), 0, byte(vm.SSTORE),
+		}},
+	}}
+	backend := newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {
+		for nonce := uint64(0); nonce < 2; nonce++ {
+			tx, _ := types.SignTx(types.NewTransaction(nonce, contract, big.NewInt(0), 100000, big.NewInt(0), nil), types.HomesteadSigner{}, key)
+			b.AddTx(tx)
+		}
+	})
+	block := backend.chain.GetBlockByNumber(1).Hash()
+
+	files, err := NewAPI(backend).StandardTraceBlockToFile(context.Background(), block, &StdTraceConfig{EIP3155: true})
+	for _, file := range files {
+		defer os.Remove(file)
+	}
+	if err != nil {
+		t.Fatalf("Failed to trace block: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("trace file count mismatch: have %d, want 2", len(files))
+	}
+	for i, file := range files {
+		have, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read trace: %v", err)
+		}
+		golden := fmt.Sprintf("testdata/standard_trace_block_%d.jsonl", i)
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Failed to read golden trace: %v", err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Errorf("trace %d mismatch\nhave:\n%s\nwant:\n%s", i, have, want)
+		}
+	}
+}
+
 func TestTraceBlock(t *testing.T) {
 	t.Parallel()
 
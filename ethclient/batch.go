@@ -0,0 +1,344 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// errBatchExecuted is returned when executing a batch for the second time.
+var errBatchExecuted = errors.New("batch already executed")
+
+// Batch collects typed calls to be sent to the server in a single round trip.
+//
+// Every queueing method returns a result object which is filled in once Execute
+// returns successfully. Errors of individual calls are reported in the Err field
+// of their result, while Execute itself only fails on transport errors.
+//
+// Methods needing more than one request, such as BlockByNumber, are not available
+// in batches. A batch can only be executed once.
+type Batch struct {
+	client   *rpc.Client
+	elems    []rpc.BatchElem
+	results  []batchResult
+	executed bool
+}
+
+// batchResult is the decoding step of a batched call.
+type batchResult interface {
+	finish(err error)
+}
+
+// NewBatch creates an empty batch of calls.
+func (ec *Client) NewBatch() *Batch {
+	return &Batch{client: ec.c}
+}
+
+// Len returns the number of calls queued in the batch.
+func (b *Batch) Len() int {
+	return len(b.elems)
+}
+
+// Execute sends all queued calls to the server and waits for the responses.
+func (b *Batch) Execute(ctx context.Context) error {
+	if b.executed {
+		return errBatchExecuted
+	}
+	b.executed = true
+	if len(b.elems) == 0 {
+		return nil
+	}
+	if err := b.client.BatchCallContext(ctx, b.elems); err != nil {
+		return err
+	}
+	for i, result := range b.results {
+		result.finish(b.elems[i].Error)
+	}
+	return nil
+}
+
+func (b *Batch) queue(result batchResult, raw interface{}, method string, args ...interface{}) {
+	b.elems = append(b.elems, rpc.BatchElem{Method: method, Args: args, Result: raw})
+	b.results = append(b.results, result)
+}
+
+// BigResult is the result of a batched call returning an integer.
+type BigResult struct {
+	Value *big.Int
+	Err   error
+	raw   hexutil.Big
+}
+
+func (r *BigResult) finish(err error) {
+	if r.Err = err; err == nil {
+		r.Value = (*big.Int)(&r.raw)
+	}
+}
+
+// Uint64Result is the result of a batched call returning a counter.
+type Uint64Result struct {
+	Value uint64
+	Err   error
+	raw   hexutil.Uint64
+}
+
+func (r *Uint64Result) finish(err error) {
+	if r.Err = err; err == nil {
+		r.Value = uint64(r.raw)
+	}
+}
+
+// BytesResult is the result of a batched call returning binary data.
+type BytesResult struct {
+	Value []byte
+	Err   error
+	raw   hexutil.Bytes
+}
+
+func (r *BytesResult) finish(err error) {
+	if r.Err = err; err == nil {
+		r.Value = r.raw
+	}
+}
+
+// HeaderResult is the result of a batched header retrieval.
+type HeaderResult struct {
+	Header *types.Header
+	Err    error
+}
+
+func (r *HeaderResult) finish(err error) {
+	if r.Err = err; err == nil && r.Header == nil {
+		r.Err = ethereum.NotFound
+	}
+}
+
+// TransactionResult is the result of a batched transaction retrieval.
+type TransactionResult struct {
+	Transaction *types.Transaction
+	IsPending   bool
+	Err         error
+	raw         *rpcTransaction
+}
+
+func (r *TransactionResult) finish(err error) {
+	if r.Err = err; err == nil {
+		if r.Transaction, r.Err = r.raw.transaction(); r.Err == nil {
+			r.IsPending = r.raw.BlockNumber == nil
+		}
+	}
+}
+
+// ReceiptResult is the result of a batched receipt retrieval.
+type ReceiptResult struct {
+	Receipt *types.Receipt
+	Err     error
+}
+
+func (r *ReceiptResult) finish(err error) {
+	if r.Err = err; err == nil && r.Receipt == nil {
+		r.Err = ethereum.NotFound
+	}
+}
+
+// LogsResult is the result of a batched filter query.
+type LogsResult struct {
+	Logs []types.Log
+	Err  error
+}
+
+func (r *LogsResult) finish(err error) {
+	r.Err = err
+}
+
+// ChainID queues the retrieval of the chain ID, see Client.ChainID.
+func (b *Batch) ChainID() *BigResult {
+	r := new(BigResult)
+	b.queue(r, &r.raw, "eth_chainId")
+	return r
+}
+
+// NetworkID queues the retrieval of the network ID, see Client.NetworkID.
+func (b *Batch) NetworkID() *BigResult {
+	r := new(networkIDResult)
+	b.queue(r, &r.raw, "net_version")
+	return &r.BigResult
+}
+
+// networkIDResult decodes the decimal string returned by net_version.
+type networkIDResult struct {
+	BigResult
+	raw string
+}
+
+func (r *networkIDResult) finish(err error) {
+	if r.Err = err; err == nil {
+		value, ok := new(big.Int).SetString(r.raw, 10)
+		if !ok {
+			r.Err = errors.New("invalid net_version result " + r.raw)
+			return
+		}
+		r.Value = value
+	}
+}
+
+// BlockNumber queues the retrieval of the most recent block number.
+func (b *Batch) BlockNumber() *Uint64Result {
+	r := new(Uint64Result)
+	b.queue(r, &r.raw, "eth_blockNumber")
+	return r
+}
+
+// HeaderByHash queues the retrieval of the block header with the given hash.
+func (b *Batch) HeaderByHash(hash common.Hash) *HeaderResult {
+	r := new(HeaderResult)
+	b.queue(r, &r.Header, "eth_getBlockByHash", hash, false)
+	return r
+}
+
+// HeaderByNumber queues the retrieval of a block header from the current canonical
+// chain. If number is nil, the latest known header is returned.
+func (b *Batch) HeaderByNumber(number *big.Int) *HeaderResult {
+	r := new(HeaderResult)
+	b.queue(r, &r.Header, "eth_getBlockByNumber", toBlockNumArg(number), false)
+	return r
+}
+
+// TransactionByHash queues the retrieval of the transaction with the given hash.
+func (b *Batch) TransactionByHash(hash common.Hash) *TransactionResult {
+	r := new(TransactionResult)
+	b.queue(r, &r.raw, "eth_getTransactionByHash", hash)
+	return r
+}
+
+// TransactionInBlock queues the retrieval of a single transaction at index in the
+// given block.
+func (b *Batch) TransactionInBlock(blockHash common.Hash, index uint) *TransactionResult {
+	r := new(TransactionResult)
+	b.queue(r, &r.raw, "eth_getTransactionByBlockHashAndIndex", blockHash, hexutil.Uint64(index))
+	return r
+}
+
+// TransactionCount queues the retrieval of the number of transactions in the given block.
+func (b *Batch) TransactionCount(blockHash common.Hash) *Uint64Result {
+	r := new(Uint64Result)
+	b.queue(r, &r.raw, "eth_getBlockTransactionCountByHash", blockHash)
+	return r
+}
+
+// TransactionReceipt queues the retrieval of the receipt of a transaction.
+func (b *Batch) TransactionReceipt(txHash common.Hash) *ReceiptResult {
+	r := new(ReceiptResult)
+	b.queue(r, &r.Receipt, "eth_getTransactionReceipt", txHash)
+	return r
+}
+
+// BalanceAt queues the retrieval of the wei balance of the given account. The block
+// number can be nil, in which case the balance is taken from the latest known block.
+func (b *Batch) BalanceAt(account common.Address, blockNumber *big.Int) *BigResult {
+	r := new(BigResult)
+	b.queue(r, &r.raw, "eth_getBalance", account, toBlockNumArg(blockNumber))
+	return r
+}
+
+// StorageAt queues the retrieval of the value of key in the contract storage of the
+// given account. The block number can be nil, in which case the value is taken from
+// the latest known block.
+func (b *Batch) StorageAt(account common.Address, key common.Hash, blockNumber *big.Int) *BytesResult {
+	r := new(BytesResult)
+	b.queue(r, &r.raw, "eth_getStorageAt", account, key, toBlockNumArg(blockNumber))
+	return r
+}
+
+// CodeAt queues the retrieval of the contract code of the given account. The block
+// number can be nil, in which case the code is taken from the latest known block.
+func (b *Batch) CodeAt(account common.Address, blockNumber *big.Int) *BytesResult {
+	r := new(BytesResult)
+	b.queue(r, &r.raw, "eth_getCode", account, toBlockNumArg(blockNumber))
+	return r
+}
+
+// NonceAt queues the retrieval of the nonce of the given account. The block number
+// can be nil, in which case the nonce is taken from the latest known block.
+func (b *Batch) NonceAt(account common.Address, blockNumber *big.Int) *Uint64Result {
+	r := new(Uint64Result)
+	b.queue(r, &r.raw, "eth_getTransactionCount", account, toBlockNumArg(blockNumber))
+	return r
+}
+
+// PendingBalanceAt queues the retrieval of the wei balance of the given account in
+// the pending state.
+func (b *Batch) PendingBalanceAt(account common.Address) *BigResult {
+	r := new(BigResult)
+	b.queue(r, &r.raw, "eth_getBalance", account, "pending")
+	return r
+}
+
+// PendingNonceAt queues the retrieval of the nonce of the given account in the
+// pending state.
+func (b *Batch) PendingNonceAt(account common.Address) *Uint64Result {
+	r := new(Uint64Result)
+	b.queue(r, &r.raw, "eth_getTransactionCount", account, "pending")
+	return r
+}
+
+// FilterLogs queues a filter query.
+func (b *Batch) FilterLogs(q ethereum.FilterQuery) (*LogsResult, error) {
+	arg, err := toFilterArg(q)
+	if err != nil {
+		return nil, err
+	}
+	r := new(LogsResult)
+	b.queue(r, &r.Logs, "eth_getLogs", arg)
+	return r, nil
+}
+
+// CallContract queues a message call, see Client.CallContract.
+func (b *Batch) CallContract(msg ethereum.CallMsg, blockNumber *big.Int) *BytesResult {
+	r := new(BytesResult)
+	b.queue(r, &r.raw, "eth_call", toCallArg(msg), toBlockNumArg(blockNumber))
+	return r
+}
+
+// PendingCallContract queues a message call against the pending state.
+func (b *Batch) PendingCallContract(msg ethereum.CallMsg) *BytesResult {
+	r := new(BytesResult)
+	b.queue(r, &r.raw, "eth_call", toCallArg(msg), "pending")
+	return r
+}
+
+// SuggestGasPrice queues the retrieval of the currently suggested gas price.
+func (b *Batch) SuggestGasPrice() *BigResult {
+	r := new(BigResult)
+	b.queue(r, &r.raw, "eth_gasPrice")
+	return r
+}
+
+// EstimateGas queues a gas estimation of the given message, see Client.EstimateGas.
+func (b *Batch) EstimateGas(msg ethereum.CallMsg) *Uint64Result {
+	r := new(Uint64Result)
+	b.queue(r, &r.raw, "eth_estimateGas", toCallArg(msg))
+	return r
+}
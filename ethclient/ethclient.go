@@ -210,15 +210,24 @@ func (ec *Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx *
 	err = ec.c.CallContext(ctx, &json, "eth_getTransactionByHash", hash)
 	if err != nil {
 		return nil, false, err
-	} else if json == nil {
-		return nil, false, ethereum.NotFound
-	} else if _, r, _ := json.tx.RawSignatureValues(); r == nil {
-		return nil, false, fmt.Errorf("server returned transaction without signature")
 	}
-	if json.From != nil && json.BlockHash != nil {
-		setSenderFromServer(json.tx, *json.From, *json.BlockHash)
+	if tx, err = json.transaction(); err != nil {
+		return nil, false, err
 	}
-	return json.tx, json.BlockNumber == nil, nil
+	return tx, json.BlockNumber == nil, nil
+}
+
+// transaction validates a transaction returned by the server and caches its sender.
+func (tx *rpcTransaction) transaction() (*types.Transaction, error) {
+	if tx == nil {
+		return nil, ethereum.NotFound
+	} else if _, r, _ := tx.tx.RawSignatureValues(); r == nil {
+		return nil, fmt.Errorf("server returned transaction without signature")
+	}
+	if tx.From != nil && tx.BlockHash != nil {
+		setSenderFromServer(tx.tx, *tx.From, *tx.BlockHash)
+	}
+	return tx.tx, nil
 }
 
 // TransactionSender returns the sender address of the given transaction. The transaction
@@ -260,15 +269,7 @@ func (ec *Client) TransactionInBlock(ctx context.Context, blockHash common.Hash,
 	if err != nil {
 		return nil, err
 	}
-	if json == nil {
-		return nil, ethereum.NotFound
-	} else if _, r, _ := json.tx.RawSignatureValues(); r == nil {
-		return nil, fmt.Errorf("server returned transaction without signature")
-	}
-	if json.From != nil && json.BlockHash != nil {
-		setSenderFromServer(json.tx, *json.From, *json.BlockHash)
-	}
-	return json.tx, err
+	return json.transaction()
 }
 
 // TransactionReceipt returns the receipt of a transaction by transaction hash.
@@ -265,6 +265,9 @@ func TestEthClient(t *testing.T) {
 		"TestAtFunctions": {
 			func(t *testing.T) { testAtFunctions(t, client) },
 		},
+		"TestBatch": {
+			func(t *testing.T) { testBatch(t, chain, client) },
+		},
 	}
 
 	t.Parallel()
@@ -432,6 +435,71 @@ func testGetBlock(t *testing.T, client *rpc.Client) {
 	}
 }
 
+func testBatch(t *testing.T, chain []*types.Block, client *rpc.Client) {
+	ec := NewClient(client)
+
+	batch := ec.NewBatch()
+	var (
+		chainID   = batch.ChainID()
+		networkID = batch.NetworkID()
+		number    = batch.BlockNumber()
+		header    = batch.HeaderByNumber(big.NewInt(1))
+		missing   = batch.HeaderByNumber(big.NewInt(1000000000))
+		byHash    = batch.HeaderByHash(chain[0].Hash())
+		balance   = batch.BalanceAt(testAddr, big.NewInt(0))
+		code      = batch.CodeAt(testAddr, nil)
+		receipt   = batch.TransactionReceipt(common.Hash{1})
+		tx        = batch.TransactionByHash(common.Hash{1})
+		call      = batch.CallContract(ethereum.CallMsg{From: testAddr, To: &common.Address{}, Gas: 21000, Value: big.NewInt(1)}, nil)
+		badCall   = batch.BalanceAt(testAddr, big.NewInt(1000000000))
+	)
+	if batch.Len() != 12 {
+		t.Fatalf("wrong batch length %d", batch.Len())
+	}
+	if err := batch.Execute(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := batch.Execute(context.Background()); err != errBatchExecuted {
+		t.Fatalf("wrong error executing batch twice: %v", err)
+	}
+	if chainID.Err != nil || chainID.Value.Cmp(params.AllEthashProtocolChanges.ChainID) != 0 {
+		t.Errorf("ChainID: %v, %v", chainID.Value, chainID.Err)
+	}
+	if networkID.Err != nil || networkID.Value.Sign() != 0 {
+		t.Errorf("NetworkID: %v, %v", networkID.Value, networkID.Err)
+	}
+	if number.Err != nil || number.Value != 1 {
+		t.Errorf("BlockNumber: %v, %v", number.Value, number.Err)
+	}
+	if header.Err != nil || header.Header.Hash() != chain[1].Hash() {
+		t.Errorf("HeaderByNumber: %v, %v", header.Header, header.Err)
+	}
+	if missing.Err != ethereum.NotFound {
+		t.Errorf("HeaderByNumber of missing block: %v", missing.Err)
+	}
+	if byHash.Err != nil || byHash.Header.Hash() != chain[0].Hash() {
+		t.Errorf("HeaderByHash: %v, %v", byHash.Header, byHash.Err)
+	}
+	if balance.Err != nil || balance.Value.Cmp(testBalance) != 0 {
+		t.Errorf("BalanceAt: %v, %v", balance.Value, balance.Err)
+	}
+	if code.Err != nil || len(code.Value) != 0 {
+		t.Errorf("CodeAt: %x, %v", code.Value, code.Err)
+	}
+	if receipt.Err != ethereum.NotFound {
+		t.Errorf("TransactionReceipt of missing transaction: %v", receipt.Err)
+	}
+	if tx.Err != ethereum.NotFound {
+		t.Errorf("TransactionByHash of missing transaction: %v", tx.Err)
+	}
+	if call.Err != nil || len(call.Value) != 0 {
+		t.Errorf("CallContract: %x, %v", call.Value, call.Err)
+	}
+	if badCall.Err == nil {
+		t.Errorf("BalanceAt of missing block: expected error")
+	}
+}
+
 func testStatusFunctions(t *testing.T, client *rpc.Client) {
 	ec := NewClient(client)
 
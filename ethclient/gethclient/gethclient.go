@@ -0,0 +1,360 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package gethclient provides an RPC client for geth-specific APIs, i.e. the
+// methods of the eth namespace not covered by package ethclient and the debug,
+// txpool and admin namespaces.
+package gethclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Client is a wrapper around rpc.Client that implements geth-specific functionality.
+//
+// If you want to use the standardized Ethereum RPC functionality, use ethclient.Client instead.
+type Client struct {
+	c *rpc.Client
+}
+
+// New creates a client that uses the given RPC client.
+func New(c *rpc.Client) *Client {
+	return &Client{c}
+}
+
+// GetProof returns the account and storage values of the specified account including
+// the Merkle-proof. The block number can be nil, in which case the value is taken
+// from the latest known block.
+func (ec *Client) GetProof(ctx context.Context, account common.Address, keys []common.Hash, blockNumber *big.Int) (*ethapi.AccountResult, error) {
+	storageKeys := make([]string, len(keys))
+	for i, key := range keys {
+		storageKeys[i] = key.Hex()
+	}
+	var result *ethapi.AccountResult
+	err := ec.c.CallContext(ctx, &result, "eth_getProof", account, storageKeys, toBlockNumArg(blockNumber))
+	if err == nil && result == nil {
+		err = ethereum.NotFound
+	}
+	return result, err
+}
+
+// ProofRequest specifies an account and some of its storage slots to be proven.
+type ProofRequest struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// MultiProofResult is a combined Merkle-proof for several accounts and storage
+// slots. The trie nodes of all account and storage proofs are deduplicated into
+// a single list.
+type MultiProofResult struct {
+	Nodes    [][]byte
+	Accounts []MultiProofAccount
+}
+
+// MultiProofAccount is an account proven by a MultiProofResult.
+type MultiProofAccount struct {
+	Address     common.Address
+	Balance     *big.Int
+	CodeHash    common.Hash
+	Nonce       uint64
+	StorageHash common.Hash
+	Storage     []StorageValue
+}
+
+// StorageValue is a storage slot proven by a MultiProofResult.
+type StorageValue struct {
+	Key   string
+	Value *big.Int
+}
+
+// GetMultiProof returns a combined Merkle-proof for several accounts and some of
+// their storage slots, with the trie nodes shared by the individual proofs only
+// included once. The block number can be nil, in which case the values are taken
+// from the latest known block.
+func (ec *Client) GetMultiProof(ctx context.Context, requests []ProofRequest, blockNumber *big.Int) (*MultiProofResult, error) {
+	type proofRequest struct {
+		Address     common.Address `json:"address"`
+		StorageKeys []string       `json:"storageKeys"`
+	}
+	type storageValue struct {
+		Key   string       `json:"key"`
+		Value *hexutil.Big `json:"value"`
+	}
+	type multiProofAccount struct {
+		Address     common.Address `json:"address"`
+		Balance     *hexutil.Big   `json:"balance"`
+		CodeHash    common.Hash    `json:"codeHash"`
+		Nonce       hexutil.Uint64 `json:"nonce"`
+		StorageHash common.Hash    `json:"storageHash"`
+		Storage     []storageValue `json:"storage"`
+	}
+	type multiProofResult struct {
+		Nodes    []hexutil.Bytes     `json:"nodes"`
+		Accounts []multiProofAccount `json:"accounts"`
+	}
+	reqs := make([]proofRequest, len(requests))
+	for i, req := range requests {
+		keys := make([]string, len(req.StorageKeys))
+		for j, key := range req.StorageKeys {
+			keys[j] = key.Hex()
+		}
+		reqs[i] = proofRequest{Address: req.Address, StorageKeys: keys}
+	}
+	var res *multiProofResult
+	if err := ec.c.CallContext(ctx, &res, "eth_getMultiProof", reqs, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, ethereum.NotFound
+	}
+	result := &MultiProofResult{
+		Nodes:    make([][]byte, len(res.Nodes)),
+		Accounts: make([]MultiProofAccount, len(res.Accounts)),
+	}
+	for i, node := range res.Nodes {
+		result.Nodes[i] = node
+	}
+	for i, acc := range res.Accounts {
+		storage := make([]StorageValue, len(acc.Storage))
+		for j, slot := range acc.Storage {
+			storage[j] = StorageValue{Key: slot.Key, Value: slot.Value.ToInt()}
+		}
+		result.Accounts[i] = MultiProofAccount{
+			Address:     acc.Address,
+			Balance:     acc.Balance.ToInt(),
+			CodeHash:    acc.CodeHash,
+			Nonce:       uint64(acc.Nonce),
+			StorageHash: acc.StorageHash,
+			Storage:     storage,
+		}
+	}
+	return result, nil
+}
+
+// OverrideAccount specifies the state of an account to be overridden during a
+// message call. Unset fields are left untouched.
+//
+// State and StateDiff cannot be set at the same time: State replaces the entire
+// storage of the account, while StateDiff only replaces the given slots.
+type OverrideAccount struct {
+	Nonce     *uint64
+	Code      []byte
+	Balance   *big.Int
+	State     map[common.Hash]common.Hash
+	StateDiff map[common.Hash]common.Hash
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a OverrideAccount) MarshalJSON() ([]byte, error) {
+	type acc struct {
+		Nonce     *hexutil.Uint64             `json:"nonce,omitempty"`
+		Code      *hexutil.Bytes              `json:"code,omitempty"`
+		Balance   *hexutil.Big                `json:"balance,omitempty"`
+		State     map[common.Hash]common.Hash `json:"state,omitempty"`
+		StateDiff map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+	}
+	output := acc{
+		Nonce:     (*hexutil.Uint64)(a.Nonce),
+		Balance:   (*hexutil.Big)(a.Balance),
+		State:     a.State,
+		StateDiff: a.StateDiff,
+	}
+	if a.Code != nil {
+		output.Code = (*hexutil.Bytes)(&a.Code)
+	}
+	return json.Marshal(output)
+}
+
+// CallContract executes a message call transaction, which is directly executed in
+// the VM of the node, but never mined into the blockchain. Unlike the method of
+// ethclient.Client, the state of any number of accounts can be overridden before
+// the call is executed.
+//
+// blockNumber selects the block height at which the call runs. It can be nil, in
+// which case the code is taken from the latest known block.
+func (ec *Client) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, overrides map[common.Address]OverrideAccount) ([]byte, error) {
+	var hex hexutil.Bytes
+	err := ec.c.CallContext(ctx, &hex, "eth_call", toCallArg(msg), toBlockNumArg(blockNumber), overrides)
+	if err != nil {
+		return nil, err
+	}
+	return hex, nil
+}
+
+// TraceTransaction replays the given transaction with the structured logger and
+// returns the execution result along with the emitted logs. The config can be nil,
+// its Tracer field must not be set.
+func (ec *Client) TraceTransaction(ctx context.Context, hash common.Hash, config *tracers.TraceConfig) (*ethapi.ExecutionResult, error) {
+	if config != nil && config.Tracer != nil {
+		return nil, fmt.Errorf("use TraceTransactionWithTracer for custom tracers")
+	}
+	var result *ethapi.ExecutionResult
+	if err := ec.c.CallContext(ctx, &result, "debug_traceTransaction", hash, config); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TraceTransactionWithTracer replays the given transaction with the tracer set in
+// the config (e.g. "callTracer" or JavaScript code) and returns the raw tracer output.
+func (ec *Client) TraceTransactionWithTracer(ctx context.Context, hash common.Hash, config *tracers.TraceConfig) (json.RawMessage, error) {
+	if config == nil || config.Tracer == nil {
+		return nil, fmt.Errorf("no tracer specified")
+	}
+	var result json.RawMessage
+	if err := ec.c.CallContext(ctx, &result, "debug_traceTransaction", hash, config); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TraceCall executes a message call on top of the given block with the structured
+// logger and returns the execution result along with the emitted logs. The block
+// number can be nil, in which case the call runs on the latest known block.
+func (ec *Client) TraceCall(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int, config *tracers.TraceConfig) (*ethapi.ExecutionResult, error) {
+	if config != nil && config.Tracer != nil {
+		return nil, fmt.Errorf("custom tracers are not supported")
+	}
+	var result *ethapi.ExecutionResult
+	if err := ec.c.CallContext(ctx, &result, "debug_traceCall", toCallArg(msg), toBlockNumArg(blockNumber), config); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// TxPoolContent is the content of the transaction pool, grouped by account and nonce.
+type TxPoolContent struct {
+	Pending map[common.Address]map[uint64]*ethapi.RPCTransaction
+	Queued  map[common.Address]map[uint64]*ethapi.RPCTransaction
+}
+
+// TxPoolContent returns all transactions contained within the transaction pool.
+func (ec *Client) TxPoolContent(ctx context.Context) (*TxPoolContent, error) {
+	var raw map[string]map[common.Address]map[string]*ethapi.RPCTransaction
+	if err := ec.c.CallContext(ctx, &raw, "txpool_content"); err != nil {
+		return nil, err
+	}
+	pending, err := parseTxPoolSection(raw["pending"])
+	if err != nil {
+		return nil, err
+	}
+	queued, err := parseTxPoolSection(raw["queued"])
+	if err != nil {
+		return nil, err
+	}
+	return &TxPoolContent{Pending: pending, Queued: queued}, nil
+}
+
+// parseTxPoolSection converts the nonce keys of a txpool content section.
+func parseTxPoolSection(section map[common.Address]map[string]*ethapi.RPCTransaction) (map[common.Address]map[uint64]*ethapi.RPCTransaction, error) {
+	result := make(map[common.Address]map[uint64]*ethapi.RPCTransaction, len(section))
+	for addr, txs := range section {
+		result[addr] = make(map[uint64]*ethapi.RPCTransaction, len(txs))
+		for key, tx := range txs {
+			nonce, err := strconv.ParseUint(key, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nonce %q in txpool content", key)
+			}
+			result[addr][nonce] = tx
+		}
+	}
+	return result, nil
+}
+
+// TxPoolStatus returns the number of pending and queued transactions in the pool.
+func (ec *Client) TxPoolStatus(ctx context.Context) (pending uint, queued uint, err error) {
+	var result map[string]hexutil.Uint
+	if err := ec.c.CallContext(ctx, &result, "txpool_status"); err != nil {
+		return 0, 0, err
+	}
+	return uint(result["pending"]), uint(result["queued"]), nil
+}
+
+// Peers returns information about the connected remote nodes.
+func (ec *Client) Peers(ctx context.Context) ([]*p2p.PeerInfo, error) {
+	var result []*p2p.PeerInfo
+	if err := ec.c.CallContext(ctx, &result, "admin_peers"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// NodeInfo returns information about the local node.
+func (ec *Client) NodeInfo(ctx context.Context) (*p2p.NodeInfo, error) {
+	var result *p2p.NodeInfo
+	if err := ec.c.CallContext(ctx, &result, "admin_nodeInfo"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddPeer requests connecting to a remote node, returning whether the request was
+// accepted by the node.
+func (ec *Client) AddPeer(ctx context.Context, url string) (bool, error) {
+	var result bool
+	err := ec.c.CallContext(ctx, &result, "admin_addPeer", url)
+	return result, err
+}
+
+// SetHead rewinds the local chain to the given block number. This is a destructive
+// action and may severely damage your chain. Use with extreme caution.
+func (ec *Client) SetHead(ctx context.Context, number *big.Int) error {
+	return ec.c.CallContext(ctx, nil, "debug_setHead", hexutil.EncodeBig(number))
+}
+
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	pending := big.NewInt(-1)
+	if number.Cmp(pending) == 0 {
+		return "pending"
+	}
+	return hexutil.EncodeBig(number)
+}
+
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["data"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+	return arg
+}
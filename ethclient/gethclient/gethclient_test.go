@@ -0,0 +1,372 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gethclient
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddr    = crypto.PubkeyToAddress(testKey.PublicKey)
+	testSlot    = common.HexToHash("0xdeadbeef")
+	testValue   = crypto.Keccak256Hash(testSlot[:])
+	testBalance = big.NewInt(2e15)
+)
+
+func newTestBackend(t *testing.T) (*node.Node, []*types.Block) {
+	// Generate test chain.
+	genesis, blocks := generateTestChain()
+	// Create node
+	n, err := node.New(&node.Config{})
+	if err != nil {
+		t.Fatalf("can't create new node: %v", err)
+	}
+	// Create Ethereum Service
+	config := &ethconfig.Config{Genesis: genesis}
+	config.Ethash.PowMode = ethash.ModeFake
+	ethservice, err := eth.New(n, config)
+	if err != nil {
+		t.Fatalf("can't create new ethereum service: %v", err)
+	}
+	n.RegisterAPIs(tracers.APIs(ethservice.APIBackend))
+
+	// Import the test chain.
+	if err := n.Start(); err != nil {
+		t.Fatalf("can't start test node: %v", err)
+	}
+	if _, err := ethservice.BlockChain().InsertChain(blocks[1:]); err != nil {
+		t.Fatalf("can't import test blocks: %v", err)
+	}
+	return n, blocks
+}
+
+func generateTestChain() (*core.Genesis, []*types.Block) {
+	db := rawdb.NewMemoryDatabase()
+	config := params.AllEthashProtocolChanges
+	genesis := &core.Genesis{
+		Config:    config,
+		Alloc:     core.GenesisAlloc{testAddr: {Balance: testBalance, Storage: map[common.Hash]common.Hash{testSlot: testValue}}},
+		ExtraData: []byte("test genesis"),
+		Timestamp: 9000,
+	}
+	generate := func(i int, g *core.BlockGen) {
+		g.OffsetTime(5)
+		g.SetExtra([]byte("test"))
+		tx, _ := types.SignTx(types.NewTransaction(0, common.Address{1}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, testKey)
+		g.AddTx(tx)
+	}
+	gblock := genesis.ToBlock(db)
+	engine := ethash.NewFaker()
+	blocks, _ := core.GenerateChain(config, gblock, engine, db, 1, generate)
+	blocks = append([]*types.Block{gblock}, blocks...)
+	return genesis, blocks
+}
+
+func TestGethClient(t *testing.T) {
+	backend, chain := newTestBackend(t)
+	client, _ := backend.Attach()
+	defer backend.Close()
+	defer client.Close()
+
+	tests := map[string]struct {
+		test func(t *testing.T)
+	}{
+		"TestGetProof": {
+			func(t *testing.T) { testGetProof(t, client) },
+		},
+		"TestGetMultiProof": {
+			func(t *testing.T) { testGetMultiProof(t, chain, client) },
+		},
+		"TestCallContract": {
+			func(t *testing.T) { testCallContract(t, client) },
+		},
+		"TestTraceTransaction": {
+			func(t *testing.T) { testTraceTransaction(t, chain, client) },
+		},
+		"TestTxPool": {
+			func(t *testing.T) { testTxPool(t, client) },
+		},
+		"TestAdmin": {
+			func(t *testing.T) { testAdmin(t, client) },
+		},
+	}
+	t.Parallel()
+	for name, tt := range tests {
+		t.Run(name, tt.test)
+	}
+}
+
+func testGetProof(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+	result, err := ec.GetProof(context.Background(), testAddr, []common.Hash{testSlot}, big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Address != testAddr {
+		t.Fatalf("unexpected address, want: %v got: %v", testAddr, result.Address)
+	}
+	if result.Balance.ToInt().Cmp(testBalance) != 0 {
+		t.Fatalf("invalid balance, want: %v got: %v", testBalance, result.Balance)
+	}
+	if len(result.AccountProof) == 0 {
+		t.Fatal("missing account proof")
+	}
+	if len(result.StorageProof) != 1 {
+		t.Fatalf("invalid storage proof, want 1 proof, got %v", len(result.StorageProof))
+	}
+	if result.StorageProof[0].Value.ToInt().Cmp(testValue.Big()) != 0 {
+		t.Fatalf("invalid storage value, want: %v got: %v", testValue.Big(), result.StorageProof[0].Value)
+	}
+}
+
+func TestGetProofRegeneration(t *testing.T) {
+	// Generate a chain long enough for the early states to be garbage collected.
+	db := rawdb.NewMemoryDatabase()
+	genesis := &core.Genesis{
+		Config: params.AllEthashProtocolChanges,
+		Alloc:  core.GenesisAlloc{testAddr: {Balance: testBalance}},
+	}
+	generate := func(i int, g *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), common.Address{1}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, testKey)
+		g.AddTx(tx)
+	}
+	blocks, _ := core.GenerateChain(genesis.Config, genesis.ToBlock(db), ethash.NewFaker(), db, 2*core.TriesInMemory, generate)
+
+	// Create a node allowing the regeneration of pruned state from a few blocks.
+	n, err := node.New(&node.Config{})
+	if err != nil {
+		t.Fatalf("can't create new node: %v", err)
+	}
+	config := &ethconfig.Config{Genesis: genesis, TrieDirtyCache: 256, TrieTimeout: time.Hour, RPCProofReexec: 4}
+	config.Ethash.PowMode = ethash.ModeFake
+	ethservice, err := eth.New(n, config)
+	if err != nil {
+		t.Fatalf("can't create new ethereum service: %v", err)
+	}
+	if err := n.Start(); err != nil {
+		t.Fatalf("can't start test node: %v", err)
+	}
+	defer n.Close()
+	if _, err := ethservice.BlockChain().InsertChain(blocks); err != nil {
+		t.Fatalf("can't import test blocks: %v", err)
+	}
+	client, _ := n.Attach()
+	defer client.Close()
+	ec := New(client)
+
+	// State within the re-execution limit of the genesis state is regenerated.
+	if _, err := ethservice.BlockChain().StateAt(blocks[2].Root()); err == nil {
+		t.Fatal("state of block 3 not garbage collected")
+	}
+	result, err := ec.GetProof(context.Background(), testAddr, nil, big.NewInt(3))
+	if err != nil {
+		t.Fatalf("failed to regenerate state: %v", err)
+	}
+	if uint64(result.Nonce) != 3 {
+		t.Fatalf("invalid nonce, want 3, got %d", result.Nonce)
+	}
+	// State beyond the limit is not.
+	if _, err := ec.GetProof(context.Background(), testAddr, nil, big.NewInt(10)); err == nil {
+		t.Fatal("regenerated state beyond the re-execution limit")
+	}
+	if _, err := ec.GetMultiProof(context.Background(), []ProofRequest{{Address: testAddr}}, big.NewInt(10)); err == nil {
+		t.Fatal("regenerated state beyond the re-execution limit")
+	}
+}
+
+func testGetMultiProof(t *testing.T, chain []*types.Block, client *rpc.Client) {
+	ec := New(client)
+	missing := common.Address{0xee}
+	requests := []ProofRequest{
+		{Address: testAddr, StorageKeys: []common.Hash{testSlot, {31: 1}}},
+		{Address: missing, StorageKeys: []common.Hash{testSlot}},
+	}
+	result, err := ec.GetMultiProof(context.Background(), requests, big.NewInt(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Accounts) != 2 {
+		t.Fatalf("invalid number of accounts, want 2, got %d", len(result.Accounts))
+	}
+	proof := memorydb.New()
+	for _, blob := range result.Nodes {
+		if has, _ := proof.Has(crypto.Keccak256(blob)); has {
+			t.Fatalf("duplicate proof node %x", blob)
+		}
+		proof.Put(crypto.Keccak256(blob), blob)
+	}
+	// Verify the accounts against the state root.
+	accounts, err := trie.VerifyMultiProof(chain[0].Root(), [][]byte{crypto.Keccak256(testAddr[:]), crypto.Keccak256(missing[:])}, proof)
+	if err != nil {
+		t.Fatalf("invalid account proof: %v", err)
+	}
+	var account state.Account
+	if err := rlp.DecodeBytes(accounts[0], &account); err != nil {
+		t.Fatalf("invalid account: %v", err)
+	}
+	if account.Balance.Cmp(testBalance) != 0 || account.Root != result.Accounts[0].StorageHash {
+		t.Fatalf("account mismatch: %+v", account)
+	}
+	if accounts[1] != nil || result.Accounts[1].StorageHash != types.EmptyRootHash {
+		t.Fatalf("missing account proven to exist: %x", accounts[1])
+	}
+	// Verify the storage against the storage root.
+	slots, err := trie.VerifyMultiProof(account.Root, [][]byte{crypto.Keccak256(testSlot[:]), crypto.Keccak256(common.Hash{31: 1}.Bytes())}, proof)
+	if err != nil {
+		t.Fatalf("invalid storage proof: %v", err)
+	}
+	_, content, _, _ := rlp.Split(slots[0])
+	if common.BytesToHash(content) != testValue || slots[1] != nil {
+		t.Fatalf("storage mismatch: %x", slots)
+	}
+	if result.Accounts[0].Storage[0].Value.Cmp(testValue.Big()) != 0 {
+		t.Fatalf("invalid storage value, want: %v got: %v", testValue.Big(), result.Accounts[0].Storage[0].Value)
+	}
+}
+
+func testCallContract(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+
+	// Code returning the CALLER's balance in a 32 byte word.
+	code := []byte{
+		byte(0x33), byte(0x31), // CALLER BALANCE
+		byte(0x60), 0x00, byte(0x52), // PUSH1 0 MSTORE
+		byte(0x60), 0x20, byte(0x60), 0x00, byte(0xf3), // PUSH1 32 PUSH1 0 RETURN
+	}
+	contract, balance := common.Address{0xc0}, big.NewInt(12345)
+	msg := ethereum.CallMsg{From: testAddr, To: &contract, Gas: 100000}
+	overrides := map[common.Address]OverrideAccount{
+		contract: {Code: code},
+		testAddr: {Balance: balance},
+	}
+	res, err := ec.CallContract(context.Background(), msg, nil, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, common.LeftPadBytes(balance.Bytes(), 32)) {
+		t.Fatalf("unexpected call result %x", res)
+	}
+	// Without overrides, the contract doesn't exist.
+	res, err = ec.CallContract(context.Background(), msg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Fatalf("unexpected call result %x", res)
+	}
+}
+
+func testTraceTransaction(t *testing.T, chain []*types.Block, client *rpc.Client) {
+	ec := New(client)
+	tx := chain[1].Transactions()[0]
+
+	result, err := ec.TraceTransaction(context.Background(), tx.Hash(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed || result.Gas != params.TxGas {
+		t.Fatalf("unexpected trace result: %+v", result)
+	}
+	tracer := "callTracer"
+	if _, err := ec.TraceTransaction(context.Background(), tx.Hash(), &tracers.TraceConfig{Tracer: &tracer}); err == nil {
+		t.Fatal("expected error for custom tracer")
+	}
+	trace, err := ec.TraceTransactionWithTracer(context.Background(), tx.Hash(), &tracers.TraceConfig{Tracer: &tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(trace, []byte(`"type":"CALL"`)) {
+		t.Fatalf("unexpected call trace: %s", trace)
+	}
+	msg := ethereum.CallMsg{From: testAddr, To: &common.Address{1}, Gas: 100000, Value: big.NewInt(1)}
+	if result, err = ec.TraceCall(context.Background(), msg, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed || result.Gas != params.TxGas {
+		t.Fatalf("unexpected call trace result: %+v", result)
+	}
+}
+
+func testTxPool(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+
+	// Send a transaction to the pool.
+	tx, _ := types.SignTx(types.NewTransaction(1, common.Address{1}, big.NewInt(1), params.TxGas, big.NewInt(params.GWei), nil), types.NewEIP155Signer(params.AllEthashProtocolChanges.ChainID), testKey)
+	if err := ethclient.NewClient(client).SendTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	pending, queued, err := ec.TxPoolStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 || queued != 0 {
+		t.Fatalf("unexpected txpool status: pending %d, queued %d", pending, queued)
+	}
+	content, err := ec.TxPoolContent(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Queued) != 0 {
+		t.Fatalf("unexpected queued transactions: %v", content.Queued)
+	}
+	if got := content.Pending[testAddr][1]; got == nil || got.Hash != tx.Hash() {
+		t.Fatalf("missing pending transaction: %v", content.Pending)
+	}
+}
+
+func testAdmin(t *testing.T, client *rpc.Client) {
+	ec := New(client)
+
+	peers, err := ec.Peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("unexpected peers: %v", peers)
+	}
+	info, err := ec.NodeInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID == "" || info.Enode == "" {
+		t.Fatalf("incomplete node info: %+v", info)
+	}
+}
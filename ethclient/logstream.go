@@ -0,0 +1,241 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// LogStreamBackend is the chain access needed by a LogStream. Besides Client, it is
+// satisfied by any bind.ContractFilterer which can also retrieve headers and report
+// new chain heads, such as the simulated backend.
+type LogStreamBackend interface {
+	ethereum.LogFilterer
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// logStreamRange is the maximum number of blocks covered by a single log query,
+// bounding the queries backfilling long ranges of historical blocks.
+var logStreamRange = uint64(2000)
+
+// LogStream turns a log filter query into an ordered stream of log additions and
+// removals following the canonical chain.
+//
+// Logs of a block are delivered once the block has been buried under the configured
+// number of confirmations. The stream tracks the hashes of recent canonical blocks,
+// and if a chain reorganisation drops a block whose logs were already delivered,
+// those logs are redelivered in reverse order with their Removed field set, followed
+// by the logs of the new canonical blocks. Reorgs deeper than the tracked window of
+// recent blocks are not detected.
+type LogStream struct {
+	backend       LogStreamBackend
+	query         ethereum.FilterQuery
+	confirmations uint64
+}
+
+// NewLogStream creates a log stream for the given filter query. The query may set
+// FromBlock to backfill historical logs before following the chain head, otherwise
+// the stream starts at the first confirmed block. ToBlock and BlockHash must not be set.
+func NewLogStream(backend LogStreamBackend, query ethereum.FilterQuery, confirmations uint64) (*LogStream, error) {
+	if query.BlockHash != nil || query.ToBlock != nil {
+		return nil, errors.New("log streams do not support block hash or end block filters")
+	}
+	if query.FromBlock != nil && !query.FromBlock.IsUint64() {
+		return nil, fmt.Errorf("invalid start block %v", query.FromBlock)
+	}
+	if confirmations >= reorgWindow {
+		return nil, fmt.Errorf("too many confirmations (%d>=%d)", confirmations, reorgWindow)
+	}
+	return &LogStream{backend: backend, query: query, confirmations: confirmations}, nil
+}
+
+// Subscribe starts streaming logs into the given channel. Connection failures of
+// the backend are handled by resubscribing with backoff (never exceeding backoffMax)
+// and catching up with any blocks missed in the meantime.
+func (s *LogStream) Subscribe(backoffMax time.Duration, ch chan<- types.Log) ethereum.Subscription {
+	state := &logStreamState{
+		LogStream: s,
+		delivered: make(map[common.Hash][]types.Log),
+	}
+	// Track enough history to detect reorgs of every block logs are delivered for.
+	depth := s.confirmations
+	if s.query.FromBlock != nil {
+		depth = reorgWindow - 1
+	}
+	return resubscribeChain(s.backend, depth, backoffMax, func(ctx context.Context, update *chainUpdate) error {
+		return state.handle(ctx, update, ch)
+	})
+}
+
+// logStreamState is the progress of a single log stream subscription.
+type logStreamState struct {
+	*LogStream
+	started   bool
+	next      uint64                      // number of the next block to deliver logs of
+	delivered map[common.Hash][]types.Log // logs delivered for recent blocks, by block hash
+}
+
+// handle delivers the log changes caused by a chain update. Newly confirmed blocks
+// are queried in ranges of at most logStreamRange blocks, and the state advances
+// past every range whose logs are delivered successfully.
+func (s *logStreamState) handle(ctx context.Context, update *chainUpdate, ch chan<- types.Log) error {
+	var (
+		head    = update.head().Number.Uint64()
+		next    = s.next
+		removed []types.Log
+	)
+	if !s.started {
+		switch {
+		case s.query.FromBlock != nil:
+			next = s.query.FromBlock.Uint64()
+		case head >= s.confirmations:
+			next = head - s.confirmations
+		}
+	}
+	// Revert the logs of delivered blocks which are no longer canonical.
+	for _, header := range update.reverted {
+		if number := header.Number.Uint64(); number < next {
+			logs := s.delivered[header.Hash()]
+			for i := len(logs) - 1; i >= 0; i-- {
+				log := logs[i]
+				log.Removed = true
+				removed = append(removed, log)
+			}
+			next = number
+		}
+	}
+	if err := deliverLogs(ctx, removed, ch); err != nil {
+		return err
+	}
+	for _, header := range update.reverted {
+		delete(s.delivered, header.Hash())
+	}
+	s.started, s.next = true, next
+
+	// Collect the logs of all newly confirmed blocks, verifying them against the
+	// tracked canonical chain to catch reorgs racing with the queries.
+	if head >= s.confirmations {
+		var (
+			target = head - s.confirmations
+			oldest = update.canon[0].Number.Uint64()
+		)
+		for s.next <= target {
+			end := s.next + logStreamRange - 1
+			if end > target {
+				end = target
+			}
+			query := s.query
+			query.FromBlock, query.ToBlock = new(big.Int).SetUint64(s.next), new(big.Int).SetUint64(end)
+			logs, err := s.backend.FilterLogs(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, log := range logs {
+				if log.BlockNumber < s.next || log.BlockNumber > end {
+					return fmt.Errorf("log of block %d outside of queried range %d-%d", log.BlockNumber, s.next, end)
+				}
+				if log.BlockNumber >= oldest {
+					if hash := update.canon[log.BlockNumber-oldest].Hash(); log.BlockHash != hash {
+						return fmt.Errorf("log of block %d has hash %x, want %x", log.BlockNumber, log.BlockHash, hash)
+					}
+				}
+			}
+			if err := deliverLogs(ctx, logs, ch); err != nil {
+				return err
+			}
+			for _, log := range logs {
+				s.delivered[log.BlockHash] = append(s.delivered[log.BlockHash], log)
+			}
+			s.next = end + 1
+		}
+	}
+	for hash, logs := range s.delivered {
+		if logs[0].BlockNumber+reorgWindow <= head {
+			delete(s.delivered, hash)
+		}
+	}
+	return nil
+}
+
+// deliverLogs sends the logs into the channel, unless the context is cancelled.
+func deliverLogs(ctx context.Context, logs []types.Log, ch chan<- types.Log) error {
+	for _, log := range logs {
+		select {
+		case ch <- log:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// LogStreamFilterer wraps a LogStreamBackend into a log filterer whose subscriptions
+// are log streams with a fixed confirmation depth. It can be used as the
+// bind.ContractFilterer of contract bindings to make their Watch methods reorg aware.
+type LogStreamFilterer struct {
+	backend       LogStreamBackend
+	confirmations uint64
+	backoffMax    time.Duration
+}
+
+// NewLogStreamFilterer creates a log filterer streaming logs with the given number
+// of confirmations.
+func NewLogStreamFilterer(backend LogStreamBackend, confirmations uint64, backoffMax time.Duration) *LogStreamFilterer {
+	return &LogStreamFilterer{backend: backend, confirmations: confirmations, backoffMax: backoffMax}
+}
+
+// FilterLogs executes a filter query on the backend.
+func (f *LogStreamFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return f.backend.FilterLogs(ctx, q)
+}
+
+// SubscribeFilterLogs streams the results of a filter query, see LogStream. The
+// subscription lasts until the context is cancelled or it is unsubscribed, the
+// cancellation of the context is reported as its error.
+func (f *LogStreamFilterer) SubscribeFilterLogs(ctx context.Context, q ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	stream, err := NewLogStream(f.backend, q, f.confirmations)
+	if err != nil {
+		return nil, err
+	}
+	sub := stream.Subscribe(f.backoffMax, ch)
+	if ctx == nil || ctx.Done() == nil {
+		return sub, nil
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+
+		select {
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-quit:
+			return nil
+		}
+	}), nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethclient
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Verify that LogStreamFilterer can back contract bindings.
+var _ = bind.ContractFilterer(&LogStreamFilterer{})
+
+type logExpecter struct {
+	t   *testing.T
+	ch  chan types.Log
+	sub ethereum.Subscription
+}
+
+func (e *logExpecter) expect(header *types.Header, removed bool) {
+	e.t.Helper()
+	select {
+	case log := <-e.ch:
+		if log.BlockHash != header.Hash() || log.Removed != removed {
+			e.t.Fatalf("wrong log of block %d (removed %t), want block %d (removed %t)", log.BlockNumber, log.Removed, header.Number, removed)
+		}
+	case err := <-e.sub.Err():
+		e.t.Fatalf("subscription failed: %v", err)
+	case <-time.After(5 * time.Second):
+		e.t.Fatalf("timeout waiting for log of block %d", header.Number)
+	}
+}
+
+func (e *logExpecter) expectNone() {
+	e.t.Helper()
+	select {
+	case log := <-e.ch:
+		e.t.Fatalf("unexpected log of block %d (removed %t)", log.BlockNumber, log.Removed)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLogStreamConfirmations(t *testing.T) {
+	chain := newFakeChain(5)
+	client, _ := chain.serve(t)
+
+	stream, err := NewLogStream(client, ethereum.FilterQuery{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan types.Log, 16)
+	sub := stream.Subscribe(50*time.Millisecond, ch)
+	defer sub.Unsubscribe()
+	e := &logExpecter{t: t, ch: ch, sub: sub}
+
+	// The stream starts at the first confirmed block.
+	e.expect(chain.header(3), false)
+	chain.reorg(5, 1, 'a')
+	old4 := chain.header(4)
+	e.expect(old4, false)
+
+	// Reorging unconfirmed blocks only doesn't revert anything.
+	chain.reorg(4, 3, 'b')
+	e.expect(chain.header(5), false)
+
+	// Reorging a confirmed block reverts its logs.
+	old5 := chain.header(5)
+	chain.reorg(3, 5, 'c')
+	e.expect(old5, true)
+	e.expect(old4, true)
+	e.expect(chain.header(4), false)
+	e.expect(chain.header(5), false)
+	e.expect(chain.header(6), false)
+	e.expectNone()
+}
+
+func TestLogStreamBackfill(t *testing.T) {
+	defer func(limit uint64) { logStreamRange = limit }(logStreamRange)
+	logStreamRange = 2
+
+	chain := newFakeChain(5)
+	client, drop := chain.serve(t)
+
+	if _, err := NewLogStream(client, ethereum.FilterQuery{ToBlock: big.NewInt(1)}, 0); err == nil {
+		t.Fatal("expected error for query with end block")
+	}
+	if _, err := NewLogStream(client, ethereum.FilterQuery{}, reorgWindow); err == nil {
+		t.Fatal("expected error for confirmations beyond reorg window")
+	}
+	filterer := NewLogStreamFilterer(client, 1, 50*time.Millisecond)
+	ch := make(chan types.Log, 16)
+	sub, err := filterer.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery{FromBlock: big.NewInt(2)}, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	e := &logExpecter{t: t, ch: ch, sub: sub}
+
+	for i := 2; i <= 4; i++ {
+		e.expect(chain.header(i), false)
+	}
+	// Blocks added while disconnected are backfilled.
+	drop()
+	chain.reorg(5, 3, 'a')
+	for i := 5; i <= 7; i++ {
+		e.expect(chain.header(i), false)
+	}
+	e.expectNone()
+
+	// Backfilled blocks are queried in bounded ranges.
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	if chain.queried > logStreamRange {
+		t.Fatalf("log query spanning %d blocks, want at most %d", chain.queried, logStreamRange)
+	}
+}
+
+func TestLogStreamFiltererContext(t *testing.T) {
+	chain := newFakeChain(5)
+	client, _ := chain.serve(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	filterer := NewLogStreamFilterer(client, 1, 50*time.Millisecond)
+	ch := make(chan types.Log, 16)
+	sub, err := filterer.SubscribeFilterLogs(ctx, ethereum.FilterQuery{}, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	e := &logExpecter{t: t, ch: ch, sub: sub}
+	e.expect(chain.header(4), false)
+
+	// Cancelling the context ends the subscription.
+	cancel()
+	select {
+	case err := <-sub.Err():
+		if err != context.Canceled {
+			t.Fatalf("subscription error mismatch: have %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not ended by context cancellation")
+	}
+	chain.reorg(5, 1, 'a')
+	e.expectNone()
+}
@@ -0,0 +1,268 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethclient
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+const (
+	// reorgWindow is the number of recently delivered headers retained by resilient
+	// subscriptions to detect chain reorganisations.
+	reorgWindow = 128
+
+	// resubscribeBuffer is the size of the channel receiving raw head notifications.
+	resubscribeBuffer = 16
+)
+
+// HeadEvent is a chain head notification delivered by ResubscribeNewHead.
+type HeadEvent struct {
+	// Header is the new canonical block. Headers are delivered in ascending order
+	// without any gaps between them.
+	Header *types.Header
+
+	// Reverted lists previously delivered headers which are no longer part of the
+	// canonical chain, newest first. It is only set on the first header following
+	// a chain reorganisation.
+	Reverted []*types.Header
+}
+
+// ResubscribeNewHead subscribes to notifications about the current blockchain head
+// like SubscribeNewHead, but keeps the subscription established across connection
+// failures. The underlying client is redialled with backoff (never exceeding
+// backoffMax) and any heads missed while disconnected are fetched and delivered
+// in order. If the chain diverged from previously delivered heads, the dropped
+// headers are reported in the Reverted field of the next event.
+//
+// The subscription only works with clients supporting notifications (e.g. over
+// WebSocket or IPC) which can reconnect to their endpoint.
+func (ec *Client) ResubscribeNewHead(backoffMax time.Duration, ch chan<- *HeadEvent) ethereum.Subscription {
+	return resubscribeChain(ec, 0, backoffMax, func(ctx context.Context, update *chainUpdate) error {
+		reverted := update.reverted
+		for _, header := range update.added {
+			select {
+			case ch <- &HeadEvent{Header: header, Reverted: reverted}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			reverted = nil
+		}
+		return nil
+	})
+}
+
+// ResubscribeFilterLogs subscribes to the results of a streaming filter query like
+// SubscribeFilterLogs, but keeps the subscription established across connection
+// failures. Logs of blocks missed while disconnected are backfilled by block range
+// and logs of blocks dropped by a chain reorganisation are redelivered with their
+// Removed field set, so the stream never skips a canonical block.
+//
+// The block range and block hash fields of the query must not be set. See LogStream
+// for delaying logs until they reach a given confirmation depth.
+func (ec *Client) ResubscribeFilterLogs(q ethereum.FilterQuery, backoffMax time.Duration, ch chan<- types.Log) (ethereum.Subscription, error) {
+	if q.BlockHash != nil || q.FromBlock != nil || q.ToBlock != nil {
+		return nil, errors.New("resilient log subscriptions do not support block ranges")
+	}
+	stream, err := NewLogStream(ec, q, 0)
+	if err != nil {
+		return nil, err
+	}
+	return stream.Subscribe(backoffMax, ch), nil
+}
+
+// chainBackend is the chain access needed to follow the canonical chain.
+type chainBackend interface {
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// chainUpdate is a change of the canonical chain as seen by a resilient subscription.
+type chainUpdate struct {
+	reverted []*types.Header // previously delivered headers dropped by a reorg, newest first
+	added    []*types.Header // new canonical headers in ascending order
+	canon    []*types.Header // recent canonical headers after the update, ascending
+}
+
+// head returns the new chain head.
+func (u *chainUpdate) head() *types.Header {
+	return u.added[len(u.added)-1]
+}
+
+// resubscribeChain keeps a head subscription established, feeding every change of
+// the canonical chain to handle. If handle fails, the subscription is re-established
+// and the update is retried. The first update also carries up to depth ancestors of
+// the initial head, so reorgs below it can be detected.
+func resubscribeChain(backend chainBackend, depth uint64, backoffMax time.Duration, handle func(context.Context, *chainUpdate) error) event.Subscription {
+	tracker := &headTracker{backend: backend, depth: depth}
+	process := func(ctx context.Context, head *types.Header) error {
+		update, err := tracker.update(ctx, head)
+		if err != nil || update == nil {
+			return err
+		}
+		if err := handle(ctx, update); err != nil {
+			return err
+		}
+		tracker.commit(update)
+		return nil
+	}
+	return event.ResubscribeErr(backoffMax, func(ctx context.Context, _ error) (event.Subscription, error) {
+		heads := make(chan *types.Header, resubscribeBuffer)
+		sub, err := backend.SubscribeNewHead(ctx, heads)
+		if err != nil {
+			return nil, err
+		}
+		// Catch up with the current head to fill the gap left by the previous
+		// connection. Notifications received meanwhile are buffered.
+		head, err := backend.HeaderByNumber(ctx, nil)
+		if err == nil {
+			err = process(ctx, head)
+		}
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		return event.NewSubscription(func(quit <-chan struct{}) error {
+			defer sub.Unsubscribe()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				select {
+				case <-quit:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			for {
+				select {
+				case head := <-heads:
+					if err := process(ctx, head); err != nil {
+						return err
+					}
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			}
+		}), nil
+	})
+}
+
+// headTracker maintains the recently delivered section of the canonical chain and
+// computes the changes needed to move it to a new head.
+type headTracker struct {
+	backend chainBackend
+	depth   uint64          // number of ancestors to track below the initial head
+	headers []*types.Header // recently delivered headers, contiguous and ascending
+}
+
+// update computes the chain change leading to the given head, fetching any missing
+// ancestors from the node. It returns nil if the head was already delivered.
+func (t *headTracker) update(ctx context.Context, head *types.Header) (*chainUpdate, error) {
+	if len(t.headers) == 0 {
+		added := []*types.Header{head} // collected in descending order
+		for current := head; uint64(len(added)) <= t.depth && current.Number.Sign() > 0; {
+			parent, err := t.backend.HeaderByHash(ctx, current.ParentHash)
+			if err != nil {
+				return nil, err
+			}
+			added = append(added, parent)
+			current = parent
+		}
+		return t.apply(nil, reverseHeaders(added)), nil
+	}
+	if t.known(head.Number, head.Hash()) {
+		return nil, nil
+	}
+	var (
+		oldest  = t.headers[0].Number.Uint64()
+		added   = []*types.Header{head} // collected in descending order
+		current = head
+	)
+	for {
+		number := current.Number.Uint64()
+		if number == 0 || number <= oldest {
+			// The reorg reaches beyond the tracked window, drop all of it.
+			return t.apply(t.tail(0), reverseHeaders(added)), nil
+		}
+		if t.known(new(big.Int).SetUint64(number-1), current.ParentHash) {
+			return t.apply(t.tail(number-oldest), reverseHeaders(added)), nil
+		}
+		parent, err := t.backend.HeaderByHash(ctx, current.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, parent)
+		current = parent
+	}
+}
+
+// apply assembles a chain update, computing the resulting canonical chain without
+// modifying the tracker.
+func (t *headTracker) apply(reverted, added []*types.Header) *chainUpdate {
+	canon := make([]*types.Header, 0, len(t.headers)-len(reverted)+len(added))
+	canon = append(canon, t.headers[:len(t.headers)-len(reverted)]...)
+	canon = append(canon, added...)
+	if len(canon) > reorgWindow {
+		canon = canon[len(canon)-reorgWindow:]
+	}
+	return &chainUpdate{reverted: reverted, added: added, canon: canon}
+}
+
+// commit applies a chain change returned by update.
+func (t *headTracker) commit(update *chainUpdate) {
+	t.headers = update.canon
+}
+
+// known reports whether the header with the given number and hash was delivered.
+func (t *headTracker) known(number *big.Int, hash common.Hash) bool {
+	oldest := t.headers[0].Number
+	if number.Cmp(oldest) < 0 {
+		return false
+	}
+	index := new(big.Int).Sub(number, oldest)
+	if !index.IsUint64() || index.Uint64() >= uint64(len(t.headers)) {
+		return false
+	}
+	return t.headers[index.Uint64()].Hash() == hash
+}
+
+// tail returns the tracked headers starting at the given index, newest first.
+func (t *headTracker) tail(index uint64) []*types.Header {
+	if index >= uint64(len(t.headers)) {
+		return nil
+	}
+	return reverseHeaders(append([]*types.Header{}, t.headers[index:]...))
+}
+
+// reverseHeaders reverses a slice of headers in place.
+func reverseHeaders(headers []*types.Header) []*types.Header {
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return headers
+}
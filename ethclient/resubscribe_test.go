@@ -0,0 +1,299 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethclient
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var fakeLogAddr = common.HexToAddress("0x1000")
+
+// fakeChain is a minimal eth namespace backend with a mutable canonical chain,
+// emitting one log per block.
+type fakeChain struct {
+	mu      sync.Mutex
+	canon   []*types.Header
+	byHash  map[common.Hash]*types.Header
+	feed    event.Feed
+	queried uint64 // widest block range of a log query served
+}
+
+func newFakeChain(n int) *fakeChain {
+	c := &fakeChain{byHash: make(map[common.Hash]*types.Header)}
+	c.reorg(-1, n+1, 0)
+	return c
+}
+
+// reorg replaces the canonical chain above block 'ancestor' with n new blocks and
+// announces the new head.
+func (c *fakeChain) reorg(ancestor int, n int, salt byte) {
+	c.mu.Lock()
+	c.canon = c.canon[:ancestor+1]
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			Number:     big.NewInt(int64(len(c.canon))),
+			Difficulty: big.NewInt(1),
+			Extra:      []byte{salt},
+		}
+		if len(c.canon) > 0 {
+			header.ParentHash = c.canon[len(c.canon)-1].Hash()
+		}
+		c.canon = append(c.canon, header)
+		c.byHash[header.Hash()] = header
+	}
+	head := c.canon[len(c.canon)-1]
+	c.mu.Unlock()
+
+	c.feed.Send(head)
+}
+
+func (c *fakeChain) header(number int) *types.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canon[number]
+}
+
+func (c *fakeChain) headerLog(header *types.Header) types.Log {
+	return types.Log{
+		Address:     fakeLogAddr,
+		Topics:      []common.Hash{},
+		Data:        header.Extra,
+		BlockNumber: header.Number.Uint64(),
+		BlockHash:   header.Hash(),
+		TxHash:      header.Hash(),
+	}
+}
+
+// serve starts a WebSocket server for the chain, returning a client connected to it
+// and a function dropping all open connections.
+func (c *fakeChain) serve(t *testing.T) (*Client, func()) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &fakeEthAPI{c}); err != nil {
+		t.Fatal(err)
+	}
+	httpsrv := httptest.NewUnstartedServer(server.WebsocketHandler([]string{"*"}))
+	listener := &dropListener{Listener: httpsrv.Listener}
+	httpsrv.Listener = listener
+	httpsrv.Start()
+	t.Cleanup(func() {
+		listener.drop()
+		httpsrv.Close()
+		server.Stop()
+	})
+	client, err := rpc.Dial("ws://" + strings.TrimPrefix(httpsrv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return NewClient(client), listener.drop
+}
+
+type fakeEthAPI struct {
+	chain *fakeChain
+}
+
+func (api *fakeEthAPI) GetBlockByNumber(number rpc.BlockNumber, full bool) *types.Header {
+	api.chain.mu.Lock()
+	defer api.chain.mu.Unlock()
+
+	if number < 0 {
+		return api.chain.canon[len(api.chain.canon)-1]
+	}
+	if int(number) >= len(api.chain.canon) {
+		return nil
+	}
+	return api.chain.canon[number]
+}
+
+func (api *fakeEthAPI) GetBlockByHash(hash common.Hash, full bool) *types.Header {
+	api.chain.mu.Lock()
+	defer api.chain.mu.Unlock()
+
+	return api.chain.byHash[hash]
+}
+
+func (api *fakeEthAPI) GetLogs(crit struct {
+	FromBlock *hexutil.Big `json:"fromBlock"`
+	ToBlock   *hexutil.Big `json:"toBlock"`
+}) []types.Log {
+	api.chain.mu.Lock()
+	defer api.chain.mu.Unlock()
+
+	if span := crit.ToBlock.ToInt().Uint64() - crit.FromBlock.ToInt().Uint64() + 1; span > api.chain.queried {
+		api.chain.queried = span
+	}
+	logs := []types.Log{}
+	for n := crit.FromBlock.ToInt().Uint64(); n <= crit.ToBlock.ToInt().Uint64() && n < uint64(len(api.chain.canon)); n++ {
+		logs = append(logs, api.chain.headerLog(api.chain.canon[n]))
+	}
+	return logs
+}
+
+func (api *fakeEthAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		heads := make(chan *types.Header, 16)
+		sub := api.chain.feed.Subscribe(heads)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case head := <-heads:
+				notifier.Notify(rpcSub.ID, head)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// dropListener tracks accepted connections so they can be severed.
+type dropListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *dropListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *dropListener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+func TestResubscribeNewHead(t *testing.T) {
+	chain := newFakeChain(3)
+	client, drop := chain.serve(t)
+
+	ch := make(chan *HeadEvent, 16)
+	sub := client.ResubscribeNewHead(50*time.Millisecond, ch)
+	defer sub.Unsubscribe()
+
+	expect := func(want *types.Header, reverted ...*types.Header) {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			if ev.Header.Hash() != want.Hash() {
+				t.Fatalf("wrong head %d (%x), want %d (%x)", ev.Header.Number, ev.Header.Hash(), want.Number, want.Hash())
+			}
+			if len(ev.Reverted) != len(reverted) {
+				t.Fatalf("head %d: reverted %d headers, want %d", want.Number, len(ev.Reverted), len(reverted))
+			}
+			for i := range reverted {
+				if ev.Reverted[i].Hash() != reverted[i].Hash() {
+					t.Fatalf("head %d: wrong reverted header %d", want.Number, i)
+				}
+			}
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for head %d", want.Number)
+		}
+	}
+	expect(chain.header(3))
+
+	chain.reorg(3, 1, 'a')
+	old4 := chain.header(4)
+	expect(old4)
+
+	// Drop the connection and reorg while disconnected, the subscription should
+	// reconnect and report the divergence.
+	drop()
+	chain.reorg(3, 3, 'b')
+	expect(chain.header(4), old4)
+	expect(chain.header(5))
+	expect(chain.header(6))
+
+	// A reorg while connected, reported by notification.
+	old6, old5 := chain.header(6), chain.header(5)
+	chain.reorg(4, 3, 'c')
+	expect(chain.header(5), old6, old5)
+	expect(chain.header(6))
+	expect(chain.header(7))
+}
+
+func TestResubscribeFilterLogs(t *testing.T) {
+	chain := newFakeChain(3)
+	client, drop := chain.serve(t)
+
+	if _, err := client.ResubscribeFilterLogs(ethereum.FilterQuery{FromBlock: big.NewInt(1)}, time.Second, nil); err == nil {
+		t.Fatal("expected error for query with block range")
+	}
+	ch := make(chan types.Log, 16)
+	sub, err := client.ResubscribeFilterLogs(ethereum.FilterQuery{}, 50*time.Millisecond, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	expect := func(header *types.Header, removed bool) {
+		t.Helper()
+		select {
+		case log := <-ch:
+			if log.BlockHash != header.Hash() || log.Removed != removed {
+				t.Fatalf("wrong log of block %d (removed %t), want block %d (removed %t)", log.BlockNumber, log.Removed, header.Number, removed)
+			}
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for log of block %d", header.Number)
+		}
+	}
+	expect(chain.header(3), false)
+
+	chain.reorg(3, 1, 'a')
+	old4 := chain.header(4)
+	expect(old4, false)
+
+	drop()
+	chain.reorg(3, 2, 'b')
+	expect(old4, true)
+	expect(chain.header(4), false)
+	expect(chain.header(5), false)
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the size of the header of an e2store entry: a 2 byte type, a
+// 4 byte length and 2 reserved bytes, all little endian.
+const headerSize = 8
+
+// entry is a typed record of an e2store file.
+type entry struct {
+	Type  uint16
+	Value []byte
+}
+
+// e2Writer writes e2store entries to a stream, tracking the offset of the next
+// entry.
+type e2Writer struct {
+	w      io.Writer
+	offset int64
+}
+
+// Write writes an entry, returning its offset.
+func (w *e2Writer) Write(typ uint16, value []byte) (int64, error) {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint16(header[0:2], typ)
+	binary.LittleEndian.PutUint32(header[2:6], uint32(len(value)))
+
+	offset := w.offset
+	if _, err := w.w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.w.Write(value); err != nil {
+		return 0, err
+	}
+	w.offset += int64(headerSize + len(value))
+	return offset, nil
+}
+
+// e2Reader reads e2store entries at arbitrary offsets of a file.
+type e2Reader struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// ReadAt reads the entry at the given offset, returning it along with the offset
+// of the following one.
+func (r *e2Reader) ReadAt(off int64) (*entry, int64, error) {
+	typ, length, err := r.readHeader(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	value := make([]byte, length)
+	if _, err := r.r.ReadAt(value, off+headerSize); err != nil {
+		return nil, 0, fmt.Errorf("entry at %d: %v", off, err)
+	}
+	return &entry{Type: typ, Value: value}, off + headerSize + int64(length), nil
+}
+
+// readHeader reads the type and the length of the entry at the given offset.
+func (r *e2Reader) readHeader(off int64) (uint16, uint32, error) {
+	if off < 0 || off+headerSize > r.size {
+		return 0, 0, fmt.Errorf("entry offset %d out of bounds", off)
+	}
+	var header [headerSize]byte
+	if _, err := r.r.ReadAt(header[:], off); err != nil {
+		return 0, 0, fmt.Errorf("entry at %d: %v", off, err)
+	}
+	if header[6] != 0 || header[7] != 0 {
+		return 0, 0, errors.New("reserved bytes of entry header not zero")
+	}
+	length := binary.LittleEndian.Uint32(header[2:6])
+	if off+headerSize+int64(length) > r.size {
+		return 0, 0, fmt.Errorf("entry at %d exceeds file size", off)
+	}
+	return binary.LittleEndian.Uint16(header[0:2]), length, nil
+}
@@ -0,0 +1,471 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package era implements archives of the chain history in the era1 style: the
+// blocks, receipts and total difficulties of an epoch of EpochSize blocks stored
+// as e2store entries, followed by the accumulator root of the epoch and an index
+// of the blocks, allowing them to be read at random.
+//
+// Every block is stored as four entries: the snappy compressed RLP of its header,
+// body and receipts, and its total difficulty as a 32 byte little endian number.
+package era
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/snappy"
+)
+
+// EpochSize is the maximum number of blocks of an archive.
+const EpochSize = 8192
+
+const (
+	typeVersion            uint16 = 0x3265
+	typeCompressedHeader   uint16 = 0x03
+	typeCompressedBody     uint16 = 0x04
+	typeCompressedReceipts uint16 = 0x05
+	typeTotalDifficulty    uint16 = 0x06
+	typeAccumulator        uint16 = 0x07
+	typeBlockIndex         uint16 = 0x3266
+)
+
+// accumulatorEntrySize is the size of the entry of the accumulator root.
+const accumulatorEntrySize = headerSize + common.HashLength
+
+// Filename returns the name of the archive of the given epoch of a network.
+func Filename(network string, epoch uint64, root common.Hash) string {
+	return fmt.Sprintf("%s-%05d-%x.era1", network, epoch, root[:4])
+}
+
+// Builder writes an archive of consecutive blocks, at most EpochSize of them.
+type Builder struct {
+	w *e2Writer
+
+	start   uint64
+	parent  common.Hash
+	offsets []int64
+	hashes  []common.Hash
+	tds     []*big.Int
+}
+
+// NewBuilder creates a builder writing an archive to w.
+func NewBuilder(w io.Writer) *Builder {
+	return &Builder{w: &e2Writer{w: w}}
+}
+
+// Add appends a block to the archive, along with its receipts and total difficulty.
+func (b *Builder) Add(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	if len(b.hashes) == EpochSize {
+		return fmt.Errorf("archive full with %d blocks", EpochSize)
+	}
+	if len(b.hashes) == 0 {
+		if _, err := b.w.Write(typeVersion, nil); err != nil {
+			return err
+		}
+		b.start = block.NumberU64()
+	} else if block.NumberU64() != b.start+uint64(len(b.hashes)) || block.ParentHash() != b.parent {
+		return fmt.Errorf("non contiguous block %d [%x…]", block.Number(), block.Hash().Bytes()[:4])
+	}
+	header, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return err
+	}
+	body, err := rlp.EncodeToBytes(block.Body())
+	if err != nil {
+		return err
+	}
+	receiptsRLP, err := rlp.EncodeToBytes(receipts)
+	if err != nil {
+		return err
+	}
+	tdLE, err := encodeTd(td)
+	if err != nil {
+		return err
+	}
+	offset := b.w.offset
+	for _, e := range []struct {
+		typ   uint16
+		value []byte
+	}{
+		{typeCompressedHeader, header},
+		{typeCompressedBody, body},
+		{typeCompressedReceipts, receiptsRLP},
+	} {
+		compressed, err := compress(e.value)
+		if err != nil {
+			return err
+		}
+		if _, err := b.w.Write(e.typ, compressed); err != nil {
+			return err
+		}
+	}
+	if _, err := b.w.Write(typeTotalDifficulty, tdLE); err != nil {
+		return err
+	}
+	b.offsets = append(b.offsets, offset)
+	b.hashes = append(b.hashes, block.Hash())
+	b.tds = append(b.tds, new(big.Int).Set(td))
+	b.parent = block.Hash()
+	return nil
+}
+
+// Finalize writes the accumulator root and the block index, returning the root.
+func (b *Builder) Finalize() (common.Hash, error) {
+	if len(b.hashes) == 0 {
+		return common.Hash{}, errors.New("empty archive")
+	}
+	root, err := ComputeAccumulator(b.hashes, b.tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if _, err := b.w.Write(typeAccumulator, root[:]); err != nil {
+		return common.Hash{}, err
+	}
+	// The index holds the starting number, the offsets of the blocks relative to
+	// the index entry and the number of blocks
+	var (
+		indexOffset = b.w.offset
+		index       = make([]byte, 16+8*len(b.offsets))
+	)
+	binary.LittleEndian.PutUint64(index, b.start)
+	for i, offset := range b.offsets {
+		binary.LittleEndian.PutUint64(index[8+8*i:], uint64(offset-indexOffset))
+	}
+	binary.LittleEndian.PutUint64(index[8+8*len(b.offsets):], uint64(len(b.offsets)))
+	if _, err := b.w.Write(typeBlockIndex, index); err != nil {
+		return common.Hash{}, err
+	}
+	return root, nil
+}
+
+// ReadAtSeekCloser is the file an archive is read from.
+type ReadAtSeekCloser interface {
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Era is an archive opened for reading.
+type Era struct {
+	f ReadAtSeekCloser
+	r *e2Reader
+
+	start   uint64
+	offsets []int64
+}
+
+// Open opens the archive at the given path.
+func Open(path string) (*Era, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := From(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// From reads the index of an archive from the given file, which is closed along
+// with the archive.
+func From(f ReadAtSeekCloser) (*Era, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	r := &e2Reader{r: f, size: size}
+
+	// The number of blocks in the trailing index locates the index
+	var count [8]byte
+	if size < headerSize+16 {
+		return nil, errors.New("archive too small")
+	}
+	if _, err := f.ReadAt(count[:], size-8); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint64(count[:])
+	if n == 0 || n > EpochSize {
+		return nil, fmt.Errorf("invalid block count %d", n)
+	}
+	indexOffset := size - int64(headerSize+16+8*n)
+	index, _, err := r.ReadAt(indexOffset)
+	if err != nil {
+		return nil, err
+	}
+	if index.Type != typeBlockIndex || uint64(len(index.Value)) != 16+8*n {
+		return nil, fmt.Errorf("invalid block index entry type %#x, length %d", index.Type, len(index.Value))
+	}
+	e := &Era{
+		f:       f,
+		r:       r,
+		start:   binary.LittleEndian.Uint64(index.Value),
+		offsets: make([]int64, n),
+	}
+	for i := range e.offsets {
+		e.offsets[i] = indexOffset + int64(binary.LittleEndian.Uint64(index.Value[8+8*i:]))
+	}
+	return e, nil
+}
+
+// Close closes the file of the archive.
+func (e *Era) Close() error {
+	return e.f.Close()
+}
+
+// Start returns the number of the first block of the archive.
+func (e *Era) Start() uint64 {
+	return e.start
+}
+
+// Count returns the number of blocks of the archive.
+func (e *Era) Count() uint64 {
+	return uint64(len(e.offsets))
+}
+
+// readEntries reads the entries of the given block, checking their types.
+func (e *Era) readEntries(number uint64, kinds ...uint16) ([]*entry, error) {
+	if number < e.start || number-e.start >= uint64(len(e.offsets)) {
+		return nil, fmt.Errorf("block %d out of archive range", number)
+	}
+	var (
+		entries = make([]*entry, 0, len(kinds))
+		offset  = e.offsets[number-e.start]
+	)
+	for _, typ := range kinds {
+		entry, next, err := e.r.ReadAt(offset)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Type != typ {
+			return nil, fmt.Errorf("block %d: invalid entry type %#x, want %#x", number, entry.Type, typ)
+		}
+		entries = append(entries, entry)
+		offset = next
+	}
+	return entries, nil
+}
+
+// GetHeaderByNumber returns the header of the given block.
+func (e *Era) GetHeaderByNumber(number uint64) (*types.Header, error) {
+	entries, err := e.readEntries(number, typeCompressedHeader)
+	if err != nil {
+		return nil, err
+	}
+	header := new(types.Header)
+	if err := decompressRLP(entries[0].Value, header); err != nil {
+		return nil, fmt.Errorf("block %d: invalid header: %v", number, err)
+	}
+	return header, nil
+}
+
+// GetBlockByNumber returns the given block.
+func (e *Era) GetBlockByNumber(number uint64) (*types.Block, error) {
+	entries, err := e.readEntries(number, typeCompressedHeader, typeCompressedBody)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		header = new(types.Header)
+		body   = new(types.Body)
+	)
+	if err := decompressRLP(entries[0].Value, header); err != nil {
+		return nil, fmt.Errorf("block %d: invalid header: %v", number, err)
+	}
+	if err := decompressRLP(entries[1].Value, body); err != nil {
+		return nil, fmt.Errorf("block %d: invalid body: %v", number, err)
+	}
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), nil
+}
+
+// GetReceiptsByNumber returns the receipts of the given block.
+func (e *Era) GetReceiptsByNumber(number uint64) (types.Receipts, error) {
+	entries, err := e.readEntries(number, typeCompressedHeader, typeCompressedBody, typeCompressedReceipts)
+	if err != nil {
+		return nil, err
+	}
+	var receipts types.Receipts
+	if err := decompressRLP(entries[2].Value, &receipts); err != nil {
+		return nil, fmt.Errorf("block %d: invalid receipts: %v", number, err)
+	}
+	return receipts, nil
+}
+
+// GetTotalDifficultyByNumber returns the total difficulty of the given block.
+func (e *Era) GetTotalDifficultyByNumber(number uint64) (*big.Int, error) {
+	entries, err := e.readEntries(number, typeCompressedHeader, typeCompressedBody, typeCompressedReceipts, typeTotalDifficulty)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTd(entries[3].Value)
+}
+
+// Accumulator returns the accumulator root stored in the archive.
+func (e *Era) Accumulator() (common.Hash, error) {
+	entry, _, err := e.r.ReadAt(e.r.size - int64(headerSize+16+8*len(e.offsets)) - accumulatorEntrySize)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if entry.Type != typeAccumulator || len(entry.Value) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid accumulator entry type %#x", entry.Type)
+	}
+	return common.BytesToHash(entry.Value), nil
+}
+
+// Verify recomputes the accumulator root from the headers and total difficulties
+// of the archive, checking that it matches the stored one, and that the headers
+// form a chain. It returns the verified root.
+func (e *Era) Verify() (common.Hash, error) {
+	want, err := e.Accumulator()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var (
+		hashes = make([]common.Hash, 0, len(e.offsets))
+		tds    = make([]*big.Int, 0, len(e.offsets))
+	)
+	for number := e.start; number < e.start+e.Count(); number++ {
+		header, err := e.GetHeaderByNumber(number)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if header.Number.Uint64() != number {
+			return common.Hash{}, fmt.Errorf("block %d: header number mismatch: %d", number, header.Number)
+		}
+		if len(hashes) > 0 && header.ParentHash != hashes[len(hashes)-1] {
+			return common.Hash{}, fmt.Errorf("block %d: parent hash mismatch", number)
+		}
+		td, err := e.GetTotalDifficultyByNumber(number)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		hashes = append(hashes, header.Hash())
+		tds = append(tds, td)
+	}
+	have, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if have != want {
+		return common.Hash{}, fmt.Errorf("accumulator mismatch: have %x, want %x", have, want)
+	}
+	return have, nil
+}
+
+// ComputeAccumulator returns the accumulator root of the blocks of an epoch: the
+// SSZ hash tree root of the list of their header records, made of the block hash
+// and the total difficulty, with a limit of EpochSize records.
+func ComputeAccumulator(hashes []common.Hash, tds []*big.Int) (common.Hash, error) {
+	if len(hashes) != len(tds) {
+		return common.Hash{}, fmt.Errorf("hash and total difficulty count mismatch: %d != %d", len(hashes), len(tds))
+	}
+	if len(hashes) > EpochSize {
+		return common.Hash{}, fmt.Errorf("too many blocks: %d > %d", len(hashes), EpochSize)
+	}
+	leaves := make([][32]byte, len(hashes))
+	for i := range hashes {
+		td, err := encodeTd(tds[i])
+		if err != nil {
+			return common.Hash{}, err
+		}
+		leaves[i] = sha256.Sum256(append(hashes[i].Bytes(), td...))
+	}
+	root := merkleize(leaves, EpochSize)
+
+	// Mix in the length of the list
+	var length [32]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(hashes)))
+	return sha256.Sum256(append(root[:], length[:]...)), nil
+}
+
+// merkleize returns the root of the binary merkle tree of the given chunks,
+// padded with zero chunks up to the limit, which is a power of two.
+func merkleize(chunks [][32]byte, limit int) [32]byte {
+	var zero [32]byte
+	for depth := 1; depth < limit; depth <<= 1 {
+		if len(chunks)%2 == 1 {
+			chunks = append(chunks, zero)
+		}
+		next := make([][32]byte, len(chunks)/2)
+		for i := range next {
+			next[i] = sha256.Sum256(append(chunks[2*i][:], chunks[2*i+1][:]...))
+		}
+		chunks = next
+		zero = sha256.Sum256(append(zero[:], zero[:]...))
+	}
+	if len(chunks) == 0 {
+		return zero
+	}
+	return chunks[0]
+}
+
+// encodeTd encodes a total difficulty as a 32 byte little endian number.
+func encodeTd(td *big.Int) ([]byte, error) {
+	if td.Sign() < 0 || td.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid total difficulty %v", td)
+	}
+	enc := make([]byte, 32)
+	for i, b := range td.Bytes() {
+		enc[len(td.Bytes())-1-i] = b
+	}
+	return enc, nil
+}
+
+// decodeTd decodes a total difficulty from a 32 byte little endian number.
+func decodeTd(enc []byte) (*big.Int, error) {
+	if len(enc) != 32 {
+		return nil, fmt.Errorf("invalid total difficulty length %d", len(enc))
+	}
+	be := make([]byte, 32)
+	for i, b := range enc {
+		be[31-i] = b
+	}
+	return new(big.Int).SetBytes(be), nil
+}
+
+// compress compresses data with the snappy framing format.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := snappy.NewBufferedWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressRLP decompresses snappy framed data and decodes its RLP into val.
+func decompressRLP(data []byte, val interface{}) error {
+	blob, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	return rlp.DecodeBytes(blob, val)
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package era
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// makeChain creates a chain of blocks with a transaction and its receipt each,
+// along with their total difficulties.
+func makeChain(n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		tds      []*big.Int
+		parent   common.Hash
+		td       = new(big.Int)
+	)
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			ParentHash: parent,
+			Number:     big.NewInt(int64(100 + i)),
+			Difficulty: big.NewInt(int64(131072 + i)),
+			GasLimit:   8000000,
+			GasUsed:    21000,
+			Time:       uint64(1000 + 13*i),
+		}
+		tx := types.NewTransaction(uint64(i), common.Address{0xaa}, big.NewInt(int64(i)), 21000, big.NewInt(1), nil)
+		receipt := &types.Receipt{
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: 21000,
+			Logs:              []*types.Log{{Address: common.Address{byte(i)}, Data: []byte{byte(i)}}},
+		}
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+
+		block := types.NewBlock(header, []*types.Transaction{tx}, nil, []*types.Receipt{receipt}, trie.NewStackTrie(nil))
+		td = new(big.Int).Add(td, header.Difficulty)
+
+		blocks = append(blocks, block)
+		receipts = append(receipts, types.Receipts{receipt})
+		tds = append(tds, td)
+		parent = block.Hash()
+	}
+	return blocks, receipts, tds
+}
+
+// writeArchive writes the given blocks into an archive in a temporary directory.
+func writeArchive(t *testing.T, dir string, blocks []*types.Block, receipts []types.Receipts, tds []*big.Int) (string, common.Hash) {
+	path := filepath.Join(dir, "test.era1")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	builder := NewBuilder(f)
+	for i, block := range blocks {
+		if err := builder.Add(block, receipts[i], tds[i]); err != nil {
+			t.Fatalf("failed to add block %d: %v", i, err)
+		}
+	}
+	root, err := builder.Finalize()
+	if err != nil {
+		t.Fatalf("failed to finalize archive: %v", err)
+	}
+	return path, root
+}
+
+func TestEraRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeChain(10)
+	path, root := writeArchive(t, dir, blocks, receipts, tds)
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer e.Close()
+
+	if e.Start() != 100 || e.Count() != 10 {
+		t.Fatalf("range mismatch: have %d+%d, want 100+10", e.Start(), e.Count())
+	}
+	for i, want := range blocks {
+		number := want.NumberU64()
+		block, err := e.GetBlockByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to read block %d: %v", number, err)
+		}
+		if block.Hash() != want.Hash() || block.Transactions()[0].Hash() != want.Transactions()[0].Hash() {
+			t.Errorf("block %d mismatch", number)
+		}
+		have, err := e.GetReceiptsByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to read receipts %d: %v", number, err)
+		}
+		if types.DeriveSha(have, trie.NewStackTrie(nil)) != want.ReceiptHash() {
+			t.Errorf("block %d: receipts mismatch", number)
+		}
+		td, err := e.GetTotalDifficultyByNumber(number)
+		if err != nil {
+			t.Fatalf("failed to read total difficulty %d: %v", number, err)
+		}
+		if td.Cmp(tds[i]) != 0 {
+			t.Errorf("block %d: total difficulty mismatch: have %v, want %v", number, td, tds[i])
+		}
+	}
+	if _, err := e.GetBlockByNumber(110); err == nil {
+		t.Errorf("expected error reading block out of range")
+	}
+	stored, err := e.Accumulator()
+	if err != nil {
+		t.Fatalf("failed to read accumulator: %v", err)
+	}
+	if stored != root {
+		t.Errorf("stored accumulator mismatch: have %x, want %x", stored, root)
+	}
+	verified, err := e.Verify()
+	if err != nil {
+		t.Fatalf("failed to verify archive: %v", err)
+	}
+	if verified != root {
+		t.Errorf("verified accumulator mismatch: have %x, want %x", verified, root)
+	}
+}
+
+func TestEraVerifyTamperedTd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "era-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, receipts, tds := makeChain(4)
+	tds[2] = new(big.Int).Add(tds[2], common.Big1)
+	path, _ := writeArchive(t, dir, blocks, receipts, tds)
+
+	// Overwrite the accumulator with the one of the correct total difficulties
+	_, _, tds = makeChain(4)
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash()
+	}
+	root, _ := ComputeAccumulator(hashes, tds)
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := f.Stat()
+	if _, err := f.WriteAt(root[:], info.Size()-int64(headerSize+16+8*len(blocks))-common.HashLength); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer e.Close()
+
+	if _, err := e.Verify(); err == nil || !strings.Contains(err.Error(), "accumulator mismatch") {
+		t.Fatalf("expected accumulator mismatch, got %v", err)
+	}
+}
+
+func TestComputeAccumulator(t *testing.T) {
+	blocks, _, tds := makeChain(3)
+	hashes := make([]common.Hash, len(blocks))
+	for i, block := range blocks {
+		hashes[i] = block.Hash()
+	}
+	root, err := ComputeAccumulator(hashes, tds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A list with an appended empty record has a different length mixed in
+	extended, _ := ComputeAccumulator(append(hashes, common.Hash{}), append(tds, new(big.Int)))
+	if extended == root {
+		t.Errorf("accumulator ignores the list length")
+	}
+	reordered, _ := ComputeAccumulator([]common.Hash{hashes[1], hashes[0], hashes[2]}, tds)
+	if reordered == root {
+		t.Errorf("accumulator ignores the record order")
+	}
+	if _, err := ComputeAccumulator(hashes, tds[:2]); err == nil {
+		t.Errorf("expected error on count mismatch")
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -567,12 +568,52 @@ type StorageResult struct {
 	Proof []string     `json:"proof"`
 }
 
+// maxProofReexec is the hard cap on the number of blocks re-executed to regenerate
+// pruned historical state for proofs, regardless of the node configuration.
+const maxProofReexec = uint64(128)
+
+// stateRegenerator is implemented by backends able to regenerate the state of a
+// historical block by re-executing the blocks leading to it.
+type stateRegenerator interface {
+	StateAtBlock(ctx context.Context, block *types.Block, reexec uint64) (*state.StateDB, func(), error)
+}
+
+// proofState returns the state of the given block for creating proofs. If the
+// state is not available anymore, it is regenerated if the node allows it and the
+// backend supports it, re-executing at most the configured number of blocks on
+// top of the closest available state. The returned function must be called once
+// the state is not needed anymore.
+func (s *PublicBlockChainAPI) proofState(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, func(), error) {
+	statedb, header, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb != nil && err == nil {
+		return statedb, func() {}, nil
+	}
+	reexec := s.b.RPCProofReexec()
+	if reexec > maxProofReexec {
+		reexec = maxProofReexec
+	}
+	regenerator, ok := s.b.(stateRegenerator)
+	if !ok || reexec == 0 || header == nil {
+		return nil, nil, err
+	}
+	block, berr := s.b.BlockByHash(ctx, header.Hash())
+	if block == nil || berr != nil {
+		return nil, nil, err
+	}
+	return regenerator.StateAtBlock(ctx, block, reexec)
+}
+
 // GetProof returns the Merkle-proof for a given account and optionally some storage keys.
+//
+// The state of historical blocks which has been pruned is only regenerated if the
+// node is configured to, re-executing a limited number of blocks leading to it.
+// Proving state older than that fails with a missing state error.
 func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNrOrHash rpc.BlockNumberOrHash) (*AccountResult, error) {
-	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	state, release, err := s.proofState(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, err
 	}
+	defer release()
 
 	storageTrie := state.StorageTrie(address)
 	storageHash := types.EmptyRootHash
@@ -617,6 +658,104 @@ func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Addre
 	}, state.Error()
 }
 
+// ProofRequest specifies an account and some of its storage slots to be proven.
+type ProofRequest struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []string       `json:"storageKeys"`
+}
+
+// MultiProofResult is a combined Merkle-proof for several accounts and storage
+// slots. The nodes of all account and storage trie proofs are deduplicated into a
+// single list.
+type MultiProofResult struct {
+	Nodes    []string            `json:"nodes"`
+	Accounts []MultiProofAccount `json:"accounts"`
+}
+
+// MultiProofAccount is an account proven by a MultiProofResult.
+type MultiProofAccount struct {
+	Address     common.Address `json:"address"`
+	Balance     *hexutil.Big   `json:"balance"`
+	CodeHash    common.Hash    `json:"codeHash"`
+	Nonce       hexutil.Uint64 `json:"nonce"`
+	StorageHash common.Hash    `json:"storageHash"`
+	Storage     []StorageValue `json:"storage"`
+}
+
+// StorageValue is a storage slot proven by a MultiProofResult.
+type StorageValue struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+}
+
+// proofNodes is a deduplicated list of trie nodes.
+type proofNodes struct {
+	seen  map[common.Hash]struct{}
+	nodes []string
+}
+
+func (p *proofNodes) add(nodes [][]byte) {
+	for _, node := range nodes {
+		hash := crypto.Keccak256Hash(node)
+		if _, ok := p.seen[hash]; !ok {
+			p.seen[hash] = struct{}{}
+			p.nodes = append(p.nodes, hexutil.Encode(node))
+		}
+	}
+}
+
+// GetMultiProof returns a combined Merkle-proof for several accounts and optionally
+// some storage keys of each. Trie nodes shared by the individual proofs are only
+// included once. Pruned historical state is regenerated as for GetProof.
+func (s *PublicBlockChainAPI) GetMultiProof(ctx context.Context, requests []ProofRequest, blockNrOrHash rpc.BlockNumberOrHash) (*MultiProofResult, error) {
+	state, release, err := s.proofState(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var (
+		nodes  = &proofNodes{seen: make(map[common.Hash]struct{})}
+		result = &MultiProofResult{Accounts: make([]MultiProofAccount, len(requests))}
+	)
+	for i, req := range requests {
+		proof, err := state.GetProof(req.Address)
+		if err != nil {
+			return nil, err
+		}
+		nodes.add(proof)
+
+		account := MultiProofAccount{
+			Address:     req.Address,
+			Balance:     (*hexutil.Big)(state.GetBalance(req.Address)),
+			CodeHash:    crypto.Keccak256Hash(nil),
+			Nonce:       hexutil.Uint64(state.GetNonce(req.Address)),
+			StorageHash: types.EmptyRootHash,
+			Storage:     make([]StorageValue, len(req.StorageKeys)),
+		}
+		storageTrie := state.StorageTrie(req.Address)
+		if storageTrie != nil {
+			account.CodeHash = state.GetCodeHash(req.Address)
+			account.StorageHash = storageTrie.Hash()
+		}
+		for j, key := range req.StorageKeys {
+			account.Storage[j] = StorageValue{Key: key, Value: &hexutil.Big{}}
+			if storageTrie == nil {
+				continue
+			}
+			proof, err := state.GetStorageProof(req.Address, common.HexToHash(key))
+			if err != nil {
+				return nil, err
+			}
+			nodes.add(proof)
+			account.Storage[j].Value = (*hexutil.Big)(state.GetState(req.Address, common.HexToHash(key)).Big())
+		}
+		result.Accounts[i] = account
+	}
+	result.Nodes = nodes.nodes
+	return result, state.Error()
+}
+
 // GetHeaderByNumber returns the requested canonical block header.
 // * When blockNr is -1 the chain head is returned.
 // * When blockNr is -2 the pending chain head is returned.
@@ -1078,7 +1217,7 @@ type StructLogRes struct {
 	Gas     uint64             `json:"gas"`
 	GasCost uint64             `json:"gasCost"`
 	Depth   int                `json:"depth"`
-	Error   error              `json:"error,omitempty"`
+	Error   string             `json:"error,omitempty"`
 	Stack   *[]string          `json:"stack,omitempty"`
 	Memory  *[]string          `json:"memory,omitempty"`
 	Storage *map[string]string `json:"storage,omitempty"`
@@ -1094,7 +1233,7 @@ func FormatLogs(logs []vm.StructLog) []StructLogRes {
 			Gas:     trace.Gas,
 			GasCost: trace.GasCost,
 			Depth:   trace.Depth,
-			Error:   trace.Err,
+			Error:   trace.ErrorString(),
 		}
 		if trace.Stack != nil {
 			stack := make([]string, len(trace.Stack))
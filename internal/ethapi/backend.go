@@ -47,6 +47,7 @@ type Backend interface {
 	ExtRPCEnabled() bool
 	RPCGasCap() uint64        // global gas cap for eth_call over rpc: DoS protection
 	RPCTxFeeCap() float64     // global tx fee cap for all transaction related APIs
+	RPCProofReexec() uint64   // blocks re-executed at most to regenerate pruned state for proofs
 	UnprotectedAllowed() bool // allows only for EIP155 transactions.
 
 	// Blockchain API
@@ -18,7 +18,10 @@ package node
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -58,6 +61,29 @@ func TestVhosts(t *testing.T) {
 	assert.Equal(t, resp2.StatusCode, http.StatusForbidden)
 }
 
+// TestGzipResponse makes sure RPC responses of the http server are compressed once.
+func TestGzipResponse(t *testing.T) {
+	srv := createAndStartServer(t, &httpConfig{}, false, &wsConfig{})
+	defer srv.stop()
+	url := "http://" + srv.listenAddr()
+
+	resp := rpcRequest(t, url, "accept-encoding", "gzip")
+	defer resp.Body.Close()
+	assert.Equal(t, "gzip", resp.Header.Get("content-encoding"))
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal("invalid gzip stream:", err)
+	}
+	body, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal("invalid gzip stream:", err)
+	}
+	if !json.Valid(body) {
+		t.Fatalf("response not plain JSON after decompression: %q", body)
+	}
+}
+
 type originTest struct {
 	spec    string
 	expOk   []string
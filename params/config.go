@@ -17,9 +17,11 @@
 package params
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"sort"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -244,16 +246,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil, nil, false}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil, false}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil, nil, false}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -332,6 +334,21 @@ type ChainConfig struct {
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+
+	// Precompiles schedules custom precompiled contracts, which have to be registered
+	// with the EVM by name. Only intended for private networks.
+	Precompiles map[common.Address]*PrecompileConfig `json:"precompiles,omitempty"`
+
+	// BinaryTrie commits to the state using binary Merkle tries hashed with SHA-256
+	// instead of Merkle-Patricia tries. It can only be set from genesis and is only
+	// intended for research networks.
+	BinaryTrie bool `json:"binaryTrie,omitempty"`
+}
+
+// PrecompileConfig schedules a custom precompiled contract at an address.
+type PrecompileConfig struct {
+	Name  string   `json:"name"`            // Name the contract was registered with in package vm
+	Block *big.Int `json:"block,omitempty"` // Activation block (nil = disabled, 0 = active from genesis)
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
@@ -445,6 +462,31 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// ActivePrecompile returns the name of the custom precompiled contract active at
+// the given address and block number, if any.
+func (c *ChainConfig) ActivePrecompile(addr common.Address, num *big.Int) (string, bool) {
+	p, ok := c.Precompiles[addr]
+	if !ok || !isForked(p.Block, num) {
+		return "", false
+	}
+	return p.Name, true
+}
+
+// ActivePrecompiles returns the addresses of all custom precompiled contracts active
+// at the given block number, in ascending order.
+func (c *ChainConfig) ActivePrecompiles(num *big.Int) []common.Address {
+	var addrs []common.Address
+	for addr, p := range c.Precompiles {
+		if isForked(p.Block, num) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -556,6 +598,38 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	for addr := range c.Precompiles {
+		if err := checkPrecompileCompatible(addr, c.Precompiles[addr], newcfg.Precompiles[addr], head); err != nil {
+			return err
+		}
+	}
+	for addr := range newcfg.Precompiles {
+		if _, ok := c.Precompiles[addr]; !ok {
+			if err := checkPrecompileCompatible(addr, nil, newcfg.Precompiles[addr], head); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPrecompileCompatible checks whether the custom precompile scheduled at addr
+// can be rescheduled or swapped for another contract.
+func checkPrecompileCompatible(addr common.Address, stored, updated *PrecompileConfig, head *big.Int) *ConfigCompatError {
+	var storedBlock, newBlock *big.Int
+	if stored != nil {
+		storedBlock = stored.Block
+	}
+	if updated != nil {
+		newBlock = updated.Block
+	}
+	what := fmt.Sprintf("precompile %s activation block", addr.Hex())
+	if isForkIncompatible(storedBlock, newBlock, head) {
+		return newCompatError(what, storedBlock, newBlock)
+	}
+	if isForked(storedBlock, head) && stored.Name != updated.Name {
+		return newCompatError(fmt.Sprintf("precompile %s contract", addr.Hex()), storedBlock, newBlock)
+	}
 	return nil
 }
 
@@ -20,6 +20,8 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
@@ -87,6 +89,34 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     30,
 			},
 		},
+		{
+			stored:  &ChainConfig{Precompiles: map[common.Address]*PrecompileConfig{{0x01, 0x00}: {Name: "a", Block: big.NewInt(10)}}},
+			new:     &ChainConfig{Precompiles: map[common.Address]*PrecompileConfig{{0x01, 0x00}: {Name: "b", Block: big.NewInt(20)}}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{},
+			new:    &ChainConfig{Precompiles: map[common.Address]*PrecompileConfig{{0x01, 0x00}: {Name: "a", Block: big.NewInt(10)}}},
+			head:   20,
+			wantErr: &ConfigCompatError{
+				What:         "precompile 0x0100000000000000000000000000000000000000 activation block",
+				StoredConfig: nil,
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{Precompiles: map[common.Address]*PrecompileConfig{{0x01, 0x00}: {Name: "a", Block: big.NewInt(10)}}},
+			new:    &ChainConfig{Precompiles: map[common.Address]*PrecompileConfig{{0x01, 0x00}: {Name: "b", Block: big.NewInt(10)}}},
+			head:   20,
+			wantErr: &ConfigCompatError{
+				What:         "precompile 0x0100000000000000000000000000000000000000 contract",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -18,17 +18,24 @@ package rpc
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
@@ -131,6 +138,19 @@ func DialHTTP(endpoint string) (*Client, error) {
 	return DialHTTPWithClient(endpoint, new(http.Client))
 }
 
+// DialH2C creates a new RPC client that connects to an RPC server over cleartext
+// HTTP/2 (h2c). All calls are multiplexed over a single long-lived connection, so
+// the server must accept HTTP/2 with prior knowledge (see Server.H2CHandler).
+func DialH2C(endpoint string) (*Client, error) {
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	return DialHTTPWithClient(endpoint, &http.Client{Transport: transport})
+}
+
 func (c *Client) sendHTTP(ctx context.Context, op *requestOp, msg interface{}) error {
 	hc := c.writeConn.(*httpConn)
 	respBody, err := hc.doRequest(ctx, msg)
@@ -193,10 +213,39 @@ func (hc *httpConn) doRequest(ctx context.Context, msg interface{}) (io.ReadClos
 	if err != nil {
 		return nil, err
 	}
+	respBody := resp.Body
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get("content-encoding"), "gzip") {
+		// The transport only decompresses transparently if it requested gzip
+		// itself, handle responses to user-supplied Accept-Encoding headers.
+		if respBody, err = newGzipReadCloser(resp.Body); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return resp.Body, errors.New(resp.Status)
+		return respBody, errors.New(resp.Status)
+	}
+	return respBody, nil
+}
+
+// gzipReadCloser decompresses a gzip encoded response body, closing the
+// underlying body when done.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func newGzipReadCloser(body io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
 	}
-	return resp.Body, nil
+	return &gzipReadCloser{Reader: zr, body: body}, nil
+}
+
+func (r *gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
 }
 
 // httpServerConn turns a HTTP connection into a Conn.
@@ -249,11 +298,95 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("content-type", contentType)
+
+	// Compress the response if the client supports it
+	if acceptsGzip(r) && w.Header().Get("content-encoding") == "" {
+		gw := newGzipResponseWriter(w)
+		defer gw.close()
+		w = gw
+	}
 	codec := newHTTPServerConn(r, w)
 	defer codec.close()
 	s.serveSingleRequest(ctx, codec)
 }
 
+// H2CHandler returns a handler serving JSON-RPC requests over both HTTP/1.1 and
+// cleartext HTTP/2. HTTP/2 clients may connect with prior knowledge or via the
+// h2c upgrade mechanism and multiplex any number of calls on one connection.
+func (s *Server) H2CHandler() http.Handler {
+	return h2c.NewHandler(s, new(http2.Server))
+}
+
+var gzPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(ioutil.Discard)
+	},
+}
+
+// gzipResponseWriter compresses everything written to the response body.
+type gzipResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+// newGzipResponseWriter marks the response as gzip encoded and wraps it with a
+// pooled compressor, which must be released by calling close.
+func newGzipResponseWriter(w http.ResponseWriter) *gzipResponseWriter {
+	w.Header().Set("content-encoding", "gzip")
+	w.Header().Add("vary", "accept-encoding")
+
+	gz := gzPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+	return &gzipResponseWriter{Writer: gz, ResponseWriter: w}
+}
+
+// close flushes the compressed stream and returns the compressor to the pool.
+func (w *gzipResponseWriter) close() {
+	gz := w.Writer.(*gzip.Writer)
+	gz.Close()
+	gzPool.Put(gz)
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.Header().Del("content-length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write(b)
+}
+
+// NewGzipHandler wraps an HTTP handler, compressing its responses for clients
+// accepting gzip. The JSON-RPC server compresses its responses by itself, this
+// is meant for the other handlers served next to it.
+func NewGzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || w.Header().Get("content-encoding") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := newGzipResponseWriter(w)
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request allows a gzip encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("accept-encoding"), ",") {
+		if i := strings.IndexByte(enc, ';'); i >= 0 {
+			if strings.TrimSpace(enc[i+1:]) == "q=0" {
+				continue
+			}
+			enc = enc[:i]
+		}
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
 // validateRequest returns a non-zero response code and error message if the
 // request is invalid.
 func validateRequest(r *http.Request) (int, error) {
@@ -17,8 +17,11 @@
 package rpc
 
 import (
+	"compress/gzip"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -123,3 +126,128 @@ func TestHTTPRespBodyUnlimited(t *testing.T) {
 		t.Fatalf("response has wrong length %d, want %d", len(r), respLength)
 	}
 }
+
+// This checks that responses are gzip encoded when the client asks for it.
+func TestHTTPGzipResponse(t *testing.T) {
+	s := newTestServer()
+	defer s.Stop()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{
+			body: `{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["x",1]}`,
+			want: `{"jsonrpc":"2.0","id":1,"result":{"String":"x","Int":1,"Args":null}}`,
+		},
+		{
+			body: `[{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["x",1]},{"jsonrpc":"2.0","id":2,"method":"test_returnError"}]`,
+			want: `[{"jsonrpc":"2.0","id":1,"result":{"String":"x","Int":1,"Args":null}},{"jsonrpc":"2.0","id":2,"error":{"code":444,"message":"testError","data":"testError data"}}]`,
+		},
+		{
+			body: `{"jsonrpc":"2.0","id":1,"method":"test_unknown"}`,
+			want: `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method test_unknown does not exist/is not available"}}`,
+		},
+	}
+	for i, test := range tests {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(test.body))
+		req.Header.Set("content-type", contentType)
+		req.Header.Set("accept-encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("test %d: request failed: %v", i, err)
+		}
+		if enc := resp.Header.Get("content-encoding"); enc != "gzip" {
+			t.Fatalf("test %d: wrong content encoding %q", i, enc)
+		}
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatalf("test %d: invalid gzip stream: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(zr)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("test %d: can't read response: %v", i, err)
+		}
+		if strings.TrimSpace(string(got)) != test.want {
+			t.Errorf("test %d: wrong response\ngot:  %s\nwant: %s", i, got, test.want)
+		}
+	}
+}
+
+// This checks that the client decodes gzip responses even when the user overrides
+// the Accept-Encoding header and the transport doesn't decompress transparently.
+func TestHTTPClientGzip(t *testing.T) {
+	s := newTestServer()
+	defer s.Stop()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	c, err := DialHTTP(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetHeader("accept-encoding", "gzip")
+
+	var res echoResult
+	if err := c.Call(&res, "test_echo", "x", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res.String != "x" || res.Int != 1 {
+		t.Fatalf("wrong result: %+v", res)
+	}
+	if err := c.Call(nil, "test_returnError"); err == nil || err.Error() != "testError" {
+		t.Fatalf("wrong error: %v", err)
+	}
+	batch := []BatchElem{
+		{Method: "test_echo", Args: []interface{}{"y", 2}, Result: new(echoResult)},
+		{Method: "test_returnError", Result: new(string)},
+	}
+	if err := c.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&echoResult{String: "y", Int: 2}); !reflect.DeepEqual(batch[0].Result, want) {
+		t.Errorf("wrong batch result: %+v", batch[0].Result)
+	}
+	if batch[1].Error == nil || batch[1].Error.Error() != "testError" {
+		t.Errorf("wrong batch error: %v", batch[1].Error)
+	}
+}
+
+// This checks that calls and batches work over cleartext HTTP/2.
+func TestHTTPH2C(t *testing.T) {
+	s := newTestServer()
+	defer s.Stop()
+	ts := httptest.NewServer(s.H2CHandler())
+	defer ts.Close()
+
+	c, err := DialH2C(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var res echoResult
+	if err := c.Call(&res, "test_echo", "x", 1); err != nil {
+		t.Fatal(err)
+	}
+	if res.String != "x" || res.Int != 1 {
+		t.Fatalf("wrong result: %+v", res)
+	}
+	batch := []BatchElem{
+		{Method: "test_echo", Args: []interface{}{"y", 2}, Result: new(echoResult)},
+		{Method: "test_returnError", Result: new(string)},
+	}
+	if err := c.BatchCall(batch); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&echoResult{String: "y", Int: 2}); !reflect.DeepEqual(batch[0].Result, want) {
+		t.Errorf("wrong batch result: %+v", batch[0].Result)
+	}
+	if batch[1].Error == nil || batch[1].Error.Error() != "testError" {
+		t.Errorf("wrong batch error: %v", batch[1].Error)
+	}
+}
@@ -94,7 +94,14 @@ type btHeaderMarshaling struct {
 	Timestamp  math.HexOrDecimal64
 }
 
-func (t *BlockTest) Run(snapshotter bool) error {
+// Network returns the name of the fork the test is defined for.
+func (t *BlockTest) Network() string {
+	return t.json.Network
+}
+
+// Run imports the blocks of the test and validates the resulting chain and state.
+// If a tracer is given, the execution of all the transactions is traced with it.
+func (t *BlockTest) Run(snapshotter bool, tracer vm.Tracer) error {
 	config, ok := Forks[t.json.Network]
 	if !ok {
 		return UnsupportedForkError{t.json.Network}
@@ -123,7 +130,7 @@ func (t *BlockTest) Run(snapshotter bool) error {
 		cache.SnapshotLimit = 1
 		cache.SnapshotWait = true
 	}
-	chain, err := core.NewBlockChain(db, cache, config, engine, vm.Config{}, nil, nil)
+	chain, err := core.NewBlockChain(db, cache, config, engine, vm.Config{Debug: tracer != nil, Tracer: tracer}, nil, nil)
 	if err != nil {
 		return err
 	}
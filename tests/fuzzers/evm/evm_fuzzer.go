@@ -0,0 +1,367 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package evm implements a differential fuzzer for the EVM. Every input is decoded
+// into a pair of contracts and executed with different interpreter configurations,
+// which must all agree on the outcome.
+package evm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// Forks is the list of forks the programs are executed under, selected by the
+// first byte of the input.
+var Forks = []string{
+	"Frontier", "Homestead", "EIP150", "EIP158", "Byzantium",
+	"Constantinople", "ConstantinopleFix", "Istanbul", "Berlin",
+}
+
+// extraEips is the list of EIPs enabled on top of the fork of a program to run a
+// variant of its jump table, selected by the second byte of the input. They are
+// mapped to the index of the first fork they can be applied to, as some modify
+// opcodes introduced by Constantinople.
+var extraEips = []struct {
+	eip  int
+	fork int
+}{{1344, 0}, {1884, 5}, {2200, 0}, {2929, 5}}
+
+var (
+	origin = common.HexToAddress("0x00000000000000000000000000000000000000a0")
+	caller = common.HexToAddress("0x00000000000000000000000000000000000000c0")
+	callee = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+)
+
+// headerSize is the number of bytes preceding the code in a fuzzer input.
+const headerSize = 5
+
+// Program is a fuzzer input decoded into the contracts to execute and the
+// environment to execute them in.
+type Program struct {
+	Fork   string // Name of the fork to execute the program with
+	Eips   []int  // Extra EIPs enabled for the jump table variant run
+	Gas    uint64 // Gas available to the execution
+	Caller []byte // Code of the contract being called
+	Callee []byte // Code of a second contract, callable from the first one
+}
+
+// Decode interprets the fuzzer input as a program. The input consists of
+//
+//	[fork] [eips] [gas/4 (2 bytes)] [callee length] [callee code] [caller code]
+//
+// It returns nil if the input is too short to contain a program.
+func Decode(input []byte) *Program {
+	if len(input) < headerSize {
+		return nil
+	}
+	fork := int(input[0]) % len(Forks)
+	prog := &Program{
+		Fork: Forks[fork],
+		Gas:  uint64(binary.BigEndian.Uint16(input[2:4]))/4 + 1,
+	}
+	for i, extra := range extraEips {
+		if input[1]&(1<<uint(i)) != 0 && fork >= extra.fork {
+			prog.Eips = append(prog.Eips, extra.eip)
+		}
+	}
+	code := input[headerSize:]
+	split := int(input[4])
+	if split > len(code) {
+		split = len(code)
+	}
+	prog.Callee, prog.Caller = code[:split], code[split:]
+	return prog
+}
+
+// Outcome is the observable result of executing a program.
+type Outcome struct {
+	Ret     string
+	GasLeft uint64
+	Err     string
+	Root    common.Hash
+	Logs    common.Hash
+	Refund  uint64
+}
+
+// String implements fmt.Stringer.
+func (o *Outcome) String() string {
+	return fmt.Sprintf("ret=%s gasleft=%d err=%q root=%x logs=%x refund=%d", o.Ret, o.GasLeft, o.Err, o.Root, o.Logs, o.Refund)
+}
+
+// Fuzz is the go-fuzz entry point. It panics if the configurations disagree on
+// the execution of the program.
+//
+// This returns 1 for programs which executed successfully, 0 otherwise
+func Fuzz(input []byte) int {
+	prog := Decode(input)
+	if prog == nil {
+		return -1
+	}
+	outcome, err := Check(prog)
+	if err != nil {
+		panic(err)
+	}
+	if outcome.Err != "" {
+		return 0
+	}
+	return 1
+}
+
+// Check executes the program with all the interpreter configurations and checks
+// that they produce the same outcome, that the traces are consistent and that
+// gas is accounted for monotonically. The outcome of the reference execution is
+// returned, or an error describing the first violated invariant.
+func Check(prog *Program) (*Outcome, error) {
+	config, _, err := tests.GetChainConfig(prog.Fork)
+	if err != nil {
+		return nil, err
+	}
+	root, db := prestate(prog)
+
+	// Execute the program with the plain interpreter as the reference
+	want, err := execute(prog, config, root, db, vm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if have, err := execute(prog, config, root, db, vm.Config{}); err != nil {
+		return nil, err
+	} else if *have != *want {
+		return nil, fmt.Errorf("non-deterministic execution:\nhave %v\nwant %v", have, want)
+	}
+	// Execution without the code analysis must be identical
+	if have, err := execute(prog, config, root, db, vm.Config{DisableCodeAnalysis: true}); err != nil {
+		return nil, err
+	} else if *have != *want {
+		return nil, fmt.Errorf("code analysis mismatch:\nhave %v\nwant %v", have, want)
+	}
+	// Execution with tracers attached must be identical, with consistent traces
+	logger := vm.NewStructLogger(nil)
+	if have, err := execute(prog, config, root, db, vm.Config{Debug: true, Tracer: logger}); err != nil {
+		return nil, err
+	} else if *have != *want {
+		return nil, fmt.Errorf("struct logger mismatch:\nhave %v\nwant %v", have, want)
+	}
+	if err := checkGas(logger.StructLogs(), prog.Gas); err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if have, err := execute(prog, config, root, db, vm.Config{Debug: true, Tracer: vm.NewJSONLogger(nil, out)}); err != nil {
+		return nil, err
+	} else if *have != *want {
+		return nil, fmt.Errorf("json logger mismatch:\nhave %v\nwant %v", have, want)
+	}
+	if err := checkJSON(out.Bytes(), logger.StructLogs(), want, prog.Gas); err != nil {
+		return nil, err
+	}
+	// Running a variant of the jump table must not affect the original one
+	if len(prog.Eips) > 0 {
+		if _, err := execute(prog, config, root, db, vm.Config{ExtraEips: prog.Eips}); err != nil {
+			return nil, err
+		}
+		if have, err := execute(prog, config, root, db, vm.Config{}); err != nil {
+			return nil, err
+		} else if *have != *want {
+			return nil, fmt.Errorf("jump table modified by eips %v:\nhave %v\nwant %v", prog.Eips, have, want)
+		}
+	}
+	return want, nil
+}
+
+// prestate creates the state the programs are executed on, returning its root
+// and the database containing it.
+func prestate(prog *Program) (common.Hash, state.Database) {
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+
+	statedb.SetBalance(origin, big.NewInt(params.Ether))
+	for _, addr := range []common.Address{caller, callee} {
+		statedb.SetBalance(addr, big.NewInt(params.GWei))
+		statedb.SetNonce(addr, 1)
+		for i := byte(1); i <= 4; i++ {
+			statedb.SetState(addr, common.Hash{31: i}, common.Hash{31: i})
+		}
+	}
+	statedb.SetCode(caller, prog.Caller)
+	statedb.SetCode(callee, prog.Callee)
+
+	root, _ := statedb.Commit(true)
+	db.TrieDB().Commit(root, false, nil)
+	return root, db
+}
+
+// execute runs the program on top of the given state with the given interpreter
+// configuration.
+func execute(prog *Program, config *params.ChainConfig, root common.Hash, db state.Database, vmconfig vm.Config) (*Outcome, error) {
+	statedb, err := state.New(root, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	statedb.Prepare(common.Hash{0x01}, common.Hash{0x02}, 0)
+
+	var (
+		number  = new(big.Int)
+		context = vm.BlockContext{
+			CanTransfer: core.CanTransfer,
+			Transfer:    core.Transfer,
+			GetHash: func(n uint64) common.Hash {
+				return crypto.Keccak256Hash(new(big.Int).SetUint64(n).Bytes())
+			},
+			Coinbase:    common.Address{0xcb},
+			BlockNumber: number,
+			Time:        big.NewInt(1000),
+			Difficulty:  big.NewInt(0x20000),
+			GasLimit:    math.MaxUint64,
+		}
+		txContext = vm.TxContext{Origin: origin, GasPrice: big.NewInt(1)}
+		evm       = vm.NewEVM(context, txContext, statedb, config, vmconfig)
+	)
+	// The access list is only charged for with EIP-2929, which may be enabled on
+	// top of earlier forks, so prepare it regardless of the fork
+	statedb.PrepareAccessList(origin, &caller, evm.ActivePrecompiles(), nil)
+
+	ret, gas, err := evm.Call(vm.AccountRef(origin), caller, nil, prog.Gas, new(big.Int))
+
+	outcome := &Outcome{
+		Ret:     hexutil.Encode(ret),
+		GasLeft: gas,
+		Root:    statedb.IntermediateRoot(config.IsEIP158(number)),
+		Refund:  statedb.GetRefund(),
+	}
+	if err != nil {
+		outcome.Err = err.Error()
+	}
+	logs, err := rlp.EncodeToBytes(statedb.Logs())
+	if err != nil {
+		return nil, err
+	}
+	outcome.Logs = crypto.Keccak256Hash(logs)
+	return outcome, nil
+}
+
+// isCall returns whether the opcode transfers gas to a child frame, which may
+// return some of it.
+func isCall(op vm.OpCode) bool {
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.CREATE, vm.CREATE2:
+		return true
+	}
+	return false
+}
+
+// checkGas verifies that the gas reported by the steps of a trace is consistent:
+// it never exceeds the gas given to the program, never increases within a call
+// frame and decreases exactly by the cost of the step for the opcodes which
+// don't start child frames.
+func checkGas(logs []vm.StructLog, gas uint64) error {
+	for i, step := range logs {
+		if step.Gas > gas {
+			return fmt.Errorf("step %d (%v): gas %d exceeds the available %d", i, step.Op, step.Gas, gas)
+		}
+		if i == len(logs)-1 || step.Err != nil {
+			continue
+		}
+		next := logs[i+1]
+		if next.Depth != step.Depth {
+			continue
+		}
+		if next.Gas > step.Gas {
+			return fmt.Errorf("step %d (%v): gas increased from %d to %d", i, step.Op, step.Gas, next.Gas)
+		}
+		if !isCall(step.Op) && next.Gas != step.Gas-step.GasCost {
+			return fmt.Errorf("step %d (%v): gas %d with cost %d followed by %d", i, step.Op, step.Gas, step.GasCost, next.Gas)
+		}
+	}
+	return nil
+}
+
+// checkJSON verifies that the output of the JSON logger is well formed and agrees
+// with the struct logger on the executed steps and with the outcome on the gas
+// used.
+func checkJSON(out []byte, logs []vm.StructLog, outcome *Outcome, gas uint64) error {
+	var (
+		scanner = bufio.NewScanner(bytes.NewReader(out))
+		steps   int
+		ended   bool
+	)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if ended {
+			return fmt.Errorf("json trace continues after the summary: %s", line)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return fmt.Errorf("invalid json trace line %q: %v", line, err)
+		}
+		if _, ok := fields["gasUsed"]; ok {
+			var end struct {
+				GasUsed math.HexOrDecimal64 `json:"gasUsed"`
+				Output  string              `json:"output"`
+			}
+			if err := json.Unmarshal(line, &end); err != nil {
+				return fmt.Errorf("invalid json trace summary %q: %v", line, err)
+			}
+			if used := uint64(end.GasUsed); used != gas-outcome.GasLeft {
+				return fmt.Errorf("json trace gas used mismatch: have %d, want %d", used, gas-outcome.GasLeft)
+			}
+			if _, err := hexutil.Decode("0x" + end.Output); end.Output != "" && err != nil {
+				return fmt.Errorf("invalid json trace output %q: %v", end.Output, err)
+			}
+			ended = true
+			continue
+		}
+		var step vm.StructLog
+		if err := json.Unmarshal(line, &step); err != nil {
+			return fmt.Errorf("invalid json trace step %q: %v", line, err)
+		}
+		if steps >= len(logs) {
+			return fmt.Errorf("json trace has more steps than the struct logs (%d)", len(logs))
+		}
+		want := logs[steps]
+		if step.Pc != want.Pc || step.Op != want.Op || step.Gas != want.Gas || step.GasCost != want.GasCost || step.Depth != want.Depth {
+			return fmt.Errorf("json trace step %d mismatch: have pc=%d op=%v gas=%d cost=%d depth=%d, want pc=%d op=%v gas=%d cost=%d depth=%d",
+				steps, step.Pc, step.Op, step.Gas, step.GasCost, step.Depth, want.Pc, want.Op, want.Gas, want.GasCost, want.Depth)
+		}
+		steps++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if steps != len(logs) {
+		return fmt.Errorf("json trace step count mismatch: have %d, want %d", steps, len(logs))
+	}
+	if !ended && len(logs) > 0 {
+		return fmt.Errorf("json trace summary missing")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package evm
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FuzzEVM runs the differential checks on the fuzzer inputs.
+func FuzzEVM(f *testing.F) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 16; i++ {
+		f.Add(Generate(r))
+	}
+	// Storage writes in the callee with the jump table of all the extra eips
+	f.Add(common.FromHex("0x080f200006600160015500" + "6000600060006000600060bb5af100"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		prog := Decode(input)
+		if prog == nil {
+			return
+		}
+		if _, err := Check(prog); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"math/rand"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// maxCodeSize is the maximum size of a generated contract.
+const maxCodeSize = 192
+
+// opcodes is the list of opcodes randomly inserted into the generated code, the
+// ones defined in all forks and taking their arguments from the stack.
+var opcodes = []vm.OpCode{
+	vm.ADD, vm.MUL, vm.SUB, vm.DIV, vm.SDIV, vm.MOD, vm.SMOD, vm.ADDMOD, vm.MULMOD, vm.EXP, vm.SIGNEXTEND,
+	vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ, vm.ISZERO, vm.AND, vm.OR, vm.XOR, vm.NOT, vm.BYTE, vm.SHA3,
+	vm.ADDRESS, vm.BALANCE, vm.ORIGIN, vm.CALLER, vm.CALLVALUE, vm.CALLDATALOAD, vm.CALLDATASIZE,
+	vm.CODESIZE, vm.GASPRICE, vm.EXTCODESIZE, vm.BLOCKHASH, vm.COINBASE, vm.TIMESTAMP, vm.NUMBER,
+	vm.DIFFICULTY, vm.GASLIMIT, vm.POP, vm.MSIZE, vm.GAS, vm.PC, vm.DUP1, vm.DUP2, vm.DUP4, vm.SWAP1,
+	vm.SWAP3, vm.LOG0, vm.LOG1,
+}
+
+// Generate creates a random fuzzer input. Unlike purely random bytes, the code
+// of the generated contracts is biased towards valid and interesting programs:
+// small pushes, jumps to jump destinations, storage accesses and calls between
+// the two contracts.
+func Generate(r *rand.Rand) []byte {
+	var (
+		callee = generateCode(r)
+		caller = generateCode(r)
+	)
+	input := []byte{
+		byte(r.Intn(len(Forks))),
+		byte(r.Intn(1 << uint(len(extraEips)))),
+		byte(r.Intn(256)), byte(r.Intn(256)),
+		byte(len(callee)),
+	}
+	input = append(input, callee...)
+	return append(input, caller...)
+}
+
+// generateCode creates the random code of a single contract.
+func generateCode(r *rand.Rand) []byte {
+	var (
+		code  []byte
+		dests []int // Positions of the pushes of jump destinations to patch
+		size  = r.Intn(maxCodeSize)
+	)
+	// Fill the stack with a few small values to make underflows unlikely
+	for i := 0; i < 8; i++ {
+		code = append(code, byte(vm.PUSH1), byte(r.Intn(64)))
+	}
+	for len(code) < size {
+		switch r.Intn(10) {
+		case 0:
+			// Jump destination, optionally jumped to from a later jump
+			code = append(code, byte(vm.JUMPDEST))
+
+		case 1:
+			// Jump to a random jump destination, patched once the code is done
+			dests = append(dests, len(code)+1)
+			code = append(code, byte(vm.PUSH1), 0)
+			if r.Intn(2) == 0 {
+				code = append(code, byte(vm.JUMP))
+			} else {
+				code = append(code, byte(vm.PUSH1), byte(r.Intn(2)), byte(vm.SWAP1), byte(vm.JUMPI))
+			}
+		case 2:
+			// Storage access to one of the prefilled slots or a fresh one
+			code = append(code, byte(vm.PUSH1), byte(r.Intn(6)), byte(vm.PUSH1), byte(r.Intn(6)))
+			if r.Intn(2) == 0 {
+				code = append(code, byte(vm.SSTORE))
+			} else {
+				code = append(code, byte(vm.POP), byte(vm.SLOAD))
+			}
+		case 3:
+			// Call the other contract, with all or some of the gas
+			op := []vm.OpCode{vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL}[r.Intn(4)]
+			code = append(code, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0)
+			if op == vm.CALL || op == vm.CALLCODE {
+				code = append(code, byte(vm.PUSH1), byte(r.Intn(2)))
+			}
+			code = append(code, byte(vm.PUSH1), callee[19], byte(vm.GAS), byte(op))
+
+		case 4:
+			// Memory access, possibly expanding the memory
+			code = append(code, byte(vm.PUSH1), byte(r.Intn(256)), byte(vm.PUSH2), byte(r.Intn(4)), byte(r.Intn(256)))
+			code = append(code, []byte{byte(vm.MSTORE), byte(vm.MLOAD), byte(vm.MSTORE8)}[r.Intn(3)])
+
+		case 5:
+			// Small push
+			code = append(code, byte(vm.PUSH1), byte(r.Intn(256)))
+
+		case 6:
+			// Any opcode, possibly undefined in the fork
+			code = append(code, byte(r.Intn(256)))
+
+		default:
+			// Any opcode valid in all forks
+			code = append(code, byte(opcodes[r.Intn(len(opcodes))]))
+		}
+	}
+	// Point the jumps to jump destinations if there are any
+	var jumpdests []int
+	for i := 0; i < len(code); i++ {
+		op := vm.OpCode(code[i])
+		if op == vm.JUMPDEST {
+			jumpdests = append(jumpdests, i)
+		}
+		if op.IsPush() {
+			i += int(op - vm.PUSH1 + 1)
+		}
+	}
+	if len(jumpdests) > 0 {
+		for _, pos := range dests {
+			if dest := jumpdests[r.Intn(len(jumpdests))]; dest < 256 {
+				code[pos] = byte(dest)
+			}
+		}
+	}
+	return code
+}
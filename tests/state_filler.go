@@ -0,0 +1,427 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// fillerForks are the forks a network of an expect section can refer to with a
+// comparison, e.g. ">=Byzantium", in order.
+var fillerForks = []string{
+	"Frontier",
+	"Homestead",
+	"EIP150",
+	"EIP158",
+	"Byzantium",
+	"Constantinople",
+	"ConstantinopleFix",
+	"Istanbul",
+	"Berlin",
+}
+
+// StateFiller is the template of a state test: the pre-state, the environment and
+// the matrix of transactions to execute, along with the expected outcome on each
+// fork. Filling it computes the post-state roots and logs hashes of the state test.
+type StateFiller struct {
+	json sfJSON
+}
+
+func (f *StateFiller) UnmarshalJSON(in []byte) error {
+	return json.Unmarshal(in, &f.json)
+}
+
+type sfJSON struct {
+	Env    stEnv                        `json:"env"`
+	Pre    map[common.Address]sfAccount `json:"pre"`
+	Tx     stTransaction                `json:"transaction"`
+	Expect []sfExpect                   `json:"expect"`
+}
+
+// sfAccount is an account of the pre-state, or the expected state of one. Numbers
+// are hex or decimal, and the code is either hex encoded, prefixed by ":raw", or
+// assembly prefixed by ":asm". Missing fields are not checked in expectations.
+type sfAccount struct {
+	Balance        *string           `json:"balance"`
+	Nonce          *string           `json:"nonce"`
+	Code           *string           `json:"code"`
+	Storage        map[string]string `json:"storage"`
+	ShouldNotExist bool              `json:"shouldnotexist"`
+}
+
+// sfExpect is an expect section of a filler, the expected state after the
+// transactions selected by the indexes on the given networks.
+type sfExpect struct {
+	Indexes struct {
+		Data  sfIndexes `json:"data"`
+		Gas   sfIndexes `json:"gas"`
+		Value sfIndexes `json:"value"`
+	} `json:"indexes"`
+	Network []string                     `json:"network"`
+	Result  map[common.Address]sfAccount `json:"result"`
+}
+
+// sfIndexes selects transaction data, gas limits or values by their indexes, with
+// -1 or no index at all selecting all of them.
+type sfIndexes []int
+
+func (ix *sfIndexes) UnmarshalJSON(input []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(input, &raw); err != nil {
+		return err
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+	for _, item := range items {
+		var index int
+		switch item := item.(type) {
+		case float64:
+			index = int(item)
+		case string:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return fmt.Errorf("invalid index %q", item)
+			}
+			index = n
+		default:
+			return fmt.Errorf("invalid index %v", item)
+		}
+		*ix = append(*ix, index)
+	}
+	return nil
+}
+
+func (ix sfIndexes) matches(index int) bool {
+	if len(ix) == 0 {
+		return true
+	}
+	for _, i := range ix {
+		if i == -1 || i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Forks returns the forks the filler has expectations for, in order.
+func (f *StateFiller) Forks() ([]string, error) {
+	set := make(map[string]bool)
+	for _, expect := range f.json.Expect {
+		forks, err := expandNetworks(expect.Network)
+		if err != nil {
+			return nil, err
+		}
+		for _, fork := range forks {
+			set[fork] = true
+		}
+	}
+	var forks []string
+	for _, fork := range fillerForks {
+		if set[fork] {
+			forks = append(forks, fork)
+			delete(set, fork)
+		}
+	}
+	// Add any other forks, e.g. with extra EIPs, after the main ones
+	var extra []string
+	for fork := range set {
+		extra = append(extra, fork)
+	}
+	sort.Strings(extra)
+	return append(forks, extra...), nil
+}
+
+// expandNetworks returns the forks the given networks of an expect section refer
+// to. A network is either the name of a fork, or a comparison with one of the
+// fillerForks, e.g. ">=Istanbul".
+func expandNetworks(networks []string) ([]string, error) {
+	var forks []string
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+
+		var op string
+		for _, prefix := range []string{">=", "<=", ">", "<"} {
+			if strings.HasPrefix(network, prefix) {
+				op, network = prefix, strings.TrimSpace(network[len(prefix):])
+				break
+			}
+		}
+		if op == "" {
+			if _, _, err := GetChainConfig(network); err != nil {
+				return nil, err
+			}
+			forks = append(forks, network)
+			continue
+		}
+		pos := -1
+		for i, fork := range fillerForks {
+			if fork == network {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return nil, fmt.Errorf("invalid network %s%s", op, network)
+		}
+		for i, fork := range fillerForks {
+			if (op == ">=" && i >= pos) || (op == ">" && i > pos) || (op == "<=" && i <= pos) || (op == "<" && i < pos) {
+				forks = append(forks, fork)
+			}
+		}
+	}
+	return forks, nil
+}
+
+// Fill executes the transactions selected by the expect sections of the filler on
+// every fork they apply to, checks the resulting states against the expectations
+// and returns the state test with the post-state roots and logs hashes.
+func (f *StateFiller) Fill(vmconfig vm.Config) (*StateTest, error) {
+	test := &StateTest{json: stJSON{
+		Env:  f.json.Env,
+		Pre:  make(core.GenesisAlloc),
+		Tx:   f.json.Tx,
+		Post: make(map[string][]stPostState),
+	}}
+	// Resolve the pre-state and the transaction data
+	for addr, account := range f.json.Pre {
+		alloc, err := account.genesisAccount()
+		if err != nil {
+			return nil, fmt.Errorf("invalid pre-state of %#x: %v", addr, err)
+		}
+		test.json.Pre[addr] = alloc
+	}
+	test.json.Tx.Data = make([]string, len(f.json.Tx.Data))
+	for i, data := range f.json.Tx.Data {
+		code, err := fillerCode(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction data %d: %v", i, err)
+		}
+		test.json.Tx.Data[i] = "0x" + hex.EncodeToString(code)
+	}
+	forks, err := f.Forks()
+	if err != nil {
+		return nil, err
+	}
+	// Execute every combination of transaction fields with expectations
+	var failures []string
+	for _, fork := range forks {
+		for d := range test.json.Tx.Data {
+			for g := range test.json.Tx.GasLimit {
+				for v := range test.json.Tx.Value {
+					var expects []*sfExpect
+					for i := range f.json.Expect {
+						expect := &f.json.Expect[i]
+						if !expect.Indexes.Data.matches(d) || !expect.Indexes.Gas.matches(g) || !expect.Indexes.Value.matches(v) {
+							continue
+						}
+						if networks, _ := expandNetworks(expect.Network); containsFork(networks, fork) {
+							expects = append(expects, expect)
+						}
+					}
+					if len(expects) == 0 {
+						continue
+					}
+					var post stPostState
+					post.Indexes.Data, post.Indexes.Gas, post.Indexes.Value = d, g, v
+					test.json.Post[fork] = append(test.json.Post[fork], post)
+
+					index := len(test.json.Post[fork]) - 1
+					_, statedb, root, err := test.RunNoVerify(StateSubtest{fork, index}, vmconfig, false)
+					if err != nil {
+						return nil, fmt.Errorf("%s d%d g%d v%d: %v", fork, d, g, v, err)
+					}
+					test.json.Post[fork][index].Root = common.UnprefixedHash(root)
+					test.json.Post[fork][index].Logs = common.UnprefixedHash(rlpHash(statedb.Logs()))
+
+					for _, expect := range expects {
+						for _, failure := range expect.check(statedb) {
+							failures = append(failures, fmt.Sprintf("%s d%d g%d v%d: %s", fork, d, g, v, failure))
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return nil, errors.New(strings.Join(failures, "\n"))
+	}
+	return test, nil
+}
+
+func containsFork(forks []string, fork string) bool {
+	for _, f := range forks {
+		if f == fork {
+			return true
+		}
+	}
+	return false
+}
+
+// genesisAccount converts an account of the pre-state to a genesis account.
+func (a *sfAccount) genesisAccount() (core.GenesisAccount, error) {
+	account := core.GenesisAccount{Balance: new(big.Int)}
+	if a.Balance != nil {
+		balance, ok := math.ParseBig256(*a.Balance)
+		if !ok {
+			return account, fmt.Errorf("invalid balance %q", *a.Balance)
+		}
+		account.Balance = balance
+	}
+	if a.Nonce != nil {
+		nonce, ok := math.ParseUint64(*a.Nonce)
+		if !ok {
+			return account, fmt.Errorf("invalid nonce %q", *a.Nonce)
+		}
+		account.Nonce = nonce
+	}
+	if a.Code != nil {
+		code, err := fillerCode(*a.Code)
+		if err != nil {
+			return account, err
+		}
+		account.Code = code
+	}
+	storage, err := parseStorage(a.Storage)
+	if err != nil {
+		return account, err
+	}
+	if len(storage) > 0 {
+		account.Storage = storage
+	}
+	return account, nil
+}
+
+// check compares the state with the expected result, returning a description of
+// every difference. The storage is expected to consist of the given slots only.
+func (e *sfExpect) check(statedb *state.StateDB) []string {
+	addrs := make([]common.Address, 0, len(e.Result))
+	for addr := range e.Result {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+
+	var failures []string
+	for _, addr := range addrs {
+		want := e.Result[addr]
+		if want.ShouldNotExist {
+			if statedb.Exist(addr) {
+				failures = append(failures, fmt.Sprintf("%#x: account should not exist", addr))
+			}
+			continue
+		}
+		if !statedb.Exist(addr) {
+			failures = append(failures, fmt.Sprintf("%#x: account missing", addr))
+			continue
+		}
+		expected, err := want.genesisAccount()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%#x: invalid expectation: %v", addr, err))
+			continue
+		}
+		if have := statedb.GetBalance(addr); want.Balance != nil && have.Cmp(expected.Balance) != 0 {
+			failures = append(failures, fmt.Sprintf("%#x: balance mismatch: have %v, want %v", addr, have, expected.Balance))
+		}
+		if have := statedb.GetNonce(addr); want.Nonce != nil && have != expected.Nonce {
+			failures = append(failures, fmt.Sprintf("%#x: nonce mismatch: have %d, want %d", addr, have, expected.Nonce))
+		}
+		if have := statedb.GetCode(addr); want.Code != nil && !bytes.Equal(have, expected.Code) {
+			failures = append(failures, fmt.Sprintf("%#x: code mismatch: have %#x, want %#x", addr, have, expected.Code))
+		}
+		if want.Storage == nil {
+			continue
+		}
+		// Check the expected slots, and that there are no others
+		slots := make(map[common.Hash]common.Hash)
+		statedb.ForEachStorage(addr, func(key, value common.Hash) bool {
+			if value != (common.Hash{}) {
+				slots[key] = value
+			}
+			return true
+		})
+		for key, value := range expected.Storage {
+			if slots[key] != value {
+				failures = append(failures, fmt.Sprintf("%#x: storage %#x mismatch: have %#x, want %#x", addr, key, slots[key], value))
+			}
+			delete(slots, key)
+		}
+		for key, value := range slots {
+			failures = append(failures, fmt.Sprintf("%#x: unexpected storage %#x: %#x", addr, key, value))
+		}
+	}
+	return failures
+}
+
+// parseStorage parses the slots and values of a storage, which are hex or decimal.
+func parseStorage(storage map[string]string) (map[common.Hash]common.Hash, error) {
+	slots := make(map[common.Hash]common.Hash, len(storage))
+	for k, v := range storage {
+		key, ok := math.ParseBig256(k)
+		if !ok {
+			return nil, fmt.Errorf("invalid storage slot %q", k)
+		}
+		value, ok := math.ParseBig256(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid storage value %q", v)
+		}
+		slots[common.BigToHash(key)] = common.BigToHash(value)
+	}
+	return slots, nil
+}
+
+// fillerCode converts the code or data of a filler to bytes. It is either hex
+// encoded, optionally prefixed by ":raw", or assembly prefixed by ":asm".
+func fillerCode(code string) ([]byte, error) {
+	code = strings.TrimSpace(code)
+	switch {
+	case strings.HasPrefix(code, ":asm"):
+		compiler := asm.NewCompiler(false)
+		compiler.Feed(asm.Lex([]byte(code[len(":asm"):]), false))
+		bin, errs := compiler.Compile()
+		if len(errs) > 0 {
+			msgs := make([]string, len(errs))
+			for i, err := range errs {
+				msgs[i] = err.Error()
+			}
+			return nil, fmt.Errorf("invalid assembly: %s", strings.Join(msgs, "; "))
+		}
+		return hex.DecodeString(bin)
+
+	case strings.HasPrefix(code, ":raw"):
+		code = strings.TrimSpace(code[len(":raw"):])
+	}
+	bin, err := hex.DecodeString(strings.TrimPrefix(code, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex code %q", code)
+	}
+	return bin, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// stateFiller increments a storage slot by the value sent, in two transactions
+// with different data and gas limits, the second one running out of gas.
+const stateFiller = `{
+	"env": {
+		"currentCoinbase": "0x2adc25665018aa1fe0e6bc666dac8fc2697ff9ba",
+		"currentDifficulty": "0x20000",
+		"currentGasLimit": "0x7fffffffffffffff",
+		"currentNumber": "1",
+		"currentTimestamp": "1000"
+	},
+	"pre": {
+		"0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b": {
+			"balance": "1000000000000000000"
+		},
+		"0x000000000000000000000000000000000000aaaa": {
+			"code": ":asm\nPUSH1 1\nSLOAD\nCALLVALUE\nADD\nPUSH1 1\nSSTORE",
+			"nonce": "1",
+			"storage": {"1": "2"}
+		}
+	},
+	"transaction": {
+		"data": ["0x", ":raw 0x01"],
+		"gasLimit": ["100000", "25000"],
+		"gasPrice": "10",
+		"nonce": "0",
+		"secretKey": "0x45a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8",
+		"to": "0x000000000000000000000000000000000000aaaa",
+		"value": ["0", "5"]
+	},
+	"expect": [
+		{
+			"indexes": {"data": -1, "gas": 0, "value": 1},
+			"network": [">=Istanbul"],
+			"result": {
+				"0x000000000000000000000000000000000000aaaa": {"balance": "5", "storage": {"0x01": "0x07"}},
+				"0xa94f5374fce5edbc8e2a8697c15331677e6ebf0b": {"nonce": "1"}
+			}
+		},
+		{
+			"indexes": {"data": 0, "gas": 1, "value": [0, 1]},
+			"network": ["Berlin"],
+			"result": {
+				"0x000000000000000000000000000000000000aaaa": {"storage": {"0x01": "0x02"}},
+				"0x000000000000000000000000000000000000bbbb": {"shouldnotexist": true}
+			}
+		}
+	]
+}`
+
+func TestStateFiller(t *testing.T) {
+	var filler StateFiller
+	if err := json.Unmarshal([]byte(stateFiller), &filler); err != nil {
+		t.Fatalf("failed to decode filler: %v", err)
+	}
+	forks, err := filler.Forks()
+	if err != nil {
+		t.Fatalf("failed to get forks: %v", err)
+	}
+	if want := []string{"Istanbul", "Berlin"}; !reflect.DeepEqual(forks, want) {
+		t.Fatalf("forks mismatch: have %v, want %v", forks, want)
+	}
+	test, err := filler.Fill(vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to fill test: %v", err)
+	}
+	// Both data on Istanbul, both data and the out of gas transactions on Berlin
+	if have := len(test.json.Post["Istanbul"]); have != 2 {
+		t.Errorf("Istanbul post-state count mismatch: have %d, want 2", have)
+	}
+	if have := len(test.json.Post["Berlin"]); have != 4 {
+		t.Errorf("Berlin post-state count mismatch: have %d, want 4", have)
+	}
+	// The filled test has to pass once encoded and decoded again
+	enc, err := json.Marshal(test)
+	if err != nil {
+		t.Fatalf("failed to encode test: %v", err)
+	}
+	var filled StateTest
+	if err := json.Unmarshal(enc, &filled); err != nil {
+		t.Fatalf("failed to decode test: %v", err)
+	}
+	for _, subtest := range filled.Subtests() {
+		if _, _, err := filled.Run(subtest, vm.Config{}, false); err != nil {
+			t.Errorf("%s/%d: %v", subtest.Fork, subtest.Index, err)
+		}
+	}
+}
+
+func TestStateFillerExpectFailure(t *testing.T) {
+	var filler StateFiller
+	if err := json.Unmarshal([]byte(strings.Replace(stateFiller, `"0x07"`, `"0x08"`, 1)), &filler); err != nil {
+		t.Fatalf("failed to decode filler: %v", err)
+	}
+	_, err := filler.Fill(vm.Config{})
+	if err == nil {
+		t.Fatal("expected expectation failure")
+	}
+	// The mismatch is reported for both data on both forks
+	if have := strings.Count(err.Error(), "storage 0x0000000000000000000000000000000000000000000000000000000000000001 mismatch"); have != 4 {
+		t.Errorf("failure count mismatch: have %d, want 4\n%v", have, err)
+	}
+}
@@ -0,0 +1,607 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Binary trie node encodings, the node hash is the SHA-256 hash of the encoding.
+//
+//	leaf:   0x00 || key (32 bytes) || value
+//	branch: 0x01 || left hash (32 bytes) || right hash (32 bytes)
+//
+// Missing children of a branch are encoded as the zero hash.
+const (
+	binaryLeafPrefix   = 0x00
+	binaryBranchPrefix = 0x01
+)
+
+// binaryNode is a node of a binary trie. Nodes are never modified once created,
+// which makes copying a trie cheap.
+type binaryNode interface {
+	cachedHash() common.Hash // Hash of the node, zero if not yet computed
+}
+
+type (
+	binaryBranch struct {
+		children [2]binaryNode
+		flags    binaryFlag
+	}
+	binaryLeaf struct {
+		key   []byte // Full 32 byte key, independent of the depth of the leaf
+		value []byte
+		flags binaryFlag
+	}
+	binaryHashNode common.Hash // Reference to a node not yet loaded from the database
+)
+
+// binaryFlag contains the caching related metadata of a node.
+type binaryFlag struct {
+	hash  common.Hash // Cached hash of the node, zero if not computed yet
+	dirty bool        // Whether the node has changes that must be written to the database
+}
+
+func (n *binaryBranch) cachedHash() common.Hash  { return n.flags.hash }
+func (n *binaryLeaf) cachedHash() common.Hash    { return n.flags.hash }
+func (n binaryHashNode) cachedHash() common.Hash { return common.Hash(n) }
+
+// BinaryTrie is a binary Merkle trie using SHA-256 for hashing its nodes. Like the
+// SecureTrie, it hashes all keys with keccak256 before storing them, so the key
+// space and iteration order are the same for both commitment schemes.
+//
+// A leaf is stored at the smallest depth at which the bits of its key differ from
+// all other keys, and branches exist only for the prefixes shared by two or more
+// keys. The structure, and thereby the root hash, only depends on the contents of
+// the trie. The hash of the empty trie equals the one of an empty Merkle-Patricia
+// trie, which keeps empty roots recognisable for code handling them specially.
+//
+// BinaryTrie is not safe for concurrent use.
+type BinaryTrie struct {
+	db   *Database
+	root binaryNode
+
+	hashKeyBuf       [common.HashLength]byte
+	secKeyCache      map[string][]byte
+	secKeyCacheOwner *BinaryTrie // Pointer to self, replace the key cache on mismatch
+}
+
+// NewBinary creates a binary trie with an existing root node from a backing
+// database. If root is the zero hash or the empty root hash, the trie is initially
+// empty. Otherwise MissingNodeError is returned if the root node cannot be found.
+func NewBinary(root common.Hash, db *Database) (*BinaryTrie, error) {
+	if db == nil {
+		panic("trie.NewBinary called without a database")
+	}
+	trie := &BinaryTrie{db: db}
+	if root != (common.Hash{}) && root != emptyRoot {
+		node, err := trie.resolve(root, nil)
+		if err != nil {
+			return nil, err
+		}
+		trie.root = node
+	}
+	return trie, nil
+}
+
+// Get returns the value for key stored in the trie.
+// The value bytes must not be modified by the caller.
+func (t *BinaryTrie) Get(key []byte) []byte {
+	res, err := t.TryGet(key)
+	if err != nil {
+		log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
+	}
+	return res
+}
+
+// TryGet returns the value for key stored in the trie. The value bytes must not
+// be modified by the caller. If a node was not found in the database, a
+// MissingNodeError is returned.
+func (t *BinaryTrie) TryGet(key []byte) ([]byte, error) {
+	value, newroot, didResolve, err := t.get(t.root, t.hashKey(key), 0)
+	if err == nil && didResolve {
+		t.root = newroot
+	}
+	return value, err
+}
+
+func (t *BinaryTrie) get(n binaryNode, key []byte, depth int) ([]byte, binaryNode, bool, error) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil, false, nil
+	case *binaryLeaf:
+		if bytes.Equal(n.key, key) {
+			return n.value, n, false, nil
+		}
+		return nil, n, false, nil
+	case *binaryBranch:
+		bit := keyBit(key, depth)
+		value, child, didResolve, err := t.get(n.children[bit], key, depth+1)
+		if err == nil && didResolve {
+			cpy := *n
+			cpy.children[bit] = child
+			return value, &cpy, true, nil
+		}
+		return value, n, false, err
+	case binaryHashNode:
+		child, err := t.resolve(common.Hash(n), keyBits(key)[:depth])
+		if err != nil {
+			return nil, n, true, err
+		}
+		value, newnode, _, err := t.get(child, key, depth)
+		return value, newnode, true, err
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// Update associates key with value in the trie. If value has length zero, any
+// existing value is deleted from the trie.
+func (t *BinaryTrie) Update(key, value []byte) {
+	if err := t.TryUpdate(key, value); err != nil {
+		log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
+	}
+}
+
+// TryUpdate associates key with value in the trie. If value has length zero, any
+// existing value is deleted from the trie. The value bytes must not be modified
+// by the caller while they are stored in the trie. If a node was not found in the
+// database, a MissingNodeError is returned.
+func (t *BinaryTrie) TryUpdate(key, value []byte) error {
+	hk := t.hashKey(key)
+	if len(value) == 0 {
+		root, err := t.delete(t.root, hk, 0)
+		if err != nil {
+			return err
+		}
+		t.root = root
+		return nil
+	}
+	root, err := t.insert(t.root, common.CopyBytes(hk), value, 0)
+	if err != nil {
+		return err
+	}
+	t.root = root
+	t.getSecKeyCache()[string(hk)] = common.CopyBytes(key)
+	return nil
+}
+
+func (t *BinaryTrie) insert(n binaryNode, key, value []byte, depth int) (binaryNode, error) {
+	switch n := n.(type) {
+	case nil:
+		return &binaryLeaf{key: key, value: value, flags: binaryFlag{dirty: true}}, nil
+	case *binaryLeaf:
+		if bytes.Equal(n.key, key) {
+			if bytes.Equal(n.value, value) {
+				return n, nil
+			}
+			return &binaryLeaf{key: key, value: value, flags: binaryFlag{dirty: true}}, nil
+		}
+		// Push the existing leaf down until the keys diverge.
+		leaf := &binaryLeaf{key: key, value: value, flags: binaryFlag{dirty: true}}
+		return splitBinaryLeaves(n, leaf, depth), nil
+	case *binaryBranch:
+		bit := keyBit(key, depth)
+		child, err := t.insert(n.children[bit], key, value, depth+1)
+		if err != nil {
+			return n, err
+		}
+		if child == n.children[bit] {
+			return n, nil
+		}
+		branch := &binaryBranch{children: n.children, flags: binaryFlag{dirty: true}}
+		branch.children[bit] = child
+		return branch, nil
+	case binaryHashNode:
+		child, err := t.resolve(common.Hash(n), keyBits(key)[:depth])
+		if err != nil {
+			return n, err
+		}
+		newnode, err := t.insert(child, key, value, depth)
+		if newnode == child {
+			return n, err
+		}
+		return newnode, err
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// splitBinaryLeaves creates the branches needed to hold two leaves with distinct
+// keys sharing the first depth bits.
+func splitBinaryLeaves(a, b *binaryLeaf, depth int) binaryNode {
+	branch := &binaryBranch{flags: binaryFlag{dirty: true}}
+	bitA, bitB := keyBit(a.key, depth), keyBit(b.key, depth)
+	if bitA == bitB {
+		branch.children[bitA] = splitBinaryLeaves(a, b, depth+1)
+	} else {
+		branch.children[bitA], branch.children[bitB] = a, b
+	}
+	return branch
+}
+
+// Delete removes any existing value for key from the trie.
+func (t *BinaryTrie) Delete(key []byte) {
+	if err := t.TryDelete(key); err != nil {
+		log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
+	}
+}
+
+// TryDelete removes any existing value for key from the trie. If a node was not
+// found in the database, a MissingNodeError is returned.
+func (t *BinaryTrie) TryDelete(key []byte) error {
+	hk := t.hashKey(key)
+	delete(t.getSecKeyCache(), string(hk))
+	root, err := t.delete(t.root, hk, 0)
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+func (t *BinaryTrie) delete(n binaryNode, key []byte, depth int) (binaryNode, error) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil
+	case *binaryLeaf:
+		if bytes.Equal(n.key, key) {
+			return nil, nil
+		}
+		return n, nil
+	case *binaryBranch:
+		bit := keyBit(key, depth)
+		child, err := t.delete(n.children[bit], key, depth+1)
+		if err != nil {
+			return n, err
+		}
+		if child == n.children[bit] {
+			return n, nil
+		}
+		// If only a single leaf is left in the subtree, it moves up to take the
+		// place of the branch.
+		sibling := n.children[1-bit]
+		if hash, ok := sibling.(binaryHashNode); ok && child == nil {
+			path := append(keyBits(key)[:depth], byte(1-bit))
+			if sibling, err = t.resolve(common.Hash(hash), path); err != nil {
+				return n, err
+			}
+		}
+		if leaf, ok := sibling.(*binaryLeaf); ok && child == nil {
+			return leaf, nil
+		}
+		if leaf, ok := child.(*binaryLeaf); ok && sibling == nil {
+			return leaf, nil
+		}
+		branch := &binaryBranch{children: n.children, flags: binaryFlag{dirty: true}}
+		branch.children[bit] = child
+		branch.children[1-bit] = sibling
+		return branch, nil
+	case binaryHashNode:
+		child, err := t.resolve(common.Hash(n), keyBits(key)[:depth])
+		if err != nil {
+			return n, err
+		}
+		newnode, err := t.delete(child, key, depth)
+		if newnode == child {
+			return n, err
+		}
+		return newnode, err
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// resolve loads the node with the given hash from the database.
+func (t *BinaryTrie) resolve(hash common.Hash, path []byte) (binaryNode, error) {
+	blob, err := t.db.Node(hash)
+	if err != nil || len(blob) == 0 {
+		return nil, &MissingNodeError{NodeHash: hash, Path: path}
+	}
+	return decodeBinaryNode(hash, blob)
+}
+
+// decodeBinaryNode parses the encoding of a node stored with the given hash.
+func decodeBinaryNode(hash common.Hash, blob []byte) (binaryNode, error) {
+	if len(blob) == 0 {
+		return nil, errors.New("empty binary node")
+	}
+	switch blob[0] {
+	case binaryLeafPrefix:
+		if len(blob) < 1+common.HashLength {
+			return nil, fmt.Errorf("invalid binary leaf size %d", len(blob))
+		}
+		return &binaryLeaf{
+			key:   common.CopyBytes(blob[1 : 1+common.HashLength]),
+			value: common.CopyBytes(blob[1+common.HashLength:]),
+			flags: binaryFlag{hash: hash},
+		}, nil
+	case binaryBranchPrefix:
+		if len(blob) != 1+2*common.HashLength {
+			return nil, fmt.Errorf("invalid binary branch size %d", len(blob))
+		}
+		branch := &binaryBranch{flags: binaryFlag{hash: hash}}
+		for i := 0; i < 2; i++ {
+			if child := common.BytesToHash(blob[1+i*common.HashLength : 1+(i+1)*common.HashLength]); child != (common.Hash{}) {
+				branch.children[i] = binaryHashNode(child)
+			}
+		}
+		return branch, nil
+	default:
+		return nil, fmt.Errorf("invalid binary node prefix %#x", blob[0])
+	}
+}
+
+// encodeBinaryNode returns the encoding of a node, whose children must have been
+// hashed already.
+func encodeBinaryNode(n binaryNode) []byte {
+	switch n := n.(type) {
+	case *binaryLeaf:
+		blob := make([]byte, 0, 1+len(n.key)+len(n.value))
+		blob = append(blob, binaryLeafPrefix)
+		blob = append(blob, n.key...)
+		return append(blob, n.value...)
+	case *binaryBranch:
+		blob := make([]byte, 1+2*common.HashLength)
+		blob[0] = binaryBranchPrefix
+		for i, child := range n.children {
+			if child != nil {
+				hash := child.cachedHash()
+				copy(blob[1+i*common.HashLength:], hash[:])
+			}
+		}
+		return blob
+	default:
+		panic(fmt.Sprintf("%T: cannot encode node: %v", n, n))
+	}
+}
+
+// hash computes the hash of a node, returning a copy of the node with the hash
+// of it and all its descendants cached.
+func hashBinaryNode(n binaryNode) (common.Hash, binaryNode) {
+	if hash := n.cachedHash(); hash != (common.Hash{}) {
+		return hash, n
+	}
+	switch n := n.(type) {
+	case *binaryLeaf:
+		cpy := *n
+		cpy.flags.hash = sha256.Sum256(encodeBinaryNode(n))
+		return cpy.flags.hash, &cpy
+	case *binaryBranch:
+		cpy := *n
+		for i, child := range n.children {
+			if child != nil {
+				_, cpy.children[i] = hashBinaryNode(child)
+			}
+		}
+		cpy.flags.hash = sha256.Sum256(encodeBinaryNode(&cpy))
+		return cpy.flags.hash, &cpy
+	default:
+		panic(fmt.Sprintf("%T: cannot hash node: %v", n, n))
+	}
+}
+
+// Hash returns the root hash of the trie. It does not write to the database and
+// can be used even if the trie doesn't have one.
+func (t *BinaryTrie) Hash() common.Hash {
+	if t.root == nil {
+		return emptyRoot
+	}
+	hash, root := hashBinaryNode(t.root)
+	t.root = root
+	return hash
+}
+
+// Commit writes all nodes and the key preimages to the trie's database. Nodes are
+// stored with their SHA-256 hash as the key. The callback is invoked for every
+// written leaf with the hash of the leaf node as the parent.
+//
+// Committing flushes nodes from memory. Subsequent Get calls will load nodes from
+// the database.
+func (t *BinaryTrie) Commit(onleaf LeafCallback) (common.Hash, error) {
+	if len(t.getSecKeyCache()) > 0 {
+		if t.db.preimages != nil {
+			t.db.lock.Lock()
+			for hk, key := range t.secKeyCache {
+				t.db.insertPreimage(common.BytesToHash([]byte(hk)), key)
+			}
+			t.db.lock.Unlock()
+		}
+		t.secKeyCache = make(map[string][]byte)
+	}
+	if t.root == nil {
+		return emptyRoot, nil
+	}
+	hash, root := hashBinaryNode(t.root)
+	if err := t.commit(root, onleaf); err != nil {
+		return common.Hash{}, err
+	}
+	t.root = binaryHashNode(hash)
+	return hash, nil
+}
+
+// commit writes a hashed node and its dirty descendants into the database.
+func (t *BinaryTrie) commit(n binaryNode, onleaf LeafCallback) error {
+	var children []common.Hash
+	switch n := n.(type) {
+	case *binaryLeaf:
+		if !n.flags.dirty {
+			return nil
+		}
+	case *binaryBranch:
+		if !n.flags.dirty {
+			return nil
+		}
+		for _, child := range n.children {
+			if child == nil {
+				continue
+			}
+			if err := t.commit(child, onleaf); err != nil {
+				return err
+			}
+			children = append(children, child.cachedHash())
+		}
+	default:
+		return nil
+	}
+	hash, blob := n.cachedHash(), encodeBinaryNode(n)
+
+	t.db.lock.Lock()
+	t.db.insertBlob(hash, blob, children)
+	t.db.lock.Unlock()
+
+	if leaf, ok := n.(*binaryLeaf); ok && onleaf != nil {
+		return onleaf(nil, leaf.value, hash)
+	}
+	return nil
+}
+
+// Copy returns a copy of the trie.
+func (t *BinaryTrie) Copy() *BinaryTrie {
+	cpy := *t
+	return &cpy
+}
+
+// GetKey returns the preimage of a hashed key that was previously used to store
+// a value.
+func (t *BinaryTrie) GetKey(shaKey []byte) []byte {
+	if key, ok := t.getSecKeyCache()[string(shaKey)]; ok {
+		return key
+	}
+	return t.db.preimage(common.BytesToHash(shaKey))
+}
+
+// Prove constructs a proof for the hashed key. The result contains the encodings
+// of all nodes on the path to the leaf of the key, or to the node proving its
+// absence. Nodes are stored in the proof database by their SHA-256 hash.
+//
+// The first fromLevel nodes of the path are omitted from the proof.
+func (t *BinaryTrie) Prove(key []byte, fromLevel uint, proofDb ethdb.KeyValueWriter) error {
+	if t.root == nil {
+		return nil
+	}
+	t.Hash()
+
+	var nodes []binaryNode
+	for n, depth := t.root, 0; n != nil; {
+		if hash, ok := n.(binaryHashNode); ok {
+			resolved, err := t.resolve(common.Hash(hash), keyBits(key)[:depth])
+			if err != nil {
+				return err
+			}
+			n = resolved
+		}
+		nodes = append(nodes, n)
+		branch, ok := n.(*binaryBranch)
+		if !ok || depth == len(key)*8 {
+			break
+		}
+		n = branch.children[keyBit(key, depth)]
+		depth++
+	}
+	for i, n := range nodes {
+		if uint(i) < fromLevel {
+			continue
+		}
+		hash := n.cachedHash()
+		proofDb.Put(hash[:], encodeBinaryNode(n))
+	}
+	return nil
+}
+
+// VerifyBinaryProof checks a proof generated by BinaryTrie.Prove for the given
+// hashed key. It returns the value of the key, or nil if the proof shows that the
+// key is not present in the trie.
+func VerifyBinaryProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader) ([]byte, error) {
+	if rootHash == emptyRoot {
+		return nil, nil
+	}
+	wantHash := rootHash
+	for depth := 0; ; depth++ {
+		blob, _ := proofDb.Get(wantHash[:])
+		if blob == nil {
+			return nil, fmt.Errorf("proof node %d (hash %064x) missing", depth, wantHash)
+		}
+		if sha256.Sum256(blob) != wantHash {
+			return nil, fmt.Errorf("proof node %d (hash %064x) hash mismatch", depth, wantHash)
+		}
+		n, err := decodeBinaryNode(wantHash, blob)
+		if err != nil {
+			return nil, fmt.Errorf("bad proof node %d: %v", depth, err)
+		}
+		switch n := n.(type) {
+		case *binaryLeaf:
+			if bytes.Equal(n.key, key) {
+				return n.value, nil
+			}
+			return nil, nil
+		case *binaryBranch:
+			if depth == len(key)*8 {
+				return nil, errors.New("proof deeper than key")
+			}
+			child := n.children[keyBit(key, depth)]
+			if child == nil {
+				return nil, nil
+			}
+			wantHash = child.cachedHash()
+		}
+	}
+}
+
+// hashKey returns the keccak256 hash of key as an ephemeral buffer. The caller
+// must not hold onto the return value because it will become invalid on the next
+// call to hashKey.
+func (t *BinaryTrie) hashKey(key []byte) []byte {
+	h := newHasher(false)
+	h.sha.Reset()
+	h.sha.Write(key)
+	h.sha.Read(t.hashKeyBuf[:])
+	returnHasherToPool(h)
+	return t.hashKeyBuf[:]
+}
+
+// getSecKeyCache returns the current secure key cache, creating a new one if
+// ownership changed (i.e. the current trie is a copy of another owning the
+// actual cache).
+func (t *BinaryTrie) getSecKeyCache() map[string][]byte {
+	if t != t.secKeyCacheOwner {
+		t.secKeyCacheOwner = t
+		t.secKeyCache = make(map[string][]byte)
+	}
+	return t.secKeyCache
+}
+
+// keyBit returns the bit of the key at the given depth.
+func keyBit(key []byte, depth int) byte {
+	return key[depth/8] >> (7 - depth%8) & 1
+}
+
+// keyBits expands a key into one byte per bit.
+func keyBits(key []byte) []byte {
+	bits := make([]byte, len(key)*8)
+	for i := range bits {
+		bits[i] = keyBit(key, i)
+	}
+	return bits
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// binaryValueTerminator is appended to the path of a leaf value, just like the
+// terminator symbol of the hex-encoded paths of the Merkle-Patricia trie.
+const binaryValueTerminator = 2
+
+// binaryIteratorState represents the iteration state at one node of a binary trie.
+type binaryIteratorState struct {
+	node    binaryNode // Node being iterated, nil for the value of a leaf
+	leaf    *binaryLeaf
+	index   int // Child to be processed next
+	pathlen int // Length of the path to this node
+}
+
+// binaryIterator is a NodeIterator traversing a binary trie pre-order. Paths are
+// sequences of bits, one per byte, and the value of a leaf is a separate node
+// located at the full bit path of its key followed by a terminator.
+type binaryIterator struct {
+	trie  *BinaryTrie
+	stack []*binaryIteratorState
+	path  []byte
+	err   error
+	seek  []byte // Path to skip to, nil once reached
+}
+
+// NodeIterator returns an iterator that returns nodes of the trie. Iteration
+// starts at the given hashed start key.
+func (t *BinaryTrie) NodeIterator(start []byte) NodeIterator {
+	it := &binaryIterator{trie: t}
+	if t.root == nil {
+		it.err = errIteratorEnd
+		return it
+	}
+	t.Hash()
+	if len(start) > 0 {
+		it.seek = keyBits(start)
+	}
+	return it
+}
+
+func (it *binaryIterator) Next(descend bool) bool {
+	for {
+		if !it.step(descend) {
+			return false
+		}
+		if it.seek == nil {
+			return true
+		}
+		// Skip all nodes before the start path, along with the ones leading to it.
+		path := it.path
+		if len(path) <= len(it.seek) && bytes.Equal(path, it.seek[:len(path)]) {
+			descend = true
+			continue
+		}
+		if bytes.Compare(path, it.seek) < 0 {
+			descend = false
+			continue
+		}
+		it.seek = nil
+		return true
+	}
+}
+
+// step moves the iterator to the next node in pre-order.
+func (it *binaryIterator) step(descend bool) bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.stack) == 0 {
+		return it.push(it.trie.root, nil, nil)
+	}
+	if !descend {
+		it.pop()
+	}
+	for len(it.stack) > 0 {
+		state := it.stack[len(it.stack)-1]
+		switch n := state.node.(type) {
+		case *binaryLeaf:
+			if state.index == 0 {
+				state.index++
+				it.path = append(it.path[:0], keyBits(n.key)...)
+				it.path = append(it.path, binaryValueTerminator)
+				return it.push(nil, n, it.path)
+			}
+		case *binaryBranch:
+			for state.index < 2 {
+				bit := state.index
+				state.index++
+				if child := n.children[bit]; child != nil {
+					path := append(it.path[:state.pathlen], byte(bit))
+					return it.push(child, nil, path)
+				}
+			}
+		}
+		it.pop()
+	}
+	it.err = errIteratorEnd
+	return false
+}
+
+// push resolves the given node and makes it the current position of the iterator.
+func (it *binaryIterator) push(n binaryNode, leaf *binaryLeaf, path []byte) bool {
+	if hash, ok := n.(binaryHashNode); ok {
+		resolved, err := it.trie.resolve(common.Hash(hash), path)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		n = resolved
+	}
+	it.path = path
+	it.stack = append(it.stack, &binaryIteratorState{node: n, leaf: leaf, pathlen: len(path)})
+	return true
+}
+
+func (it *binaryIterator) pop() {
+	it.stack = it.stack[:len(it.stack)-1]
+	if len(it.stack) > 0 {
+		it.path = it.path[:it.stack[len(it.stack)-1].pathlen]
+	} else {
+		it.path = it.path[:0]
+	}
+}
+
+func (it *binaryIterator) Error() error {
+	if it.err == errIteratorEnd {
+		return nil
+	}
+	return it.err
+}
+
+func (it *binaryIterator) Hash() common.Hash {
+	if len(it.stack) == 0 || it.stack[len(it.stack)-1].node == nil {
+		return common.Hash{}
+	}
+	return it.stack[len(it.stack)-1].node.cachedHash()
+}
+
+func (it *binaryIterator) Parent() common.Hash {
+	if len(it.stack) < 2 {
+		return common.Hash{}
+	}
+	return it.stack[len(it.stack)-2].node.cachedHash()
+}
+
+func (it *binaryIterator) Path() []byte {
+	return it.path
+}
+
+func (it *binaryIterator) Leaf() bool {
+	return len(it.stack) > 0 && it.stack[len(it.stack)-1].leaf != nil
+}
+
+func (it *binaryIterator) LeafKey() []byte {
+	if !it.Leaf() {
+		panic("not at leaf")
+	}
+	return it.stack[len(it.stack)-1].leaf.key
+}
+
+func (it *binaryIterator) LeafBlob() []byte {
+	if !it.Leaf() {
+		panic("not at leaf")
+	}
+	return it.stack[len(it.stack)-1].leaf.value
+}
+
+func (it *binaryIterator) LeafProof() [][]byte {
+	if !it.Leaf() {
+		panic("not at leaf")
+	}
+	proofs := make([][]byte, 0, len(it.stack)-1)
+	for _, state := range it.stack[:len(it.stack)-1] {
+		proofs = append(proofs, encodeBinaryNode(state.node))
+	}
+	return proofs
+}
@@ -0,0 +1,317 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+func makeBinaryEntries(n int) map[string][]byte {
+	entries := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		entries[string(key)] = randBytes(1 + rand.Intn(40))
+	}
+	return entries
+}
+
+func sha256Sum(blob []byte) []byte {
+	hash := sha256.Sum256(blob)
+	return hash[:]
+}
+
+func newBinaryTestTrie(t *testing.T, db *Database, entries map[string][]byte) *BinaryTrie {
+	trie, _ := NewBinary(common.Hash{}, db)
+	for key, value := range entries {
+		if err := trie.TryUpdate([]byte(key), value); err != nil {
+			t.Fatalf("failed to insert %x: %v", key, err)
+		}
+	}
+	return trie
+}
+
+func TestBinaryEmpty(t *testing.T) {
+	trie, _ := NewBinary(common.Hash{}, NewDatabase(memorydb.New()))
+	if hash := trie.Hash(); hash != emptyRoot {
+		t.Errorf("empty hash mismatch: have %x, want %x", hash, emptyRoot)
+	}
+	if value, err := trie.TryGet([]byte("foo")); value != nil || err != nil {
+		t.Errorf("unexpected value %x, err %v", value, err)
+	}
+	if _, err := NewBinary(common.Hash{1}, NewDatabase(memorydb.New())); err == nil {
+		t.Errorf("no error for missing root")
+	}
+}
+
+// Tests that the root hash only depends on the contents of the trie and not on
+// the order of the operations leading to them.
+func TestBinaryDeterministic(t *testing.T) {
+	entries := makeBinaryEntries(500)
+	trie := newBinaryTestTrie(t, NewDatabase(memorydb.New()), entries)
+
+	// Overwrite and delete some entries, and insert some deleted later on.
+	for i, key := range []string{"\x00\x00\x00\x00\x00\x00\x00\x01", "\x00\x00\x00\x00\x00\x00\x01\x00", "foo"} {
+		trie.Update([]byte(key), []byte{byte(i + 1)})
+		if i > 0 {
+			trie.Delete([]byte(key))
+			delete(entries, key)
+		} else {
+			entries[key] = []byte{byte(i + 1)}
+		}
+	}
+	for key := range entries {
+		if rand.Intn(3) == 0 {
+			trie.Delete([]byte(key))
+			delete(entries, key)
+		}
+	}
+	want := newBinaryTestTrie(t, NewDatabase(memorydb.New()), entries).Hash()
+	if have := trie.Hash(); have != want {
+		t.Fatalf("root mismatch: have %x, want %x", have, want)
+	}
+	for key, value := range entries {
+		if have := trie.Get([]byte(key)); !bytes.Equal(have, value) {
+			t.Fatalf("value mismatch for %x: have %x, want %x", key, have, value)
+		}
+	}
+	// Delete everything that's left.
+	for key := range entries {
+		trie.Delete([]byte(key))
+	}
+	if hash := trie.Hash(); hash != emptyRoot {
+		t.Fatalf("empty hash mismatch: have %x, want %x", hash, emptyRoot)
+	}
+}
+
+func TestBinaryCommit(t *testing.T) {
+	var (
+		diskdb  = memorydb.New()
+		triedb  = NewDatabase(diskdb)
+		entries = makeBinaryEntries(300)
+		trie    = newBinaryTestTrie(t, triedb, entries)
+	)
+	var leaves int
+	root, err := trie.Commit(func(_ []byte, leaf []byte, parent common.Hash) error {
+		leaves++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit trie: %v", err)
+	}
+	if leaves != len(entries) {
+		t.Errorf("leaf callback count mismatch: have %d, want %d", leaves, len(entries))
+	}
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to flush trie: %v", err)
+	}
+	// Check every node reachable from the root is on disk and hashed by SHA-256.
+	it := newBinaryTestIterator(t, NewDatabase(diskdb), root)
+	var (
+		nodes int
+		last  common.Hash
+	)
+	for it.Next(true) {
+		if it.Hash() == (common.Hash{}) {
+			continue
+		}
+		last = it.Hash()
+		blob, err := diskdb.Get(it.Hash().Bytes())
+		if err != nil {
+			t.Fatalf("node %x missing from disk", it.Hash())
+		}
+		if crypto.Keccak256Hash(blob) == it.Hash() {
+			t.Fatalf("node %x hashed with keccak", it.Hash())
+		}
+		nodes++
+	}
+	stored := 0
+	diskit := diskdb.NewIterator(nil, nil)
+	for diskit.Next() {
+		if bytes.Equal(diskit.Key(), sha256Sum(diskit.Value())) {
+			stored++
+		}
+	}
+	diskit.Release()
+	if nodes != stored {
+		t.Errorf("disk node count mismatch: have %d, iterated %d", stored, nodes)
+	}
+	// Modify the committed trie, and reopen both versions from disk.
+	trie.Update([]byte("foo"), []byte("bar"))
+	updated, _ := trie.Commit(nil)
+	triedb.Commit(updated, false, nil)
+
+	for _, root := range []common.Hash{root, updated} {
+		reopened, err := NewBinary(root, NewDatabase(diskdb))
+		if err != nil {
+			t.Fatalf("failed to reopen trie: %v", err)
+		}
+		for key, value := range entries {
+			if have := reopened.Get([]byte(key)); !bytes.Equal(have, value) {
+				t.Fatalf("value mismatch for %x: have %x, want %x", key, have, value)
+			}
+		}
+		if have := reopened.Get([]byte("foo")); (root == updated) != (have != nil) {
+			t.Fatalf("root %x: unexpected value %x", root, have)
+		}
+		if hash := reopened.Hash(); hash != root {
+			t.Fatalf("root mismatch: have %x, want %x", hash, root)
+		}
+	}
+	// Check missing nodes are reported.
+	diskdb.Delete(last.Bytes())
+	reopened, _ := NewBinary(root, NewDatabase(diskdb))
+	var missing bool
+	for key := range entries {
+		if _, err := reopened.TryGet([]byte(key)); err != nil {
+			if _, ok := err.(*MissingNodeError); !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			missing = true
+		}
+	}
+	if !missing {
+		t.Fatalf("no error for missing node")
+	}
+}
+
+// newBinaryTestIterator opens a binary trie and returns an iterator over it.
+func newBinaryTestIterator(t *testing.T, db *Database, root common.Hash) NodeIterator {
+	trie, err := NewBinary(root, db)
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	return trie.NodeIterator(nil)
+}
+
+func TestBinaryProof(t *testing.T) {
+	entries := makeBinaryEntries(200)
+	trie := newBinaryTestTrie(t, NewDatabase(memorydb.New()), entries)
+	root := trie.Hash()
+
+	for key, value := range entries {
+		hk := crypto.Keccak256([]byte(key))
+		proof := memorydb.New()
+		if err := trie.Prove(hk, 0, proof); err != nil {
+			t.Fatalf("failed to prove %x: %v", key, err)
+		}
+		have, err := VerifyBinaryProof(root, hk, proof)
+		if err != nil {
+			t.Fatalf("failed to verify proof for %x: %v", key, err)
+		}
+		if !bytes.Equal(have, value) {
+			t.Fatalf("proven value mismatch for %x: have %x, want %x", key, have, value)
+		}
+		// Any tampering must be detected.
+		it := proof.NewIterator(nil, nil)
+		it.Next()
+		blob := common.CopyBytes(it.Value())
+		blob[len(blob)-1] ^= 0x01
+		proof.Put(it.Key(), blob)
+		it.Release()
+		if _, err := VerifyBinaryProof(root, hk, proof); err == nil {
+			t.Fatalf("no error for tampered proof of %x", key)
+		}
+	}
+	// Prove absent keys.
+	for i := 0; i < 100; i++ {
+		hk := crypto.Keccak256(randBytes(10))
+		proof := memorydb.New()
+		if err := trie.Prove(hk, 0, proof); err != nil {
+			t.Fatalf("failed to prove %x: %v", hk, err)
+		}
+		if value, err := VerifyBinaryProof(root, hk, proof); value != nil || err != nil {
+			t.Fatalf("unexpected value %x, err %v for absent key", value, err)
+		}
+	}
+}
+
+func TestBinaryIterator(t *testing.T) {
+	entries := makeBinaryEntries(300)
+	trie := newBinaryTestTrie(t, NewDatabase(memorydb.New()), entries)
+
+	var keys []string
+	for key := range entries {
+		keys = append(keys, string(crypto.Keccak256([]byte(key))))
+	}
+	sort.Strings(keys)
+
+	// Iterate over all leaves, checking their order and preimages.
+	it := NewIterator(trie.NodeIterator(nil))
+	var i int
+	for ; it.Next(); i++ {
+		if string(it.Key) != keys[i] {
+			t.Fatalf("key %d mismatch: have %x, want %x", i, it.Key, keys[i])
+		}
+		if value := entries[string(trie.GetKey(it.Key))]; !bytes.Equal(value, it.Value) {
+			t.Fatalf("value mismatch for %x: have %x, want %x", it.Key, it.Value, value)
+		}
+		proof := memorydb.New()
+		for _, node := range it.Prove() {
+			proof.Put(sha256Sum(node), node)
+		}
+		if value, err := VerifyBinaryProof(trie.Hash(), it.Key, proof); err != nil || !bytes.Equal(value, it.Value) {
+			t.Fatalf("invalid leaf proof for %x: %v", it.Key, err)
+		}
+	}
+	if it.Err != nil || i != len(keys) {
+		t.Fatalf("iteration failed after %d of %d keys: %v", i, len(keys), it.Err)
+	}
+	// Start the iteration in the middle, both from an existing and a missing key.
+	for _, start := range []int{0, 150, 299} {
+		it := NewIterator(trie.NodeIterator([]byte(keys[start])))
+		if !it.Next() || string(it.Key) != keys[start] {
+			t.Fatalf("wrong first key from %x: %x", keys[start], it.Key)
+		}
+		missing := common.CopyBytes([]byte(keys[start]))
+		missing[31]++
+		it = NewIterator(trie.NodeIterator(missing))
+		n := 0
+		for it.Next() {
+			n++
+		}
+		if n != len(keys)-start-1 {
+			t.Fatalf("wrong number of keys after %x: have %d, want %d", missing, n, len(keys)-start-1)
+		}
+	}
+	// The difference iterator only yields changed leaves.
+	updated := trie.Copy()
+	updated.Update([]byte("foo"), []byte("bar"))
+	for key := range entries {
+		updated.Update([]byte(key), []byte("changed"))
+		break
+	}
+	diff, _ := NewDifferenceIterator(trie.NodeIterator(nil), updated.NodeIterator(nil))
+	it = NewIterator(diff)
+	for i = 0; it.Next(); i++ {
+		if !bytes.Equal(it.Value, []byte("changed")) && !bytes.Equal(it.Value, []byte("bar")) {
+			t.Fatalf("unchanged leaf %x reported", it.Key)
+		}
+	}
+	if i != 2 {
+		t.Fatalf("wrong number of changed leaves: have %d, want 2", i)
+	}
+}
@@ -88,6 +88,12 @@ type Database struct {
 	childrenSize  common.StorageSize // Storage size of the external children tracking
 	preimagesSize common.StorageSize // Storage size of the preimages cache
 
+	pathScheme bool   // Whether nodes are persisted by path instead of by hash
+	histories  uint64 // Number of state histories to retain in the path-based scheme
+
+	marker    ethdb.KeyValueWriter // Optional writer notified of every node flushed to disk
+	flushLock sync.Mutex           // Lock serializing node flushes with online pruning
+
 	lock sync.RWMutex
 }
 
@@ -277,6 +283,11 @@ type Config struct {
 	Cache     int    // Memory allowance (MB) to use for caching trie nodes in memory
 	Journal   string // Journal of clean cache to survive node restarts
 	Preimages bool   // Flag whether the preimage of trie key is recorded
+
+	// StateHistory is the number of reverse diffs retained with the path-based
+	// scheme, zero to keep all of them. The scheme itself is a property of the
+	// disk database, see rawdb.ReadStateScheme.
+	StateHistory uint64
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
@@ -304,6 +315,10 @@ func NewDatabaseWithConfig(diskdb ethdb.KeyValueStore, config *Config) *Database
 		dirties: map[common.Hash]*cachedNode{{}: {
 			children: make(map[common.Hash]uint16),
 		}},
+		pathScheme: rawdb.ReadStateScheme(diskdb) == rawdb.PathScheme,
+	}
+	if config != nil {
+		db.histories = config.StateHistory
 	}
 	if config == nil || config.Preimages { // TODO(karalabe): Flip to default off in the future
 		db.preimages = make(map[common.Hash][]byte)
@@ -311,6 +326,15 @@ func NewDatabaseWithConfig(diskdb ethdb.KeyValueStore, config *Config) *Database
 	return db
 }
 
+// Scheme returns the node storage scheme of the database, either rawdb.HashScheme
+// or rawdb.PathScheme.
+func (db *Database) Scheme() string {
+	if db.pathScheme {
+		return rawdb.PathScheme
+	}
+	return rawdb.HashScheme
+}
+
 // DiskDB retrieves the persistent storage backing the trie database.
 func (db *Database) DiskDB() ethdb.KeyValueStore {
 	return db.diskdb
@@ -349,6 +373,22 @@ func (db *Database) insert(hash common.Hash, size int, node node) {
 	db.dirtiesSize += common.StorageSize(common.HashLength + entry.size)
 }
 
+// insertBlob inserts an already encoded node into the memory database, tracking
+// the given children as references of it. It is used for nodes of tries using a
+// different encoding than the Merkle-Patricia trie, whose children can't be found
+// by decoding the node.
+//
+// Note, this method assumes that the database's lock is held!
+func (db *Database) insertBlob(hash common.Hash, blob []byte, children []common.Hash) {
+	if _, ok := db.dirties[hash]; ok {
+		return
+	}
+	db.insert(hash, len(blob), rawNode(blob))
+	for _, child := range children {
+		db.reference(child, hash)
+	}
+}
+
 // insertPreimage writes a new trie node pre-image to the memory database if it's
 // yet unknown. The method will NOT make a copy of the slice,
 // only use if the preimage will NOT be changed later on.
@@ -368,8 +408,9 @@ func (db *Database) insertPreimage(hash common.Hash, preimage []byte) {
 }
 
 // node retrieves a cached trie node from memory, or returns nil if none can be
-// found in the memory cache.
-func (db *Database) node(hash common.Hash) node {
+// found in the memory cache. The owner and path of the node are only used to
+// locate it on disk if the database uses the path-based scheme.
+func (db *Database) node(owner common.Hash, path []byte, hash common.Hash) node {
 	// Retrieve the node from the clean cache if available
 	if db.cleans != nil {
 		if enc := db.cleans.Get(nil, hash[:]); enc != nil {
@@ -391,8 +432,13 @@ func (db *Database) node(hash common.Hash) node {
 	memcacheDirtyMissMeter.Mark(1)
 
 	// Content unavailable in memory, attempt to retrieve from disk
-	enc, err := db.diskdb.Get(hash[:])
-	if err != nil || enc == nil {
+	var enc []byte
+	if db.pathScheme {
+		enc = db.pathNode(owner, path, hash)
+	} else {
+		enc, _ = db.diskdb.Get(hash[:])
+	}
+	if enc == nil {
 		return nil
 	}
 	if db.cleans != nil {
@@ -430,6 +476,10 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 	}
 	memcacheDirtyMissMeter.Mark(1)
 
+	// Nodes can't be looked up on disk by hash alone in the path-based scheme
+	if db.pathScheme {
+		return nil, errors.New("not found")
+	}
 	// Content unavailable in memory, attempt to retrieve from disk
 	enc := rawdb.ReadTrieNode(db.diskdb, hash)
 	if len(enc) != 0 {
@@ -595,7 +645,7 @@ func (db *Database) Cap(limit common.StorageSize) error {
 	// memory cache during commit but not yet in persistent storage). This is ensured
 	// by only uncaching existing data when the database write finalizes.
 	nodes, storage, start := len(db.dirties), db.dirtiesSize, time.Now()
-	batch := db.diskdb.NewBatch()
+	batch := db.newBatch()
 
 	// db.dirtiesSize only contains the useful data in the cache, but when reporting
 	// the total memory consumption, the maintenance metadata is also needed to be
@@ -619,9 +669,10 @@ func (db *Database) Cap(limit common.StorageSize) error {
 			}
 		}
 	}
-	// Keep committing nodes from the flush-list until we're below allowance
+	// Keep committing nodes from the flush-list until we're below allowance. The
+	// path-based scheme can only persist entire states, so nodes are not flushed.
 	oldest := db.oldest
-	for size > limit && oldest != (common.Hash{}) {
+	for size > limit && oldest != (common.Hash{}) && !db.pathScheme {
 		// Fetch the oldest referenced node and push into the batch
 		node := db.dirties[oldest]
 		rawdb.WriteTrieNode(batch, oldest, node.rlp())
@@ -698,7 +749,7 @@ func (db *Database) Commit(node common.Hash, report bool, callback func(common.H
 	// memory cache during commit but not yet in persistent storage). This is ensured
 	// by only uncaching existing data when the database write finalizes.
 	start := time.Now()
-	batch := db.diskdb.NewBatch()
+	batch := db.newBatch()
 
 	// Move all of the accumulated preimages into a write batch
 	if db.preimages != nil {
@@ -716,8 +767,31 @@ func (db *Database) Commit(node common.Hash, report bool, callback func(common.H
 		}
 		batch.Reset()
 	}
-	// Move the trie itself into the batch, flushing if enough data is accumulated
+	// The path-based scheme persists the state atomically along with its history
 	nodes, storage := len(db.dirties), db.dirtiesSize
+	if db.pathScheme {
+		if err := db.commitPath(node, callback); err != nil {
+			log.Error("Failed to commit trie from trie database", "err", err)
+			return err
+		}
+		db.lock.Lock()
+		defer db.lock.Unlock()
+
+		if db.preimages != nil {
+			db.preimages, db.preimagesSize = make(map[common.Hash][]byte), 0
+		}
+		memcacheCommitTimeTimer.Update(time.Since(start))
+		memcacheCommitSizeMeter.Mark(int64(storage - db.dirtiesSize))
+		memcacheCommitNodesMeter.Mark(int64(nodes - len(db.dirties)))
+
+		logger := log.Info
+		if !report {
+			logger = log.Debug
+		}
+		logger("Persisted trie from memory database", "root", node, "time", time.Since(start), "livenodes", len(db.dirties), "livesize", db.dirtiesSize)
+		return nil
+	}
+	// Move the trie itself into the batch, flushing if enough data is accumulated
 
 	uncacher := &cleaner{db}
 	if err := db.commit(node, batch, uncacher, callback); err != nil {
@@ -0,0 +1,447 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The path-based scheme stores every trie node under the path leading to it in
+// its trie, prefixed by the hash of the account owning the trie for storage tries.
+// Writing a new state overwrites the nodes of the previous one in place, so only
+// a single state is persisted at any time and obsolete nodes never accumulate.
+//
+// Every commit stores a reverse diff, the state history, holding the previous
+// content of all the nodes it modified. Applying them in reverse order rolls the
+// persistent state back to the one preceding the commit. The roots before and
+// after the commit are stored apart from the nodes, along with an index of the
+// histories by the root they revert to, so that finding the histories to apply
+// doesn't require loading them.
+//
+// Since tries are resolved top-down, every node that is not in the dirty cache
+// is the one persisted at its position, as long as states are committed in the
+// order they were derived in. This allows the commit to skip all the subtries
+// which are already persisted, and to recognize the obsolete ones by comparing
+// the positions of the children of the previous and the new nodes.
+
+// stateHistory is the reverse diff of a state committed with the path-based
+// scheme.
+type stateHistory struct {
+	Parent common.Hash   // Persistent state root before the commit
+	Root   common.Hash   // Persistent state root after the commit
+	Nodes  []historyNode // Previous content of the modified nodes
+}
+
+// historyNode is the previous content of a single trie node.
+type historyNode struct {
+	Owner common.Hash
+	Path  []byte
+	Blob  []byte // Empty if the node did not exist
+}
+
+// pathCommitter persists a state with the path-based scheme.
+type pathCommitter struct {
+	db       *Database
+	batch    ethdb.Batch
+	callback func(common.Hash)
+
+	history *stateHistory
+	touched map[string]struct{}    // Positions already modified by the commit
+	pending map[string][][]byte    // Obsolete positions below new nodes, by the new position
+	owners  map[common.Hash]bool   // Accounts whose storage might have become obsolete
+	written map[common.Hash][]byte // Dirty nodes persisted by the commit
+}
+
+// pathNode retrieves the node with the given hash from its position on disk, or
+// nil if a different node is stored there.
+func (db *Database) pathNode(owner common.Hash, path []byte, hash common.Hash) []byte {
+	enc := rawdb.ReadTrieNodeByPath(db.diskdb, owner, path)
+	if len(enc) == 0 || crypto.Keccak256Hash(enc) != hash {
+		return nil
+	}
+	return enc
+}
+
+// commitPath persists the state with the given root with the path-based scheme,
+// replacing the current persistent state along with storing the reverse diff.
+// The state must have been derived from the persistent one.
+func (db *Database) commitPath(root common.Hash, callback func(common.Hash)) error {
+	if root == (common.Hash{}) {
+		root = emptyRoot
+	}
+	parent := rawdb.ReadPersistentStateRoot(db.diskdb)
+	if root == parent {
+		return nil
+	}
+	c := &pathCommitter{
+		db:       db,
+		batch:    db.diskdb.NewBatch(),
+		callback: callback,
+		history:  &stateHistory{Parent: parent, Root: root},
+		touched:  make(map[string]struct{}),
+		pending:  make(map[string][][]byte),
+		owners:   make(map[common.Hash]bool),
+		written:  make(map[common.Hash][]byte),
+	}
+	if root == emptyRoot {
+		if err := c.obsolete(common.Hash{}, nil, nil); err != nil {
+			return err
+		}
+	} else {
+		if _, ok := db.dirties[root]; !ok {
+			return fmt.Errorf("state %x not available in memory", root)
+		}
+		if err := c.commit(common.Hash{}, nil, root); err != nil {
+			return err
+		}
+	}
+	if err := c.wipeStorage(root); err != nil {
+		return err
+	}
+	// Store the reverse diff, dropping the ones beyond the retention limit
+	blob, err := rlp.EncodeToBytes(c.history.Nodes)
+	if err != nil {
+		return err
+	}
+	tail, head := rawdb.ReadStateHistoryRange(db.diskdb)
+	head++
+	for db.histories > 0 && head-tail > db.histories {
+		tail++
+		deleteStateHistory(db.diskdb, c.batch, tail)
+	}
+	rawdb.WriteStateHistory(c.batch, head, blob)
+	rawdb.WriteStateHistoryMeta(c.batch, head, parent, root)
+	rawdb.WriteStateHistoryID(c.batch, parent, head)
+	rawdb.WriteStateHistoryRange(c.batch, tail, head)
+	rawdb.WritePersistentStateRoot(c.batch, root)
+
+	if err := c.batch.Write(); err != nil {
+		return err
+	}
+	// Nodes of states tracked by the garbage collector are retained in memory until
+	// dereferenced, as they may be needed at other positions by newer states.
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if node := db.dirties[root]; node != nil && node.parents == 0 {
+		uncacher := &cleaner{db}
+		for hash, blob := range c.written {
+			uncacher.Put(hash[:], blob)
+		}
+	}
+	log.Debug("Persisted state with path scheme", "root", root, "parent", parent, "nodes", len(c.written), "history", head)
+	return nil
+}
+
+// commit persists the dirty node at the given position along with its dirty
+// children, deleting the nodes of the previous state which became obsolete.
+func (c *pathCommitter) commit(owner common.Hash, path []byte, hash common.Hash) error {
+	// Nodes not in the dirty cache and ones already written by an earlier commit
+	// are persisted along with their entire subtries.
+	cached, ok := c.db.dirties[hash]
+	if !ok {
+		return nil
+	}
+	blob := cached.rlp()
+	prev := rawdb.ReadTrieNodeByPath(c.db.diskdb, owner, path)
+	if bytes.Equal(prev, blob) {
+		return nil
+	}
+	var (
+		n        = cached.obj(hash)
+		children [][]byte
+	)
+	forHashedChildren(n, path, func(path []byte, _ common.Hash) {
+		children = append(children, path)
+	})
+	// Delete the subtries of the previous node which are not part of the new one
+	obsolete := c.pending[string(owner[:])+string(path)]
+	if prev != nil {
+		pn, err := decodeNode(nil, prev)
+		if err != nil {
+			return fmt.Errorf("invalid node at %x/%x: %v", owner, path, err)
+		}
+		forHashedChildren(pn, path, func(path []byte, _ common.Hash) {
+			obsolete = append(obsolete, path)
+		})
+		c.trackOwner(owner, pn, path)
+	}
+	for _, child := range obsolete {
+		if err := c.obsolete(owner, child, children); err != nil {
+			return err
+		}
+	}
+	// Persist the children, including the storage tries of account leaves
+	var err error
+	forHashedChildren(n, path, func(path []byte, child common.Hash) {
+		if err == nil {
+			err = c.commit(owner, path, child)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if key, ok := leafKey(n, path); ok {
+		for child := range cached.children {
+			if err := c.commit(common.BytesToHash(key), nil, child); err != nil {
+				return err
+			}
+		}
+	}
+	c.write(owner, path, prev, blob)
+	c.written[hash] = blob
+	if c.callback != nil {
+		c.callback(hash)
+	}
+	return nil
+}
+
+// obsolete deletes the persisted node at the given position along with its
+// subtrie, stopping at the given positions of the new trie. Positions below the
+// new ones are left to be checked when the new node is committed.
+func (c *pathCommitter) obsolete(owner common.Hash, path []byte, keep [][]byte) error {
+	for _, pos := range keep {
+		if bytes.Equal(pos, path) {
+			return nil
+		}
+		if bytes.HasPrefix(path, pos) {
+			id := string(owner[:]) + string(pos)
+			c.pending[id] = append(c.pending[id], path)
+			return nil
+		}
+	}
+	blob := rawdb.ReadTrieNodeByPath(c.db.diskdb, owner, path)
+	if blob == nil {
+		return nil
+	}
+	n, err := decodeNode(nil, blob)
+	if err != nil {
+		return fmt.Errorf("invalid node at %x/%x: %v", owner, path, err)
+	}
+	forHashedChildren(n, path, func(path []byte, _ common.Hash) {
+		if err == nil {
+			err = c.obsolete(owner, path, keep)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	c.trackOwner(owner, n, path)
+	c.write(owner, path, blob, nil)
+	return nil
+}
+
+// trackOwner marks the account stored in the given account trie node as one whose
+// storage trie may have become obsolete.
+func (c *pathCommitter) trackOwner(owner common.Hash, n node, path []byte) {
+	if owner != (common.Hash{}) {
+		return
+	}
+	if key, ok := leafKey(n, path); ok && len(key) == common.HashLength {
+		c.owners[common.BytesToHash(key)] = true
+	}
+}
+
+// wipeStorage deletes the storage tries of the accounts which were removed or
+// which had all their storage cleared by the new state.
+func (c *pathCommitter) wipeStorage(root common.Hash) error {
+	if len(c.owners) == 0 {
+		return nil
+	}
+	tr, err := New(root, c.db)
+	if err != nil {
+		return err
+	}
+	for owner := range c.owners {
+		enc, err := tr.TryGet(owner[:])
+		if err != nil {
+			return err
+		}
+		if enc != nil {
+			var account struct {
+				Nonce    uint64
+				Balance  *big.Int
+				Root     common.Hash
+				CodeHash []byte
+			}
+			if err := rlp.DecodeBytes(enc, &account); err != nil || account.Root != emptyRoot {
+				continue
+			}
+		}
+		it := rawdb.IterateStorageTrieNodes(c.db.diskdb, owner)
+		for it.Next() {
+			path := it.Key()[1+common.HashLength:]
+			if _, ok := c.touched[string(owner[:])+string(path)]; !ok {
+				c.write(owner, path, common.CopyBytes(it.Value()), nil)
+			}
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write modifies the node at the given position, deleting it if the new content
+// is nil, and records the previous content in the state history.
+func (c *pathCommitter) write(owner common.Hash, path []byte, prev []byte, blob []byte) {
+	id := string(owner[:]) + string(path)
+	if _, ok := c.touched[id]; !ok {
+		c.touched[id] = struct{}{}
+		c.history.Nodes = append(c.history.Nodes, historyNode{Owner: owner, Path: common.CopyBytes(path), Blob: prev})
+	}
+	if blob == nil {
+		rawdb.DeleteTrieNodeByPath(c.batch, owner, path)
+	} else {
+		rawdb.WriteTrieNodeByPath(c.batch, owner, path, blob)
+	}
+}
+
+// forHashedChildren invokes the callback for all the children of an expanded node
+// which are stored separately, along with their positions in the trie.
+func forHashedChildren(n node, path []byte, onChild func(path []byte, hash common.Hash)) {
+	switch n := n.(type) {
+	case *shortNode:
+		forHashedChildren(n.Val, append(common.CopyBytes(path), n.Key...), onChild)
+	case *fullNode:
+		for i := 0; i < 16; i++ {
+			forHashedChildren(n.Children[i], append(common.CopyBytes(path), byte(i)), onChild)
+		}
+	case hashNode:
+		onChild(path, common.BytesToHash(n))
+	}
+}
+
+// leafKey returns the key of the value stored in an expanded node at the given
+// position, if the node is a leaf.
+func leafKey(n node, path []byte) ([]byte, bool) {
+	short, ok := n.(*shortNode)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := short.Val.(valueNode); !ok {
+		return nil, false
+	}
+	return hexToKeybytes(append(common.CopyBytes(path), short.Key...)), true
+}
+
+// Recoverable returns whether the persistent state can be rolled back to the state
+// with the given root using the stored state histories. A root persisted more than
+// once is only tracked up to its latest occurrence.
+func (db *Database) Recoverable(root common.Hash) bool {
+	if !db.pathScheme {
+		return false
+	}
+	if root == (common.Hash{}) {
+		root = emptyRoot
+	}
+	if rawdb.ReadPersistentStateRoot(db.diskdb) == root {
+		return true
+	}
+	tail, head := rawdb.ReadStateHistoryRange(db.diskdb)
+	id, ok := rawdb.ReadStateHistoryID(db.diskdb, root)
+	return ok && id > tail && id <= head
+}
+
+// Rollback reverts the persistent state to the one with the given root, applying
+// the stored state histories in reverse order. It's only supported by databases
+// using the path-based scheme.
+//
+// Note, this method is a non-synchronized mutator. It is unsafe to call this
+// concurrently with other mutators.
+func (db *Database) Rollback(root common.Hash) error {
+	if !db.pathScheme {
+		return errors.New("state rollback requires the path-based scheme")
+	}
+	if root == (common.Hash{}) {
+		root = emptyRoot
+	}
+	if !db.Recoverable(root) {
+		return fmt.Errorf("state %x is not recoverable", root)
+	}
+	var (
+		current    = rawdb.ReadPersistentStateRoot(db.diskdb)
+		tail, head = rawdb.ReadStateHistoryRange(db.diskdb)
+	)
+	for current != root {
+		history, err := readStateHistory(db.diskdb, head)
+		if err != nil {
+			return err
+		}
+		if history.Root != current {
+			return fmt.Errorf("state history %d mismatch: have root %x, want %x", head, history.Root, current)
+		}
+		batch := db.diskdb.NewBatch()
+		for _, node := range history.Nodes {
+			if len(node.Blob) == 0 {
+				rawdb.DeleteTrieNodeByPath(batch, node.Owner, node.Path)
+			} else {
+				rawdb.WriteTrieNodeByPath(batch, node.Owner, node.Path, node.Blob)
+			}
+		}
+		deleteStateHistory(db.diskdb, batch, head)
+		head--
+		rawdb.WriteStateHistoryRange(batch, tail, head)
+		rawdb.WritePersistentStateRoot(batch, history.Parent)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		log.Debug("Rolled back persistent state", "root", history.Parent, "from", current, "nodes", len(history.Nodes))
+		current = history.Parent
+	}
+	return nil
+}
+
+// readStateHistory retrieves and decodes the state history with the given id.
+func readStateHistory(db ethdb.KeyValueReader, id uint64) (*stateHistory, error) {
+	parent, root, ok := rawdb.ReadStateHistoryMeta(db, id)
+	if !ok {
+		return nil, fmt.Errorf("state history %d missing", id)
+	}
+	blob := rawdb.ReadStateHistory(db, id)
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("state history %d missing", id)
+	}
+	history := &stateHistory{Parent: parent, Root: root}
+	if err := rlp.DecodeBytes(blob, &history.Nodes); err != nil {
+		return nil, fmt.Errorf("invalid state history %d: %v", id, err)
+	}
+	return history, nil
+}
+
+// deleteStateHistory deletes the state history with the given id, along with its
+// index entry unless a newer history reverts to the same root.
+func deleteStateHistory(db ethdb.KeyValueReader, batch ethdb.KeyValueWriter, id uint64) {
+	if parent, _, ok := rawdb.ReadStateHistoryMeta(db, id); ok {
+		if indexed, ok := rawdb.ReadStateHistoryID(db, parent); ok && indexed == id {
+			rawdb.DeleteStateHistoryID(batch, parent)
+		}
+	}
+	rawdb.DeleteStateHistoryMeta(batch, id)
+	rawdb.DeleteStateHistory(batch, id)
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// newPathDatabase creates an empty disk database using the path-based scheme.
+func newPathDatabase() ethdb.KeyValueStore {
+	diskdb := memorydb.New()
+	rawdb.WritePersistentStateRoot(diskdb, emptyRoot)
+	return diskdb
+}
+
+// checkPathState checks that the persisted state matches the given entries, and
+// that no other nodes are stored on disk.
+func checkPathState(t *testing.T, diskdb ethdb.KeyValueStore, root common.Hash, entries map[string]string) {
+	t.Helper()
+
+	if have := rawdb.ReadPersistentStateRoot(diskdb); have != root {
+		t.Fatalf("persistent root mismatch: have %x, want %x", have, root)
+	}
+	tr, err := New(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	for key, value := range entries {
+		if have, err := tr.TryGet([]byte(key)); err != nil || string(have) != value {
+			t.Fatalf("value mismatch for %x: have %x, want %x, err %v", key, have, value, err)
+		}
+	}
+	var nodes int
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+		if it.Hash() != (common.Hash{}) {
+			nodes++
+		}
+	}
+	if it.Error() != nil {
+		t.Fatalf("failed to iterate trie: %v", it.Error())
+	}
+	var stored int
+	diskit := diskdb.NewIterator(rawdb.TrieNodeAccountPrefix, nil)
+	for diskit.Next() {
+		stored++
+	}
+	diskit.Release()
+	if stored != nodes {
+		t.Fatalf("stored node count mismatch: have %d, want %d", stored, nodes)
+	}
+}
+
+func TestPathSchemeCommit(t *testing.T) {
+	var (
+		diskdb  = newPathDatabase()
+		triedb  = NewDatabaseWithConfig(diskdb, &Config{StateHistory: 4})
+		entries = make(map[string]string)
+		roots   []common.Hash
+		states  []map[string]string
+		root    = emptyRoot
+	)
+	if triedb.Scheme() != rawdb.PathScheme {
+		t.Fatalf("wrong scheme: %s", triedb.Scheme())
+	}
+	for i := 0; i < 6; i++ {
+		tr, err := New(root, triedb)
+		if err != nil {
+			t.Fatalf("failed to open trie %d: %v", i, err)
+		}
+		// Insert some new keys, update and delete some existing ones
+		for j := 0; j < 200; j++ {
+			key, value := string(randBytes(1+rand.Intn(6))), string(randBytes(1+rand.Intn(60)))
+			tr.Update([]byte(key), []byte(value))
+			entries[key] = value
+		}
+		for key := range entries {
+			switch rand.Intn(4) {
+			case 0:
+				tr.Delete([]byte(key))
+				delete(entries, key)
+			case 1:
+				value := string(randBytes(1 + rand.Intn(60)))
+				tr.Update([]byte(key), []byte(value))
+				entries[key] = value
+			}
+		}
+		root, _ = tr.Commit(nil)
+		if err := triedb.Commit(root, false, nil); err != nil {
+			t.Fatalf("failed to commit state %d: %v", i, err)
+		}
+		checkPathState(t, diskdb, root, entries)
+
+		state := make(map[string]string)
+		for key, value := range entries {
+			state[key] = value
+		}
+		roots, states = append(roots, root), append(states, state)
+	}
+	// Only the retained histories can be used to roll back
+	if tail, head := rawdb.ReadStateHistoryRange(diskdb); tail != 2 || head != 6 {
+		t.Fatalf("history range mismatch: have (%d, %d), want (2, 6)", tail, head)
+	}
+	if triedb.Recoverable(roots[0]) {
+		t.Fatalf("pruned state reported recoverable")
+	}
+	if _, ok := rawdb.ReadStateHistoryID(diskdb, roots[0]); ok {
+		t.Fatalf("index of pruned history retained")
+	}
+	if err := triedb.Rollback(roots[0]); err == nil {
+		t.Fatalf("no error for rollback beyond the retained histories")
+	}
+	for i := len(roots) - 2; i >= 1; i-- {
+		if !triedb.Recoverable(roots[i]) {
+			t.Fatalf("state %d not recoverable", i)
+		}
+		if err := triedb.Rollback(roots[i]); err != nil {
+			t.Fatalf("failed to roll back to state %d: %v", i, err)
+		}
+		checkPathState(t, diskdb, roots[i], states[i])
+
+		if _, _, ok := rawdb.ReadStateHistoryMeta(diskdb, uint64(i+2)); ok {
+			t.Fatalf("state %d: applied history retained", i)
+		}
+		if _, ok := rawdb.ReadStateHistoryID(diskdb, roots[i]); ok {
+			t.Fatalf("state %d: index of applied history retained", i)
+		}
+	}
+	// Old states can't be resolved anymore, only the persisted one
+	if _, err := New(roots[len(roots)-1], NewDatabase(diskdb)); err == nil {
+		t.Fatalf("rolled back state still available")
+	}
+}
+
+// Tests that the nodes of the same subtrie are persisted separately for every
+// position it appears at, and that tries stored below leaves are owned by them.
+func TestPathSchemeOwners(t *testing.T) {
+	var (
+		diskdb = newPathDatabase()
+		triedb = NewDatabase(diskdb)
+	)
+	// Create two identical child tries and reference them from two leaves
+	var (
+		child  common.Hash
+		owners = []common.Hash{{0x01}, {0x02}}
+	)
+	for range owners {
+		tr, _ := New(common.Hash{}, triedb)
+		for i := 0; i < 50; i++ {
+			tr.Update([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, 40))
+		}
+		child, _ = tr.Commit(nil)
+	}
+	tr, _ := New(common.Hash{}, triedb)
+	for _, owner := range owners {
+		tr.Update(owner[:], bytes.Repeat([]byte{0xff}, 40))
+	}
+	root, _ := tr.Commit(func(_ []byte, _ []byte, parent common.Hash) error {
+		triedb.Reference(child, parent)
+		return nil
+	})
+	if err := triedb.Commit(root, false, nil); err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	for _, owner := range owners {
+		tr, err := NewWithOwner(owner, child, NewDatabase(diskdb))
+		if err != nil {
+			t.Fatalf("failed to open trie of %x: %v", owner, err)
+		}
+		for i := 0; i < 50; i++ {
+			if value, err := tr.TryGet([]byte{byte(i)}); err != nil || len(value) != 40 {
+				t.Fatalf("missing value %d of %x: %v", i, owner, err)
+			}
+		}
+	}
+	if _, err := NewWithOwner(common.Hash{0x03}, child, NewDatabase(diskdb)); err == nil {
+		t.Fatalf("trie available without owner")
+	}
+}
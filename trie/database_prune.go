@@ -0,0 +1,96 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// SetMarker installs a writer which is notified of the hash of every trie node
+// flushed to disk from now on, or removes it if nil. Online pruning uses it to
+// learn about the nodes written while it runs, so it never deletes them.
+func (db *Database) SetMarker(marker ethdb.KeyValueWriter) {
+	db.flushLock.Lock()
+	defer db.flushLock.Unlock()
+
+	db.marker = marker
+}
+
+// Prune deletes the given trie nodes from disk and from the clean cache, except
+// the ones the keep function reports as still in use. The check and deletion are
+// atomic with regard to node flushes, so a node written to disk concurrently and
+// reported to the marker is never lost. The number of deleted nodes is returned.
+func (db *Database) Prune(hashes []common.Hash, keep func(hash common.Hash) bool) (int, error) {
+	db.flushLock.Lock()
+	defer db.flushLock.Unlock()
+
+	var (
+		batch   = db.diskdb.NewBatch()
+		deleted int
+	)
+	for _, hash := range hashes {
+		if keep(hash) {
+			continue
+		}
+		rawdb.DeleteTrieNode(batch, hash)
+		if db.cleans != nil {
+			db.cleans.Del(hash[:])
+		}
+		deleted++
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// newBatch creates a write batch for flushing trie nodes to disk, reporting them
+// to the marker if one is installed.
+func (db *Database) newBatch() ethdb.Batch {
+	return &markedBatch{Batch: db.diskdb.NewBatch(), db: db}
+}
+
+// markedBatch is a database batch which notifies the marker of the trie database
+// about the nodes written, and serializes the writes with online pruning.
+type markedBatch struct {
+	ethdb.Batch
+	db *Database
+}
+
+// Put inserts the given value into the batch, notifying the marker if the entry
+// is a trie node. The marker is notified before the node reaches the disk, which
+// makes it safe against a concurrent deletion by the pruner.
+func (b *markedBatch) Put(key []byte, value []byte) error {
+	if len(key) == common.HashLength {
+		b.db.flushLock.Lock()
+		if b.db.marker != nil {
+			b.db.marker.Put(key, nil)
+		}
+		b.db.flushLock.Unlock()
+	}
+	return b.Batch.Put(key, value)
+}
+
+// Write flushes the accumulated data to disk, blocking while nodes are pruned.
+func (b *markedBatch) Write() error {
+	b.db.flushLock.Lock()
+	defer b.db.flushLock.Unlock()
+
+	return b.Batch.Write()
+}
@@ -129,6 +129,53 @@ func VerifyProof(rootHash common.Hash, key []byte, proofDb ethdb.KeyValueReader)
 	}
 }
 
+// VerifyMultiProof checks a combined merkle proof for several keys of a trie with
+// the given root hash, i.e. the union of the proofs of the individual keys. Every
+// node shared by multiple proofs is only decoded once. The proof may contain nodes
+// of other tries as well, so the same node set can be used to verify an account
+// and its storage.
+//
+// VerifyMultiProof returns the values of the keys, nil for keys not present in the
+// trie, or an error if the proof of any key is incomplete or invalid.
+func VerifyMultiProof(rootHash common.Hash, keys [][]byte, proofDb ethdb.KeyValueReader) ([][]byte, error) {
+	var (
+		values  = make([][]byte, len(keys))
+		decoded = make(map[common.Hash]node)
+	)
+	for k, key := range keys {
+		hexkey := keybytesToHex(key)
+		wantHash := rootHash
+	walk:
+		for i := 0; ; i++ {
+			n, ok := decoded[wantHash]
+			if !ok {
+				buf, _ := proofDb.Get(wantHash[:])
+				if buf == nil {
+					return nil, fmt.Errorf("key %x: proof node %d (hash %064x) missing", key, i, wantHash)
+				}
+				var err error
+				if n, err = decodeNode(wantHash[:], buf); err != nil {
+					return nil, fmt.Errorf("key %x: bad proof node %d: %v", key, i, err)
+				}
+				decoded[wantHash] = n
+			}
+			keyrest, cld := get(n, hexkey, true)
+			switch cld := cld.(type) {
+			case nil:
+				// The trie doesn't contain the key.
+				break walk
+			case hashNode:
+				hexkey = keyrest
+				copy(wantHash[:], cld)
+			case valueNode:
+				values[k] = cld
+				break walk
+			}
+		}
+	}
+	return values, nil
+}
+
 // proofToPath converts a merkle proof to trie node path. The main purpose of
 // this function is recovering a node path from the merkle proof stream. All
 // necessary nodes will be resolved and leave the remaining as hashnode.
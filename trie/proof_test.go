@@ -150,6 +150,74 @@ func TestMissingKeyProof(t *testing.T) {
 	}
 }
 
+// Tests that the combined proofs of many existent and missing keys verify, that
+// shared nodes are only included once and that missing nodes are detected.
+func TestMultiProof(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := trie.Hash()
+
+	var (
+		keys   [][]byte
+		want   [][]byte
+		proof  = memorydb.New()
+		single int
+	)
+	for _, kv := range vals {
+		keys = append(keys, kv.k)
+		want = append(want, kv.v)
+		if len(keys) == 100 {
+			break
+		}
+	}
+	for i := 0; i < 10; i++ {
+		key := randBytes(32)
+		if _, ok := vals[string(key)]; !ok {
+			keys = append(keys, key)
+			want = append(want, nil)
+		}
+	}
+	for _, key := range keys {
+		single += proofSize(t, trie, key)
+		trie.Prove(key, 0, proof)
+	}
+	if proof.Len() >= single {
+		t.Errorf("multiproof not deduplicated: %d nodes, %d in individual proofs", proof.Len(), single)
+	}
+	values, err := VerifyMultiProof(root, keys, proof)
+	if err != nil {
+		t.Fatalf("failed to verify multiproof: %v", err)
+	}
+	for i := range keys {
+		if !bytes.Equal(values[i], want[i]) {
+			t.Fatalf("verified value mismatch for key %x: have %x, want %x", keys[i], values[i], want[i])
+		}
+	}
+	// Dropping any node must break the proof.
+	it := proof.NewIterator(nil, nil)
+	for it.Next() {
+		incomplete := memorydb.New()
+		it2 := proof.NewIterator(nil, nil)
+		for it2.Next() {
+			if !bytes.Equal(it.Key(), it2.Key()) {
+				incomplete.Put(it2.Key(), it2.Value())
+			}
+		}
+		it2.Release()
+		if _, err := VerifyMultiProof(root, keys, incomplete); err == nil {
+			t.Fatalf("expected multiproof without node %x to fail", it.Key())
+		}
+	}
+	it.Release()
+}
+
+func proofSize(t *testing.T, trie *Trie, key []byte) int {
+	proof := memorydb.New()
+	if err := trie.Prove(key, 0, proof); err != nil {
+		t.Fatalf("failed to prove key %x: %v", key, err)
+	}
+	return proof.Len()
+}
+
 type entrySlice []*kv
 
 func (p entrySlice) Len() int           { return len(p) }